@@ -10,7 +10,10 @@ package buckt
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/backend"
 	"github.com/Rhaqim/buckt/internal/cache"
@@ -21,6 +24,7 @@ import (
 	"github.com/Rhaqim/buckt/internal/repository"
 	"github.com/Rhaqim/buckt/internal/service"
 	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
 )
 
 type Client struct {
@@ -32,8 +36,30 @@ type Client struct {
 	logger   domain.BucktLogger
 	lruCache domain.LRUCache
 
-	fileService   domain.FileService
-	folderService domain.FolderService
+	fileService      domain.FileService
+	folderService    domain.FolderService
+	renditionService domain.RenditionService
+	archiveService   domain.ArchiveService
+	batchService     domain.BatchService
+	reconcileService domain.ReconcileService
+	integrityService domain.IntegrityService
+	metadataService  domain.MetadataService
+	importService    domain.ImportService
+	exportService    domain.ExportService
+	migrationService domain.MigrationService
+
+	erasure    *backend.ErasureBackend
+	migration  domain.MigratableBackend
+	throttle   *backend.Throttle
+	instanceID uuid.UUID // Owner of the journal entries of this instance
+	cached     *backend.CachedBackend
+	replicated *backend.ReplicatedBackend
+	encrypted  *backend.EncryptedBackend
+
+	// background jobs
+	jobsCtx    context.Context
+	stopJobs   context.CancelFunc
+	jobsWaiter sync.WaitGroup
 }
 
 // New initializes a new Buckt client with the provided configuration options.
@@ -73,7 +99,96 @@ func New(conf Config, opts ...ConfigFunc) (*Client, error) {
 	cacheManager, lruCache := initializeCache(conf.Cache, bucktLog)
 
 	// Initialise Backend
-	var backend domain.FileBackend = resolveBackend(conf.MediaDir, conf.Backend, bucktLog, lruCache)
+	var erasure *backend.ErasureBackend
+	if ecConf := conf.Backend.Erasure; len(ecConf.Dirs) > 0 {
+		if erasure, err = backend.NewErasureBackend(bucktLog, ecConf.Dirs, ecConf.DataShards, ecConf.ParityShards, ecConf.WriteQuorum); err != nil {
+			return nil, bucktLog.WrapErrorf("failed to initialize erasure coding", err)
+		}
+	}
+
+	// Local storage is erasure coded when configured
+	newLocal := func() Backend {
+		if erasure != nil {
+			return erasure
+		}
+		return backend.NewLocalFileSystemService(bucktLog, conf.MediaDir, lruCache)
+	}
+
+	// Background jobs share a throttle, it applies to all of them together
+	throttle, err := backend.NewThrottle(conf.Throttle)
+	if err != nil {
+		return nil, bucktLog.WrapErrorf("invalid throttle configuration", err)
+	}
+
+	var activeBackend domain.FileBackend = resolveBackend(conf.Backend, bucktLog, newLocal, repository.NewMigrationRepository(db), throttle)
+	migration, _ := activeBackend.(domain.MigratableBackend)
+	var migrationService domain.MigrationService
+	if migration != nil {
+		migrationService = service.NewMigrationService(bucktLog, migration)
+	}
+
+	// Apply replication
+	var replicated *backend.ReplicatedBackend
+	if repConf := conf.Backend.Replication; len(repConf.Replicas) > 0 {
+		replicas := []domain.FileBackend{activeBackend}
+		for _, r := range repConf.Replicas {
+			replicas = append(replicas, instantiateIfLocal(r, newLocal))
+		}
+		replicated = backend.NewReplicatedBackend(bucktLog, repConf.WriteQuorum, replicas...)
+		activeBackend = replicated
+	}
+
+	// Apply the disk cache, below encryption so cached files stay encrypted
+	var cached *backend.CachedBackend
+	if dcConf := conf.Cache.Disk; dcConf.Dir != "" {
+		dcConf.Validate()
+		disk, err := cache.NewDiskCache(dcConf.Dir, dcConf.MaxSize, dcConf.Policy)
+		if err != nil {
+			return nil, bucktLog.WrapErrorf("failed to initialize disk cache", err)
+		}
+		cached = backend.NewCachedBackend(bucktLog, activeBackend, lruCache, disk)
+		activeBackend = cached
+	}
+
+	// Apply encryption
+	var encrypted *backend.EncryptedBackend
+	if encConf := conf.Backend.Encryption; encConf.KeyProvider != nil {
+		encrypted = backend.NewEncryptedBackend(bucktLog, activeBackend, encConf.KeyProvider, encConf.ChunkSize, encConf.AllowPlaintext)
+		activeBackend = encrypted
+	}
+
+	// Apply compression, data is compressed before it is encrypted
+	if cmpConf := conf.Backend.Compression; cmpConf.Enabled {
+		activeBackend = backend.NewCompressedBackend(bucktLog, activeBackend, cmpConf.Level, cmpConf.MinSize, cmpConf.SkipContentTypes)
+	}
+
+	// Background jobs go through the throttle
+	bulkBackend := backend.NewThrottledBackend(activeBackend, throttle)
+
+	journal := repository.NewOperationRepository(db)
+	instanceID := uuid.New()
+
+	fileOpts := []service.FileServiceOption{
+		service.WithUploadPolicy(conf.UploadPolicy),
+	}
+	if conf.Scan.Scanner != nil {
+		bucktLog.Infof("🛡️ Scanning uploads in %s mode", conf.Scan.Mode)
+		fileOpts = append(fileOpts, service.WithScanner(conf.Scan.Scanner, conf.Scan.Mode))
+	}
+
+	var renditionService domain.RenditionService
+	if rConf := conf.Renditions; len(rConf.Sizes) > 0 {
+		renditionService = service.NewRenditionService(bucktLog, repository.NewRenditionRepository(db), repository.NewFileRepository(db), activeBackend, rConf.Sizes, rConf.Workers)
+		fileOpts = append(fileOpts, service.WithRenditions(renditionService))
+	}
+
+	metadataService := service.NewMetadataService(bucktLog, repository.NewFolderRepository(db), repository.NewFileRepository(db), activeBackend)
+
+	// Sidecars are only written when enabled, an index can be rebuilt from existing ones regardless
+	var sidecars domain.MetadataService
+	if conf.MetadataSidecars {
+		sidecars = metadataService
+	}
 
 	// Initialize the app services
 	folderService, fileService := newAppServices(
@@ -81,18 +196,111 @@ func New(conf Config, opts ...ConfigFunc) (*Client, error) {
 		db,
 		bucktLog,
 		cacheManager,
-		backend,
+		activeBackend,
+		sidecars,
+		journal,
+		instanceID,
+		fileOpts...,
 	)
 
 	// Initialize the Buckt instance
 	buckt := &Client{
-		db:             db,
-		logger:         bucktLog,
-		lruCache:       lruCache,
-		flatnameSpaces: conf.FlatNameSpaces,
-		silence:        logConf.Silence,
-		fileService:    fileService,
-		folderService:  folderService,
+		db:               db,
+		logger:           bucktLog,
+		lruCache:         lruCache,
+		flatnameSpaces:   conf.FlatNameSpaces,
+		silence:          logConf.Silence,
+		fileService:      fileService,
+		folderService:    folderService,
+		renditionService: renditionService,
+		archiveService:   service.NewArchiveService(bucktLog, folderService, fileService, conf.Extract),
+		batchService:     service.NewBatchService(bucktLog, db, repository.NewFolderRepository(db), repository.NewFileRepository(db), folderService, fileService),
+		reconcileService: service.NewReconcileService(bucktLog, repository.NewFileRepository(db), repository.NewRenditionRepository(db), journal, bulkBackend),
+		integrityService: service.NewIntegrityService(bucktLog, cacheManager, repository.NewFileRepository(db), bulkBackend),
+		metadataService:  metadataService,
+		importService:    service.NewImportService(bucktLog, folderService, fileService, repository.NewFileRepository(db), activeBackend),
+		exportService:    service.NewExportService(bucktLog, repository.NewFolderRepository(db), repository.NewFileRepository(db), bulkBackend, sidecars),
+		migrationService: migrationService,
+		throttle:         throttle,
+		instanceID:       instanceID,
+		erasure:          erasure,
+		migration:        migration,
+		cached:           cached,
+		replicated:       replicated,
+		encrypted:        encrypted,
+	}
+
+	// Complete or roll back the operations interrupted by the last shutdown
+	if n, err := fileService.RecoverOperations(context.Background()); err != nil {
+		return nil, bucktLog.WrapErrorf("failed to recover operations", err)
+	} else if n > 0 {
+		bucktLog.Infof("🩹 Recovered %d interrupted file operations", n)
+	}
+	if n, err := folderService.RecoverOperations(context.Background()); err != nil {
+		return nil, bucktLog.WrapErrorf("failed to recover operations", err)
+	} else if n > 0 {
+		bucktLog.Infof("🩹 Recovered %d interrupted folder operations", n)
+	}
+
+	buckt.jobsCtx, buckt.stopJobs = context.WithCancel(context.Background())
+
+	// Keep the journal entries of this instance from being recovered by others
+	buckt.scheduleJob("operation lease renewal", operationRenewInterval, func(ctx context.Context) error {
+		return journal.Renew(ctx, instanceID)
+	})
+
+	// Finish the operations whose steps after the commit failed, such as moving
+	// an object, and recover those of instances whose lease has expired since
+	buckt.scheduleJob("operation retry", operationRetryInterval, func(ctx context.Context) error {
+		if _, err := fileService.RetryOperations(ctx); err != nil {
+			return err
+		}
+		if _, err := folderService.RetryOperations(ctx); err != nil {
+			return err
+		}
+		if _, err := fileService.RecoverOperations(ctx); err != nil {
+			return err
+		}
+		_, err := folderService.RecoverOperations(ctx)
+		return err
+	})
+
+	if replicated != nil && conf.Backend.Replication.AntiEntropyInterval > 0 {
+		buckt.scheduleJob("anti-entropy", conf.Backend.Replication.AntiEntropyInterval, func(ctx context.Context) error {
+			_, err := buckt.RepairReplicas(ctx)
+			return err
+		})
+	}
+
+	if encrypted != nil && conf.Backend.Encryption.RewrapInterval > 0 {
+		buckt.scheduleJob("key re-wrap", conf.Backend.Encryption.RewrapInterval, func(ctx context.Context) error {
+			_, err := buckt.RewrapKeys(ctx)
+			return err
+		})
+	}
+
+	if conf.Scan.Scanner != nil && conf.Scan.Mode == ScanAsync && conf.Scan.RescanInterval > 0 {
+		buckt.scheduleJob("rescan", conf.Scan.RescanInterval, func(ctx context.Context) error {
+			_, err := buckt.RescanPending(ctx)
+			return err
+		})
+	}
+
+	if rcConf := conf.Reconcile; rcConf.Interval > 0 {
+		buckt.scheduleJob("reconciliation", rcConf.Interval, func(ctx context.Context) error {
+			report, err := buckt.Reconcile(ctx, rcConf.Options)
+			if err != nil || rcConf.ReportFile == "" {
+				return err
+			}
+			return writeReport(rcConf.ReportFile, report)
+		})
+	}
+
+	if icConf := conf.Integrity; icConf.Interval > 0 {
+		buckt.scheduleJob("integrity check", icConf.Interval, func(ctx context.Context) error {
+			_, err := buckt.VerifyFiles(ctx, icConf.MaxAge, icConf.BatchSize)
+			return err
+		})
 	}
 
 	bucktLog.Info("✅ Buckt initialized")
@@ -131,12 +339,46 @@ func Default(opts ...ConfigFunc) (*Client, error) {
 }
 
 // Close closes the Buckt instance.
-// It closes the database connection and the LRU cache.
+// It stops background jobs, waits for queued scans, renditions, read-repairs and migration promotions and closes the database connection and the LRU cache.
 func (b *Client) Close() {
+	if b.stopJobs != nil {
+		b.stopJobs()
+		b.jobsWaiter.Wait()
+	}
+	b.fileService.Close()
+	if b.renditionService != nil {
+		b.renditionService.Close()
+	}
+	if b.replicated != nil {
+		b.replicated.Close()
+	}
+	if b.migrationService != nil {
+		b.migrationService.Close()
+	}
 	b.db.Close()
+	if b.cached != nil {
+		if err := b.cached.Close(); err != nil {
+			b.logger.Errorf("failed to persist disk cache: %v", err)
+		}
+	}
 	b.lruCache.Close()
 }
 
+// CacheStats returns the hit and miss counters of the in-memory file cache and,
+// when enabled, the on-disk cache.
+//
+// Returns:
+//   - CacheStats: The cache counters.
+func (b *Client) CacheStats() CacheStats {
+	if b.cached != nil {
+		return b.cached.Stats()
+	}
+	return CacheStats{
+		L1Hits:   b.lruCache.Hits(),
+		L1Misses: b.lruCache.Misses(),
+	}
+}
+
 /* Folder Methods */
 
 // NewFolder creates a new folder for a user within a specified parent folder.
@@ -266,6 +508,7 @@ func (b *Client) UploadFileFromReader(user_id string, parent_id string, file_nam
 
 // GetFile retrieves a file based on the provided file ID.
 // It returns the file data and an error, if any occurred during the retrieval process.
+// Files that are infected or not scanned yet are refused with ErrFileInfected or ErrFileScanPending.
 //
 // Parameters:
 //   - file_id: A string representing the unique identifier of the file to be retrieved.
@@ -277,8 +520,22 @@ func (b *Client) GetFile(file_id string) (*model.FileModel, error) {
 	return b.GetFileContext(context.Background(), file_id)
 }
 
+// GetFileInfo retrieves the metadata of a file based on the provided file ID, without reading its content.
+// Files that are infected or not scanned yet are refused with ErrFileInfected or ErrFileScanPending.
+//
+// Parameters:
+//   - file_id: A string representing the unique identifier of the file.
+//
+// Returns:
+//   - *model.FileModel: The file metadata, with no data.
+//   - error: An error object if an error occurred, otherwise nil.
+func (b *Client) GetFileInfo(file_id string) (*model.FileModel, error) {
+	return b.GetFileInfoContext(context.Background(), file_id)
+}
+
 // GetFileStream retrieves a file stream based on the provided file ID.
 // It returns the file data and an error, if any occurred during the retrieval process.
+// Files that are infected or not scanned yet are refused with ErrFileInfected or ErrFileScanPending.
 //
 // Parameters:
 //   - file_id: A string representing the unique identifier of the file to be retrieved.
@@ -293,6 +550,20 @@ func (b *Client) GetFileStream(file_id string) (*model.FileModel, io.ReadCloser,
 	return b.GetFileStreamContext(context.Background(), file_id)
 }
 
+// GetRendition retrieves a resized version of an image, see WithRenditions.
+// Renditions that have not been generated yet are generated on demand.
+//
+// Parameters:
+//   - file_id: A string representing the unique identifier of the image.
+//   - name: The name of the rendition, e.g. "thumb".
+//
+// Returns:
+//   - *Rendition: The rendition metadata and data.
+//   - error: ErrRenditionNotFound if the size is not configured or the file is not an image.
+func (b *Client) GetRendition(file_id, name string) (*Rendition, error) {
+	return b.GetRenditionContext(context.Background(), file_id, name)
+}
+
 // ListFiles retrieves a list of files for a given folder.
 //
 // Parameters:
@@ -530,6 +801,19 @@ func (b *Client) GetFileContext(ctx context.Context, file_id string) (*model.Fil
 	return b.fileService.GetFile(ctx, file_id)
 }
 
+// GetFileInfoContext retrieves the metadata of a file based on the provided file ID, without reading its content.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - file_id: A string representing the unique identifier of the file.
+//
+// Returns:
+//   - *model.FileModel: The file metadata, with no data.
+//   - error: An error object if an error occurred, otherwise nil.
+func (b *Client) GetFileInfoContext(ctx context.Context, file_id string) (*model.FileModel, error) {
+	return b.fileService.GetFileInfo(ctx, file_id)
+}
+
 // GetFileStreamContext retrieves a file stream based on the provided file ID.
 // It returns the file data and an error, if any occurred during the retrieval process.
 //
@@ -547,6 +831,24 @@ func (b *Client) GetFileStreamContext(ctx context.Context, file_id string) (*mod
 	return b.fileService.GetFileStream(ctx, file_id)
 }
 
+// GetRenditionContext retrieves a resized version of an image, see WithRenditions.
+// Renditions that have not been generated yet are generated on demand.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - file_id: A string representing the unique identifier of the image.
+//   - name: The name of the rendition, e.g. "thumb".
+//
+// Returns:
+//   - *Rendition: The rendition metadata and data.
+//   - error: ErrRenditionNotFound if the size is not configured or the file is not an image.
+func (b *Client) GetRenditionContext(ctx context.Context, file_id, name string) (*Rendition, error) {
+	if b.renditionService == nil {
+		return nil, fmt.Errorf("%w: renditions are not enabled", ErrRenditionNotFound)
+	}
+	return b.renditionService.GetRendition(ctx, file_id, name)
+}
+
 // ListFilesContext retrieves a list of files for a given folder.
 //
 // Parameters:
@@ -614,8 +916,678 @@ func (b *Client) DeleteFilePermanentlyContext(ctx context.Context, file_id strin
 	return b.fileService.ScrubFile(ctx, file_id)
 }
 
+/* Archives */
+
+// ArchiveFolder writes a folder and all of its subfolders to w as a zip or tar.gz archive.
+// Files are streamed from the backend one at a time, the archive is never held in memory.
+// Entries are named relative to the folder, files that are infected or not scanned yet are left out.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the folder.
+//   - folder_id: The ID of the folder to archive, the root folder of the user when empty.
+//   - w: The writer the archive is written to.
+//   - format: ArchiveZip or ArchiveTarGz.
+//
+// Returns:
+//   - error: An error if the folder does not belong to the user or a file could not be read.
+//     The archive is incomplete when an error is returned.
+func (b *Client) ArchiveFolder(ctx context.Context, user_id, folder_id string, w io.Writer, format ArchiveFormat) error {
+	return b.archiveService.ArchiveFolder(ctx, user_id, folder_id, w, format)
+}
+
+// ArchiveFiles writes a selection of files to w as a zip or tar.gz archive.
+// Files are written to the root of the archive, files with the same name are numbered.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the files.
+//   - file_ids: The IDs of the files to archive.
+//   - w: The writer the archive is written to.
+//   - format: ArchiveZip or ArchiveTarGz.
+//
+// Returns:
+//   - error: An error if a file does not belong to the user or could not be read.
+//     The archive is incomplete when an error is returned.
+func (b *Client) ArchiveFiles(ctx context.Context, user_id string, file_ids []string, w io.Writer, format ArchiveFormat) error {
+	return b.archiveService.ArchiveFiles(ctx, user_id, file_ids, w, format)
+}
+
+// ExtractArchive creates the folders and files of a zip, tar or tar.gz archive under a folder.
+// Folders that already exist are merged into, files go through the upload policy and scanner.
+// Entries with absolute paths or ".." elements, links and rejected uploads are skipped.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the folder.
+//   - parent_id: The ID of the folder to extract into, the root folder of the user when empty.
+//   - archive: The archive to extract, its format is detected from its content.
+//   - conflict: What to do with files whose name is taken, ConflictSkip when empty.
+//
+// Returns:
+//   - ExtractReport: What was created, overwritten or skipped, also when an error is returned.
+//   - error: An error if the folder does not belong to the user, the archive cannot be read
+//     or it exceeds the extraction limits (ErrArchiveLimitExceeded). Entries extracted
+//     before the error are kept.
+func (b *Client) ExtractArchive(ctx context.Context, user_id, parent_id string, archive io.Reader, conflict ConflictPolicy) (ExtractReport, error) {
+	return b.archiveService.ExtractArchive(ctx, user_id, parent_id, archive, conflict)
+}
+
+/* Import */
+
+// ImportTree adopts the objects below prefix in backend, for example a directory
+// of existing files or a bucket prefix, as files of the folder dest_folder_id.
+// Sub-prefixes become sub-folders, created where they do not exist yet.
+//
+// With ImportCopy every object is uploaded again through the upload policy and
+// scanner. With ImportReference the objects stay where they are and are only
+// indexed, after passing the upload policy and scanner as well; they must be
+// stored in the backend of the client, pass nil as backend. In ScanAsync mode
+// referenced files stay pending until RescanPending scans them.
+// Imports can be repeated: files whose content is already stored are reported
+// as unchanged. An interrupted import is resumed by passing ImportReport.LastKey
+// as opts.After.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the folder.
+//   - backend: The backend to import from, nil for the backend of the client.
+//   - prefix: The prefix of the objects to import, all objects when empty.
+//   - dest_folder_id: The ID of the folder to import into, the root folder of the user when empty.
+//   - opts: Whether objects are copied or referenced and what happens when a name is taken.
+//
+// Returns:
+//   - ImportReport: What was created, referenced or skipped, also when an error is returned.
+//   - error: An error if the folder does not belong to the user, the backend cannot
+//     be listed, or ErrImportReference when objects of another backend are referenced.
+func (b *Client) ImportTree(ctx context.Context, user_id string, backend Backend, prefix, dest_folder_id string, opts ImportOptions) (ImportReport, error) {
+	return b.importService.ImportTree(ctx, user_id, backend, prefix, dest_folder_id, opts)
+}
+
+/* Batch */
+
+// BatchDelete soft deletes files and folders, which can be mixed in ids.
+// Items are processed concurrently and fail independently, unless opts.Atomic is set:
+// the batch then runs in a single transaction and nothing is deleted if any item fails.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the files and folders.
+//   - ids: The IDs of the files and folders to delete.
+//   - opts: Whether the batch is atomic and how many items are processed at once.
+//
+// Returns:
+//   - []BatchResult: The outcome of every item, in the order of ids.
+//   - error: ErrBatchRolledBack when an atomic batch failed, nil otherwise.
+func (b *Client) BatchDelete(ctx context.Context, user_id string, ids []string, opts BatchOptions) ([]BatchResult, error) {
+	return b.batchService.BatchDelete(ctx, user_id, ids, opts)
+}
+
+// BatchScrub permanently deletes files and folders, which can be mixed in ids.
+// Content is removed from the backend once the database rows are gone, for atomic
+// batches only after the transaction is committed.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the files and folders.
+//   - ids: The IDs of the files and folders to delete.
+//   - opts: Whether the batch is atomic and how many items are processed at once.
+//
+// Returns:
+//   - []BatchResult: The outcome of every item, in the order of ids.
+//   - error: ErrBatchRolledBack when an atomic batch failed, nil otherwise.
+func (b *Client) BatchScrub(ctx context.Context, user_id string, ids []string, opts BatchOptions) ([]BatchResult, error) {
+	return b.batchService.BatchScrub(ctx, user_id, ids, opts)
+}
+
+// BatchMove moves files and folders, which can be mixed in ids, into a folder.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the files and folders.
+//   - ids: The IDs of the files and folders to move.
+//   - new_parent_id: The ID of the folder to move them to, the root folder of the user when empty.
+//   - opts: Whether the batch is atomic and how many items are processed at once.
+//
+// Returns:
+//   - []BatchResult: The outcome of every item, in the order of ids.
+//   - error: An error if the folder does not belong to the user,
+//     ErrBatchRolledBack when an atomic batch failed.
+func (b *Client) BatchMove(ctx context.Context, user_id string, ids []string, new_parent_id string, opts BatchOptions) ([]BatchResult, error) {
+	return b.batchService.BatchMove(ctx, user_id, ids, new_parent_id, opts)
+}
+
+// BatchUpload uploads files into a folder. Every file goes through the upload policy and scanner.
+// Files already written to the backend are deleted again when an atomic batch is rolled back.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - user_id: The ID of the user who owns the folder.
+//   - parent_id: The ID of the folder to upload to, the root folder of the user when empty.
+//   - files: The files to upload.
+//   - opts: Whether the batch is atomic and how many items are processed at once.
+//
+// Returns:
+//   - []BatchResult: The outcome of every file with the ID it was created with, in the order of files.
+//   - error: An error if the folder does not belong to the user,
+//     ErrBatchRolledBack when an atomic batch failed.
+func (b *Client) BatchUpload(ctx context.Context, user_id, parent_id string, files []BatchUpload, opts BatchOptions) ([]BatchResult, error) {
+	return b.batchService.BatchUpload(ctx, user_id, parent_id, files, opts)
+}
+
+/* Scanning */
+
+// RescanPending scans every file that is still waiting for an antivirus scan,
+// for example because the scanner was unreachable when it was uploaded.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - int: The number of files scanned.
+//   - error: An error if the pending files could not be listed.
+func (b *Client) RescanPending(ctx context.Context) (int, error) {
+	n, err := b.fileService.RescanPending(ctx)
+	if n > 0 {
+		b.logger.Infof("🛡️ Scanned %d pending files", n)
+	}
+	return n, err
+}
+
+/* Reconciliation */
+
+// Reconcile compares the objects in the backend with the file table. Objects no
+// file refers to, such as leftovers of failed uploads, are reported as stray and
+// files whose object is gone as missing.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - opts: Whether stray objects older than the grace period are deleted and missing files marked.
+//
+// Returns:
+//   - ReconcileReport: The stray objects and missing files found.
+//   - error: An error if the backend or the file table could not be listed.
+func (b *Client) Reconcile(ctx context.Context, opts ReconcileOptions) (ReconcileReport, error) {
+	report, err := b.reconcileService.Reconcile(ctx, opts)
+	if err != nil {
+		return report, b.logger.WrapError("failed to reconcile backend", err)
+	}
+
+	b.logger.Infof("🧹 Reconciled %d objects with %d files: %d stray, %d missing", report.Objects, report.Files, len(report.Stray), len(report.Missing))
+	return report, nil
+}
+
+/* Integrity */
+
+// VerifyFile reads the content of a file and compares it with its checksum.
+// Corrupt or missing content is restored from another copy when the backend
+// keeps one, such as a replica. The outcome is recorded on the file.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - file_id: The ID of the file to verify.
+//
+// Returns:
+//   - VerifyResult: The integrity status of the file and whether it was repaired.
+//   - error: An error if the file could not be read or the outcome recorded.
+func (b *Client) VerifyFile(ctx context.Context, file_id string) (VerifyResult, error) {
+	result, err := b.integrityService.VerifyFile(ctx, file_id)
+	if err != nil {
+		return result, b.logger.WrapError("failed to verify file", err)
+	}
+	return result, nil
+}
+
+// VerifyFiles verifies up to limit files that have not been verified within max_age,
+// least recently verified first. See VerifyFile.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - max_age: How long a verification holds.
+//   - limit: The maximum number of files to verify.
+//
+// Returns:
+//   - VerifyReport: How many files were verified, corrupt, missing and repaired.
+//   - error: An error if the files due could not be listed.
+func (b *Client) VerifyFiles(ctx context.Context, max_age time.Duration, limit int) (VerifyReport, error) {
+	report, err := b.integrityService.VerifyFiles(ctx, max_age, limit)
+	if err != nil {
+		return report, b.logger.WrapError("failed to verify files", err)
+	}
+
+	if report.Verified > 0 || report.Failed > 0 {
+		b.logger.Infof("🔎 Verified %d files: %d corrupt, %d missing, %d unverified, %d repaired, %d failed", report.Verified, report.Corrupt, report.Missing, report.Unverified, report.Repaired, report.Failed)
+	}
+	return report, nil
+}
+
+// RebaselineFile accepts the stored content of a file as correct and records
+// its checksum as the hash of the file. Use it for files VerifyFile marked
+// unverified, whose hash predates content checksums and no longer matches,
+// once their content is known to be good.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - file_id: The ID of the file to re-baseline.
+//
+// Returns:
+//   - VerifyResult: The integrity status of the file, missing if its content is gone.
+//   - error: An error if the file could not be read or the outcome recorded.
+func (b *Client) RebaselineFile(ctx context.Context, file_id string) (VerifyResult, error) {
+	result, err := b.integrityService.RebaselineFile(ctx, file_id)
+	if err != nil {
+		return result, b.logger.WrapError("failed to re-baseline file", err)
+	}
+	return result, nil
+}
+
+/* Disaster Recovery */
+
+// RebuildIndex recreates the folders and files described by the metadata
+// sidecars in the backend, for example after the database was lost. Sidecars
+// are written when the client is created with WithMetadataSidecars. Folders
+// and files that are already indexed are left alone, so a rebuild can be
+// repeated after resolving the conflicts it reports.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - opts: Whether to only report what would be indexed.
+//
+// Returns:
+//   - RebuildReport: The folders and files created and the sidecars that could not be indexed.
+//   - error: An error if the backend could not be listed.
+func (b *Client) RebuildIndex(ctx context.Context, opts RebuildOptions) (RebuildReport, error) {
+	report, err := b.metadataService.RebuildIndex(ctx, opts)
+	if err != nil {
+		return report, b.logger.WrapError("failed to rebuild index", err)
+	}
+
+	verb := "Rebuilt"
+	if opts.DryRun {
+		verb = "Dry run of rebuilding"
+	}
+	b.logger.Infof("🗂️ %s index from %d sidecars: %d folders and %d files created, %d conflicts", verb, report.Sidecars, report.FoldersCreated, report.FilesCreated, len(report.Conflicts))
+	return report, nil
+}
+
+/* Export */
+
+// Export writes the whole instance to w as a tar.gz archive: a versioned JSON
+// manifest of the users, folders and files, followed by the content of every
+// file. The archive can be imported with Import into a client with any
+// database driver and backend, for example to move from SQLite to Postgres or
+// from local storage to S3. Soft deleted folders and files are exported too,
+// renditions are generated again after an import.
+//
+// With opts.ManifestOnly the content is left out, for moving the index to
+// another database while the objects stay in the same backend.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - w: The writer the archive is written to.
+//   - opts: Whether to leave the content out and a callback for the progress.
+//
+// Returns:
+//   - ExportReport: The number of users, folders, files and bytes exported.
+//   - error: An error if the index could not be read or the object of a file is missing.
+func (b *Client) Export(ctx context.Context, w io.Writer, opts ExportOptions) (ExportReport, error) {
+	report, err := b.exportService.Export(ctx, w, opts)
+	if err != nil {
+		return report, b.logger.WrapError("failed to export", err)
+	}
+
+	b.logger.Infof("📦 Exported %d users, %d folders and %d files (%d bytes)", report.Users, report.Folders, report.Files, report.Bytes)
+	return report, nil
+}
+
+// Import restores an archive written by Export into the database and backend
+// of the client. Folders and files keep the IDs they were exported with unless
+// opts.RemapIDs is set. Folders whose path is already indexed are merged, files
+// whose path or name is taken and content that does not match its checksum are
+// reported as conflicts. Files of a manifest only export are indexed when their
+// object is in the backend of the client.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - r: The reader of the archive.
+//   - opts: Whether to give the imported folders and files new IDs and a callback for the progress.
+//
+// Returns:
+//   - InstanceImportReport: What was created, the IDs that changed and the conflicts.
+//   - error: ErrInvalidExport if r is not an export, or an error if the archive could not be read.
+func (b *Client) Import(ctx context.Context, r io.Reader, opts InstanceImportOptions) (InstanceImportReport, error) {
+	report, err := b.exportService.Import(ctx, r, opts)
+	if err != nil {
+		return report, b.logger.WrapError("failed to import", err)
+	}
+
+	b.logger.Infof("📦 Imported %d folders and %d files (%d bytes), %d conflicts", report.FoldersCreated, report.FilesCreated, report.Bytes, len(report.Conflicts))
+	return report, nil
+}
+
+/* Erasure Coding */
+
+// HealDisks rebuilds missing, corrupt and stale shards of all erasure coded files,
+// for example after a failed disk has been replaced.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - HealReport: The number of files scanned and healed, and the files that could not be recovered.
+//   - error: An error if erasure coding is not enabled or the files could not be listed.
+func (b *Client) HealDisks(ctx context.Context) (HealReport, error) {
+	if b.erasure == nil {
+		return HealReport{}, fmt.Errorf("erasure coding is not enabled")
+	}
+
+	report, err := b.erasure.Heal(ctx, "")
+	if err != nil {
+		return report, b.logger.WrapError("failed to heal disks", err)
+	}
+
+	b.logger.Infof("🧩 Healed %d files, rebuilt %d shards, %d unrecoverable", report.Healed, report.Shards, len(report.Unrecoverable))
+	return report, nil
+}
+
+// DiskHealth reports whether every disk used for erasure coding is online and writable,
+// and how many shards it holds.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - []DiskHealth: The health of every configured disk.
+//   - error: An error if erasure coding is not enabled.
+func (b *Client) DiskHealth(ctx context.Context) ([]DiskHealth, error) {
+	if b.erasure == nil {
+		return nil, fmt.Errorf("erasure coding is not enabled")
+	}
+	return b.erasure.DiskHealth(ctx), nil
+}
+
+/* Replication */
+
+// RepairReplicas reconciles all replicas: objects that are missing or differ from
+// the majority of the replicas are rewritten with the majority version. Objects
+// whose copies differ without a majority are counted as failed, VerifyFile
+// repairs them from the copy matching the file's checksum.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - ReplicationReport: The number of objects scanned, repaired and failed.
+//   - error: An error if replication is not enabled or the replicas could not be listed.
+func (b *Client) RepairReplicas(ctx context.Context) (ReplicationReport, error) {
+	if b.replicated == nil {
+		return ReplicationReport{}, fmt.Errorf("replication is not enabled")
+	}
+
+	report, err := b.replicated.AntiEntropy(ctx, "")
+	if err != nil {
+		return report, b.logger.WrapError("failed to repair replicas", err)
+	}
+
+	b.logger.Infof("🪞 Anti-entropy scanned %d objects, repaired %d copies, %d failed", report.Scanned, report.Repaired, report.Failed)
+	return report, nil
+}
+
+/* Encryption */
+
+// RewrapKeys re-wraps the data keys of all objects that are not wrapped with the
+// current master key of the configured KeyProvider. Object content is not re-encrypted.
+// Call it after rotating master keys so older keys can be retired.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - int: The number of objects re-wrapped.
+//   - error: An error if encryption is not enabled or the operation fails.
+func (b *Client) RewrapKeys(ctx context.Context) (int, error) {
+	if b.encrypted == nil {
+		return 0, fmt.Errorf("encryption is not enabled")
+	}
+
+	n, err := b.encrypted.RewrapAll(ctx, "")
+	if err != nil {
+		return n, b.logger.WrapError("failed to re-wrap keys", err)
+	}
+
+	b.logger.Infof("🔐 Re-wrapped %d objects with the current master key", n)
+	return n, nil
+}
+
 /* Migration */
 
+// MigrateAll copies every object of the source backend to the target backend
+// of a migration, see EnableMigration. Every copy is read back and compared
+// with the source by size and checksum before it is committed, and before the
+// source is deleted when MigrationConfig.DeleteSource is set. Objects the target
+// already holds with the same content are not copied again.
+//
+// The progress is recorded in the database: a migration that was interrupted
+// or had failures is resumed by the next call, copying only the objects that
+// were not committed. It returns once all objects are copied, run it in a
+// goroutine and follow it with MigrationStatus.
+//
+// Parameters:
+//   - ctx: The context for the operation, cancelling it stops the migration.
+//
+// Returns:
+//   - error: An error if migration is not enabled, a migration is already running
+//     or objects could not be copied and verified after retrying.
+func (b *Client) MigrateAll(ctx context.Context) error {
+	if b.migration == nil {
+		return fmt.Errorf("migration is not enabled")
+	}
+
+	if err := b.migration.MigrateAll(ctx); err != nil {
+		return b.logger.WrapError("failed to migrate", err)
+	}
+	return nil
+}
+
+// MigrationStatus reports the progress of the migration run started last. The
+// progress of every object is kept in the database, in the migration_models
+// table, so it can also be followed from another instance sharing the database.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - completed: The number of objects copied or found in the target.
+//   - total: The number of objects to migrate.
+//   - error: An error if migration is not enabled.
+func (b *Client) MigrationStatus(ctx context.Context) (completed int64, total int64, err error) {
+	if b.migration == nil {
+		return 0, 0, fmt.Errorf("migration is not enabled")
+	}
+
+	completed, total = b.migration.MigrationStatus(ctx)
+	return completed, total, nil
+}
+
+// StartMigration starts copying the objects under prefix from the source to
+// the target backend of a migration in the background, like MigrateAll for
+// the whole source. Follow it with MigrationProgress or SubscribeMigration and
+// control it with PauseMigration, ResumeMigration and CancelMigration. Only a
+// migration of the whole source completes it for the cutover.
+//
+// Parameters:
+//   - prefix: The prefix of the objects to copy, empty for all objects.
+//   - opts: Options such as the number of objects copied at once.
+//
+// Returns:
+//   - error: ErrMigrationRunning if a migration is already running, or an error
+//     if migration is not enabled.
+func (b *Client) StartMigration(prefix string, opts MigrationOptions) error {
+	if b.migrationService == nil {
+		return fmt.Errorf("migration is not enabled")
+	}
+
+	if err := b.migrationService.Start(prefix, opts); err != nil {
+		return err
+	}
+	b.logger.Infof("🔄 Started migration of %q", prefix)
+	return nil
+}
+
+// PauseMigration pauses the migration started with StartMigration. Objects
+// that are being copied are finished, no new ones are started until
+// ResumeMigration.
+//
+// Returns:
+//   - error: ErrMigrationNotRunning if no migration is running, or an error if
+//     migration is not enabled.
+func (b *Client) PauseMigration() error {
+	if b.migrationService == nil {
+		return fmt.Errorf("migration is not enabled")
+	}
+	return b.migrationService.Pause()
+}
+
+// ResumeMigration resumes the migration paused with PauseMigration.
+//
+// Returns:
+//   - error: ErrMigrationNotPaused if the migration is not paused, or an error if
+//     migration is not enabled.
+func (b *Client) ResumeMigration() error {
+	if b.migrationService == nil {
+		return fmt.Errorf("migration is not enabled")
+	}
+	return b.migrationService.Resume()
+}
+
+// CancelMigration stops the migration started with StartMigration and returns
+// once it stopped. The objects that were not copied yet are copied by the next
+// migration.
+//
+// Returns:
+//   - error: ErrMigrationNotRunning if no migration is running, or an error if
+//     migration is not enabled.
+func (b *Client) CancelMigration() error {
+	if b.migrationService == nil {
+		return fmt.Errorf("migration is not enabled")
+	}
+	return b.migrationService.Cancel()
+}
+
+// MigrationProgress reports the progress of the migration started last with
+// StartMigration: its state, the objects and bytes copied, the objects that
+// failed, the throughput and the estimated time left.
+//
+// Returns:
+//   - MigrationProgress: The progress, in MigrationIdle before the first migration.
+//   - error: An error if migration is not enabled.
+func (b *Client) MigrationProgress() (MigrationProgress, error) {
+	if b.migrationService == nil {
+		return MigrationProgress{}, fmt.Errorf("migration is not enabled")
+	}
+	return b.migrationService.Progress(), nil
+}
+
+// SubscribeMigration subscribes to the events of migrations started with
+// StartMigration. An event is sent for every object copied or failed, with
+// the error it failed with, and for every change of the state. Events are
+// dropped for subscribers that fall behind.
+//
+// Returns:
+//   - <-chan MigrationEvent: The events, closed once unsubscribed.
+//   - func(): Ends the subscription.
+//   - error: An error if migration is not enabled.
+func (b *Client) SubscribeMigration() (<-chan MigrationEvent, func(), error) {
+	if b.migrationService == nil {
+		return nil, nil, fmt.Errorf("migration is not enabled")
+	}
+	events, unsubscribe := b.migrationService.Subscribe()
+	return events, unsubscribe, nil
+}
+
+// Cutover moves the migration to the given cutover phase, switching reads and
+// writes from the source to the target backend without a restart:
+// CutoverDualWrite, CutoverReadTarget, CutoverTargetOnly and finally
+// CutoverDecommissioned. The phase is stored in the database so every instance
+// sharing it follows, instances pick up changes made elsewhere within
+// MigrationConfig.CutoverRefresh. Until the source is decommissioned writes go
+// to both backends, so instances that have not picked up a change yet and
+// rollbacks still find the source up to date.
+//
+// The phase moves forward one step at a time and only once the last MigrateAll
+// committed every object, it moves back to any earlier phase to roll back until
+// the source is decommissioned.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//   - phase: The phase to move to.
+//
+// Returns:
+//   - error: ErrCutoverNotVerified if the migration has objects left to copy,
+//     ErrInvalidCutover if the phase cannot be reached from the current one, or
+//     an error if migration is not enabled.
+func (b *Client) Cutover(ctx context.Context, phase CutoverPhase) error {
+	if b.migration == nil {
+		return fmt.Errorf("migration is not enabled")
+	}
+
+	if err := b.migration.Cutover(ctx, phase); err != nil {
+		return b.logger.WrapError("failed to cut over migration", err)
+	}
+	return nil
+}
+
+// CutoverPhase returns the cutover phase of the migration, see Cutover.
+//
+// Parameters:
+//   - ctx: The context for the operation.
+//
+// Returns:
+//   - CutoverPhase: The phase stored in the database.
+//   - error: An error if migration is not enabled or the phase could not be read.
+func (b *Client) CutoverPhase(ctx context.Context) (CutoverPhase, error) {
+	if b.migration == nil {
+		return "", fmt.Errorf("migration is not enabled")
+	}
+
+	return b.migration.CutoverPhase(ctx)
+}
+
+/* Throttling */
+
+// SetThrottle replaces the limits of background jobs at runtime, see
+// WithThrottle. Jobs that are running pick up the new limits with their next
+// backend call.
+//
+// Parameters:
+//   - cfg: The limits and their windows.
+//
+// Returns:
+//   - error: An error if a limit is negative or a time of day is not "15:04".
+func (b *Client) SetThrottle(cfg ThrottleConfig) error {
+	if err := b.throttle.SetConfig(cfg); err != nil {
+		return b.logger.WrapError("invalid throttle configuration", err)
+	}
+	b.logger.Infof("🚦 Throttling background jobs to %+v", cfg.Limits)
+	return nil
+}
+
+// Throttle returns the limits of background jobs and their windows.
+//
+// Returns:
+//   - ThrottleConfig: The configuration set with WithThrottle or SetThrottle.
+func (b *Client) Throttle() ThrottleConfig {
+	return b.throttle.Config()
+}
+
+// ThrottleLimits returns the limits of background jobs that apply now.
+//
+// Returns:
+//   - ThrottleLimits: The limits of the window containing the current time, or the default limits.
+func (b *Client) ThrottleLimits() ThrottleLimits {
+	return b.throttle.Limits()
+}
+
 /* Helper Methods */
 
 func initializeCache(conf CacheConfig, bucktLog domain.BucktLogger) (domain.CacheManager, domain.LRUCache) {
@@ -642,44 +1614,58 @@ func newAppServices(
 	logger domain.BucktLogger,
 	cacheManager domain.CacheManager,
 	activeBackend domain.FileBackend,
+	metadata domain.MetadataService,
+	journal domain.OperationRepository,
+	instanceID uuid.UUID,
+	fileOpts ...service.FileServiceOption,
 ) (domain.FolderService, domain.FileService) {
 	// Initialize the stores
 	var folderRepository domain.FolderRepository = repository.NewFolderRepository(db)
 	var fileRepository domain.FileRepository = repository.NewFileRepository(db)
 
+	var folderOpts []service.FolderServiceOption
+	if metadata != nil {
+		folderOpts = append(folderOpts, service.WithFolderMetadata(metadata))
+		fileOpts = append(fileOpts, service.WithMetadata(metadata))
+	}
+	if journal != nil {
+		folderOpts = append(folderOpts, service.WithFolderJournal(journal, instanceID))
+		fileOpts = append(fileOpts, service.WithJournal(journal, instanceID))
+	}
+
 	// initialize the services
-	var folderService domain.FolderService = service.NewFolderService(logger, cacheManager, folderRepository, activeBackend)
-	var fileService domain.FileService = service.NewFileService(logger, cacheManager, fileRepository, folderService, activeBackend, flatNameSpaces)
+	var folderService domain.FolderService = service.NewFolderService(logger, cacheManager, folderRepository, activeBackend, folderOpts...)
+	var fileService domain.FileService = service.NewFileService(logger, cacheManager, fileRepository, folderService, activeBackend, flatNameSpaces, fileOpts...)
 
 	logger.Info("✅ Initialized app services")
 
 	return folderService, fileService
 }
 
-func resolveBackend(mediaDir string, bc BackendConfig, log domain.BucktLogger, lru domain.LRUCache) Backend {
+func resolveBackend(bc BackendConfig, log domain.BucktLogger, newLocal func() Backend, migrations domain.MigrationRepository, throttle *backend.Throttle) Backend {
 	if bc.MigrationEnabled {
 		var source, target Backend
 
 		// Fallback logic for source
 		if bc.Source != nil {
-			source = instantiateIfLocal(bc.Source, mediaDir, log, lru)
+			source = instantiateIfLocal(bc.Source, newLocal)
 		} else {
 			log.Warn("⚠️ Migration enabled but source backend missing — falling back to local as source")
-			source = backend.NewLocalFileSystemService(log, mediaDir, lru)
+			source = newLocal()
 		}
 
 		// Fallback logic for target
 		if bc.Target != nil {
-			target = instantiateIfLocal(bc.Target, mediaDir, log, lru)
+			target = instantiateIfLocal(bc.Target, newLocal)
 		} else {
 			log.Warn("⚠️ Migration enabled but target backend missing — falling back to local as target")
-			target = backend.NewLocalFileSystemService(log, mediaDir, lru)
+			target = newLocal()
 		}
 
 		// ensure both source and target are set and different
 		if source == nil || target == nil {
 			log.Errorf("❌ Migration enabled but one of the backends is nil — falling back to local")
-			return backend.NewLocalFileSystemService(log, mediaDir, lru)
+			return newLocal()
 		}
 
 		if source == target {
@@ -688,27 +1674,27 @@ func resolveBackend(mediaDir string, bc BackendConfig, log domain.BucktLogger, l
 		}
 
 		log.Infof("🔄 Migration mode: %s → %s", source.Name(), target.Name())
-		return backend.NewMigrationBackend(log, source, target)
+		return backend.NewMigrationBackend(log, source, target, bc.Migration, migrations, throttle)
 	}
 
 	// Non-migration modes
 	switch {
 	case bc.Source != nil:
-		return instantiateIfLocal(bc.Source, mediaDir, log, lru)
+		return instantiateIfLocal(bc.Source, newLocal)
 
 	case bc.Target != nil:
 		log.Warn("⚠️ Using target backend as primary because source is missing")
-		return instantiateIfLocal(bc.Target, mediaDir, log, lru)
+		return instantiateIfLocal(bc.Target, newLocal)
 
 	default:
 		log.Warn("⚠️ No backend configured, falling back to local")
-		return backend.NewLocalFileSystemService(log, mediaDir, lru)
+		return newLocal()
 	}
 }
 
-func instantiateIfLocal(b Backend, mediaDir string, log domain.BucktLogger, lru domain.LRUCache) Backend {
+func instantiateIfLocal(b Backend, newLocal func() Backend) Backend {
 	if b.Name() == "local" {
-		return backend.NewLocalFileSystemService(log, mediaDir, lru)
+		return newLocal()
 	}
 	return b
 }
@@ -3,9 +3,12 @@ package buckt
 import (
 	"database/sql"
 	"log"
+	"time"
 
+	"github.com/Rhaqim/buckt/internal/backend"
 	"github.com/Rhaqim/buckt/internal/domain"
 	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/internal/scanner"
 )
 
 type DBDrivers = model.DBDrivers // Type alias
@@ -67,15 +70,58 @@ func (f *FileCacheConfig) Validate() {
 	}
 }
 
+// EvictionPolicy selects which entries the disk cache evicts first.
+type EvictionPolicy = model.EvictionPolicy
+
+const (
+	// EvictLRU evicts the least recently used entries first.
+	EvictLRU = model.EvictLRU
+	// EvictLFU evicts the least frequently used entries first.
+	EvictLFU = model.EvictLFU
+)
+
+// CacheStats reports the hit and miss counters of the file caches.
+type CacheStats = model.CacheStats
+
+// DiskCacheConfig holds the configuration for the on-disk file cache.
+// It is used to cache files of remote backends such as S3, GCS or Azure.
+//
+// Fields:
+//
+//	Dir: Directory the cache is stored in. The disk cache is disabled when empty.
+//	MaxSize: The maximum size of the cache in bytes.
+//	Policy: The eviction policy, EvictLRU or EvictLFU.
+type DiskCacheConfig struct {
+	Dir     string
+	MaxSize int64
+	Policy  EvictionPolicy
+}
+
+// Validate sets default values for the disk cache configuration.
+// The default values are:
+//
+//	MaxSize: 10 << 30 (10 GB)
+//	Policy: EvictLRU
+func (d *DiskCacheConfig) Validate() {
+	if d.MaxSize <= 0 {
+		d.MaxSize = 10 << 30 // 10GB
+	}
+	if d.Policy == "" {
+		d.Policy = EvictLRU
+	}
+}
+
 // CacheConfig holds the configuration for the cache manager.
 // It includes the cache manager instance and file cache configuration.
 // Fields:
 //
 //	Manager: The cache manager instance.
 //	FileCacheConfig: The file cache configuration.
+//	Disk: The on-disk file cache configuration, the file cache is used in front of it.
 type CacheConfig struct {
 	Manager domain.CacheManager
 	FileCacheConfig
+	Disk DiskCacheConfig
 }
 
 // LogConfig holds the configuration for logging in the application.
@@ -116,6 +162,186 @@ type BackendConfig struct {
 
 	// MigrationEnabled enables dual-write migration mode.
 	MigrationEnabled bool
+
+	// Migration tunes how MigrateAll copies existing objects to the target.
+	Migration MigrationConfig
+
+	// Erasure stores local files as erasure coded shards over several disks
+	// instead of a single media directory.
+	Erasure ErasureConfig
+
+	// Replication mirrors the resolved backend to additional replicas.
+	Replication ReplicationConfig
+
+	// Encryption enables encryption at rest on top of the resolved backend.
+	Encryption EncryptionConfig
+
+	// Compression enables transparent compression on top of the resolved backend.
+	// Objects are compressed before they are encrypted.
+	Compression CompressionConfig
+}
+
+// MigrationConfig tunes how MigrateAll copies existing objects to the target backend.
+//
+// Fields:
+//
+//	ID: Identifies the migration in the database, the same on every instance running it. Defaults to "<source>-><target>" with the backend names, set it when another migration between backends of the same names shares the database.
+//	Concurrency: Number of objects copied at once, defaults to 8.
+//	Retries: How often a failed copy is retried, defaults to 3.
+//	RetryBackoff: Wait before the first retry, doubled for every next one, defaults to 500ms.
+//	DeleteSource: Delete objects from the source once their copy in the target is verified.
+//	Lazy: Serve reads from the target and promote objects missing there from the source when they are read.
+//	LazySync: Promote objects before the read returns instead of in the background.
+//	CutoverRefresh: How often the cutover phase is reloaded from the database, defaults to 10s.
+type MigrationConfig = model.MigrationConfig
+
+// MigrationOptions configure a migration started with StartMigration.
+//
+// Fields:
+//
+//	Concurrency: Number of objects copied at once, overrides MigrationConfig.Concurrency when set.
+type MigrationOptions = model.MigrationOptions
+
+// MigrationProgress reports how far a migration started with StartMigration got.
+type MigrationProgress = model.MigrationProgress
+
+// MigrationEvent is sent to the subscribers of SubscribeMigration.
+type MigrationEvent = model.MigrationEvent
+
+// MigrationState is the state of a migration started with StartMigration.
+type MigrationState = model.MigrationState
+
+const (
+	// MigrationIdle is the state before the first migration was started.
+	MigrationIdle = model.MigrationIdle
+	// MigrationRunning is the state while objects are copied.
+	MigrationRunning = model.MigrationRunning
+	// MigrationPaused is the state between PauseMigration and ResumeMigration.
+	MigrationPaused = model.MigrationPaused
+	// MigrationCompleted is the state once every object was copied.
+	MigrationCompleted = model.MigrationCompleted
+	// MigrationFailed is the state once the migration stopped with objects that failed or an error.
+	MigrationFailed = model.MigrationFailed
+	// MigrationCancelled is the state after CancelMigration.
+	MigrationCancelled = model.MigrationCancelled
+)
+
+// ThrottleConfig limits the bandwidth and backend operations of background jobs:
+// migrations, integrity checks, reconciliation and exports. The limits can be
+// changed at runtime with SetThrottle.
+//
+// Fields:
+//
+//	Limits: The limits outside of the windows, zero values are unlimited.
+//	Windows: Limits for times of day, the first window containing the current time applies.
+type ThrottleConfig = model.ThrottleConfig
+
+// ThrottleLimits bound the bytes and backend operations per second, zero values are unlimited.
+type ThrottleLimits = model.ThrottleLimits
+
+// ThrottleWindow replaces the limits from one local time of day ("22:00") to
+// another ("06:00"), wrapping around midnight.
+type ThrottleWindow = model.ThrottleWindow
+
+// CutoverPhase is how far a migration switched from the source to the target backend.
+type CutoverPhase = model.CutoverPhase
+
+const (
+	// CutoverDualWrite writes to both backends and reads from the source.
+	CutoverDualWrite = model.CutoverDualWrite
+	// CutoverReadTarget writes to both backends and reads from the target.
+	CutoverReadTarget = model.CutoverReadTarget
+	// CutoverTargetOnly reads the target only and still writes the source, it can still be rolled back.
+	CutoverTargetOnly = model.CutoverTargetOnly
+	// CutoverDecommissioned no longer touches the source, it cannot be rolled back.
+	CutoverDecommissioned = model.CutoverDecommissioned
+)
+
+// HealReport summarises a heal pass over erasure coded storage.
+type HealReport = model.HealReport
+
+// DiskHealth reports the state of a disk used by erasure coded storage.
+type DiskHealth = model.DiskHealth
+
+// ErasureConfig holds the configuration for erasure coded local storage.
+//
+// Fields:
+//
+//	Dirs: Directories to spread shards over, ideally one per disk.
+//	Erasure coding is disabled when empty.
+//	DataShards: Number of data shards every object is split into.
+//	ParityShards: Number of parity shards, the number of shards that can be lost.
+//	WriteQuorum: Number of shards a write needs, between DataShards and
+//	DataShards+ParityShards. Defaults to DataShards+1, so every object
+//	survives the loss of at least one shard.
+type ErasureConfig struct {
+	Dirs         []string
+	DataShards   int
+	ParityShards int
+	WriteQuorum  int
+}
+
+// ReplicationReport summarises an anti-entropy pass over the replicas.
+type ReplicationReport = model.ReplicationReport
+
+// ReplicationConfig holds the configuration for replicated storage.
+//
+// Fields:
+//
+//	Replicas: Additional backends the resolved backend is replicated to.
+//	Replication is disabled when empty. Use LocalBackend() for the local file system.
+//	WriteQuorum: Number of replicas that must acknowledge a write, defaults to a majority.
+//	AntiEntropyInterval: How often all replicas are reconciled in the background.
+//	Zero disables the background job.
+type ReplicationConfig struct {
+	Replicas            []Backend
+	WriteQuorum         int
+	AntiEntropyInterval time.Duration
+}
+
+// KeyProvider supplies the master keys used to wrap per-object data keys.
+type KeyProvider = domain.KeyProvider
+
+// EncryptionConfig holds the configuration for encryption at rest.
+//
+// Fields:
+//
+//	KeyProvider: Source of master keys. Encryption is disabled when nil.
+//	ChunkSize: Plaintext size of each encrypted chunk, defaults to 64KB.
+//	RewrapInterval: How often objects wrapped with an old master key are re-wrapped
+//	with the current one. Zero disables the background job.
+//	AllowPlaintext: Returns objects that are not encrypted as-is instead of
+//	refusing them, to enable encryption on a backend holding plaintext objects.
+//	Their content is not authenticated.
+type EncryptionConfig struct {
+	KeyProvider    KeyProvider
+	ChunkSize      int
+	RewrapInterval time.Duration
+	AllowPlaintext bool
+}
+
+// CompressionConfig holds the configuration for transparent compression.
+//
+// Fields:
+//
+//	Enabled: Whether objects are compressed before they are stored.
+//	Level: The gzip compression level, defaults to gzip.DefaultCompression.
+//	MinSize: Objects smaller than this are stored as-is, defaults to 1KB.
+//	SkipContentTypes: Content types that are already compressed and stored as-is.
+//	Entries ending in "/" match a whole top level type (e.g. "image/").
+//	Defaults to common image, video, audio and archive types.
+type CompressionConfig struct {
+	Enabled          bool
+	Level            int
+	MinSize          int
+	SkipContentTypes []string
+}
+
+// NewStaticKeyProvider creates a KeyProvider from in-memory 32 byte AES-256 master keys.
+// New objects are wrapped with the key identified by currentID, the others are
+// only used to read objects until they have been re-wrapped.
+func NewStaticKeyProvider(currentID string, keys map[string][]byte) (KeyProvider, error) {
+	return backend.NewStaticKeyProvider(currentID, keys)
 }
 
 // LocalBackend is a placeholder and is replaced with the actual local backend implementation.
@@ -131,6 +357,250 @@ func LocalBackend() Backend {
 	return &domain.PlaceholderBackend{Title: "local"}
 }
 
+// UploadPolicy restricts which files can be uploaded: their size, extension and content type.
+// The content type is also sniffed from the first bytes of the file, see MIMEMismatchAction.
+type UploadPolicy = model.UploadPolicy
+
+// MIMEMismatchAction decides what happens when the declared content type of an
+// upload does not match its content.
+type MIMEMismatchAction = model.MIMEMismatchAction
+
+const (
+	// MIMEMismatchIgnore keeps the declared content type.
+	MIMEMismatchIgnore = model.MIMEMismatchIgnore
+	// MIMEMismatchReject rejects the upload with ErrMIMETypeMismatch.
+	MIMEMismatchReject = model.MIMEMismatchReject
+	// MIMEMismatchCorrect stores the file with the detected content type.
+	MIMEMismatchCorrect = model.MIMEMismatchCorrect
+)
+
+// RenditionSize configures a resized version generated for every uploaded image.
+type RenditionSize = model.RenditionSize
+
+// Rendition is a resized version of an uploaded image.
+type Rendition = model.RenditionModel
+
+// DefaultRenditionSizes are generated when WithRenditions is called without sizes.
+var DefaultRenditionSizes = []RenditionSize{
+	{Name: "thumb", Width: 150},
+	{Name: "small", Width: 480},
+	{Name: "medium", Width: 1024},
+}
+
+// RenditionConfig configures the renditions generated for uploaded images.
+//
+// Fields:
+//
+//	Sizes: The renditions generated for every image, none disables renditions.
+//	Workers: The number of images resized concurrently, defaults to 2.
+type RenditionConfig struct {
+	Sizes   []RenditionSize
+	Workers int
+}
+
+// ArchiveFormat is the format of folder and file selection downloads.
+type ArchiveFormat = model.ArchiveFormat
+
+const (
+	ArchiveZip   = model.ArchiveZip
+	ArchiveTarGz = model.ArchiveTarGz
+)
+
+// ConflictPolicy decides what happens to an extracted file when the folder
+// already has a file with the same name.
+type ConflictPolicy = model.ConflictPolicy
+
+const (
+	ConflictSkip      = model.ConflictSkip
+	ConflictOverwrite = model.ConflictOverwrite
+	ConflictRename    = model.ConflictRename
+)
+
+// ExtractLimits bound the archives that can be extracted, see model.ExtractLimits for the defaults.
+type ExtractLimits = model.ExtractLimits
+
+// ExtractReport lists what was created, overwritten or skipped when extracting an archive.
+type ExtractReport = model.ExtractReport
+
+// ExtractEntry is the outcome of a single archive entry.
+type ExtractEntry = model.ExtractEntry
+
+// ExtractAction is what happened to an archive entry or imported object.
+type ExtractAction = model.ExtractAction
+
+const (
+	ExtractCreated     = model.ExtractCreated
+	ExtractOverwritten = model.ExtractOverwritten
+	ExtractRenamed     = model.ExtractRenamed
+	ExtractExisting    = model.ExtractExisting
+	ExtractUnchanged   = model.ExtractUnchanged
+	ExtractReferenced  = model.ExtractReferenced
+	ExtractSkipped     = model.ExtractSkipped
+)
+
+// ImportMode decides whether ImportTree copies objects or references them in place.
+type ImportMode = model.ImportMode
+
+const (
+	ImportCopy      = model.ImportCopy
+	ImportReference = model.ImportReference
+)
+
+// ImportOptions configure ImportTree.
+type ImportOptions = model.ImportOptions
+
+// ImportReport lists what ImportTree created, referenced or skipped.
+type ImportReport = model.ImportReport
+
+// BatchOptions configures BatchDelete, BatchScrub, BatchMove and BatchUpload.
+type BatchOptions = model.BatchOptions
+
+// BatchResult is the outcome of a single item of a batch.
+type BatchResult = model.BatchResult
+
+// BatchUpload is a file uploaded with BatchUpload.
+type BatchUpload = model.BatchUpload
+
+// BatchItemType tells whether a batch item is a file or a folder.
+type BatchItemType = model.BatchItemType
+
+const (
+	BatchFile   = model.BatchFile
+	BatchFolder = model.BatchFolder
+)
+
+// ReconcileOptions select whether Reconcile deletes stray objects and marks missing files.
+type ReconcileOptions = model.ReconcileOptions
+
+// ReconcileReport lists the stray objects and missing files found by Reconcile.
+type ReconcileReport = model.ReconcileReport
+
+// StrayObject is an object in the backend no file refers to.
+type StrayObject = model.StrayObject
+
+// MissingFile is a file whose object is gone from the backend.
+type MissingFile = model.MissingFile
+
+// IntegrityStatus is the state of the stored content of a file.
+type IntegrityStatus = model.IntegrityStatus
+
+const (
+	IntegrityUnknown    = model.IntegrityUnknown
+	IntegrityOK         = model.IntegrityOK
+	IntegrityCorrupt    = model.IntegrityCorrupt
+	IntegrityMissing    = model.IntegrityMissing
+	IntegrityUnverified = model.IntegrityUnverified
+)
+
+// VerifyResult is the outcome of verifying the content of a file.
+type VerifyResult = model.VerifyResult
+
+// VerifyReport counts the outcomes of a verification run.
+type VerifyReport = model.VerifyReport
+
+// RebuildOptions select whether RebuildIndex only reports what it would index.
+type RebuildOptions = model.RebuildOptions
+
+// RebuildReport lists what RebuildIndex indexed and the sidecars it could not index.
+type RebuildReport = model.RebuildReport
+
+// RebuildConflict is a metadata sidecar RebuildIndex could not index.
+type RebuildConflict = model.RebuildConflict
+
+// ExportOptions select whether Export leaves the blobs out and report its progress.
+type ExportOptions = model.ExportOptions
+
+// ExportReport counts what Export wrote.
+type ExportReport = model.ExportReport
+
+// ExportManifest is the first entry of an export, describing its content.
+type ExportManifest = model.ExportManifest
+
+// ExportProgress reports how many files Export or Import handled so far.
+type ExportProgress = model.ExportProgress
+
+// InstanceImportOptions select whether Import keeps the exported IDs and report its progress.
+type InstanceImportOptions = model.InstanceImportOptions
+
+// InstanceImportReport lists what Import created and what it could not import.
+type InstanceImportReport = model.InstanceImportReport
+
+// ExportConflict is a folder or file Import could not import.
+type ExportConflict = model.ExportConflict
+
+// IntegrityConfig configures the background verification of stored content.
+//
+// Fields:
+//
+//	Interval: How often files due for verification are checked, 0 disables it.
+//	MaxAge: How long a verification holds before the file is due again.
+//	BatchSize: How many files are verified per run.
+type IntegrityConfig struct {
+	Interval  time.Duration
+	MaxAge    time.Duration
+	BatchSize int
+}
+
+// ReconcileConfig configures the background reconciliation of the backend with the file table.
+//
+// Fields:
+//
+//	Interval: How often the backend is reconciled, 0 disables it.
+//	Options: Whether stray objects are deleted and missing files marked.
+//	ReportFile: Where the JSON report of the last run is written, none only logs a summary.
+type ReconcileConfig struct {
+	Interval   time.Duration
+	Options    ReconcileOptions
+	ReportFile string
+}
+
+// Scanner checks uploads for malware, see NewClamdScanner.
+type Scanner = domain.Scanner
+
+// ScanResult is the verdict of a Scanner.
+type ScanResult = model.ScanResult
+
+// ScanMode decides when uploads are scanned.
+type ScanMode = model.ScanMode
+
+const (
+	// ScanSync scans uploads before they are stored, infected uploads are rejected with ErrFileInfected.
+	ScanSync = model.ScanSync
+	// ScanAsync stores uploads as pending and scans them in the background.
+	// Pending and infected files cannot be downloaded.
+	ScanAsync = model.ScanAsync
+)
+
+// ScanStatus is the antivirus scan state of a file.
+type ScanStatus = model.ScanStatus
+
+const (
+	ScanStatusNone     = model.ScanStatusNone
+	ScanStatusPending  = model.ScanStatusPending
+	ScanStatusClean    = model.ScanStatusClean
+	ScanStatusInfected = model.ScanStatusInfected
+)
+
+// ScanConfig configures antivirus scanning of uploads.
+//
+// Fields:
+//
+//	Scanner: The scanner uploads are checked with, nil disables scanning.
+//	Mode: Whether uploads are scanned before they are stored or in the background.
+//	RescanInterval: How often files still pending are scanned again in async mode, 0 disables it.
+type ScanConfig struct {
+	Scanner        Scanner
+	Mode           ScanMode
+	RescanInterval time.Duration
+}
+
+// NewClamdScanner creates a Scanner for a clamd daemon listening at address,
+// either "host:port" or "unix:/path/to/clamd.sock". Content is streamed with the
+// INSTREAM command. A timeout of 0 defaults to one minute.
+func NewClamdScanner(address string, timeout time.Duration) Scanner {
+	return scanner.NewClamdScanner(address, timeout)
+}
+
 // Config represents the configuration options for the Buckt application.
 // It includes settings for logging, media directory, and standalone mode.
 //
@@ -141,9 +611,25 @@ func LocalBackend() Backend {
 //	Log: Configuration for logging.
 //	MediaDir: Path to the directory where media files are stored.
 //	FlatNameSpaces: Flag indicating whether the application should use flat namespaces when storing files.
+//	UploadPolicy: Restrictions every upload is validated against, nil accepts any file.
+//	Scan: Antivirus scanning of uploads.
+//	Renditions: Resized versions generated for uploaded images.
+//	Extract: Limits on the archives that can be extracted.
+//	Reconcile: Background reconciliation of the backend with the file table.
+//	Integrity: Background verification of stored content against its checksum.
+//	MetadataSidecars: Whether a metadata sidecar is written for every object, see Client.RebuildIndex.
 type Config struct {
 	MediaDir       string
 	FlatNameSpaces bool
+	UploadPolicy   *UploadPolicy
+	Scan           ScanConfig
+	Renditions     RenditionConfig
+	Extract        ExtractLimits
+	Reconcile      ReconcileConfig
+	Integrity      IntegrityConfig
+	Throttle       ThrottleConfig
+
+	MetadataSidecars bool
 
 	DB      DBConfig
 	Cache   CacheConfig
@@ -186,6 +672,21 @@ func WithCache(cache CacheConfig) ConfigFunc {
 	}
 }
 
+// WithDiskCache enables a read-through on-disk cache in front of the backend,
+// with the in-memory file cache in front of it. Files are stored in the
+// cache as they are stored in the backend, encrypted if encryption is enabled.
+//
+// Parameters:
+//   - conf: The DiskCacheConfig to use.
+//
+// Returns:
+//   - A ConfigFunc that sets the disk cache configuration.
+func WithDiskCache(conf DiskCacheConfig) ConfigFunc {
+	return func(c *Config) {
+		c.Cache.Disk = conf
+	}
+}
+
 // WithLog is a configuration function that sets the logger for the Config.
 // It takes a Log instance as an argument and assigns it to the Log field of Config.
 //
@@ -226,6 +727,131 @@ func FlatNameSpaces(flat bool) ConfigFunc {
 	}
 }
 
+// WithUploadPolicy validates every upload against the given policy before
+// anything is stored. Violations are reported with ErrFileTooLarge,
+// ErrExtensionNotAllowed, ErrMIMETypeNotAllowed or ErrMIMETypeMismatch.
+//
+// Parameters:
+//   - policy: The UploadPolicy to enforce.
+//
+// Returns:
+//   - A ConfigFunc that sets the upload policy.
+func WithUploadPolicy(policy UploadPolicy) ConfigFunc {
+	return func(c *Config) {
+		c.UploadPolicy = &policy
+	}
+}
+
+// WithScanner scans every upload with the given scanner. In ScanSync mode
+// infected uploads are rejected with ErrFileInfected, and uploads are rejected
+// when the scanner cannot be reached. In ScanAsync mode files are stored as
+// pending and cannot be downloaded until they are found clean; files whose
+// scan failed are scanned again every 10 minutes.
+//
+// Parameters:
+//   - scanner: The Scanner to use, e.g. NewClamdScanner("localhost:3310", 0).
+//   - mode: ScanSync or ScanAsync.
+//
+// Returns:
+//   - A ConfigFunc that sets the scan configuration.
+func WithScanner(scanner Scanner, mode ScanMode) ConfigFunc {
+	return func(c *Config) {
+		c.Scan.Scanner = scanner
+		c.Scan.Mode = mode
+		if mode == ScanAsync && c.Scan.RescanInterval == 0 {
+			c.Scan.RescanInterval = 10 * time.Minute
+		}
+	}
+}
+
+// WithRenditions generates resized versions of every uploaded JPEG, PNG or GIF
+// image in the background. They are stored next to the original, regenerated
+// when the file is updated and deleted with it. Renditions that are not
+// generated yet are generated when they are first requested.
+//
+// Parameters:
+//   - sizes: The renditions to generate, DefaultRenditionSizes when empty.
+//
+// Returns:
+//   - A ConfigFunc that sets the rendition configuration.
+func WithRenditions(sizes ...RenditionSize) ConfigFunc {
+	return func(c *Config) {
+		if len(sizes) == 0 {
+			sizes = DefaultRenditionSizes
+		}
+		c.Renditions.Sizes = sizes
+	}
+}
+
+// WithExtractLimits sets the limits archives are checked against when they are
+// extracted. Zero values keep the defaults of 1GB, 10000 entries and a
+// compression ratio of 100.
+//
+// Parameters:
+//   - limits: The ExtractLimits to use.
+//
+// Returns:
+//   - A ConfigFunc that sets the extraction limits.
+func WithExtractLimits(limits ExtractLimits) ConfigFunc {
+	return func(c *Config) {
+		c.Extract = limits
+	}
+}
+
+// WithReconciliation reconciles the backend with the file table in the background,
+// every 24 hours unless conf.Interval is set. See Client.Reconcile.
+//
+// Parameters:
+//   - conf: The ReconcileConfig to use.
+//
+// Returns:
+//   - A ConfigFunc that sets the reconciliation configuration.
+func WithReconciliation(conf ReconcileConfig) ConfigFunc {
+	return func(c *Config) {
+		if conf.Interval == 0 {
+			conf.Interval = 24 * time.Hour
+		}
+		c.Reconcile = conf
+	}
+}
+
+// WithMetadataSidecars writes a JSON sidecar describing the file for every
+// object, and one per folder, so the index can be rebuilt from the backend
+// alone with Client.RebuildIndex if the database is lost. Sidecars are kept
+// under the reserved .buckt/ prefix of the backend.
+//
+// Returns:
+//   - A ConfigFunc that enables metadata sidecars.
+func WithMetadataSidecars() ConfigFunc {
+	return func(c *Config) {
+		c.MetadataSidecars = true
+	}
+}
+
+// WithIntegrityChecks verifies stored content against its checksum in the
+// background. Unless set in conf, up to 1000 files not verified for 7 days are
+// checked every hour. See Client.VerifyFiles.
+//
+// Parameters:
+//   - conf: The IntegrityConfig to use.
+//
+// Returns:
+//   - A ConfigFunc that sets the integrity check configuration.
+func WithIntegrityChecks(conf IntegrityConfig) ConfigFunc {
+	return func(c *Config) {
+		if conf.Interval == 0 {
+			conf.Interval = time.Hour
+		}
+		if conf.MaxAge == 0 {
+			conf.MaxAge = 7 * 24 * time.Hour
+		}
+		if conf.BatchSize == 0 {
+			conf.BatchSize = 1000
+		}
+		c.Integrity = conf
+	}
+}
+
 // RegisterPrimaryBackend registers the primary backend for the Buckt application.
 func RegisterPrimaryBackend(backend Backend) ConfigFunc {
 	return func(c *Config) {
@@ -246,3 +872,114 @@ func EnableMigration() ConfigFunc {
 		c.Backend.MigrationEnabled = true
 	}
 }
+
+// WithMigration enables dual-write migration mode and tunes how MigrateAll
+// copies existing objects from the source to the target backend.
+//
+// Parameters:
+//   - cfg: The concurrency and retries of the copy, zero values use the defaults.
+//
+// Returns:
+//   - A ConfigFunc that enables migration with the given configuration.
+func WithMigration(cfg MigrationConfig) ConfigFunc {
+	return func(c *Config) {
+		c.Backend.MigrationEnabled = true
+		c.Backend.Migration = cfg
+	}
+}
+
+// WithErasureCoding stores local files as Reed-Solomon erasure coded shards spread over
+// the given directories, ideally one per disk. Every object is split into dataShards
+// data shards and parityShards parity shards and survives the loss of up to
+// parityShards of them.
+//
+// Parameters:
+//   - dirs: The directories to spread shards over.
+//   - dataShards: The number of data shards.
+//   - parityShards: The number of parity shards.
+//
+// Returns:
+//   - A ConfigFunc that sets the erasure coding configuration of the backend.
+func WithErasureCoding(dirs []string, dataShards, parityShards int) ConfigFunc {
+	return func(c *Config) {
+		c.Backend.Erasure = ErasureConfig{
+			Dirs:         dirs,
+			DataShards:   dataShards,
+			ParityShards: parityShards,
+		}
+	}
+}
+
+// WithReplication replicates every object of the resolved backend to the given replicas.
+// Writes succeed once a majority of all backends acknowledged them, reads are served
+// by the first healthy backend and missing or divergent copies are repaired.
+// All replicas are reconciled in the background every 6 hours.
+//
+// Parameters:
+//   - replicas: The backends to replicate to.
+//
+// Returns:
+//   - A ConfigFunc that sets the replication configuration of the backend.
+func WithReplication(replicas ...Backend) ConfigFunc {
+	return func(c *Config) {
+		c.Backend.Replication.Replicas = append(c.Backend.Replication.Replicas, replicas...)
+		if c.Backend.Replication.AntiEntropyInterval == 0 {
+			c.Backend.Replication.AntiEntropyInterval = 6 * time.Hour
+		}
+	}
+}
+
+// WithCompression enables transparent gzip compression of stored objects.
+// Already compressed content such as images, video and archives is stored as-is.
+// Sizes reported for files remain the logical, uncompressed size.
+//
+// Parameters:
+//   - conf: The CompressionConfig to use, Enabled is set automatically.
+//
+// Returns:
+//   - A ConfigFunc that sets the compression configuration of the backend.
+func WithCompression(conf CompressionConfig) ConfigFunc {
+	return func(c *Config) {
+		conf.Enabled = true
+		c.Backend.Compression = conf
+	}
+}
+
+// WithEncryption enables encryption at rest using AES-256-GCM envelope encryption.
+// Every object is encrypted with its own data key, which is wrapped by a master key
+// from the provided KeyProvider. Objects wrapped with an older master key are
+// re-wrapped in the background every 24 hours.
+//
+// Parameters:
+//   - keys: The KeyProvider supplying master keys.
+//
+// Returns:
+//   - A ConfigFunc that sets the encryption configuration of the backend.
+func WithEncryption(keys KeyProvider) ConfigFunc {
+	return func(c *Config) {
+		c.Backend.Encryption.KeyProvider = keys
+		if c.Backend.Encryption.RewrapInterval == 0 {
+			c.Backend.Encryption.RewrapInterval = 24 * time.Hour
+		}
+	}
+}
+
+// WithThrottle limits the bandwidth and backend operations of background jobs
+// such as migrations, integrity checks, reconciliation and exports. Reads and
+// writes of users are not throttled.
+//
+//	WithThrottle(ThrottleConfig{
+//		Limits:  ThrottleLimits{BytesPerSecond: 10 << 20, OpsPerSecond: 50},
+//		Windows: []ThrottleWindow{{From: "22:00", To: "06:00"}}, // Full speed at night
+//	})
+//
+// Parameters:
+//   - cfg: The limits and their windows.
+//
+// Returns:
+//   - A ConfigFunc that sets the throttle configuration.
+func WithThrottle(cfg ThrottleConfig) ConfigFunc {
+	return func(c *Config) {
+		c.Throttle = cfg
+	}
+}
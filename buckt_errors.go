@@ -0,0 +1,61 @@
+package buckt
+
+import (
+	errs "github.com/Rhaqim/buckt/internal/error"
+)
+
+// Errors returned for files and folders that do not exist or belong to another user.
+var (
+	ErrFileNotFound   = errs.ErrFileNotFound
+	ErrFolderNotFound = errs.ErrFolderNotFound
+)
+
+// ErrRenditionNotFound is returned for renditions of files that are not images
+// and for sizes that are not configured.
+var ErrRenditionNotFound = errs.ErrRenditionNotFound
+
+// Errors returned when an upload violates the UploadPolicy.
+// Use errors.Is to check for them.
+var (
+	ErrFileTooLarge        = errs.ErrFileTooLarge
+	ErrExtensionNotAllowed = errs.ErrExtensionNotAllowed
+	ErrMIMETypeNotAllowed  = errs.ErrMIMETypeNotAllowed
+	ErrMIMETypeMismatch    = errs.ErrMIMETypeMismatch
+)
+
+// Errors returned when a file is downloaded before it was found clean.
+var (
+	ErrFileInfected    = errs.ErrFileInfected
+	ErrFileScanPending = errs.ErrFileScanPending
+)
+
+// Errors returned when an archive cannot be extracted.
+var (
+	ErrUnsupportedArchive   = errs.ErrUnsupportedArchive
+	ErrArchiveLimitExceeded = errs.ErrArchiveLimitExceeded
+)
+
+// ErrImportReference is returned when an import references objects of a
+// backend other than the one of the client.
+var ErrImportReference = errs.ErrImportReference
+
+// ErrInvalidExport is returned when importing an archive that is not an
+// instance export or was written by a newer version.
+var ErrInvalidExport = errs.ErrInvalidExport
+
+// ErrBatchRolledBack is returned when an item of an atomic batch fails and
+// none of the items were applied.
+var ErrBatchRolledBack = errs.ErrBatchRolledBack
+
+// Errors returned when a migration cannot be cut over to the given phase.
+var (
+	ErrInvalidCutover     = errs.ErrInvalidCutover
+	ErrCutoverNotVerified = errs.ErrCutoverNotVerified
+)
+
+// Errors returned when controlling a migration running in the background.
+var (
+	ErrMigrationRunning    = errs.ErrMigrationRunning
+	ErrMigrationNotRunning = errs.ErrMigrationNotRunning
+	ErrMigrationNotPaused  = errs.ErrMigrationNotPaused
+)
@@ -0,0 +1,58 @@
+package buckt
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/service"
+)
+
+// operationRetryInterval is how often failed steps of file operations are retried.
+const operationRetryInterval = time.Minute
+
+// operationRenewInterval is how often the journal entries of the client are
+// renewed, well within service.OperationLease.
+const operationRenewInterval = service.OperationLease / 5
+
+// scheduleJob runs fn every interval in the background until the client is closed.
+// Errors are logged and do not stop the schedule.
+func (b *Client) scheduleJob(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	b.jobsWaiter.Add(1)
+
+	go func() {
+		defer b.jobsWaiter.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		b.logger.Infof("⏱️ Scheduled %s every %s", name, interval)
+
+		for {
+			select {
+			case <-b.jobsCtx.Done():
+				return
+			case <-ticker.C:
+				if err := fn(b.jobsCtx); err != nil && b.jobsCtx.Err() == nil {
+					b.logger.Errorf("%s failed: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// writeReport writes the JSON report of a background job to path, replacing
+// the report of the previous run at once.
+func writeReport(path string, report any) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
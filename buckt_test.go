@@ -1,15 +1,34 @@
 package buckt
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
 	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/backend"
 	"github.com/Rhaqim/buckt/internal/database"
 	"github.com/Rhaqim/buckt/internal/mocks"
 	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/internal/repository"
+	"github.com/Rhaqim/buckt/internal/service"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -20,6 +39,17 @@ func UUIDFromString(name string) uuid.UUID {
 	return uuid.NewSHA1(namespace, []byte(name))
 }
 
+// stubScanner flags content containing "EICAR".
+type stubScanner struct{}
+
+func (stubScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	return ScanResult{Infected: bytes.Contains(data, []byte("EICAR")), Signature: "Eicar-Test-Signature"}, nil
+}
+
 type MockBuckt struct {
 	*Client
 	MockFileService   *mocks.FileService
@@ -222,6 +252,195 @@ func TestDefault(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, buckt)
 	})
+
+	t.Run("With Encryption", func(t *testing.T) {
+		keys, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)})
+		assert.NoError(t, err)
+
+		buckt, err := Default(WithEncryption(keys))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, buckt.encrypted)
+
+		n, err := buckt.RewrapKeys(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	})
+
+	t.Run("With Erasure Coding", func(t *testing.T) {
+		dirs := []string{t.TempDir(), t.TempDir(), t.TempDir()}
+
+		buckt, err := Default(WithErasureCoding(dirs, 2, 1))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, buckt.erasure)
+
+		health, err := buckt.DiskHealth(t.Context())
+		assert.NoError(t, err)
+		assert.Len(t, health, 3)
+		assert.True(t, health[0].Writable)
+
+		_, err = buckt.HealDisks(t.Context())
+		assert.NoError(t, err)
+	})
+
+	t.Run("With Disk Cache", func(t *testing.T) {
+		buckt, err := Default(WithDiskCache(DiskCacheConfig{Dir: t.TempDir(), MaxSize: 1 << 20}))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, buckt.cached)
+		assert.Equal(t, 0, buckt.CacheStats().DiskEntries)
+	})
+
+	t.Run("With Upload Policy", func(t *testing.T) {
+		buckt, err := Default(WithUploadPolicy(UploadPolicy{MaxSize: 4}))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+
+		_, err = buckt.UploadFile("user1", "", "file.txt", "text/plain", []byte("too large"))
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+	})
+
+	t.Run("With Scanner", func(t *testing.T) {
+		buckt, err := Default(WithScanner(stubScanner{}, ScanSync))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+
+		_, err = buckt.UploadFile("user1", "", "virus.txt", "text/plain", []byte("EICAR"))
+		assert.ErrorIs(t, err, ErrFileInfected)
+
+		fileID, err := buckt.UploadFile("user1", "", "clean.txt", "text/plain", []byte("hello"))
+		assert.NoError(t, err)
+
+		file, err := buckt.GetFile(fileID)
+		assert.NoError(t, err)
+		assert.Equal(t, ScanStatusClean, file.ScanStatus)
+	})
+
+	t.Run("With Async Scanner", func(t *testing.T) {
+		buckt, err := Default(WithScanner(stubScanner{}, ScanAsync))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+
+		fileID, err := buckt.UploadFile("user1", "", "quarantined.txt", "text/plain", []byte("EICAR"))
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			_, err := buckt.GetFile(fileID)
+			return errors.Is(err, ErrFileInfected)
+		}, 5*time.Second, 10*time.Millisecond)
+
+		_, _, err = buckt.GetFileStream(fileID)
+		assert.ErrorIs(t, err, ErrFileInfected)
+	})
+
+	t.Run("With Renditions", func(t *testing.T) {
+		buckt, err := Default(WithRenditions())
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+
+		var img bytes.Buffer
+		assert.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 300, 300))))
+
+		fileID, err := buckt.UploadFile("user1", "", "image.png", "image/png", img.Bytes())
+		assert.NoError(t, err)
+
+		thumb, err := buckt.GetRendition(fileID, "thumb")
+		assert.NoError(t, err)
+		assert.Equal(t, 150, thumb.Width)
+		assert.Equal(t, "image/png", thumb.ContentType)
+
+		_, err = buckt.GetRendition(fileID, "poster")
+		assert.ErrorIs(t, err, ErrRenditionNotFound)
+
+		_, err = buckt.DeleteFilePermanently(fileID)
+		assert.NoError(t, err)
+		_, err = buckt.GetRendition(fileID, "thumb")
+		assert.Error(t, err)
+	})
+
+	t.Run("With Replication", func(t *testing.T) {
+		replica := mocks.NewMemoryBackend("memory")
+
+		buckt, err := Default(WithReplication(replica))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, buckt.replicated)
+
+		_, err = buckt.RepairReplicas(t.Context())
+		assert.NoError(t, err)
+	})
+
+	t.Run("With Migration", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := mocks.NewMemoryBackend("target")
+		assert.NoError(t, source.Put(t.Context(), "existing.txt", []byte("existing")))
+
+		buckt, err := Default(RegisterPrimaryBackend(source), RegisterSecondaryBackend(target), WithMigration(MigrationConfig{Concurrency: 2}))
+		// Cleanup to ensure the server is closed after the test
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		assert.NoError(t, err)
+		assert.NotNil(t, buckt.migration)
+
+		assert.NoError(t, buckt.MigrateAll(t.Context()))
+		assert.Contains(t, target.Objects(), "existing.txt")
+
+		completed, total, err := buckt.MigrationStatus(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, total, completed)
+		assert.Positive(t, total)
+
+		assert.ErrorIs(t, buckt.Cutover(t.Context(), CutoverTargetOnly), ErrInvalidCutover)
+		assert.NoError(t, buckt.Cutover(t.Context(), CutoverReadTarget))
+		phase, err := buckt.CutoverPhase(t.Context())
+		assert.NoError(t, err)
+		assert.Equal(t, CutoverReadTarget, phase)
+		assert.NoError(t, buckt.Cutover(t.Context(), CutoverDualWrite))
+
+		assert.NoError(t, source.Put(t.Context(), "later/object.txt", []byte("later")))
+		events, unsubscribe, err := buckt.SubscribeMigration()
+		assert.NoError(t, err)
+		defer unsubscribe()
+
+		assert.NoError(t, buckt.StartMigration("later/", MigrationOptions{}))
+		for event := range events {
+			if event.Progress.State.Done() {
+				break
+			}
+		}
+
+		progress, err := buckt.MigrationProgress()
+		assert.NoError(t, err)
+		assert.Equal(t, MigrationCompleted, progress.State)
+		assert.Equal(t, int64(1), progress.Completed)
+		assert.Contains(t, target.Objects(), "later/object.txt")
+	})
 }
 
 func TestClose(t *testing.T) {
@@ -231,6 +450,23 @@ func TestClose(t *testing.T) {
 	buckt.Close()
 }
 
+func TestThrottle(t *testing.T) {
+	buckt := setupBucktTest(t)
+	assert.True(t, buckt.ThrottleLimits().Unlimited())
+
+	cfg := ThrottleConfig{
+		Limits:  ThrottleLimits{BytesPerSecond: 1 << 20, OpsPerSecond: 50},
+		Windows: []ThrottleWindow{{From: "00:00", To: "00:00", Limits: ThrottleLimits{}}},
+	}
+	assert.NoError(t, buckt.SetThrottle(cfg))
+	assert.Equal(t, cfg, buckt.Throttle())
+	// The window wraps around the whole day
+	assert.True(t, buckt.ThrottleLimits().Unlimited())
+
+	assert.Error(t, buckt.SetThrottle(ThrottleConfig{Windows: []ThrottleWindow{{From: "9am", To: "17:00"}}}))
+	assert.Equal(t, cfg, buckt.Throttle())
+}
+
 func TestNewFolder(t *testing.T) {
 	buckt := setupBucktTest(t)
 
@@ -570,6 +806,961 @@ func TestDeleteFilePermanently(t *testing.T) {
 	buckt.MockFileService.AssertExpectations(t)
 }
 
+func TestArchiveFolder(t *testing.T) {
+	buckt, err := Default()
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	// Fresh users, the default database outlives the test
+	user, other := uuid.NewString(), uuid.NewString()
+
+	folderID, err := buckt.NewFolder(user, "", "docs", "")
+	assert.NoError(t, err)
+	subID, err := buckt.NewFolder(user, folderID, "sub", "")
+	assert.NoError(t, err)
+
+	_, err = buckt.UploadFile(user, folderID, "a.txt", "text/plain", []byte("alpha"))
+	assert.NoError(t, err)
+	bID, err := buckt.UploadFile(user, subID, "b.txt", "text/plain", []byte("beta"))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, buckt.ArchiveFolder(t.Context(), user, folderID, &buf, ArchiveZip))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[f.Name] = string(data)
+	}
+	assert.Equal(t, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"}, contents)
+
+	// Folders of other users are not found
+	err = buckt.ArchiveFolder(t.Context(), other, folderID, io.Discard, ArchiveZip)
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+
+	buf.Reset()
+	assert.NoError(t, buckt.ArchiveFiles(t.Context(), user, []string{bID, bID}, &buf, ArchiveTarGz))
+
+	gz, err := gzip.NewReader(&buf)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"b.txt", "b (1).txt"}, names)
+
+	err = buckt.ArchiveFiles(t.Context(), other, []string{bID}, io.Discard, ArchiveZip)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestExtractArchive(t *testing.T) {
+	buckt, err := Default(WithExtractLimits(ExtractLimits{MaxEntries: 10}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	user := uuid.NewString()
+	folderID, err := buckt.NewFolder(user, "", "import", "")
+	assert.NoError(t, err)
+
+	newZip := func(files map[string]string) *bytes.Buffer {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, err := zw.Create(name)
+			assert.NoError(t, err)
+			w.Write([]byte(content))
+		}
+		assert.NoError(t, zw.Close())
+		return &buf
+	}
+
+	report, err := buckt.ExtractArchive(t.Context(), user, folderID, newZip(map[string]string{
+		"a.txt":         "alpha",
+		"sub/b.txt":     "beta",
+		"../escape.txt": "nope",
+	}), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Count(ExtractCreated)) // a.txt, sub/ and sub/b.txt
+	assert.Equal(t, 1, report.Count(ExtractSkipped))
+
+	files, err := buckt.ListFilesMetadata(folderID)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// Conflicts
+	report, err = buckt.ExtractArchive(t.Context(), user, folderID, newZip(map[string]string{"a.txt": "again"}), ConflictSkip)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Count(ExtractSkipped))
+
+	report, err = buckt.ExtractArchive(t.Context(), user, folderID, newZip(map[string]string{"a.txt": "again"}), ConflictRename)
+	assert.NoError(t, err)
+	assert.Equal(t, "a (1).txt", report.Entries[0].Name)
+
+	report, err = buckt.ExtractArchive(t.Context(), user, folderID, newZip(map[string]string{"a.txt": "omega"}), ConflictOverwrite)
+	assert.NoError(t, err)
+	assert.Equal(t, ExtractOverwritten, report.Entries[0].Action)
+
+	// Tarballs
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "tar/c.txt", Mode: 0644, Size: 5, Typeflag: tar.TypeReg}))
+	tw.Write([]byte("gamma"))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "tar/link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink}))
+	tw.Close()
+	gz.Close()
+
+	report, err = buckt.ExtractArchive(t.Context(), user, folderID, &tgz, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Count(ExtractCreated))
+	assert.Equal(t, 1, report.Count(ExtractSkipped))
+
+	// Limits
+	bomb := newZip(map[string]string{"zeros.txt": strings.Repeat("0", 4<<20)})
+	_, err = buckt.ExtractArchive(t.Context(), user, folderID, bomb, "")
+	assert.ErrorIs(t, err, ErrArchiveLimitExceeded)
+
+	many := make(map[string]string)
+	for i := range 11 {
+		many[fmt.Sprintf("%d.txt", i)] = "x"
+	}
+	_, err = buckt.ExtractArchive(t.Context(), user, folderID, newZip(many), "")
+	assert.ErrorIs(t, err, ErrArchiveLimitExceeded)
+
+	_, err = buckt.ExtractArchive(t.Context(), user, folderID, strings.NewReader("not an archive"), "")
+	assert.ErrorIs(t, err, ErrUnsupportedArchive)
+
+	// Folders of other users are not found
+	_, err = buckt.ExtractArchive(t.Context(), uuid.NewString(), folderID, newZip(map[string]string{"a.txt": "a"}), "")
+	assert.ErrorIs(t, err, ErrFolderNotFound)
+}
+
+func TestBatchOperations(t *testing.T) {
+	buckt, err := Default(FlatNameSpaces(false), WithUploadPolicy(UploadPolicy{DeniedExtensions: []string{".exe"}}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	ctx := t.Context()
+	user := uuid.NewString()
+
+	folderID, err := buckt.NewFolder(user, "", "batch", "")
+	assert.NoError(t, err)
+	subID, err := buckt.NewFolder(user, folderID, "sub", "")
+	assert.NoError(t, err)
+
+	results, err := buckt.BatchUpload(ctx, user, folderID, []BatchUpload{
+		{Name: "a.txt", ContentType: "text/plain", Data: []byte("a")},
+		{Name: "b.txt", ContentType: "text/plain", Data: []byte("b")},
+		{Name: "c.exe", ContentType: "application/octet-stream", Data: []byte("c")},
+	}, BatchOptions{})
+	assert.NoError(t, err)
+	assert.True(t, results[0].OK())
+	assert.True(t, results[1].OK())
+	assert.False(t, results[2].OK())
+	a, b := results[0].ID, results[1].ID
+
+	// Atomic batches apply nothing when an item fails
+	_, err = buckt.BatchUpload(ctx, user, folderID, []BatchUpload{
+		{Name: "d.txt", ContentType: "text/plain", Data: []byte("d")},
+		{Name: "e.exe", ContentType: "application/octet-stream", Data: []byte("e")},
+	}, BatchOptions{Atomic: true})
+	assert.ErrorIs(t, err, ErrBatchRolledBack)
+
+	files, err := buckt.ListFilesMetadata(folderID)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	results, err = buckt.BatchMove(ctx, user, []string{a, b}, subID, BatchOptions{Atomic: true})
+	assert.NoError(t, err)
+	assert.Equal(t, BatchFile, results[0].Type)
+
+	files, err = buckt.ListFilesMetadata(subID)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	results, err = buckt.BatchScrub(ctx, user, []string{a, uuid.NewString()}, BatchOptions{Atomic: true})
+	assert.ErrorIs(t, err, ErrBatchRolledBack)
+	assert.Equal(t, ErrBatchRolledBack.Error(), results[0].Error)
+
+	_, err = buckt.GetFile(a)
+	assert.NoError(t, err)
+
+	// Items of other users are not touched
+	results, err = buckt.BatchDelete(ctx, uuid.NewString(), []string{a}, BatchOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, ErrFileNotFound.Error(), results[0].Error)
+
+	results, err = buckt.BatchScrub(ctx, user, []string{a, b, subID}, BatchOptions{Atomic: true})
+	assert.NoError(t, err)
+	assert.Equal(t, BatchFolder, results[2].Type)
+
+	_, err = buckt.GetFile(a)
+	assert.Error(t, err)
+}
+
+func TestOperationJournal(t *testing.T) {
+	memory := mocks.NewMemoryBackend("memory")
+	faulty := mocks.NewFaultyBackend(memory)
+
+	buckt, err := Default(FlatNameSpaces(false), RegisterPrimaryBackend(faulty))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	ctx := t.Context()
+	user := uuid.NewString()
+	journal := repository.NewOperationRepository(buckt.db)
+
+	pending := func(fileID string) []*model.OperationModel {
+		ops, err := journal.GetOperations(ctx)
+		assert.NoError(t, err)
+
+		var matched []*model.OperationModel
+		for _, op := range ops {
+			if op.FileID.String() == fileID {
+				matched = append(matched, op)
+			}
+		}
+		return matched
+	}
+
+	folderID, err := buckt.NewFolder(user, "", "journal", "")
+	assert.NoError(t, err)
+
+	t.Run("Completed create leaves no entry", func(t *testing.T) {
+		fileID, err := buckt.UploadFile(user, folderID, "a.txt", "text/plain", []byte("a"))
+		assert.NoError(t, err)
+		assert.Empty(t, pending(fileID))
+
+		file, err := buckt.GetFile(fileID)
+		assert.NoError(t, err)
+		assert.Contains(t, memory.Objects(), file.Path)
+	})
+
+	t.Run("Failed write creates no row", func(t *testing.T) {
+		faulty.FailOn("Put", errors.New("backend unavailable"))
+		_, err := buckt.UploadFile(user, folderID, "failed.txt", "text/plain", []byte("x"))
+		faulty.Heal()
+		assert.Error(t, err)
+
+		files, err := buckt.ListFilesMetadata(folderID)
+		assert.NoError(t, err)
+		for _, file := range files {
+			assert.NotEqual(t, "failed.txt", file.Name)
+		}
+	})
+
+	t.Run("Interrupted create is rolled back", func(t *testing.T) {
+		// The object was written but the process stopped before the row was inserted
+		op := &model.OperationModel{Type: model.OperationCreateFile, FileID: uuid.New(), Path: "journal/orphan.txt"}
+		assert.NoError(t, memory.Put(ctx, op.Path, []byte("orphan")))
+		assert.NoError(t, journal.Create(ctx, op))
+
+		_, err := buckt.fileService.RecoverOperations(ctx)
+		assert.NoError(t, err)
+		assert.NotContains(t, memory.Objects(), op.Path)
+		assert.Empty(t, pending(op.FileID.String()))
+	})
+
+	t.Run("Failed replace is retried", func(t *testing.T) {
+		fileID, err := buckt.UploadFile(user, folderID, "b.txt", "text/plain", []byte("old"))
+		assert.NoError(t, err)
+
+		// The row is updated for good, so the update succeeds
+		faulty.FailOn("Move", errors.New("backend unavailable"))
+		err = buckt.fileService.UpdateFile(ctx, user, fileID, "b.txt", []byte("new"))
+		faulty.Heal()
+		assert.NoError(t, err)
+		assert.Len(t, pending(fileID), 1)
+
+		file, err := buckt.GetFileInfo(fileID)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("old"), memory.Objects()[file.Path])
+
+		n, err := buckt.fileService.RetryOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, []byte("new"), memory.Objects()[file.Path])
+		assert.Empty(t, pending(fileID))
+	})
+
+	t.Run("Interrupted replace is completed", func(t *testing.T) {
+		fileID, err := buckt.UploadFile(user, folderID, "e.txt", "text/plain", []byte("old"))
+		assert.NoError(t, err)
+		file, err := buckt.GetFileInfo(fileID)
+		assert.NoError(t, err)
+
+		// The row was updated but the process stopped before the content was moved into place
+		op := &model.OperationModel{Type: model.OperationReplaceFile, FileID: file.ID, Path: file.Path, Hash: file.Hash}
+		op.StagingPath = file.Path + ".staging"
+		assert.NoError(t, memory.Put(ctx, op.StagingPath, []byte("new")))
+		assert.NoError(t, journal.Create(ctx, op))
+
+		_, err = buckt.fileService.RecoverOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("new"), memory.Objects()[file.Path])
+		assert.NotContains(t, memory.Objects(), op.StagingPath)
+		assert.Empty(t, pending(fileID))
+	})
+
+	t.Run("Failed scrub is retried", func(t *testing.T) {
+		fileID, err := buckt.UploadFile(user, folderID, "c.txt", "text/plain", []byte("c"))
+		assert.NoError(t, err)
+		file, err := buckt.GetFile(fileID)
+		assert.NoError(t, err)
+
+		faulty.FailOn("Delete", errors.New("backend unavailable"))
+		_, err = buckt.DeleteFilePermanently(fileID)
+		faulty.Heal()
+		assert.NoError(t, err)
+		assert.Contains(t, memory.Objects(), file.Path)
+		assert.Len(t, pending(fileID), 1)
+
+		n, err := buckt.fileService.RetryOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.NotContains(t, memory.Objects(), file.Path)
+		assert.Empty(t, pending(fileID))
+	})
+
+	t.Run("Failed folder scrub is retried", func(t *testing.T) {
+		subID, err := buckt.NewFolder(user, folderID, "scrubbed", "")
+		assert.NoError(t, err)
+		fileID, err := buckt.UploadFile(user, subID, "f.txt", "text/plain", []byte("f"))
+		assert.NoError(t, err)
+		file, err := buckt.GetFileInfo(fileID)
+		assert.NoError(t, err)
+
+		faulty.FailOn("DeleteFolder", errors.New("backend unavailable"))
+		_, err = buckt.folderService.ScrubFolder(ctx, user, subID)
+		faulty.Heal()
+		assert.NoError(t, err)
+		assert.Contains(t, memory.Objects(), file.Path)
+
+		n, err := buckt.folderService.RetryOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.NotContains(t, memory.Objects(), file.Path)
+
+		ops, err := journal.GetOperations(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, ops)
+	})
+
+	t.Run("Interrupted folder scrub is completed", func(t *testing.T) {
+		// The row was deleted but the process stopped before the objects were
+		op := &model.OperationModel{Type: model.OperationScrubFolder, FolderID: uuid.New(), Path: "journal/interrupted"}
+		assert.NoError(t, memory.Put(ctx, op.Path+"/g.txt", []byte("g")))
+		assert.NoError(t, journal.Create(ctx, op))
+
+		// File operations leave it to the folder service
+		_, err := buckt.fileService.RecoverOperations(ctx)
+		assert.NoError(t, err)
+		assert.Contains(t, memory.Objects(), op.Path+"/g.txt")
+
+		n, err := buckt.folderService.RecoverOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.NotContains(t, memory.Objects(), op.Path+"/g.txt")
+	})
+
+	t.Run("Failed move is retried", func(t *testing.T) {
+		fileID, err := buckt.UploadFile(user, folderID, "d.txt", "text/plain", []byte("d"))
+		assert.NoError(t, err)
+		before, err := buckt.GetFile(fileID)
+		assert.NoError(t, err)
+		subID, err := buckt.NewFolder(user, folderID, "moved", "")
+		assert.NoError(t, err)
+
+		// The row is moved for good, the object follows once the backend is back
+		faulty.FailOn("Move", errors.New("backend unavailable"))
+		assert.NoError(t, buckt.MoveFile(fileID, subID))
+		assert.Contains(t, memory.Objects(), before.Path)
+		assert.Len(t, pending(fileID), 1)
+
+		n, err := buckt.fileService.RetryOperations(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+
+		faulty.Heal()
+		n, err = buckt.fileService.RetryOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+
+		after, err := buckt.GetFile(fileID)
+		assert.NoError(t, err)
+		assert.NotEqual(t, before.Path, after.Path)
+		assert.Equal(t, []byte("d"), memory.Objects()[after.Path])
+		assert.NotContains(t, memory.Objects(), before.Path)
+		assert.Empty(t, pending(fileID))
+	})
+
+	t.Run("Operations of running instances are left alone", func(t *testing.T) {
+		// Another instance is writing the object and renews its lease
+		other := &model.OperationModel{Type: model.OperationCreateFile, FileID: uuid.New(), Path: "journal/running.txt", Owner: uuid.New(), HeartbeatAt: time.Now()}
+		assert.NoError(t, memory.Put(ctx, other.Path, []byte("running")))
+		assert.NoError(t, journal.Create(ctx, other))
+
+		// The lease of this instance is renewed by the client, even when it is late
+		own := &model.OperationModel{Type: model.OperationCreateFile, FileID: uuid.New(), Path: "journal/own.txt", Owner: buckt.instanceID, HeartbeatAt: time.Now().Add(-time.Hour)}
+		assert.NoError(t, memory.Put(ctx, own.Path, []byte("own")))
+		assert.NoError(t, journal.Create(ctx, own))
+
+		n, err := buckt.fileService.RecoverOperations(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+		assert.Contains(t, memory.Objects(), other.Path)
+		assert.Contains(t, memory.Objects(), own.Path)
+
+		assert.NoError(t, journal.Renew(ctx, buckt.instanceID))
+		ops, err := journal.GetExpired(ctx, other.Owner, time.Now().Add(-time.Minute))
+		assert.NoError(t, err)
+		assert.Empty(t, ops)
+
+		// The other instance stopped and its lease expired
+		other.HeartbeatAt = time.Now().Add(-2 * service.OperationLease)
+		assert.NoError(t, buckt.db.Save(other).Error)
+
+		n, err = buckt.fileService.RecoverOperations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.NotContains(t, memory.Objects(), other.Path)
+		assert.Empty(t, pending(other.FileID.String()))
+
+		assert.NoError(t, journal.Delete(ctx, own.ID))
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	buckt, err := Default(FlatNameSpaces(false), WithReconciliation(ReconcileConfig{}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	user := uuid.NewString()
+	fileID, err := buckt.UploadFile(user, "", "kept.txt", "text/plain", []byte("kept"))
+	assert.NoError(t, err)
+	file, err := buckt.GetFile(fileID)
+	assert.NoError(t, err)
+
+	report, err := buckt.Reconcile(t.Context(), ReconcileOptions{})
+	assert.NoError(t, err)
+	assert.Positive(t, report.Files)
+	for _, stray := range report.Stray {
+		assert.NotEqual(t, strings.TrimPrefix(file.Path, "/"), strings.TrimPrefix(stray.Path, "/"))
+	}
+
+	path := filepath.Join(t.TempDir(), "reconcile.json")
+	assert.NoError(t, writeReport(path, report))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var written ReconcileReport
+	assert.NoError(t, json.Unmarshal(data, &written))
+	assert.Equal(t, report.Files, written.Files)
+}
+
+func TestVerifyFile(t *testing.T) {
+	memory := mocks.NewMemoryBackend("memory")
+	buckt, err := Default(FlatNameSpaces(false), RegisterPrimaryBackend(memory), WithIntegrityChecks(IntegrityConfig{}),
+		WithDiskCache(DiskCacheConfig{Dir: t.TempDir(), MaxSize: 1 << 20}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	user := uuid.NewString()
+	fileID, err := buckt.UploadFile(user, "", "verified.txt", "text/plain", []byte("verified"))
+	assert.NoError(t, err)
+	file, err := buckt.GetFile(fileID)
+	assert.NoError(t, err)
+	assert.Equal(t, IntegrityOK, file.Integrity)
+	assert.NotNil(t, file.VerifiedAt)
+
+	result, err := buckt.VerifyFile(t.Context(), fileID)
+	assert.NoError(t, err)
+	assert.Equal(t, IntegrityOK, result.Status)
+
+	// Flip the stored content behind the client's back, cached copies must
+	// not hide it
+	_, rc, err := buckt.GetFileStream(fileID)
+	assert.NoError(t, err)
+	_, err = io.Copy(io.Discard, rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.NoError(t, memory.Put(t.Context(), file.Path, []byte("verifieD")))
+
+	result, err = buckt.VerifyFile(t.Context(), fileID)
+	assert.NoError(t, err)
+	assert.Equal(t, IntegrityCorrupt, result.Status)
+	assert.False(t, result.Repaired)
+
+	file, err = buckt.GetFile(fileID)
+	assert.NoError(t, err)
+	assert.Equal(t, IntegrityCorrupt, file.Integrity)
+}
+
+func TestVerifyFileRepairs(t *testing.T) {
+	memory := mocks.NewMemoryBackend("memory")
+	replica := mocks.NewMemoryBackend("replica")
+
+	// Background jobs go through the throttle, it must not hide the replicas
+	buckt, err := Default(FlatNameSpaces(false), RegisterPrimaryBackend(memory), WithReplication(replica),
+		WithIntegrityChecks(IntegrityConfig{}), WithThrottle(ThrottleConfig{Limits: ThrottleLimits{BytesPerSecond: 1 << 20}}))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		buckt.Close()
+	})
+
+	user := uuid.NewString()
+	fileID, err := buckt.UploadFile(user, "", "repaired.txt", "text/plain", []byte("repaired"))
+	assert.NoError(t, err)
+	file, err := buckt.GetFile(fileID)
+	assert.NoError(t, err)
+	assert.NoError(t, memory.Put(t.Context(), file.Path, []byte("repaireD")))
+
+	result, err := buckt.VerifyFile(t.Context(), fileID)
+	assert.NoError(t, err)
+	assert.True(t, result.Repaired)
+
+	data, err := memory.Get(t.Context(), file.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("repaired"), data)
+}
+
+func TestRebuildIndex(t *testing.T) {
+	memory := mocks.NewMemoryBackend("memory")
+
+	newClient := func(opts ...ConfigFunc) *Client {
+		sqlDB, err := sql.Open("sqlite3", ":memory:")
+		assert.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+		t.Cleanup(func() {
+			sqlDB.Close()
+		})
+
+		buckt, err := Default(append([]ConfigFunc{FlatNameSpaces(false), WithDB(SQLite, sqlDB), RegisterPrimaryBackend(memory)}, opts...)...)
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		return buckt
+	}
+
+	buckt := newClient(WithMetadataSidecars())
+
+	user := uuid.NewString()
+	docsID, err := buckt.NewFolder(user, "", "docs", "Documents")
+	assert.NoError(t, err)
+	draftsID, err := buckt.NewFolder(user, docsID, "drafts", "")
+	assert.NoError(t, err)
+
+	readmeID, err := buckt.UploadFile(user, "", "readme.txt", "text/plain", []byte("readme"))
+	assert.NoError(t, err)
+	draftID, err := buckt.UploadFile(user, draftsID, "draft.txt", "text/plain", []byte("draft"))
+	assert.NoError(t, err)
+	notesID, err := buckt.UploadFile(user, docsID, "notes.meta.json", "application/json", []byte("{}"))
+	assert.NoError(t, err)
+	// The sidecar of notes must not overwrite the file named like it
+	plainNotesID, err := buckt.UploadFile(user, docsID, "notes", "text/plain", []byte("notes"))
+	assert.NoError(t, err)
+	notes, err := buckt.GetFile(notesID)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("{}"), notes.Data)
+	trashedID, err := buckt.UploadFile(user, docsID, "trashed.txt", "text/plain", []byte("trashed"))
+	assert.NoError(t, err)
+	_, err = buckt.DeleteFile(trashedID)
+	assert.NoError(t, err)
+	scrubbedID, err := buckt.UploadFile(user, docsID, "scrubbed.txt", "text/plain", []byte("scrubbed"))
+	assert.NoError(t, err)
+	_, err = buckt.DeleteFilePermanently(scrubbedID)
+	assert.NoError(t, err)
+
+	// An object whose sidecar survived it
+	draft, err := buckt.GetFile(draftID)
+	assert.NoError(t, err)
+	lost, err := buckt.UploadFile(user, docsID, "lost.txt", "text/plain", []byte("lost"))
+	assert.NoError(t, err)
+	lostFile, err := buckt.GetFile(lost)
+	assert.NoError(t, err)
+	assert.NoError(t, memory.Delete(t.Context(), lostFile.Path))
+
+	// The database is lost
+	restored := newClient()
+
+	report, err := restored.RebuildIndex(t.Context(), RebuildOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 5, report.FilesCreated)
+	if assert.Len(t, report.Conflicts, 1) {
+		assert.Equal(t, "object is missing", report.Conflicts[0].Reason)
+	}
+	_, err = restored.GetFile(readmeID)
+	assert.Error(t, err)
+
+	report, err = restored.RebuildIndex(t.Context(), RebuildOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.FoldersCreated)
+	assert.Equal(t, 5, report.FilesCreated)
+	assert.Len(t, report.Conflicts, 1)
+
+	for id, data := range map[string]string{readmeID: "readme", draftID: "draft", notesID: "{}", plainNotesID: "notes"} {
+		file, err := restored.GetFile(id)
+		if assert.NoError(t, err) {
+			assert.Equal(t, data, string(file.Data))
+		}
+	}
+
+	folder, err := restored.GetFolderWithContent(user, draftsID)
+	assert.NoError(t, err)
+	assert.Equal(t, draft.ParentID.String(), folder.ID.String())
+	assert.Len(t, folder.Files, 1)
+
+	docs, err := restored.GetFolderWithContent(user, docsID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Documents", docs.Description)
+	assert.Len(t, docs.Files, 2) // the trashed file stays in the trash
+
+	_, err = restored.GetFile(trashedID)
+	assert.Error(t, err)
+
+	// Everything indexed is left alone
+	report, err = restored.RebuildIndex(t.Context(), RebuildOptions{})
+	assert.NoError(t, err)
+	assert.Zero(t, report.FoldersCreated)
+	assert.Zero(t, report.FilesCreated)
+}
+
+func TestImportTree(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("copy", func(t *testing.T) {
+		buckt, err := Default(FlatNameSpaces(false))
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+
+		src := mocks.NewMemoryBackend("src")
+		for key, data := range map[string]string{
+			"export/a.txt":         "a",
+			"export/docs/b.md":     "# b",
+			"export/docs/deep/c":   "c",
+			"exported/outside.txt": "outside",
+		} {
+			assert.NoError(t, src.Put(ctx, key, []byte(data)))
+		}
+
+		user := uuid.NewString()
+		report, err := buckt.ImportTree(ctx, user, src, "export", "", ImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 5, report.Count(ExtractCreated)) // three files and two folders
+		assert.Equal(t, "exported/outside.txt", report.LastKey)
+		assert.Equal(t, 1, report.Count(ExtractSkipped))
+
+		root, err := buckt.GetFolderWithContent(user, "")
+		assert.NoError(t, err)
+		if assert.Len(t, root.Files, 1) {
+			assert.Equal(t, "a.txt", root.Files[0].Name)
+			assert.Equal(t, "text/plain; charset=utf-8", root.Files[0].ContentType)
+		}
+
+		// A re-run imports nothing twice
+		assert.NoError(t, src.Put(ctx, "export/docs/b.md", []byte("# b, edited")))
+		report, err = buckt.ImportTree(ctx, user, src, "export", "", ImportOptions{Conflict: ConflictRename})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Count(ExtractUnchanged))
+		assert.Equal(t, 1, report.Count(ExtractRenamed))
+		assert.Zero(t, report.Count(ExtractCreated))
+
+		// Resuming after the last key handled skips everything before it
+		report, err = buckt.ImportTree(ctx, user, src, "export", "", ImportOptions{Conflict: ConflictRename, After: "export/docs/b.md"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Count(ExtractUnchanged))
+		assert.Zero(t, report.Count(ExtractRenamed))
+	})
+
+	t.Run("reference", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("memory")
+		buckt, err := Default(FlatNameSpaces(false), RegisterPrimaryBackend(memory))
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+
+		// Paths are unique across runs sharing the database
+		prefix := "legacy-" + uuid.NewString()
+		assert.NoError(t, memory.Put(ctx, prefix+"/photos/a.png", []byte("\x89PNG\r\n\x1a\n")))
+		assert.NoError(t, memory.Put(ctx, prefix+"/notes", []byte("plain text")))
+
+		user := uuid.NewString()
+		_, err = buckt.ImportTree(ctx, user, mocks.NewMemoryBackend("other"), prefix, "", ImportOptions{Mode: ImportReference})
+		assert.ErrorIs(t, err, ErrImportReference)
+
+		report, err := buckt.ImportTree(ctx, user, nil, prefix, "", ImportOptions{Mode: ImportReference})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Count(ExtractReferenced))
+		assert.Equal(t, int64(18), report.Bytes)
+
+		root, err := buckt.GetFolderWithContent(user, "")
+		assert.NoError(t, err)
+		if assert.Len(t, root.Files, 1) {
+			notes := root.Files[0]
+			assert.Equal(t, prefix+"/notes", notes.Path)
+			assert.Equal(t, "text/plain; charset=utf-8", notes.ContentType)
+
+			file, err := buckt.GetFile(notes.ID.String())
+			assert.NoError(t, err)
+			assert.Equal(t, "plain text", string(file.Data))
+
+			result, err := buckt.VerifyFile(ctx, notes.ID.String())
+			assert.NoError(t, err)
+			assert.Equal(t, IntegrityOK, result.Status)
+		}
+
+		// Objects are not copied and a re-run indexes nothing twice
+		assert.Len(t, memory.Objects(), 2)
+		report, err = buckt.ImportTree(ctx, user, nil, prefix, "", ImportOptions{Mode: ImportReference})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, report.Count(ExtractUnchanged))
+	})
+
+	t.Run("policy and scanner", func(t *testing.T) {
+		for _, mode := range []ImportMode{ImportCopy, ImportReference} {
+			memory := mocks.NewMemoryBackend("memory")
+			buckt, err := Default(FlatNameSpaces(false), RegisterPrimaryBackend(memory),
+				WithUploadPolicy(UploadPolicy{MaxSize: 16, DeniedExtensions: []string{".exe"}}), WithScanner(stubScanner{}, ScanSync))
+			assert.NoError(t, err)
+			t.Cleanup(func() {
+				buckt.Close()
+			})
+
+			prefix := "legacy-" + uuid.NewString()
+			for name, data := range map[string]string{
+				"clean.txt":    "clean",
+				"infected.txt": "EICAR",
+				"tool.exe":     "tool",
+				"large.txt":    strings.Repeat("large", 10),
+			} {
+				assert.NoError(t, memory.Put(ctx, prefix+"/"+name, []byte(data)))
+			}
+
+			var src Backend // objects are referenced in the backend of the client
+			if mode == ImportCopy {
+				src = memory
+			}
+			report, err := buckt.ImportTree(ctx, uuid.NewString(), src, prefix, "", ImportOptions{Mode: mode})
+			assert.NoError(t, err, mode)
+			assert.Equal(t, 1, report.Count(ExtractCreated)+report.Count(ExtractReferenced), mode)
+			assert.Equal(t, 3, report.Count(ExtractSkipped), mode)
+		}
+	})
+}
+
+func TestExportImport(t *testing.T) {
+	newClient := func(memory *mocks.MemoryBackend) *Client {
+		sqlDB, err := sql.Open("sqlite3", ":memory:")
+		assert.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+		t.Cleanup(func() {
+			sqlDB.Close()
+		})
+
+		buckt, err := Default(FlatNameSpaces(false), WithDB(SQLite, sqlDB), RegisterPrimaryBackend(memory))
+		assert.NoError(t, err)
+		t.Cleanup(func() {
+			buckt.Close()
+		})
+		return buckt
+	}
+
+	source := newClient(mocks.NewMemoryBackend("source"))
+
+	user := uuid.NewString()
+	docsID, err := source.NewFolder(user, "", "docs", "Documents")
+	assert.NoError(t, err)
+	readmeID, err := source.UploadFile(user, "", "readme.txt", "text/plain", []byte("readme"))
+	assert.NoError(t, err)
+	notesID, err := source.UploadFile(user, docsID, "notes.txt", "text/plain", []byte("notes"))
+	assert.NoError(t, err)
+	trashedID, err := source.UploadFile(user, docsID, "trashed.txt", "text/plain", []byte("trashed"))
+	assert.NoError(t, err)
+	_, err = source.DeleteFile(trashedID)
+	assert.NoError(t, err)
+
+	var export bytes.Buffer
+	var progress []ExportProgress
+	report, err := source.Export(t.Context(), &export, ExportOptions{Progress: func(p ExportProgress) {
+		progress = append(progress, p)
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Users)
+	assert.Equal(t, 2, report.Folders)
+	assert.Equal(t, 3, report.Files)
+	assert.Equal(t, int64(len("readme")+len("notes")+len("trashed")), report.Bytes)
+	if assert.Len(t, progress, 3) {
+		assert.Equal(t, ExportProgress{Files: 3, TotalFiles: 3, Bytes: report.Bytes}, progress[2])
+	}
+
+	t.Run("restore", func(t *testing.T) {
+		target := newClient(mocks.NewMemoryBackend("target"))
+
+		imported, err := target.Import(t.Context(), bytes.NewReader(export.Bytes()), InstanceImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, imported.FoldersCreated)
+		assert.Equal(t, 3, imported.FilesCreated)
+		assert.Empty(t, imported.Conflicts)
+		assert.Empty(t, imported.IDs)
+
+		for id, data := range map[string]string{readmeID: "readme", notesID: "notes"} {
+			file, err := target.GetFile(id)
+			if assert.NoError(t, err) {
+				assert.Equal(t, data, string(file.Data))
+			}
+		}
+
+		docs, err := target.GetFolderWithContent(user, docsID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Documents", docs.Description)
+		assert.Len(t, docs.Files, 1) // the trashed file stays in the trash
+
+		// Everything is indexed already
+		imported, err = target.Import(t.Context(), bytes.NewReader(export.Bytes()), InstanceImportOptions{})
+		assert.NoError(t, err)
+		assert.Zero(t, imported.FoldersCreated)
+		assert.Equal(t, 2, imported.FoldersExisting)
+		assert.Zero(t, imported.FilesCreated)
+		assert.Len(t, imported.Conflicts, 3)
+	})
+
+	t.Run("remap", func(t *testing.T) {
+		target := newClient(mocks.NewMemoryBackend("target"))
+
+		imported, err := target.Import(t.Context(), bytes.NewReader(export.Bytes()), InstanceImportOptions{RemapIDs: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, imported.FilesCreated)
+		assert.Len(t, imported.IDs, 5)
+
+		_, err = target.GetFile(readmeID)
+		assert.Error(t, err)
+		file, err := target.GetFile(imported.IDs[uuid.MustParse(readmeID)].String())
+		if assert.NoError(t, err) {
+			assert.Equal(t, "readme", string(file.Data))
+		}
+	})
+
+	t.Run("manifest only", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("shared")
+		shared := newClient(memory)
+		id, err := shared.UploadFile(user, "", "shared.txt", "text/plain", []byte("shared"))
+		assert.NoError(t, err)
+
+		var manifest bytes.Buffer
+		report, err := shared.Export(t.Context(), &manifest, ExportOptions{ManifestOnly: true})
+		assert.NoError(t, err)
+		assert.Zero(t, report.Bytes)
+
+		// The database moves, the objects stay
+		target := newClient(memory)
+		imported, err := target.Import(t.Context(), &manifest, InstanceImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, imported.FilesCreated)
+
+		file, err := target.GetFile(id)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "shared", string(file.Data))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		target := newClient(mocks.NewMemoryBackend("target"))
+
+		_, err := target.Import(t.Context(), strings.NewReader("not an export"), InstanceImportOptions{})
+		assert.ErrorIs(t, err, ErrInvalidExport)
+	})
+	t.Run("untrusted manifest", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("target")
+		target := newClient(memory)
+
+		rootID, docsID := uuid.New(), uuid.New()
+		folder := func(id uuid.UUID, parent *uuid.UUID, name, path, owner string) model.FolderMetadata {
+			return model.FolderMetadata{Version: 1, ID: id, ParentID: parent, Name: name, Path: path, Owner: owner}
+		}
+		blobs := map[string]string{}
+		file := func(name, path, data string, size int64) model.ExportFile {
+			sum := sha256.Sum256([]byte(data))
+			f := model.ExportFile{FileMetadata: model.FileMetadata{
+				Version: 1, ID: uuid.New(), ParentID: rootID, Name: name, Path: path, Size: size, Hash: hex.EncodeToString(sum[:]),
+			}, Blob: "blobs/" + name}
+			blobs[f.Blob] = data
+			return f
+		}
+
+		manifest := ExportManifest{Version: 1, Users: []string{"u1"},
+			Folders: []model.FolderMetadata{
+				folder(rootID, nil, "root_folder", "/u1/root_folder", "u1"),
+				folder(docsID, &rootID, "docs", "/u1/root_folder/docs", "u2"),       // claims another owner
+				folder(uuid.New(), &rootID, "up", "/u1/root_folder/../../u2", "u1"), // climbs out
+			},
+			Files: []model.ExportFile{
+				file("ok.txt", "/u1/root_folder/ok.txt", "ok", 2),
+				file("escape.txt", "/u1/root_folder/../../escape.txt", "escape", 6),
+				file("other.txt", "/u2/root_folder/other.txt", "other", 5), // outside its folder
+				file("sidecar.txt", ".buckt/files/sidecar.txt", "sidecar", 7),
+				file("short.txt", "/u1/root_folder/short.txt", "short", 1), // blob larger than announced
+			},
+		}
+
+		var export bytes.Buffer
+		gz := gzip.NewWriter(&export)
+		tw := tar.NewWriter(gz)
+		add := func(name, content string) {
+			assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644}))
+			_, err := tw.Write([]byte(content))
+			assert.NoError(t, err)
+		}
+		data, err := json.Marshal(manifest)
+		assert.NoError(t, err)
+		add("manifest.json", string(data))
+		for _, f := range manifest.Files {
+			add(f.Blob, blobs[f.Blob])
+		}
+		assert.NoError(t, tw.Close())
+		assert.NoError(t, gz.Close())
+
+		imported, err := target.Import(t.Context(), &export, InstanceImportOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, imported.FoldersCreated)
+		assert.Equal(t, 1, imported.FilesCreated)
+		assert.Len(t, imported.Conflicts, 6)
+		assert.Equal(t, []string{"/u1/root_folder/ok.txt"}, slices.Collect(maps.Keys(memory.Objects())))
+	})
+}
+
 func TestInitializeCache(t *testing.T) {
 	// Mock logger
 	mockLogger := &mocks.NoopLogger{}
@@ -629,6 +1820,9 @@ func TestResolveBackend(t *testing.T) {
 	mockLogger := &mocks.NoopLogger{}
 	mockLRU := &mocks.NoopLRUCache{}
 	mediaDir := "media"
+	newLocal := func() Backend {
+		return backend.NewLocalFileSystemService(mockLogger, mediaDir, mockLRU)
+	}
 
 	t.Run("MigrationEnabled with Source and Target", func(t *testing.T) {
 		source := &mocks.Backend{NameVal: "local"}
@@ -638,7 +1832,7 @@ func TestResolveBackend(t *testing.T) {
 			Source:           source,
 			Target:           target,
 		}
-		result := resolveBackend(mediaDir, bc, mockLogger, mockLRU)
+		result := resolveBackend(bc, mockLogger, newLocal, nil, nil)
 		_, ok := result.(*backend.MigrationBackendService)
 		assert.True(t, ok)
 	})
@@ -648,7 +1842,7 @@ func TestResolveBackend(t *testing.T) {
 		bc := BackendConfig{
 			Source: source,
 		}
-		result := resolveBackend(mediaDir, bc, mockLogger, mockLRU)
+		result := resolveBackend(bc, mockLogger, newLocal, nil, nil)
 		// Should instantiate local backend
 		_, ok := result.(*backend.LocalFileSystemService)
 		assert.True(t, ok)
@@ -659,14 +1853,14 @@ func TestResolveBackend(t *testing.T) {
 		bc := BackendConfig{
 			Target: target,
 		}
-		result := resolveBackend(mediaDir, bc, mockLogger, mockLRU)
+		result := resolveBackend(bc, mockLogger, newLocal, nil, nil)
 		_, ok := result.(*backend.LocalFileSystemService)
 		assert.True(t, ok)
 	})
 
 	t.Run("No Source or Target", func(t *testing.T) {
 		bc := BackendConfig{}
-		result := resolveBackend(mediaDir, bc, mockLogger, mockLRU)
+		result := resolveBackend(bc, mockLogger, newLocal, nil, nil)
 		_, ok := result.(*backend.LocalFileSystemService)
 		assert.True(t, ok)
 	})
@@ -676,17 +1870,20 @@ func TestInstantiateIfLocal(t *testing.T) {
 	mockLogger := &mocks.NoopLogger{}
 	mockLRU := &mocks.NoopLRUCache{}
 	mediaDir := "media"
+	newLocal := func() Backend {
+		return backend.NewLocalFileSystemService(mockLogger, mediaDir, mockLRU)
+	}
 
 	t.Run("Returns LocalFileSystemService if backend name is local", func(t *testing.T) {
 		b := &mocks.Backend{NameVal: "local"}
-		result := instantiateIfLocal(b, mediaDir, mockLogger, mockLRU)
+		result := instantiateIfLocal(b, newLocal)
 		_, ok := result.(*backend.LocalFileSystemService)
 		assert.True(t, ok)
 	})
 
 	t.Run("Returns backend as is if name is not local", func(t *testing.T) {
 		b := &mocks.Backend{NameVal: "mock"}
-		result := instantiateIfLocal(b, mediaDir, mockLogger, mockLRU)
+		result := instantiateIfLocal(b, newLocal)
 		assert.Equal(t, b, result)
 	})
 }
@@ -712,6 +1909,9 @@ func TestNewAppServices(t *testing.T) {
 			mockLogger,
 			mockCacheManager,
 			mockBackend,
+			nil,
+			nil,
+			uuid.Nil,
 		)
 		assert.NotNil(t, folderService)
 		assert.NotNil(t, fileService)
@@ -724,6 +1924,9 @@ func TestNewAppServices(t *testing.T) {
 			mockLogger,
 			mockCacheManager,
 			mockBackend,
+			nil,
+			nil,
+			uuid.Nil,
 		)
 		assert.NotNil(t, folderService)
 		assert.NotNil(t, fileService)
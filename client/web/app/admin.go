@@ -0,0 +1,153 @@
+package app
+
+import (
+	"errors"
+	"io"
+
+	"github.com/Rhaqim/buckt"
+	"github.com/Rhaqim/buckt/client/web/domain"
+	"github.com/Rhaqim/buckt/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminService struct {
+	client *buckt.Client
+}
+
+func NewAdminService(client *buckt.Client) domain.AdminService {
+	return &AdminService{
+		client: client,
+	}
+}
+
+// MigrationStatus implements domain.AdminService.
+func (svc *AdminService) MigrationStatus(c *gin.Context) {
+	progress, err := svc.client.MigrationProgress()
+	if err != nil {
+		c.AbortWithStatusJSON(migrationErrorStatus(err), response.WrapError("failed to get migration", err))
+		return
+	}
+
+	c.JSON(200, response.Success(progress))
+}
+
+// StartMigration implements domain.AdminService.
+// The body is optional, without it every object is migrated.
+func (svc *AdminService) StartMigration(c *gin.Context) {
+	var req struct {
+		Prefix      string `json:"prefix"`
+		Concurrency int    `json:"concurrency"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(400, response.Error("invalid request", err.Error()))
+		return
+	}
+
+	if err := svc.client.StartMigration(req.Prefix, buckt.MigrationOptions{Concurrency: req.Concurrency}); err != nil {
+		c.AbortWithStatusJSON(migrationErrorStatus(err), response.WrapError("failed to start migration", err))
+		return
+	}
+
+	progress, _ := svc.client.MigrationProgress()
+	c.JSON(202, response.Success(progress))
+}
+
+// PauseMigration implements domain.AdminService.
+func (svc *AdminService) PauseMigration(c *gin.Context) {
+	svc.control(c, "failed to pause migration", svc.client.PauseMigration)
+}
+
+// ResumeMigration implements domain.AdminService.
+func (svc *AdminService) ResumeMigration(c *gin.Context) {
+	svc.control(c, "failed to resume migration", svc.client.ResumeMigration)
+}
+
+// CancelMigration implements domain.AdminService.
+func (svc *AdminService) CancelMigration(c *gin.Context) {
+	svc.control(c, "failed to cancel migration", svc.client.CancelMigration)
+}
+
+// MigrationEvents implements domain.AdminService.
+// It streams the progress as server-sent events, starting with the current
+// progress, until the migration stops or the client disconnects.
+func (svc *AdminService) MigrationEvents(c *gin.Context) {
+	events, unsubscribe, err := svc.client.SubscribeMigration()
+	if err != nil {
+		c.AbortWithStatusJSON(migrationErrorStatus(err), response.WrapError("failed to follow migration", err))
+		return
+	}
+	defer unsubscribe()
+
+	progress, _ := svc.client.MigrationProgress()
+	c.SSEvent("progress", buckt.MigrationEvent{Progress: progress})
+	if progress.State != buckt.MigrationRunning && progress.State != buckt.MigrationPaused {
+		return
+	}
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent("progress", event)
+			return !event.Progress.State.Done()
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// GetThrottle implements domain.AdminService.
+// It responds with the configured limits and the limits that apply now.
+func (svc *AdminService) GetThrottle(c *gin.Context) {
+	svc.throttle(c)
+}
+
+// SetThrottle implements domain.AdminService.
+// The body replaces the limits and windows, an empty body lifts every limit.
+func (svc *AdminService) SetThrottle(c *gin.Context) {
+	var req buckt.ThrottleConfig
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(400, response.Error("invalid request", err.Error()))
+		return
+	}
+
+	if err := svc.client.SetThrottle(req); err != nil {
+		c.AbortWithStatusJSON(400, response.WrapError("failed to set throttle", err))
+		return
+	}
+
+	svc.throttle(c)
+}
+
+// throttle responds with the throttle configuration and the limits that apply now.
+func (svc *AdminService) throttle(c *gin.Context) {
+	c.JSON(200, response.Success(gin.H{
+		"config": svc.client.Throttle(),
+		"active": svc.client.ThrottleLimits(),
+	}))
+}
+
+// control runs a state change of the migration and responds with the progress after it.
+func (svc *AdminService) control(c *gin.Context, msg string, fn func() error) {
+	if err := fn(); err != nil {
+		c.AbortWithStatusJSON(migrationErrorStatus(err), response.WrapError(msg, err))
+		return
+	}
+
+	progress, _ := svc.client.MigrationProgress()
+	c.JSON(200, response.Success(progress))
+}
+
+// migrationErrorStatus maps the errors of the migration controls to a status code.
+func migrationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, buckt.ErrMigrationRunning),
+		errors.Is(err, buckt.ErrMigrationNotRunning),
+		errors.Is(err, buckt.ErrMigrationNotPaused):
+		return 409
+	default:
+		return 500
+	}
+}
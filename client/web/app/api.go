@@ -1,6 +1,7 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -213,7 +214,7 @@ func (svc *APIService) UploadFile(c *gin.Context) {
 
 	fileID, err := svc.client.UploadFile(user_id, parentID, fileName, file.Header.Get("Content-Type"), fileByte)
 	if err != nil {
-		c.AbortWithStatusJSON(500, response.WrapError("failed to create file", err))
+		c.AbortWithStatusJSON(uploadErrorStatus(err), response.WrapError("failed to create file", err))
 		return
 	}
 
@@ -233,7 +234,7 @@ func (svc *APIService) DownloadFile(c *gin.Context) {
 
 	file, err := svc.client.GetFile(fileID)
 	if err != nil {
-		c.AbortWithStatusJSON(500, response.WrapError("failed to get file", err))
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get file", err))
 		return
 	}
 
@@ -264,7 +265,7 @@ func (svc *APIService) ServeFile(c *gin.Context) {
 
 	file, err := svc.client.GetFile(fileID)
 	if err != nil {
-		c.AbortWithStatusJSON(500, response.WrapError("failed to get file", err))
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get file", err))
 		return
 	}
 
@@ -294,7 +295,7 @@ func (svc *APIService) StreamFile(c *gin.Context) {
 
 	file, stream, err := svc.client.GetFileStream(fileID)
 	if err != nil {
-		c.AbortWithStatusJSON(500, response.WrapError("failed to get file", err))
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get file", err))
 		return
 	}
 	defer stream.Close()
@@ -367,6 +368,30 @@ func (svc *APIService) StreamFile(c *gin.Context) {
 	// io.CopyN(c.Writer, stream, bytesToSend)
 }
 
+// ServeRendition implements domain.APIService.
+func (svc *APIService) ServeRendition(c *gin.Context) {
+	// get the file_id and size from the request
+	fileID := c.Param("file_id")
+	size := c.Param("size")
+	if fileID == "" || size == "" {
+		c.AbortWithStatusJSON(400, response.Error("file_id and size are required", ""))
+		return
+	}
+
+	rendition, err := svc.client.GetRendition(fileID, size)
+	if err != nil {
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get rendition", err))
+		return
+	}
+
+	// Set headers
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Header("Content-Length", fmt.Sprintf("%d", rendition.Size))
+
+	// Send rendition data
+	c.Data(200, rendition.ContentType, rendition.Data)
+}
+
 // DeleteFile implements domain.APIService.
 // Subtle: this method shadows the method (FileService).DeleteFile of APIService.FileService.
 func (svc *APIService) DeleteFile(c *gin.Context) {
@@ -411,6 +436,34 @@ func (f *APIService) constructURL(s string) string {
 	return fmt.Sprintf("/serve/%s", s)
 }
 
+// uploadErrorStatus maps upload policy violations to their HTTP status code.
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, buckt.ErrFileTooLarge):
+		return 413
+	case errors.Is(err, buckt.ErrExtensionNotAllowed),
+		errors.Is(err, buckt.ErrMIMETypeNotAllowed),
+		errors.Is(err, buckt.ErrMIMETypeMismatch):
+		return 415
+	default:
+		return 500
+	}
+}
+
+// downloadErrorStatus maps errors returned when reading a file to a status code.
+func downloadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, buckt.ErrFileInfected):
+		return 403
+	case errors.Is(err, buckt.ErrFileScanPending):
+		return 409
+	case errors.Is(err, buckt.ErrRenditionNotFound):
+		return 404
+	default:
+		return 500
+	}
+}
+
 func parseRange(rangeHeader string, fileSize int64) (start, end int64, err error) {
 	// Example: "bytes=500-1000"
 	if !strings.HasPrefix(rangeHeader, "bytes=") {
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Rhaqim/buckt"
+	"github.com/Rhaqim/buckt/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// DownloadArchive implements domain.APIService.
+func (svc *APIService) DownloadArchive(c *gin.Context) {
+	serveArchive(c, svc.client)
+}
+
+// DownloadArchive implements domain.WebService.
+func (svc *WebService) DownloadArchive(c *gin.Context) {
+	serveArchive(c, svc.client)
+}
+
+// serveArchive streams the folder in the folder_id parameter, or the files in
+// the file_ids query (comma separated or repeated), as a zip or tar.gz archive.
+func serveArchive(c *gin.Context, client *buckt.Client) {
+	user_id := c.GetString("owner_id")
+
+	format := buckt.ArchiveZip
+	switch c.DefaultQuery("format", "zip") {
+	case "zip":
+	case "tar.gz", "tgz":
+		format = buckt.ArchiveTarGz
+	default:
+		c.AbortWithStatusJSON(400, response.Error("format must be zip or tar.gz", ""))
+		return
+	}
+
+	folderID := c.Param("folder_id")
+
+	var fileIDs []string
+	for _, ids := range c.QueryArray("file_ids") {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				fileIDs = append(fileIDs, id)
+			}
+		}
+	}
+
+	name := "files"
+	if folderID != "" {
+		name = folderID
+	} else if len(fileIDs) == 0 {
+		c.AbortWithStatusJSON(400, response.Error("folder_id or file_ids is required", ""))
+		return
+	}
+
+	// Set headers, the archive is streamed so its length is unknown
+	c.Header("Content-Disposition", "attachment; filename="+name+"."+string(format))
+	c.Header("Content-Type", format.ContentType())
+
+	var err error
+	if folderID != "" {
+		err = client.ArchiveFolder(c.Request.Context(), user_id, folderID, c.Writer, format)
+	} else {
+		err = client.ArchiveFiles(c.Request.Context(), user_id, fileIDs, c.Writer, format)
+	}
+
+	if err != nil {
+		if c.Writer.Written() {
+			// Too late for an error response, the client receives a truncated archive
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Writer.Header().Del("Content-Disposition")
+		c.Writer.Header().Del("Content-Type")
+		c.AbortWithStatusJSON(archiveErrorStatus(err), response.WrapError("failed to create archive", err))
+	}
+}
+
+// ExtractArchive implements domain.APIService.
+// It extracts an uploaded zip, tar or tar.gz archive into the parent_id folder
+// and responds with the extraction report.
+func (svc *APIService) ExtractArchive(c *gin.Context) {
+	// get the user_id from the context
+	user_id := c.GetString("owner_id")
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.AbortWithStatusJSON(400, response.Error("file is required", err.Error()))
+		return
+	}
+
+	// an empty parent_id extracts into the root folder
+	parentID := c.PostForm("parent_id")
+	conflict := buckt.ConflictPolicy(c.DefaultPostForm("conflict", string(buckt.ConflictSkip)))
+	switch conflict {
+	case buckt.ConflictSkip, buckt.ConflictOverwrite, buckt.ConflictRename:
+	default:
+		c.AbortWithStatusJSON(400, response.Error("conflict must be skip, overwrite or rename", ""))
+		return
+	}
+
+	archive, err := file.Open()
+	if err != nil {
+		c.AbortWithStatusJSON(500, response.WrapError("failed to process file", err))
+		return
+	}
+	defer archive.Close()
+
+	report, err := svc.client.ExtractArchive(c.Request.Context(), user_id, parentID, archive, conflict)
+	if err != nil {
+		// Entries extracted before the error are kept, report them with the error
+		c.AbortWithStatusJSON(extractErrorStatus(err), response.APIResponse[buckt.ExtractReport]{
+			Data:  report,
+			Error: &response.APIError{Message: "failed to extract archive", Details: err.Error()},
+		})
+		return
+	}
+
+	c.JSON(200, response.Success(report))
+}
+
+func extractErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, buckt.ErrFolderNotFound):
+		return 404
+	case errors.Is(err, buckt.ErrArchiveLimitExceeded):
+		return 413
+	case errors.Is(err, buckt.ErrUnsupportedArchive):
+		return 415
+	default:
+		return 500
+	}
+}
+
+func archiveErrorStatus(err error) int {
+	if errors.Is(err, buckt.ErrFolderNotFound) || errors.Is(err, buckt.ErrFileNotFound) {
+		return 404
+	}
+	return downloadErrorStatus(err)
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/Rhaqim/buckt"
+	"github.com/Rhaqim/buckt/internal/utils"
+	"github.com/Rhaqim/buckt/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+// batchRequest is the body of the batch delete, scrub and move endpoints.
+// IDs can mix files and folders.
+type batchRequest struct {
+	IDs         []string `json:"ids" binding:"required"`
+	NewParentID string   `json:"new_parent_id"`
+	Atomic      bool     `json:"atomic"`
+}
+
+// BatchDelete implements domain.APIService.
+func (svc *APIService) BatchDelete(c *gin.Context) {
+	svc.batch(c, func(user_id string, req batchRequest) ([]buckt.BatchResult, error) {
+		return svc.client.BatchDelete(c.Request.Context(), user_id, req.IDs, buckt.BatchOptions{Atomic: req.Atomic})
+	})
+}
+
+// BatchScrub implements domain.APIService.
+func (svc *APIService) BatchScrub(c *gin.Context) {
+	svc.batch(c, func(user_id string, req batchRequest) ([]buckt.BatchResult, error) {
+		return svc.client.BatchScrub(c.Request.Context(), user_id, req.IDs, buckt.BatchOptions{Atomic: req.Atomic})
+	})
+}
+
+// BatchMove implements domain.APIService.
+func (svc *APIService) BatchMove(c *gin.Context) {
+	svc.batch(c, func(user_id string, req batchRequest) ([]buckt.BatchResult, error) {
+		return svc.client.BatchMove(c.Request.Context(), user_id, req.IDs, req.NewParentID, buckt.BatchOptions{Atomic: req.Atomic})
+	})
+}
+
+// BatchUpload implements domain.APIService.
+// It uploads every part of the multipart "files" field into the parent_id folder.
+func (svc *APIService) BatchUpload(c *gin.Context) {
+	// get the user_id from the context
+	user_id := c.GetString("owner_id")
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["files"]) == 0 {
+		c.AbortWithStatusJSON(400, response.Error("files are required", ""))
+		return
+	}
+
+	atomic, _ := strconv.ParseBool(c.PostForm("atomic"))
+
+	var files []buckt.BatchUpload
+	for _, file := range form.File["files"] {
+		fileName, fileByte, err := utils.ProcessFile(file)
+		if err != nil {
+			c.AbortWithStatusJSON(500, response.WrapError("failed to process file", err))
+			return
+		}
+		files = append(files, buckt.BatchUpload{Name: fileName, ContentType: file.Header.Get("Content-Type"), Data: fileByte})
+	}
+
+	results, err := svc.client.BatchUpload(c.Request.Context(), user_id, c.PostForm("parent_id"), files, buckt.BatchOptions{Atomic: atomic})
+	respondBatch(c, results, err)
+}
+
+func (svc *APIService) batch(c *gin.Context, fn func(user_id string, req batchRequest) ([]buckt.BatchResult, error)) {
+	// get the user_id from the context
+	user_id := c.GetString("owner_id")
+
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(400, response.Error("invalid request", err.Error()))
+		return
+	}
+
+	results, err := fn(user_id, req)
+	respondBatch(c, results, err)
+}
+
+// respondBatch responds with the result of every item. Batches where only some
+// items failed succeed with 207, rolled back batches fail with 409.
+func respondBatch(c *gin.Context, results []buckt.BatchResult, err error) {
+	if err != nil {
+		status := 500
+		switch {
+		case errors.Is(err, buckt.ErrBatchRolledBack):
+			status = 409
+		case errors.Is(err, buckt.ErrFolderNotFound):
+			status = 404
+		}
+		c.AbortWithStatusJSON(status, response.APIResponse[[]buckt.BatchResult]{
+			Data:  results,
+			Error: &response.APIError{Message: "batch failed", Details: err.Error()},
+		})
+		return
+	}
+
+	status := 200
+	for _, result := range results {
+		if !result.OK() {
+			status = 207
+			break
+		}
+	}
+	c.JSON(status, response.Success(results))
+}
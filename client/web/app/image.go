@@ -0,0 +1,284 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/Rhaqim/buckt"
+	"github.com/Rhaqim/buckt/client/web/domain"
+	"github.com/Rhaqim/buckt/client/web/model"
+	"github.com/Rhaqim/buckt/internal/cache"
+	bucktdomain "github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/pkg/response"
+	"github.com/gin-gonic/gin"
+	"github.com/nfnt/resize"
+)
+
+var errInvalidImageParams = errors.New("invalid image parameters")
+
+// imageParams are the parsed query parameters of an /img request.
+type imageParams struct {
+	width   int
+	height  int
+	fit     string // clip, cover or fill
+	format  string // jpeg, png or gif, empty keeps the format of the original
+	quality int
+}
+
+type ImageService struct {
+	client *buckt.Client
+	conf   model.ImageConfig
+	cache  bucktdomain.LRUCache
+}
+
+func NewImageService(client *buckt.Client, conf model.ImageConfig) (domain.ImageService, error) {
+	conf.Validate()
+
+	// Counters are sized for roughly 10x the number of images of 10KB that fit
+	lru, err := cache.NewFileCache(conf.CacheSize/1000, conf.CacheSize, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image cache: %w", err)
+	}
+
+	return &ImageService{
+		client: client,
+		conf:   conf,
+		cache:  lru,
+	}, nil
+}
+
+// TransformImage implements domain.ImageService.
+// It serves /img/:file_id?w=300&h=200&fit=cover&fmt=jpeg&q=80.
+func (svc *ImageService) TransformImage(c *gin.Context) {
+	// get the file_id from the request
+	fileID := c.Param("file_id")
+	if fileID == "" {
+		c.AbortWithStatusJSON(400, response.Error("file_id is required", ""))
+		return
+	}
+
+	query := c.Request.URL.Query()
+	if len(svc.conf.SigningKey) > 0 && !hmac.Equal([]byte(query.Get("s")), []byte(signImage(svc.conf.SigningKey, fileID, query))) {
+		c.AbortWithStatusJSON(403, response.Error("invalid signature", ""))
+		return
+	}
+
+	params, err := parseImageParams(query, svc.conf)
+	if err != nil {
+		c.AbortWithStatusJSON(400, response.WrapError("invalid parameters", err))
+		return
+	}
+
+	// Derived images are keyed by the content of the original, so updates are
+	// never served stale and cached images need no read from storage
+	file, err := svc.client.GetFileInfo(fileID)
+	if err != nil {
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get file", err))
+		return
+	}
+	key := params.cacheKey(file.Hash)
+	if data, ok := svc.cache.Get(key); ok {
+		svc.serveImage(c, key, data)
+		return
+	}
+
+	file, stream, err := svc.client.GetFileStream(fileID)
+	if err != nil {
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get file", err))
+		return
+	}
+	defer stream.Close()
+
+	// The file may have been updated since, key the result by what is read
+	key = params.cacheKey(file.Hash)
+
+	src, err := io.ReadAll(stream)
+	if err != nil {
+		c.AbortWithStatusJSON(500, response.WrapError("failed to read file", err))
+		return
+	}
+
+	// Check the dimensions before decoding to reject decompression bombs
+	config, _, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		c.AbortWithStatusJSON(415, response.WrapError("file is not a supported image", err))
+		return
+	}
+	if config.Width*config.Height > svc.conf.MaxSourcePixels {
+		c.AbortWithStatusJSON(413, response.Error("image is too large to transform", ""))
+		return
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		c.AbortWithStatusJSON(415, response.WrapError("file is not a supported image", err))
+		return
+	}
+
+	data, err := transformImage(img, format, params)
+	if err != nil {
+		c.AbortWithStatusJSON(500, response.WrapError("failed to transform image", err))
+		return
+	}
+
+	svc.cache.Add(key, data)
+	svc.serveImage(c, key, data)
+}
+
+func (svc *ImageService) serveImage(c *gin.Context, key string, data []byte) {
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(key)))
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(304)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.Header("ETag", etag)
+	c.Data(200, contentTypeOf(data), data)
+}
+
+func parseImageParams(query url.Values, conf model.ImageConfig) (imageParams, error) {
+	params := imageParams{fit: "clip", quality: 80}
+
+	var err error
+	if params.width, err = intParam(query, "w", 0, conf.MaxWidth); err != nil {
+		return params, err
+	}
+	if params.height, err = intParam(query, "h", 0, conf.MaxHeight); err != nil {
+		return params, err
+	}
+	if q := query.Get("q"); q != "" {
+		if params.quality, err = intParam(query, "q", 1, 100); err != nil {
+			return params, err
+		}
+	}
+
+	switch fit := query.Get("fit"); fit {
+	case "":
+	case "clip", "cover", "fill":
+		params.fit = fit
+	default:
+		return params, fmt.Errorf("%w: unknown fit %q", errInvalidImageParams, fit)
+	}
+
+	switch format := query.Get("fmt"); format {
+	case "", "jpeg", "png", "gif":
+		params.format = format
+	case "jpg":
+		params.format = "jpeg"
+	default:
+		return params, fmt.Errorf("%w: unknown format %q", errInvalidImageParams, format)
+	}
+
+	return params, nil
+}
+
+func intParam(query url.Values, name string, min, max int) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%w: %s must be between %d and %d", errInvalidImageParams, name, min, max)
+	}
+	return n, nil
+}
+
+func (p imageParams) cacheKey(hash string) string {
+	return fmt.Sprintf("img:%s:%dx%d:%s:%s:%d", hash, p.width, p.height, p.fit, p.format, p.quality)
+}
+
+// transformImage resizes and crops img as requested by params and encodes it.
+func transformImage(img image.Image, format string, params imageParams) ([]byte, error) {
+	bounds := img.Bounds()
+	sw, sh := float64(bounds.Dx()), float64(bounds.Dy())
+	w, h := params.width, params.height
+
+	switch {
+	case w == 0 && h == 0:
+		// Re-encode only
+	case params.fit == "fill":
+		img = resize.Resize(uint(w), uint(h), img, resize.Lanczos3)
+	case params.fit == "cover" && w > 0 && h > 0:
+		// Scale until the box is covered, then crop the centre
+		scale := math.Max(float64(w)/sw, float64(h)/sh)
+		img = resize.Resize(uint(math.Ceil(sw*scale)), uint(math.Ceil(sh*scale)), img, resize.Lanczos3)
+		b := img.Bounds()
+		x, y := b.Min.X+(b.Dx()-w)/2, b.Min.Y+(b.Dy()-h)/2
+		img = img.(interface {
+			SubImage(r image.Rectangle) image.Image
+		}).SubImage(image.Rect(x, y, x+w, y+h))
+	default:
+		// Fit inside the box, never scaling up
+		if w == 0 {
+			w = bounds.Dx()
+		}
+		if h == 0 {
+			h = bounds.Dy()
+		}
+		img = resize.Thumbnail(uint(w), uint(h), img, resize.Lanczos3)
+	}
+
+	if params.format != "" {
+		format = params.format
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: params.quality})
+	}
+	return buf.Bytes(), err
+}
+
+func contentTypeOf(data []byte) string {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return "image/" + format
+}
+
+// signImage returns the signature of an /img URL: the hex encoded HMAC-SHA256
+// of the file ID and the sorted query parameters, without the signature itself.
+func signImage(key []byte, fileID string, query url.Values) string {
+	params := url.Values{}
+	for k, v := range query {
+		if k != "s" {
+			params[k] = v
+		}
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(fileID + "?" + params.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignImageURL returns the query string of a signed /img URL for the given parameters.
+func SignImageURL(key []byte, fileID string, params url.Values) string {
+	signed := url.Values{}
+	for k, v := range params {
+		signed[k] = v
+	}
+	signed.Set("s", signImage(key, fileID, params))
+	return signed.Encode()
+}
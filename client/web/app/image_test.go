@@ -0,0 +1,134 @@
+package app
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt"
+	"github.com/Rhaqim/buckt/client/web/model"
+	"github.com/gin-gonic/gin"
+)
+
+func setupImageTest(t *testing.T) (*buckt.Client, *ImageService, *gin.Engine) {
+	t.Chdir(t.TempDir())
+	gin.SetMode(gin.TestMode)
+
+	client, err := buckt.Default(buckt.MediaDir("media"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	svc, err := NewImageService(client, model.ImageConfig{CacheSize: 1 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/img/:file_id", svc.TransformImage)
+	return client, svc.(*ImageService), router
+}
+
+func uploadPNG(t *testing.T, client *buckt.Client, width, height int) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	fileID, err := client.UploadFile("user", "", "image.png", "image/png", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileID
+}
+
+func getImage(router *gin.Engine, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	return w
+}
+
+func TestTransformImage(t *testing.T) {
+	client, _, router := setupImageTest(t)
+	fileID := uploadPNG(t, client, 40, 20)
+
+	w := getImage(router, "/img/"+fileID+"?w=10&fmt=jpeg")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type = %q, want image/jpeg", got)
+	}
+	config, _, err := image.DecodeConfig(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 10 || config.Height != 5 {
+		t.Errorf("size = %dx%d, want 10x5", config.Width, config.Height)
+	}
+
+	// The ETag of a derived image lets clients revalidate without a body
+	req := httptest.NewRequest(http.MethodGet, "/img/"+fileID+"?w=10&fmt=jpeg", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", w.Code)
+	}
+
+	for _, query := range []string{"w=-1", "w=99999", "fit=tile", "fmt=webp", "q=0"} {
+		if w := getImage(router, "/img/"+fileID+"?"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestTransformImageServesCacheWithoutReadingStorage(t *testing.T) {
+	client, svc, router := setupImageTest(t)
+	fileID := uploadPNG(t, client, 40, 20)
+
+	if w := getImage(router, "/img/"+fileID+"?w=10"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// The image cache admits entries asynchronously
+	file, err := client.GetFileInfo(fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := imageParams{width: 10, fit: "clip", quality: 80}.cacheKey(file.Hash)
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, ok := svc.cache.Get(key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("transformed image was not cached")
+		}
+	}
+
+	// With the stored object gone only a cache hit can still be served
+	err = filepath.WalkDir("media", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := getImage(router, "/img/"+fileID+"?w=10"); w.Code != http.StatusOK {
+		t.Errorf("cached status = %d, body = %s", w.Code, w.Body)
+	}
+	if w := getImage(router, "/img/"+fileID+"?w=20"); w.Code == http.StatusOK {
+		t.Error("uncached image was served without its original")
+	}
+}
@@ -200,7 +200,7 @@ func (svc *WebService) UploadFile(c *gin.Context) {
 
 		_, err = svc.client.UploadFile(user_id, folderID, fileName, file.Header.Get("Content-Type"), fileByte)
 		if err != nil {
-			c.AbortWithStatusJSON(500, response.WrapError("failed to create file", err))
+			c.AbortWithStatusJSON(uploadErrorStatus(err), response.WrapError("failed to create file", err))
 			return
 		}
 
@@ -227,7 +227,7 @@ func (svc *WebService) DownloadFile(c *gin.Context) {
 	// get the file
 	file, err := svc.client.GetFile(fileID)
 	if err != nil {
-		c.AbortWithStatusJSON(500, response.WrapError("failed to get file", err))
+		c.AbortWithStatusJSON(downloadErrorStatus(err), response.WrapError("failed to get file", err))
 		return
 	}
 
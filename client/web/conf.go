@@ -1,6 +1,11 @@
 package web
 
-import "github.com/Rhaqim/buckt/client/web/model"
+import (
+	"net/url"
+
+	"github.com/Rhaqim/buckt/client/web/app"
+	"github.com/Rhaqim/buckt/client/web/model"
+)
 
 type WebMode = model.WebMode
 
@@ -18,7 +23,22 @@ const (
 	WebModeMount = model.WebModeMount
 )
 
+// ImageConfig configures the /img transformation endpoint.
+type ImageConfig = model.ImageConfig
+
 type Config struct {
-	Mode  WebMode
-	Debug bool
+	Mode   WebMode
+	Debug  bool
+	Images ImageConfig
+	// AdminToken enables the /admin endpoints for clients sending it as a
+	// bearer token. Empty disables them.
+	AdminToken string
+}
+
+// SignImageURL returns the signed query string for an /img URL, required when
+// ImageConfig.SigningKey is set.
+//
+//	"/img/" + fileID + "?" + web.SignImageURL(key, fileID, url.Values{"w": {"300"}})
+func SignImageURL(key []byte, fileID string, params url.Values) string {
+	return app.SignImageURL(key, fileID, params)
 }
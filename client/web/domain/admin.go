@@ -0,0 +1,15 @@
+package domain
+
+import "github.com/gin-gonic/gin"
+
+// AdminService serves the endpoints that operate the whole instance.
+type AdminService interface {
+	MigrationStatus(c *gin.Context)
+	StartMigration(c *gin.Context)
+	PauseMigration(c *gin.Context)
+	ResumeMigration(c *gin.Context)
+	CancelMigration(c *gin.Context)
+	MigrationEvents(c *gin.Context)
+	GetThrottle(c *gin.Context)
+	SetThrottle(c *gin.Context)
+}
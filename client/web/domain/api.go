@@ -14,6 +14,13 @@ type APIService interface {
 	DownloadFile(c *gin.Context)
 	ServeFile(c *gin.Context)
 	StreamFile(c *gin.Context)
+	ServeRendition(c *gin.Context)
+	DownloadArchive(c *gin.Context)
+	ExtractArchive(c *gin.Context)
+	BatchDelete(c *gin.Context)
+	BatchScrub(c *gin.Context)
+	BatchMove(c *gin.Context)
+	BatchUpload(c *gin.Context)
 	DeleteFile(c *gin.Context)
 	DeleteFilePermanently(c *gin.Context)
 
@@ -0,0 +1,7 @@
+package domain
+
+import "github.com/gin-gonic/gin"
+
+type ImageService interface {
+	TransformImage(c *gin.Context)
+}
@@ -5,4 +5,5 @@ import "github.com/gin-gonic/gin"
 type Middleware interface {
 	APIGuardMiddleware() gin.HandlerFunc
 	WebGuardMiddleware() gin.HandlerFunc
+	AdminGuardMiddleware() gin.HandlerFunc
 }
@@ -12,6 +12,7 @@ type WebService interface {
 
 	UploadFile(c *gin.Context)
 	DownloadFile(c *gin.Context)
+	DownloadArchive(c *gin.Context)
 	MoveFile(c *gin.Context)
 	DeleteFile(c *gin.Context)
 	DeleteFilePermanently(c *gin.Context)
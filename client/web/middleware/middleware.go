@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"log"
 
 	"github.com/Rhaqim/buckt/client/web/domain"
@@ -8,15 +9,30 @@ import (
 )
 
 type bucketMiddleware struct {
-	logger  *log.Logger
-	mounted bool
+	logger     *log.Logger
+	mounted    bool
+	adminToken string
 }
 
-func NewBucketMiddleware(bucktLog *log.Logger, mounted bool) domain.Middleware {
-	return &bucketMiddleware{
+// Option configures the middleware.
+type Option func(*bucketMiddleware)
+
+// WithAdminToken enables the admin endpoints for requests bearing token.
+func WithAdminToken(token string) Option {
+	return func(b *bucketMiddleware) {
+		b.adminToken = token
+	}
+}
+
+func NewBucketMiddleware(bucktLog *log.Logger, mounted bool, opts ...Option) domain.Middleware {
+	b := &bucketMiddleware{
 		logger:  bucktLog,
 		mounted: mounted,
 	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 func (b *bucketMiddleware) APIGuardMiddleware() gin.HandlerFunc {
@@ -51,3 +67,24 @@ func (b *bucketMiddleware) WebGuardMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AdminGuardMiddleware implements domain.Middleware.
+// Admin endpoints require the admin token as a bearer token and are disabled
+// without one.
+func (b *bucketMiddleware) AdminGuardMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if b.adminToken == "" {
+			c.AbortWithStatusJSON(403, gin.H{"error": "forbidden", "message": "admin endpoints are disabled"})
+			return
+		}
+
+		token := c.GetHeader("Authorization")
+		if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+b.adminToken)) != 1 {
+			b.logger.Printf("unauthorised: invalid admin token")
+			c.AbortWithStatusJSON(401, gin.H{"error": "unauthorised", "message": "invalid admin token"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -21,3 +21,32 @@ func (wm WebMode) String() string {
 		return "All"
 	}
 }
+
+// ImageConfig configures the /img transformation endpoint.
+type ImageConfig struct {
+	// MaxWidth and MaxHeight bound the requested output size, default 4096.
+	MaxWidth  int
+	MaxHeight int
+	// MaxSourcePixels bounds the size of the originals that are decoded, default 50 megapixels.
+	MaxSourcePixels int
+	// CacheSize is the number of bytes of transformed images kept in memory, default 256MB.
+	CacheSize int64
+	// SigningKey requires every URL to be signed with the key, see SignImageURL. Empty disables signing.
+	SigningKey []byte
+}
+
+// Validate sets defaults for unset fields.
+func (c *ImageConfig) Validate() {
+	if c.MaxWidth <= 0 {
+		c.MaxWidth = 4096
+	}
+	if c.MaxHeight <= 0 {
+		c.MaxHeight = 4096
+	}
+	if c.MaxSourcePixels <= 0 {
+		c.MaxSourcePixels = 50_000_000
+	}
+	if c.CacheSize <= 0 {
+		c.CacheSize = 256 << 20
+	}
+}
@@ -17,6 +17,8 @@ type Router struct {
 
 	domain.APIService
 	domain.WebService
+	domain.ImageService
+	domain.AdminService
 	domain.Middleware
 }
 
@@ -30,6 +32,8 @@ func NewRouter(
 
 	apiService domain.APIService,
 	webService domain.WebService,
+	imageService domain.ImageService,
+	adminService domain.AdminService,
 	middleware domain.Middleware,
 ) domain.RouterService {
 	r := gin.New()
@@ -53,9 +57,11 @@ func NewRouter(
 
 		mode: mode,
 
-		APIService: apiService,
-		WebService: webService,
-		Middleware: middleware,
+		APIService:   apiService,
+		WebService:   webService,
+		ImageService: imageService,
+		AdminService: adminService,
+		Middleware:   middleware,
 	}
 
 	return router
@@ -83,6 +89,8 @@ func (r *Router) registerBaseRoutes() {
 	})
 	r.GET("/serve/:file_id", r.APIService.ServeFile)
 	r.GET("/stream/:file_id", r.APIService.StreamFile)
+	r.GET("/thumb/:file_id/:size", r.APIService.ServeRendition)
+	r.GET("/img/:file_id", r.ImageService.TransformImage)
 }
 
 // RegisterAPIRoutes sets up API endpoints
@@ -91,11 +99,21 @@ func (r *Router) registerAPIRoutes() {
 		r.Use(r.APIGuardMiddleware())
 		{
 			r.POST("/upload", r.APIService.UploadFile)
+			r.POST("/extract", r.APIService.ExtractArchive)
 			r.GET("/download/:file_id", r.APIService.DownloadFile)
+			r.GET("/archive", r.APIService.DownloadArchive)
+			r.GET("/archive/:folder_id", r.APIService.DownloadArchive)
 			r.DELETE("/delete/:file_id", r.APIService.DeleteFile)
 			r.DELETE("/scrub/:file_id", r.APIService.DeleteFilePermanently)
 		}
 
+		{
+			r.POST("/batch/upload", r.APIService.BatchUpload)
+			r.POST("/batch/delete", r.APIService.BatchDelete)
+			r.POST("/batch/scrub", r.APIService.BatchScrub)
+			r.POST("/batch/move", r.APIService.BatchMove)
+		}
+
 		{
 			r.POST("/new_folder", r.APIService.CreateFolder)
 			r.GET("/folder_content/:folder_id", r.APIService.GetFolderContent)
@@ -110,6 +128,24 @@ func (r *Router) registerAPIRoutes() {
 	}
 }
 
+// registerAdminRoutes sets up the endpoints that operate the whole instance
+func (r *Router) registerAdminRoutes() {
+	admin := r.Group("/admin")
+	{
+		admin.Use(r.AdminGuardMiddleware())
+		{
+			admin.GET("/migration", r.AdminService.MigrationStatus)
+			admin.GET("/migration/events", r.AdminService.MigrationEvents)
+			admin.POST("/migration/start", r.AdminService.StartMigration)
+			admin.POST("/migration/pause", r.AdminService.PauseMigration)
+			admin.POST("/migration/resume", r.AdminService.ResumeMigration)
+			admin.POST("/migration/cancel", r.AdminService.CancelMigration)
+			admin.GET("/throttle", r.AdminService.GetThrottle)
+			admin.PUT("/throttle", r.AdminService.SetThrottle)
+		}
+	}
+}
+
 // RegisterWebRoutes sets up the web interface routes
 func (r *Router) registerWebRoutes() {
 	/* Web Routes */
@@ -127,6 +163,8 @@ func (r *Router) registerWebRoutes() {
 
 			web.POST("/upload", r.WebService.UploadFile)
 			web.GET("/file/:file_id", r.WebService.DownloadFile)
+			web.GET("/archive", r.WebService.DownloadArchive)
+			web.GET("/archive/:folder_id", r.WebService.DownloadArchive)
 			web.PUT("/file/:file_id", r.WebService.MoveFile)
 			web.DELETE("/file/:file_id", r.WebService.DeleteFile)
 			web.DELETE("/scrub/:file_id", r.WebService.DeleteFilePermanently)
@@ -139,6 +177,11 @@ func (r *Router) registerAllRoutes(mode model.WebMode) {
 	// Register core routes
 	r.registerBaseRoutes()
 
+	// Admin routes go before the API routes, whose guard applies to every route registered after it
+	if mode != model.WebModeUI {
+		r.registerAdminRoutes()
+	}
+
 	switch mode {
 	case model.WebModeAPI, model.WebModeMount:
 		r.registerAPIRoutes()
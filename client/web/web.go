@@ -22,18 +22,28 @@ func NewClient(bucktClient *buckt.Client, conf ...Config) (domain.RouterService,
 
 	var apiService domain.APIService = app.NewAPIService(bucktClient)
 	var webService domain.WebService = app.NewWebService(bucktClient)
+	var adminService domain.AdminService = app.NewAdminService(bucktClient)
 
 	mode := WebModeAll
 	debug := false
+	var images ImageConfig
+	var adminToken string
 
 	// Apply any provided configuration options
 	for _, c := range conf {
 		mode = c.Mode
 		debug = c.Debug
+		images = c.Images
+		adminToken = c.AdminToken
+	}
+
+	imageService, err := app.NewImageService(bucktClient, images)
+	if err != nil {
+		return nil, err
 	}
 
 	// 	// middleware server
-	var middleware domain.Middleware = middleware.NewBucketMiddleware(logger, mode == WebModeMount)
+	var middleware domain.Middleware = middleware.NewBucketMiddleware(logger, mode == WebModeMount, middleware.WithAdminToken(adminToken))
 
 	router := router.NewRouter(
 		logger,
@@ -42,6 +52,8 @@ func NewClient(bucktClient *buckt.Client, conf ...Config) (domain.RouterService,
 		mode,
 		apiService,
 		webService,
+		imageService,
+		adminService,
 		middleware)
 
 	return router, nil
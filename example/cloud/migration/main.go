@@ -10,7 +10,7 @@
 //   - Replace the placeholder AWS credentials with actual values
 //   - Ensure the specified S3 bucket exists and is accessible
 //   - Pass in the enabled migration configuration to support dual-write operations
-//   - Run the program to establish a connection to AWS S3 through buckt
+//   - Run the program to copy the existing local files to AWS S3 through buckt
 //
 // Prerequisites:
 //   - Valid AWS credentials (Access Key and Secret Key)
@@ -19,7 +19,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/Rhaqim/buckt"
 	"github.com/Rhaqim/buckt/cloud/aws"
@@ -41,8 +43,8 @@ func main() {
 		return
 	}
 
-	// Enable migration mode
-	migration := buckt.EnableMigration()
+	// Enable migration mode, copying 16 objects at once
+	migration := buckt.WithMigration(buckt.MigrationConfig{Concurrency: 16})
 
 	// Register AWS S3 as the secondary backend for migration target
 	backend := buckt.RegisterSecondaryBackend(awsBackend)
@@ -56,4 +58,29 @@ func main() {
 	defer client.Close()
 
 	fmt.Println("Buckt Client initialized successfully")
+
+	// Copy the files stored before the migration, new files are written to both backends
+	done := make(chan error, 1)
+	go func() {
+		done <- client.MigrateAll(context.Background())
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				fmt.Println("Migration failed:", err)
+				return
+			}
+			completed, total, _ := client.MigrationStatus(context.Background())
+			fmt.Printf("Migration complete: %d of %d objects\n", completed, total)
+			return
+		case <-ticker.C:
+			completed, total, _ := client.MigrationStatus(context.Background())
+			fmt.Printf("Migrating: %d of %d objects\n", completed, total)
+		}
+	}
 }
@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Rhaqim/buckt/internal/cache"
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+// CachedBackend is a read-through cache in front of a slow, usually remote,
+// FileBackend. Reads are served from the in-memory LRU cache (L1), then from
+// the on-disk cache, and only then from the inner backend. Mutations
+// invalidate both levels.
+//
+// Streams are cached on disk only, and only once they have been read to the end.
+type CachedBackend struct {
+	logger domain.BucktLogger
+	inner  domain.FileBackend
+	l1     domain.LRUCache
+	disk   *cache.DiskCache
+
+	// mu orders cache fills against invalidations, version is bumped by every
+	// invalidation so fills of data read before it are dropped.
+	mu      sync.Mutex
+	version atomic.Uint64
+	// epoch is part of every L1 key and bumped by DeleteFolder, since the
+	// L1 cache cannot be invalidated by prefix.
+	epoch atomic.Uint64
+
+	l1Hits   atomic.Uint64
+	l1Misses atomic.Uint64
+}
+
+var _ domain.FileBackend = (*CachedBackend)(nil)
+var _ domain.StatBackend = (*CachedBackend)(nil)
+var _ domain.RepairableBackend = (*CachedBackend)(nil)
+
+// uncachedKey marks contexts whose reads bypass the cache, see WithoutCache.
+type uncachedKey struct{}
+
+// WithoutCache returns a context whose reads through a CachedBackend go to the
+// inner backend, for callers that must see the stored object rather than a
+// cached copy of it, such as the integrity checker.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, uncachedKey{}, true)
+}
+
+func uncached(ctx context.Context) bool {
+	return ctx.Value(uncachedKey{}) != nil
+}
+
+func NewCachedBackend(logger domain.BucktLogger, inner domain.FileBackend, l1 domain.LRUCache, disk *cache.DiskCache) *CachedBackend {
+	logger.Info("💾 Initialising disk cache")
+	return &CachedBackend{
+		logger: logger,
+		inner:  inner,
+		l1:     l1,
+		disk:   disk,
+	}
+}
+
+// Name implements domain.FileBackend.
+func (c *CachedBackend) Name() string {
+	return c.inner.Name()
+}
+
+// Put implements domain.FileBackend.
+func (c *CachedBackend) Put(ctx context.Context, path string, data []byte) error {
+	defer c.invalidate(path)
+	return c.inner.Put(ctx, path, data)
+}
+
+// Get implements domain.FileBackend.
+func (c *CachedBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	if uncached(ctx) {
+		return c.inner.Get(ctx, path)
+	}
+
+	version := c.version.Load()
+
+	if data, ok := c.l1.Get(c.l1Key(path)); ok {
+		c.l1Hits.Add(1)
+		return data, nil
+	}
+	c.l1Misses.Add(1)
+
+	if data, ok := c.disk.Get(path); ok {
+		c.fill(version, path, data, nil)
+		return data, nil
+	}
+
+	data, err := c.inner.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := c.disk.Create(path)
+	if err != nil {
+		c.logger.Errorf("failed to cache %s: %v", path, err)
+		return data, nil
+	}
+	w.Write(data)
+	c.fill(version, path, data, w)
+
+	return data, nil
+}
+
+// Stream implements domain.FileBackend.
+func (c *CachedBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	if uncached(ctx) {
+		return c.inner.Stream(ctx, path)
+	}
+
+	version := c.version.Load()
+
+	if data, ok := c.l1.Get(c.l1Key(path)); ok {
+		c.l1Hits.Add(1)
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	c.l1Misses.Add(1)
+
+	if rc, ok := c.disk.Open(path); ok {
+		return rc, nil
+	}
+
+	rc, err := c.inner.Stream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := c.disk.Create(path)
+	if err != nil {
+		c.logger.Errorf("failed to cache %s: %v", path, err)
+		return rc, nil
+	}
+
+	return &cachingReader{ReadCloser: rc, w: w, commit: func() {
+		c.fill(version, path, nil, w)
+	}}, nil
+}
+
+// Stat implements domain.StatBackend.
+func (c *CachedBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	if stater, ok := c.inner.(domain.StatBackend); ok {
+		return stater.Stat(ctx, path)
+	}
+	return statByReading(ctx, c, path)
+}
+
+// RepairObject implements domain.RepairableBackend when the inner backend does.
+// Cached copies are dropped so the repaired object is served afterwards.
+func (c *CachedBackend) RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error) {
+	repairer, ok := c.inner.(domain.RepairableBackend)
+	if !ok {
+		return false, nil
+	}
+	defer c.invalidate(path)
+	return repairer.RepairObject(ctx, path, verify)
+}
+
+// List implements domain.FileBackend.
+func (c *CachedBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return c.inner.List(ctx, prefix)
+}
+
+// Delete implements domain.FileBackend.
+func (c *CachedBackend) Delete(ctx context.Context, path string) error {
+	defer c.invalidate(path)
+	return c.inner.Delete(ctx, path)
+}
+
+// Exists implements domain.FileBackend.
+func (c *CachedBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return c.inner.Exists(ctx, path)
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (c *CachedBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.version.Add(1)
+		c.epoch.Add(1)
+		c.disk.RemovePrefix(strings.TrimSuffix(prefix, "/") + "/")
+	}()
+	return c.inner.DeleteFolder(ctx, prefix)
+}
+
+// Move implements domain.FileBackend.
+func (c *CachedBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	defer c.invalidate(oldPath, newPath)
+	return c.inner.Move(ctx, oldPath, newPath)
+}
+
+// Stats returns the hit and miss counters of both cache levels.
+func (c *CachedBackend) Stats() model.CacheStats {
+	stats := model.CacheStats{
+		L1Hits:   c.l1Hits.Load(),
+		L1Misses: c.l1Misses.Load(),
+	}
+	c.disk.Stats(&stats)
+	return stats
+}
+
+// Close persists the index of the disk cache.
+func (c *CachedBackend) Close() error {
+	return c.disk.Close()
+}
+
+// fill adds data to L1 and commits w to the disk cache, unless the path was
+// invalidated since version was read.
+func (c *CachedBackend) fill(version uint64, path string, data []byte, w *cache.DiskCacheWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.version.Load() != version {
+		if w != nil {
+			w.Abort()
+		}
+		return
+	}
+
+	if data != nil {
+		c.l1.Add(c.l1Key(path), data)
+	}
+	if w != nil {
+		if err := w.Commit(); err != nil {
+			c.logger.Errorf("failed to cache %s: %v", path, err)
+		}
+	}
+}
+
+func (c *CachedBackend) invalidate(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.version.Add(1)
+	for _, p := range paths {
+		c.l1.Remove(c.l1Key(p))
+		c.disk.Remove(p)
+	}
+}
+
+func (c *CachedBackend) l1Key(path string) string {
+	return fmt.Sprintf("cached:%d:%s", c.epoch.Load(), path)
+}
+
+// cachingReader copies a stream into the disk cache and commits it once the
+// stream has been read to the end.
+type cachingReader struct {
+	io.ReadCloser
+	w      *cache.DiskCacheWriter
+	commit func()
+	done   bool
+}
+
+func (r *cachingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.done {
+		r.w.Write(p[:n])
+	}
+	if err == io.EOF && !r.done {
+		r.done = true
+		r.commit()
+	}
+	return n, err
+}
+
+func (r *cachingReader) Close() error {
+	if !r.done {
+		r.done = true
+		r.w.Abort()
+	}
+	return r.ReadCloser.Close()
+}
+
+// statObject stats path with b, or by reading it when b cannot stat objects.
+func statObject(ctx context.Context, b domain.FileBackend, path string) (*model.FileInfo, error) {
+	if stater, ok := b.(domain.StatBackend); ok {
+		return stater.Stat(ctx, path)
+	}
+	return statByReading(ctx, b, path)
+}
+
+// statByReading builds the metadata of an object by reading it, for backends
+// that cannot stat objects.
+func statByReading(ctx context.Context, b domain.FileBackend, path string) (*model.FileInfo, error) {
+	data, err := b.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &model.FileInfo{Size: int64(len(data)), ContentType: contentType}, nil
+}
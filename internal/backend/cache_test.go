@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/cache"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapLRU is a synchronous domain.LRUCache, ristretto applies writes asynchronously.
+type mapLRU struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (c *mapLRU) Add(key string, value []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[key] = value
+	return false
+}
+
+func (c *mapLRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[key]
+	return v, ok
+}
+
+func (c *mapLRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, key)
+}
+
+func (c *mapLRU) Hits() uint64   { return 0 }
+func (c *mapLRU) Misses() uint64 { return 0 }
+func (c *mapLRU) Close()         {}
+
+func setupCacheTest(t *testing.T, dir string, maxSize int64, policy model.EvictionPolicy) (*CachedBackend, *mocks.FaultyBackend, *mapLRU) {
+	disk, err := cache.NewDiskCache(dir, maxSize, policy)
+	assert.NoError(t, err)
+
+	inner := mocks.NewFaultyBackend(mocks.NewMemoryBackend("memory"))
+	l1 := &mapLRU{m: map[string][]byte{}}
+	log := logger.NewLogger("", true, false)
+	return NewCachedBackend(log, inner, l1, disk), inner, l1
+}
+
+func TestCachedReadThrough(t *testing.T) {
+	ctx := t.Context()
+	cb, inner, l1 := setupCacheTest(t, t.TempDir(), 1<<20, model.EvictLRU)
+
+	assert.NoError(t, cb.Put(ctx, "a.txt", []byte("hello")))
+
+	for range 3 {
+		data, err := cb.Get(ctx, "a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("hello"), data)
+	}
+	assert.Equal(t, 1, inner.Calls("Get"))
+
+	// Served from disk once L1 lost the entry.
+	l1.m = map[string][]byte{}
+	data, err := cb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+	assert.Equal(t, 1, inner.Calls("Get"))
+
+	stats := cb.Stats()
+	assert.Equal(t, uint64(2), stats.L1Hits)
+	assert.Equal(t, uint64(2), stats.L1Misses)
+	assert.Equal(t, uint64(1), stats.DiskHits)
+	assert.Equal(t, uint64(1), stats.DiskMisses)
+	assert.Equal(t, 1, stats.DiskEntries)
+	assert.Equal(t, int64(5), stats.DiskSize)
+}
+
+func TestCachedInvalidation(t *testing.T) {
+	ctx := t.Context()
+	cb, inner, _ := setupCacheTest(t, t.TempDir(), 1<<20, model.EvictLRU)
+
+	assert.NoError(t, cb.Put(ctx, "dir/a.txt", []byte("one")))
+	_, err := cb.Get(ctx, "dir/a.txt")
+	assert.NoError(t, err)
+
+	assert.NoError(t, cb.Put(ctx, "dir/a.txt", []byte("two")))
+	data, err := cb.Get(ctx, "dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("two"), data)
+
+	assert.NoError(t, cb.Move(ctx, "dir/a.txt", "dir/b.txt"))
+	_, err = cb.Get(ctx, "dir/a.txt")
+	assert.Error(t, err)
+	data, err = cb.Get(ctx, "dir/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("two"), data)
+
+	assert.NoError(t, cb.DeleteFolder(ctx, "dir"))
+	_, err = cb.Get(ctx, "dir/b.txt")
+	assert.Error(t, err)
+
+	assert.NoError(t, cb.Put(ctx, "c.txt", []byte("three")))
+	_, err = cb.Get(ctx, "c.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, cb.Delete(ctx, "c.txt"))
+	_, err = cb.Get(ctx, "c.txt")
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, cb.Stats().DiskEntries)
+	assert.Equal(t, 7, inner.Calls("Get"))
+}
+
+func TestCachedStream(t *testing.T) {
+	ctx := t.Context()
+	cb, inner, _ := setupCacheTest(t, t.TempDir(), 1<<20, model.EvictLRU)
+	data := randomBytes(t, 10000)
+	assert.NoError(t, cb.Put(ctx, "big.bin", data))
+
+	// A stream closed early is not cached.
+	rc, err := cb.Stream(ctx, "big.bin")
+	assert.NoError(t, err)
+	_, err = rc.Read(make([]byte, 10))
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, 0, cb.Stats().DiskEntries)
+
+	for range 2 {
+		rc, err = cb.Stream(ctx, "big.bin")
+		assert.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.NoError(t, rc.Close())
+		assert.Equal(t, data, got)
+	}
+	assert.Equal(t, 2, inner.Calls("Stream"))
+	assert.Equal(t, 1, cb.Stats().DiskEntries)
+}
+
+func TestCachedWithoutCache(t *testing.T) {
+	ctx := t.Context()
+	cb, inner, _ := setupCacheTest(t, t.TempDir(), 1<<20, model.EvictLRU)
+
+	assert.NoError(t, cb.Put(ctx, "a.txt", []byte("hello")))
+	_, err := cb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+
+	// Changed behind the cache, only uncached reads see it
+	assert.NoError(t, inner.Put(ctx, "a.txt", []byte("hellO")))
+
+	data, err := cb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	data, err = cb.Get(WithoutCache(ctx), "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hellO"), data)
+
+	rc, err := cb.Stream(WithoutCache(ctx), "a.txt")
+	assert.NoError(t, err)
+	data, err = io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, []byte("hellO"), data)
+	assert.Equal(t, 2, inner.Calls("Get"))
+	assert.Equal(t, 1, inner.Calls("Stream"))
+}
+
+func TestCachedEvictionAndPersistence(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	cb, inner, l1 := setupCacheTest(t, dir, 100, model.EvictLFU)
+
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NoError(t, cb.Put(ctx, name, randomBytes(t, 40)))
+	}
+
+	_, err := cb.Get(ctx, "a")
+	assert.NoError(t, err)
+	_, err = cb.Get(ctx, "b")
+	assert.NoError(t, err)
+	l1.m = map[string][]byte{}
+	_, err = cb.Get(ctx, "a")
+	assert.NoError(t, err)
+
+	// c does not fit, b is used least and evicted.
+	_, err = cb.Get(ctx, "c")
+	assert.NoError(t, err)
+	stats := cb.Stats()
+	assert.Equal(t, uint64(1), stats.DiskEvictions)
+	assert.Equal(t, 2, stats.DiskEntries)
+	assert.NoError(t, cb.Close())
+
+	// The index survives a restart.
+	disk, err := cache.NewDiskCache(dir, 100, model.EvictLFU)
+	assert.NoError(t, err)
+	reopened := NewCachedBackend(logger.NewLogger("", true, false), inner, &mapLRU{m: map[string][]byte{}}, disk)
+
+	calls := inner.Calls("Get")
+	_, err = reopened.Get(ctx, "a")
+	assert.NoError(t, err)
+	_, err = reopened.Get(ctx, "c")
+	assert.NoError(t, err)
+	assert.Equal(t, calls, inner.Calls("Get"))
+	assert.Equal(t, 2, reopened.Stats().DiskEntries)
+}
+
+func TestCachedDiskDirectory(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	// Files of others in the directory are left alone
+	notes := filepath.Join(dir, "notes.txt")
+	assert.NoError(t, os.WriteFile(notes, []byte("keep me"), 0644))
+	stale := filepath.Join(dir, strings.Repeat("ab", sha256.Size)+".cache")
+	assert.NoError(t, os.WriteFile(stale, []byte("stale"), 0644))
+
+	cb, inner, _ := setupCacheTest(t, dir, 1000, model.EvictLRU)
+	assert.FileExists(t, notes)
+	assert.NoFileExists(t, stale)
+
+	for _, name := range []string{"a", "b"} {
+		assert.NoError(t, cb.Put(ctx, name, randomBytes(t, 40)))
+		_, err := cb.Get(ctx, name)
+		assert.NoError(t, err)
+	}
+
+	// The index is saved without Close, so a crash keeps the cached files
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "buckt-cache-index.json"))
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	disk, err := cache.NewDiskCache(dir, 1000, model.EvictLRU)
+	assert.NoError(t, err)
+	reopened := NewCachedBackend(logger.NewLogger("", true, false), inner, &mapLRU{m: map[string][]byte{}}, disk)
+	assert.Equal(t, 2, reopened.Stats().DiskEntries)
+	assert.FileExists(t, notes)
+	assert.NoError(t, reopened.Close())
+	assert.NoError(t, cb.Close())
+}
@@ -0,0 +1,322 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+const (
+	cmpMagic     = "BKTZ"
+	cmpVersion   = 1
+	cmpHeaderLen = len(cmpMagic) + 2 + 8
+
+	cmpAlgoNone byte = 0
+	cmpAlgoGzip byte = 1
+
+	// DefaultCompressionMinSize is the smallest object worth compressing.
+	DefaultCompressionMinSize = 1024
+
+	// cmpMaxPrealloc bounds the buffer allocated up front for decompressed
+	// content, the logical size in the header is read from storage and not
+	// trusted. Larger objects grow the buffer while they are read.
+	cmpMaxPrealloc = 16 << 20
+)
+
+// DefaultSkipContentTypes lists content types that are already compressed.
+// Entries ending in "/" match a whole top level type.
+var DefaultSkipContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/pdf",
+}
+
+// CompressedBackend wraps a FileBackend and transparently gzips objects
+// before they are stored. Compressed objects carry a small header:
+//
+//	magic(4) | version(1) | algorithm(1) | logicalSize(8) | payload...
+//
+// Objects without the header are returned as-is, so compressed and
+// uncompressed objects can live side by side.
+type CompressedBackend struct {
+	logger  domain.BucktLogger
+	inner   domain.FileBackend
+	level   int
+	minSize int
+	skip    []string
+}
+
+var _ domain.FileBackend = (*CompressedBackend)(nil)
+var _ domain.RepairableBackend = (*CompressedBackend)(nil)
+
+func NewCompressedBackend(logger domain.BucktLogger, inner domain.FileBackend, level, minSize int, skipContentTypes []string) *CompressedBackend {
+	logger.Info("🗜️ Initialising transparent compression")
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if minSize <= 0 {
+		minSize = DefaultCompressionMinSize
+	}
+	if skipContentTypes == nil {
+		skipContentTypes = DefaultSkipContentTypes
+	}
+	return &CompressedBackend{
+		logger:  logger,
+		inner:   inner,
+		level:   level,
+		minSize: minSize,
+		skip:    skipContentTypes,
+	}
+}
+
+// Name implements domain.FileBackend.
+func (c *CompressedBackend) Name() string {
+	return c.inner.Name()
+}
+
+// Put implements domain.FileBackend.
+func (c *CompressedBackend) Put(ctx context.Context, path string, data []byte) error {
+	if !c.shouldCompress(path, data) {
+		// Escape raw objects that happen to look like a compressed one.
+		if bytes.HasPrefix(data, []byte(cmpMagic)) {
+			return c.inner.Put(ctx, path, withCmpHeader(cmpAlgoNone, data, data))
+		}
+		return c.inner.Put(ctx, path, data)
+	}
+
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return c.logger.WrapError("failed to create compressor", err)
+	}
+	if _, err := zw.Write(data); err != nil {
+		return c.logger.WrapError("failed to compress object", err)
+	}
+	if err := zw.Close(); err != nil {
+		return c.logger.WrapError("failed to compress object", err)
+	}
+
+	// Not worth it, keep the original bytes.
+	if buf.Len()+cmpHeaderLen >= len(data) {
+		return c.inner.Put(ctx, path, data)
+	}
+
+	return c.inner.Put(ctx, path, withCmpHeader(cmpAlgoGzip, data, buf.Bytes()))
+}
+
+// Get implements domain.FileBackend.
+func (c *CompressedBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	raw, err := c.inner.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	algo, size, ok := parseCmpHeader(raw)
+	if !ok {
+		return raw, nil
+	}
+
+	payload := raw[cmpHeaderLen:]
+	switch algo {
+	case cmpAlgoNone:
+		return payload, nil
+	case cmpAlgoGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, c.logger.WrapError("failed to decompress object", err)
+		}
+		defer zr.Close()
+
+		data := bytes.NewBuffer(make([]byte, 0, max(0, min(size, cmpMaxPrealloc))))
+		if _, err := io.Copy(data, zr); err != nil {
+			return nil, c.logger.WrapError("failed to decompress object", err)
+		}
+		return data.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %d", algo)
+	}
+}
+
+// Stream implements domain.FileBackend.
+// Compressed objects are decompressed on the fly while reading.
+func (c *CompressedBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := c.inner.Stream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := c.decompress(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if zr, ok := r.(*gzip.Reader); ok {
+		return readCloser{Reader: zr, Closer: closerFunc(func() error {
+			zr.Close()
+			return rc.Close()
+		})}, nil
+	}
+	return readCloser{Reader: r, Closer: rc}, nil
+}
+
+// RepairObject implements domain.RepairableBackend when the inner backend does,
+// copies are verified once they are decompressed.
+func (c *CompressedBackend) RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error) {
+	repairer, ok := c.inner.(domain.RepairableBackend)
+	if !ok {
+		return false, nil
+	}
+	return repairer.RepairObject(ctx, path, func(r io.Reader) bool {
+		logical, err := c.decompress(r)
+		return err == nil && verify(logical)
+	})
+}
+
+// decompress returns the logical content of the object read from r.
+func (c *CompressedBackend) decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	hdr, _ := br.Peek(cmpHeaderLen)
+	algo, _, ok := parseCmpHeader(hdr)
+	if !ok {
+		return br, nil
+	}
+	br.Discard(cmpHeaderLen)
+
+	switch algo {
+	case cmpAlgoNone:
+		return br, nil
+	case cmpAlgoGzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, c.logger.WrapError("failed to decompress object", err)
+		}
+		return zr, nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %d", algo)
+	}
+}
+
+// Stat returns the metadata of an object with its logical, uncompressed size.
+// The size is read from the header, objects are not read to the end. Inner
+// backends that cannot stat objects are not read at all, the size of their
+// objects is unknown without decompressing them.
+func (c *CompressedBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	stater, ok := c.inner.(domain.StatBackend)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s cannot stat objects", errors.ErrUnsupported, c.inner.Name())
+	}
+	inner, err := stater.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	info := *inner
+
+	rc, err := c.inner.Stream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	hdr := make([]byte, cmpHeaderLen)
+	n, _ := io.ReadFull(rc, hdr)
+	if _, size, ok := parseCmpHeader(hdr[:n]); ok {
+		info.Size = size
+	}
+	return &info, nil
+}
+
+// List implements domain.FileBackend.
+func (c *CompressedBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return c.inner.List(ctx, prefix)
+}
+
+// Delete implements domain.FileBackend.
+func (c *CompressedBackend) Delete(ctx context.Context, path string) error {
+	return c.inner.Delete(ctx, path)
+}
+
+// Exists implements domain.FileBackend.
+func (c *CompressedBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return c.inner.Exists(ctx, path)
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (c *CompressedBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	return c.inner.DeleteFolder(ctx, prefix)
+}
+
+// Move implements domain.FileBackend.
+func (c *CompressedBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	return c.inner.Move(ctx, oldPath, newPath)
+}
+
+// shouldCompress decides based on size, the extension of the path and the
+// sniffed content type of the data.
+func (c *CompressedBackend) shouldCompress(path string, data []byte) bool {
+	if len(data) < c.minSize {
+		return false
+	}
+
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" && c.skipped(ct) {
+		return false
+	}
+
+	return !c.skipped(http.DetectContentType(data))
+}
+
+func (c *CompressedBackend) skipped(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, s := range c.skip {
+		if strings.HasSuffix(s, "/") && strings.HasPrefix(contentType, s) {
+			return true
+		}
+		if contentType == s {
+			return true
+		}
+	}
+	return false
+}
+
+func withCmpHeader(algo byte, logical, payload []byte) []byte {
+	out := make([]byte, cmpHeaderLen, cmpHeaderLen+len(payload))
+	copy(out, cmpMagic)
+	out[len(cmpMagic)] = cmpVersion
+	out[len(cmpMagic)+1] = algo
+	binary.BigEndian.PutUint64(out[len(cmpMagic)+2:], uint64(len(logical)))
+	return append(out, payload...)
+}
+
+func parseCmpHeader(b []byte) (algo byte, size int64, ok bool) {
+	if len(b) < cmpHeaderLen || string(b[:len(cmpMagic)]) != cmpMagic || b[len(cmpMagic)] != cmpVersion {
+		return 0, 0, false
+	}
+	return b[len(cmpMagic)+1], int64(binary.BigEndian.Uint64(b[len(cmpMagic)+2:])), true
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
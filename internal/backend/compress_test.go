@@ -0,0 +1,131 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCompressionTest() (*CompressedBackend, *mocks.MemoryBackend) {
+	log := logger.NewLogger("", true, false)
+	inner := mocks.NewMemoryBackend("memory")
+	return NewCompressedBackend(log, inner, 0, 0, nil), inner
+}
+
+func TestCompressedPutGet(t *testing.T) {
+	cmp, inner := setupCompressionTest()
+	ctx := t.Context()
+
+	data := []byte(strings.Repeat(`{"id":1,"name":"buckt","tags":["a","b"]}`+"\n", 500))
+	assert.NoError(t, cmp.Put(ctx, "logs/app.json", data))
+
+	raw := inner.Objects()["logs/app.json"]
+	assert.True(t, bytes.HasPrefix(raw, []byte(cmpMagic)))
+	assert.Less(t, len(raw), len(data)/5)
+
+	got, err := cmp.Get(ctx, "logs/app.json")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	rc, err := cmp.Stream(ctx, "logs/app.json")
+	assert.NoError(t, err)
+	streamed, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.NoError(t, rc.Close())
+	assert.Equal(t, data, streamed)
+
+	// The size is only read from the header of objects the inner backend stats
+	_, err = cmp.Stat(ctx, "logs/app.json")
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+
+	cmp.inner = &statMemoryBackend{MemoryBackend: inner}
+	info, err := cmp.Stat(ctx, "logs/app.json")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), info.Size)
+}
+
+func TestCompressedSkipsCompressedContent(t *testing.T) {
+	cmp, inner := setupCompressionTest()
+	ctx := t.Context()
+
+	// PNG signature followed by compressible bytes, sniffed as image/png
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 4096)...)
+	assert.NoError(t, cmp.Put(ctx, "photo.bin", png))
+	assert.Equal(t, png, inner.Objects()["photo.bin"])
+
+	// Skipped by extension
+	zip := bytes.Repeat([]byte("a"), 4096)
+	assert.NoError(t, cmp.Put(ctx, "archive.zip", zip))
+	assert.Equal(t, zip, inner.Objects()["archive.zip"])
+
+	// Too small to bother
+	small := []byte("hello hello hello")
+	assert.NoError(t, cmp.Put(ctx, "small.txt", small))
+	assert.Equal(t, small, inner.Objects()["small.txt"])
+}
+
+func TestCompressedMixedObjects(t *testing.T) {
+	cmp, inner := setupCompressionTest()
+	ctx := t.Context()
+
+	// Written before compression was enabled
+	assert.NoError(t, inner.Put(ctx, "legacy.txt", []byte("plain")))
+	got, err := cmp.Get(ctx, "legacy.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("plain"), got)
+
+	// Raw data that looks like a compressed object is escaped
+	tricky := []byte(cmpMagic + "not really")
+	assert.NoError(t, cmp.Put(ctx, "tricky.txt", tricky))
+	got, err = cmp.Get(ctx, "tricky.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, tricky, got)
+
+	rc, err := cmp.Stream(ctx, "tricky.txt")
+	assert.NoError(t, err)
+	streamed, _ := io.ReadAll(rc)
+	assert.Equal(t, tricky, streamed)
+}
+
+func TestCompressedOverEncrypted(t *testing.T) {
+	enc, inner, _ := setupEncryptionTest(t, 1024)
+	cmp := NewCompressedBackend(logger.NewLogger("", true, false), enc, 0, 0, nil)
+	ctx := t.Context()
+
+	data := []byte(strings.Repeat("timestamp,level,message\n", 1000))
+	assert.NoError(t, cmp.Put(ctx, "data.csv", data))
+	assert.Less(t, len(inner.Objects()["data.csv"]), len(data)/5)
+
+	rc, err := cmp.Stream(ctx, "data.csv")
+	assert.NoError(t, err)
+	streamed, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, data, streamed)
+}
+
+func TestCompressedUntrustedSize(t *testing.T) {
+	cmp, inner := setupCompressionTest()
+	ctx := t.Context()
+
+	data := []byte(strings.Repeat("buckt", 1000))
+	assert.NoError(t, cmp.Put(ctx, "file.txt", data))
+	raw := inner.Objects()["file.txt"]
+
+	// The logical size is only a hint, forged sizes neither allocate nor panic
+	for _, size := range []uint64{1 << 62, 1 << 63} {
+		forged := bytes.Clone(raw)
+		binary.BigEndian.PutUint64(forged[len(cmpMagic)+2:], size)
+		assert.NoError(t, inner.Put(ctx, "file.txt", forged))
+
+		got, err := cmp.Get(ctx, "file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got)
+	}
+}
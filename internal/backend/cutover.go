@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"gorm.io/gorm"
+)
+
+// errSourceDecommissioned is returned for migrations after the cutover decommissioned the source.
+var errSourceDecommissioned = errors.New("the source of the migration is decommissioned")
+
+// Cutover implements domain.MigratableBackend.
+// The phase moves forward one step at a time, and only when the migration run
+// started last committed every object it queued, so the target holds a
+// verified copy of the source. It moves back to any earlier phase until the
+// source is decommissioned. Transitions only succeed from the phase stored in
+// the database, instances racing for a transition cannot skip a gate.
+func (d *MigrationBackendService) Cutover(ctx context.Context, phase model.CutoverPhase) error {
+	if phase.Order() < 0 {
+		return fmt.Errorf("%w: unknown phase %q", errs.ErrInvalidCutover, phase)
+	}
+
+	current, err := d.loadPhase(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case phase == current:
+		return nil
+	case current == model.CutoverDecommissioned:
+		return fmt.Errorf("%w: the source is decommissioned", errs.ErrInvalidCutover)
+	case phase.Order() > current.Order()+1:
+		return fmt.Errorf("%w: %s cannot follow %s", errs.ErrInvalidCutover, phase, current)
+	case phase.Order() > current.Order():
+		if err := d.verifyCutover(ctx); err != nil {
+			return err
+		}
+	}
+
+	changed, err := d.migrations.SetCutoverPhase(ctx, d.cfg.ID, current, phase)
+	if err != nil {
+		return d.logger.WrapError("failed to change cutover phase", err)
+	}
+	if !changed {
+		return fmt.Errorf("%w: the phase was changed by another instance", errs.ErrInvalidCutover)
+	}
+
+	d.setPhase(phase)
+	d.logger.Infof("🔀 Cut migration %s over from %s to %s", d.Name(), current, phase)
+	return nil
+}
+
+// CutoverPhase implements domain.MigratableBackend.
+func (d *MigrationBackendService) CutoverPhase(ctx context.Context) (model.CutoverPhase, error) {
+	return d.loadPhase(ctx)
+}
+
+// verifyCutover checks that the latest run of the migration completed with
+// every object committed.
+func (d *MigrationBackendService) verifyCutover(ctx context.Context) error {
+	run, err := d.migrations.GetLatestRunFor(ctx, d.cfg.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: no migration has run", errs.ErrCutoverNotVerified)
+	}
+	if err != nil {
+		return d.logger.WrapError("failed to get migration", err)
+	}
+
+	counts, err := d.migrations.CountByStatus(ctx, run.ID)
+	if err != nil {
+		return d.logger.WrapError("failed to count migrated objects", err)
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	committed := counts[model.MigrationStatusCommitted]
+
+	if run.Status != model.MigrationRunCompleted || committed != total {
+		return fmt.Errorf("%w: %d of %d objects of migration %s are committed", errs.ErrCutoverNotVerified, committed, total, run.ID)
+	}
+	return nil
+}
+
+// phase returns the cutover phase, reloaded from the database once it is
+// older than the refresh interval. The last known phase is kept when the
+// database cannot be read. Callers finding it stale share a single reload.
+func (d *MigrationBackendService) phase(ctx context.Context) model.CutoverPhase {
+	d.cutoverMu.Lock()
+	phase, fresh := d.cutover, time.Since(d.cutoverLoaded) < d.cfg.CutoverRefresh
+	d.cutoverMu.Unlock()
+	if fresh {
+		return phase
+	}
+
+	reloaded, _, _ := d.cutoverFetch.Do("", func() (any, error) {
+		return d.reloadPhase(context.WithoutCancel(ctx)), nil
+	})
+	return reloaded.(model.CutoverPhase)
+}
+
+// reloadPhase reads the cutover phase from the database and caches it unless
+// a newer phase was cached while the query ran.
+func (d *MigrationBackendService) reloadPhase(ctx context.Context) model.CutoverPhase {
+	started := time.Now()
+	phase, err := d.fetchPhase(ctx)
+
+	d.cutoverMu.Lock()
+	defer d.cutoverMu.Unlock()
+
+	if err != nil {
+		d.logger.Errorf("failed to get cutover phase: %v", err)
+	}
+	if d.cutoverLoaded.Before(started) {
+		if err == nil {
+			d.cutover = phase
+		}
+		d.cutoverLoaded = time.Now()
+	}
+	return d.cutover
+}
+
+// loadPhase reads the cutover phase from the database and caches it.
+func (d *MigrationBackendService) loadPhase(ctx context.Context) (model.CutoverPhase, error) {
+	phase, err := d.fetchPhase(ctx)
+	if err != nil {
+		return "", d.logger.WrapError("failed to get cutover phase", err)
+	}
+	d.setPhase(phase)
+	return phase, nil
+}
+
+// fetchPhase reads the cutover phase from the database, migrations that were
+// never cut over are in model.CutoverDualWrite.
+func (d *MigrationBackendService) fetchPhase(ctx context.Context) (model.CutoverPhase, error) {
+	cutover, err := d.migrations.GetCutover(ctx, d.cfg.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.CutoverDualWrite, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return cutover.Phase, nil
+}
+
+func (d *MigrationBackendService) setPhase(phase model.CutoverPhase) {
+	d.cutoverMu.Lock()
+	defer d.cutoverMu.Unlock()
+	d.cutover = phase
+	d.cutoverLoaded = time.Now()
+}
+
+// targetOnly reports whether the source is no longer read. It is still written
+// until it is decommissioned.
+func (d *MigrationBackendService) targetOnly(ctx context.Context) bool {
+	return d.phase(ctx).Order() >= model.CutoverTargetOnly.Order()
+}
+
+// decommissioned reports whether the source is no longer touched at all.
+func (d *MigrationBackendService) decommissioned(ctx context.Context) bool {
+	return d.phase(ctx) == model.CutoverDecommissioned
+}
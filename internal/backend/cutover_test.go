@@ -0,0 +1,202 @@
+package backend
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/internal/repository"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestCutover(t *testing.T) {
+	ctx := t.Context()
+	source := mocks.NewMemoryBackend("source")
+	target := mocks.NewMemoryBackend("target")
+	mb, db := newTestMigrationBackend(t, source, target, model.MigrationConfig{})
+
+	assert.NoError(t, source.Put(ctx, "old.txt", []byte("old")))
+
+	// Nothing is verified before a migration ran
+	assert.ErrorIs(t, mb.Cutover(ctx, model.CutoverReadTarget), errs.ErrCutoverNotVerified)
+	assert.ErrorIs(t, mb.Cutover(ctx, model.CutoverTargetOnly), errs.ErrInvalidCutover)
+	assert.ErrorIs(t, mb.Cutover(ctx, "sideways"), errs.ErrInvalidCutover)
+
+	assert.NoError(t, mb.MigrateAll(ctx))
+	assert.NoError(t, mb.Cutover(ctx, model.CutoverReadTarget))
+
+	// Reads are served by the target
+	assert.NoError(t, target.Put(ctx, "old.txt", []byte("target")))
+	data, err := mb.Get(ctx, "old.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("target"), data)
+
+	// The source is no longer read but still written
+	assert.NoError(t, mb.Cutover(ctx, model.CutoverTargetOnly))
+	assert.NoError(t, source.Delete(ctx, "old.txt"))
+	data, err = mb.Get(ctx, "old.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("target"), data)
+	assert.NoError(t, mb.Put(ctx, "new.txt", []byte("new")))
+	assert.Contains(t, source.Objects(), "new.txt")
+	assert.Contains(t, target.Objects(), "new.txt")
+
+	// Rolling back finds the objects written in the meantime in the source
+	assert.NoError(t, mb.Cutover(ctx, model.CutoverDualWrite))
+	data, err = source.Get(ctx, "new.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), data)
+	assert.NoError(t, source.Put(ctx, "old.txt", []byte("old")))
+
+	// Another instance sharing the database follows the phase
+	other := NewMigrationBackend(mb.logger, source, target, model.MigrationConfig{}, repository.NewMigrationRepository(db), nil).(*MigrationBackendService)
+	for _, phase := range []model.CutoverPhase{model.CutoverReadTarget, model.CutoverTargetOnly, model.CutoverDecommissioned} {
+		assert.NoError(t, other.Cutover(ctx, phase))
+	}
+
+	phase, err := mb.CutoverPhase(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CutoverDecommissioned, phase)
+
+	assert.ErrorIs(t, mb.Cutover(ctx, model.CutoverTargetOnly), errs.ErrInvalidCutover)
+	assert.Error(t, mb.MigrateAll(ctx))
+
+	assert.NoError(t, mb.Delete(ctx, "old.txt"))
+	assert.Contains(t, source.Objects(), "old.txt") // Decommissioned sources are left alone
+	assert.NoError(t, mb.Put(ctx, "newer.txt", []byte("newer")))
+	assert.NotContains(t, source.Objects(), "newer.txt")
+}
+
+func TestCutoverRefresh(t *testing.T) {
+	ctx := t.Context()
+	source := mocks.NewMemoryBackend("source")
+	target := mocks.NewMemoryBackend("target")
+	cfg := model.MigrationConfig{CutoverRefresh: time.Hour}
+	mb, db := newTestMigrationBackend(t, source, target, cfg)
+	other := NewMigrationBackend(mb.logger, source, target, cfg, repository.NewMigrationRepository(db), nil).(*MigrationBackendService)
+
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("source")))
+	assert.NoError(t, mb.MigrateAll(ctx))
+	assert.NoError(t, target.Put(ctx, "a.txt", []byte("target"))) // Tells the backends apart
+
+	data, err := other.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("source"), data)
+
+	assert.NoError(t, mb.Cutover(ctx, model.CutoverReadTarget))
+
+	// The other instance keeps its phase until it is reloaded
+	data, err = other.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("source"), data)
+
+	other.cutoverLoaded = time.Time{}
+	data, err = other.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("target"), data)
+
+	// Concurrent transitions from a stale phase are refused
+	ok, err := other.migrations.SetCutoverPhase(ctx, other.cfg.ID, model.CutoverDualWrite, model.CutoverReadTarget)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mb.Cutover(ctx, model.CutoverReadTarget)) // Already in the phase
+}
+
+func TestCutoverPerMigration(t *testing.T) {
+	ctx := t.Context()
+	source, target := mocks.NewMemoryBackend("memory"), mocks.NewMemoryBackend("memory-target")
+	mb, db := newTestMigrationBackend(t, source, target, model.MigrationConfig{ID: "media"})
+	assert.Equal(t, "media", mb.cfg.ID)
+
+	// A migration between backends of the same names sharing the database
+	otherSource, otherTarget := mocks.NewMemoryBackend("memory"), mocks.NewMemoryBackend("memory-target")
+	other := NewMigrationBackend(mb.logger, otherSource, otherTarget, model.MigrationConfig{ID: "archive"}, repository.NewMigrationRepository(db), nil).(*MigrationBackendService)
+	t.Cleanup(other.Close)
+
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("a")))
+	assert.NoError(t, otherSource.Put(ctx, "b.txt", []byte("b")))
+	assert.NoError(t, otherSource.Put(ctx, "c.txt", []byte("c")))
+
+	// The runs of one migration do not verify the other one
+	assert.NoError(t, mb.MigrateAll(ctx))
+	assert.ErrorIs(t, other.Cutover(ctx, model.CutoverReadTarget), errs.ErrCutoverNotVerified)
+	assert.NoError(t, mb.Cutover(ctx, model.CutoverReadTarget))
+
+	phase, err := other.CutoverPhase(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, model.CutoverDualWrite, phase)
+
+	completed, total := mb.MigrationStatus(ctx)
+	assert.Equal(t, int64(1), completed)
+	assert.Equal(t, int64(1), total)
+	completed, total = other.MigrationStatus(ctx)
+	assert.Zero(t, completed)
+	assert.Zero(t, total)
+
+	// Without an ID the migration is identified by the backend names
+	unnamed := NewMigrationBackend(mb.logger, source, target, model.MigrationConfig{}, repository.NewMigrationRepository(db), nil).(*MigrationBackendService)
+	t.Cleanup(unnamed.Close)
+	assert.Equal(t, "memory->memory-target", unnamed.cfg.ID)
+}
+
+// blockingCutovers holds GetCutover until release is closed.
+type blockingCutovers struct {
+	domain.MigrationRepository
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingCutovers) GetCutover(ctx context.Context, migration_id string) (*model.MigrationCutoverModel, error) {
+	if b.calls.Add(1) == 1 {
+		close(b.started)
+	}
+	<-b.release
+	return b.MigrationRepository.GetCutover(ctx, migration_id)
+}
+
+func TestCutoverPhaseReload(t *testing.T) {
+	ctx := t.Context()
+	mb, db := newTestMigrationBackend(t, mocks.NewMemoryBackend("source"), mocks.NewMemoryBackend("target"), model.MigrationConfig{})
+	repo := &blockingCutovers{MigrationRepository: repository.NewMigrationRepository(db), started: make(chan struct{}), release: make(chan struct{})}
+	mb.migrations = repo
+
+	var g errgroup.Group
+	phases := make([]model.CutoverPhase, 4)
+	for i := range phases {
+		g.Go(func() error {
+			phases[i] = mb.phase(ctx)
+			return nil
+		})
+	}
+	<-repo.started
+
+	// The cached phase can be replaced while the reload waits for the database
+	done := make(chan struct{})
+	go func() {
+		mb.setPhase(model.CutoverReadTarget)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("setPhase waited for the reload")
+	}
+
+	// Callers finding the phase stale wait for the same query
+	assert.Equal(t, int32(1), repo.calls.Load())
+	close(repo.release)
+	assert.NoError(t, g.Wait())
+
+	// The reload started before the change does not overwrite it
+	assert.Equal(t, model.CutoverReadTarget, mb.phase(ctx))
+	for _, phase := range phases {
+		assert.Equal(t, model.CutoverReadTarget, phase)
+	}
+}
@@ -0,0 +1,622 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+const (
+	encMagic   = "BKTE"
+	encVersion = 1
+
+	// DefaultEncryptionChunkSize is the plaintext size of each sealed chunk.
+	DefaultEncryptionChunkSize = 64 * 1024
+
+	dataKeySize     = 32
+	noncePrefixSize = 7
+	chunkTagSize    = 16 // AES-GCM tag sealed with every chunk
+)
+
+var ErrInvalidEncryptedObject = errors.New("invalid encrypted object")
+
+// EncryptedBackend wraps a FileBackend and encrypts objects at rest using
+// envelope encryption: every object gets a random AES-256 data key which is
+// wrapped with a master key from the KeyProvider. Content is sealed in
+// fixed-size AES-GCM chunks so it can be decrypted while streaming.
+//
+// Object layout:
+//
+//	magic(4) | version(1) | keyIDLen(1) | keyID | wrappedKeyLen(1) | wrappedKey | chunkSize(4) | noncePrefix(7) | chunks...
+//
+// Objects without the header are refused, anyone able to write to the inner
+// backend could otherwise replace an object with unauthenticated content.
+// allowPlaintext returns them as-is instead, so encryption can be enabled on
+// a backend that already holds plaintext objects.
+type EncryptedBackend struct {
+	logger         domain.BucktLogger
+	inner          domain.FileBackend
+	keys           domain.KeyProvider
+	chunkSize      int
+	allowPlaintext bool
+
+	// paths serialises writes with re-wraps, so a re-wrap cannot write back
+	// content read before a write.
+	paths pathLocks
+}
+
+var _ domain.FileBackend = (*EncryptedBackend)(nil)
+var _ domain.RepairableBackend = (*EncryptedBackend)(nil)
+var _ domain.StatBackend = (*EncryptedBackend)(nil)
+
+func NewEncryptedBackend(logger domain.BucktLogger, inner domain.FileBackend, keys domain.KeyProvider, chunkSize int, allowPlaintext bool) *EncryptedBackend {
+	logger.Info("🔐 Initialising encryption at rest")
+	if chunkSize <= 0 {
+		chunkSize = DefaultEncryptionChunkSize
+	}
+	return &EncryptedBackend{
+		logger:         logger,
+		inner:          inner,
+		keys:           keys,
+		chunkSize:      chunkSize,
+		allowPlaintext: allowPlaintext,
+	}
+}
+
+// Name implements domain.FileBackend.
+func (e *EncryptedBackend) Name() string {
+	return e.inner.Name()
+}
+
+// Put implements domain.FileBackend.
+func (e *EncryptedBackend) Put(ctx context.Context, path string, data []byte) error {
+	defer e.paths.lock(path)()
+
+	keyID, master, err := e.keys.CurrentKey(ctx)
+	if err != nil {
+		return e.logger.WrapError("failed to get master key", err)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return e.logger.WrapError("failed to generate data key", err)
+	}
+
+	hdr := encHeader{keyID: keyID, chunkSize: uint32(e.chunkSize)}
+	if hdr.wrappedKey, err = wrapKey(master, keyID, dataKey); err != nil {
+		return e.logger.WrapError("failed to wrap data key", err)
+	}
+	if _, err := rand.Read(hdr.noncePrefix[:]); err != nil {
+		return e.logger.WrapError("failed to generate nonce", err)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+len(data)/e.chunkSize*chunkTagSize+128))
+	if err := hdr.write(buf); err != nil {
+		return err
+	}
+	if err := sealChunks(buf, dataKey, hdr, data); err != nil {
+		return e.logger.WrapError("failed to encrypt object", err)
+	}
+
+	return e.inner.Put(ctx, path, buf.Bytes())
+}
+
+// Get implements domain.FileBackend.
+func (e *EncryptedBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	raw, err := e.inner.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(raw, []byte(encMagic)) {
+		if !e.allowPlaintext {
+			return nil, e.logger.WrapError("failed to decrypt object", errNotEncrypted)
+		}
+		return raw, nil
+	}
+
+	r, err := e.newDecryptReader(ctx, bytes.NewReader(raw))
+	if err != nil {
+		return nil, e.logger.WrapError("failed to decrypt object", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, e.logger.WrapError("failed to decrypt object", err)
+	}
+	return data, nil
+}
+
+// Stream implements domain.FileBackend.
+func (e *EncryptedBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	rc, err := e.inner.Stream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := e.plaintext(ctx, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return readCloser{Reader: r, Closer: rc}, nil
+}
+
+// RepairObject implements domain.RepairableBackend when the inner backend does,
+// copies are verified once they are decrypted.
+func (e *EncryptedBackend) RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error) {
+	repairer, ok := e.inner.(domain.RepairableBackend)
+	if !ok {
+		return false, nil
+	}
+	return repairer.RepairObject(ctx, path, func(r io.Reader) bool {
+		plain, err := e.plaintext(ctx, r)
+		return err == nil && verify(plain)
+	})
+}
+
+// Stat implements domain.StatBackend.
+// The plaintext size follows from the size of the object and its header, only
+// the header is read. Backends that cannot stat objects are read instead.
+func (e *EncryptedBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	stater, ok := e.inner.(domain.StatBackend)
+	if !ok {
+		return statByReading(ctx, e, path)
+	}
+	inner, err := stater.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	info := *inner
+
+	hdr, err := e.readHeader(ctx, path)
+	if errors.Is(err, ErrInvalidEncryptedObject) && e.allowPlaintext {
+		return &info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Every chunk but an empty object's only one holds plaintext and a tag
+	body := info.Size - hdr.size()
+	sealed := int64(hdr.chunkSize) + chunkTagSize
+	chunks := max(1, (body+sealed-1)/sealed)
+	if info.Size = body - chunks*chunkTagSize; info.Size < 0 {
+		return nil, ErrInvalidEncryptedObject
+	}
+	return &info, nil
+}
+
+// errNotEncrypted is returned for objects without the header unless plaintext
+// objects are allowed.
+var errNotEncrypted = fmt.Errorf("%w: object is not encrypted", ErrInvalidEncryptedObject)
+
+// plaintext returns the decrypted content of the object read from r.
+func (e *EncryptedBackend) plaintext(ctx context.Context, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(encMagic))
+	if err != nil || string(magic) != encMagic {
+		if !e.allowPlaintext {
+			return nil, e.logger.WrapError("failed to decrypt object", errNotEncrypted)
+		}
+		// Short or plaintext object, hand it back untouched.
+		return br, nil
+	}
+
+	dr, err := e.newDecryptReader(ctx, br)
+	if err != nil {
+		return nil, e.logger.WrapError("failed to decrypt object", err)
+	}
+	return dr, nil
+}
+
+// List implements domain.FileBackend.
+func (e *EncryptedBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return e.inner.List(ctx, prefix)
+}
+
+// Delete implements domain.FileBackend.
+func (e *EncryptedBackend) Delete(ctx context.Context, path string) error {
+	defer e.paths.lock(path)()
+	return e.inner.Delete(ctx, path)
+}
+
+// Exists implements domain.FileBackend.
+func (e *EncryptedBackend) Exists(ctx context.Context, path string) (bool, error) {
+	return e.inner.Exists(ctx, path)
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (e *EncryptedBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	return e.inner.DeleteFolder(ctx, prefix)
+}
+
+// Move implements domain.FileBackend.
+func (e *EncryptedBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	defer e.paths.lockPair(oldPath, newPath)()
+	return e.inner.Move(ctx, oldPath, newPath)
+}
+
+// RewrapAll re-wraps the data key of every object under prefix that is not
+// wrapped with the current master key. Object content is not re-encrypted,
+// only the header is rewritten. It returns the number of objects re-wrapped.
+func (e *EncryptedBackend) RewrapAll(ctx context.Context, prefix string) (int, error) {
+	currentID, current, err := e.keys.CurrentKey(ctx)
+	if err != nil {
+		return 0, e.logger.WrapError("failed to get master key", err)
+	}
+
+	paths, err := e.inner.List(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var rewrapped int
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return rewrapped, err
+		}
+
+		keyID, err := e.objectKeyID(ctx, path)
+		if err != nil || keyID == "" || keyID == currentID {
+			continue
+		}
+
+		if err := e.rewrap(ctx, path, currentID, current); err != nil {
+			e.logger.Errorf("failed to re-wrap %s: %v", path, err)
+			continue
+		}
+		rewrapped++
+	}
+
+	return rewrapped, nil
+}
+
+// objectKeyID reads just the header of an object and returns the ID of the
+// master key its data key is wrapped with, or "" for plaintext objects.
+func (e *EncryptedBackend) objectKeyID(ctx context.Context, path string) (string, error) {
+	hdr, err := e.readHeader(ctx, path)
+	if errors.Is(err, ErrInvalidEncryptedObject) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return hdr.keyID, nil
+}
+
+// readHeader reads just the header of the object at path.
+func (e *EncryptedBackend) readHeader(ctx context.Context, path string) (encHeader, error) {
+	rc, err := e.inner.Stream(ctx, path)
+	if err != nil {
+		return encHeader{}, err
+	}
+	defer rc.Close()
+
+	return readEncHeader(bufio.NewReader(rc))
+}
+
+// rewrap rewrites the header of the object at path, holding the path so
+// writes of this instance wait for it. Writes of other instances sharing the
+// inner backend are not serialised, rotate keys from a single instance.
+func (e *EncryptedBackend) rewrap(ctx context.Context, path, currentID string, current []byte) error {
+	defer e.paths.lock(path)()
+
+	raw, err := e.inner.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	r := bytes.NewReader(raw)
+	hdr, err := readEncHeader(r)
+	if err != nil {
+		return err
+	}
+
+	old, err := e.keys.Key(ctx, hdr.keyID)
+	if err != nil {
+		return err
+	}
+	dataKey, err := unwrapKey(old, hdr.keyID, hdr.wrappedKey)
+	if err != nil {
+		return err
+	}
+
+	hdr.keyID = currentID
+	if hdr.wrappedKey, err = wrapKey(current, currentID, dataKey); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(raw)+64))
+	if err := hdr.write(buf); err != nil {
+		return err
+	}
+	if _, err := r.WriteTo(buf); err != nil {
+		return err
+	}
+
+	return e.inner.Put(ctx, path, buf.Bytes())
+}
+
+func (e *EncryptedBackend) newDecryptReader(ctx context.Context, r io.Reader) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+
+	hdr, err := readEncHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	master, err := e.keys.Key(ctx, hdr.keyID)
+	if err != nil {
+		return nil, fmt.Errorf("master key %q: %w", hdr.keyID, err)
+	}
+	dataKey, err := unwrapKey(master, hdr.keyID, hdr.wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		src:  br,
+		aead: aead,
+		hdr:  hdr,
+		buf:  make([]byte, int(hdr.chunkSize)+aead.Overhead()),
+	}, nil
+}
+
+/* Header */
+
+type encHeader struct {
+	keyID       string
+	wrappedKey  []byte
+	chunkSize   uint32
+	noncePrefix [noncePrefixSize]byte
+}
+
+func (h encHeader) write(w io.Writer) error {
+	if len(h.keyID) > 255 || len(h.wrappedKey) > 255 {
+		return fmt.Errorf("key id or wrapped key too long")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(encMagic)
+	buf.WriteByte(encVersion)
+	buf.WriteByte(byte(len(h.keyID)))
+	buf.WriteString(h.keyID)
+	buf.WriteByte(byte(len(h.wrappedKey)))
+	buf.Write(h.wrappedKey)
+	binary.Write(&buf, binary.BigEndian, h.chunkSize)
+	buf.Write(h.noncePrefix[:])
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// size is the number of bytes the header takes in the object.
+func (h encHeader) size() int64 {
+	return int64(len(encMagic) + 2 + len(h.keyID) + 1 + len(h.wrappedKey) + 4 + noncePrefixSize)
+}
+
+func readEncHeader(r io.Reader) (encHeader, error) {
+	var hdr encHeader
+
+	fixed := make([]byte, len(encMagic)+2)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return hdr, ErrInvalidEncryptedObject
+	}
+	if string(fixed[:len(encMagic)]) != encMagic || fixed[len(encMagic)] != encVersion {
+		return hdr, ErrInvalidEncryptedObject
+	}
+
+	keyID := make([]byte, fixed[len(encMagic)+1])
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return hdr, ErrInvalidEncryptedObject
+	}
+	hdr.keyID = string(keyID)
+
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return hdr, ErrInvalidEncryptedObject
+	}
+	hdr.wrappedKey = make([]byte, n[0])
+	if _, err := io.ReadFull(r, hdr.wrappedKey); err != nil {
+		return hdr, ErrInvalidEncryptedObject
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &hdr.chunkSize); err != nil || hdr.chunkSize == 0 {
+		return hdr, ErrInvalidEncryptedObject
+	}
+	if _, err := io.ReadFull(r, hdr.noncePrefix[:]); err != nil {
+		return hdr, ErrInvalidEncryptedObject
+	}
+
+	return hdr, nil
+}
+
+/* Chunked AEAD */
+
+func sealChunks(w io.Writer, dataKey []byte, hdr encHeader, data []byte) error {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	size := int(hdr.chunkSize)
+	var counter uint32
+	for {
+		n := min(size, len(data))
+		last := n == len(data)
+
+		sealed := aead.Seal(nil, chunkNonce(hdr.noncePrefix, counter, last), data[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+
+		data = data[n:]
+		counter++
+	}
+}
+
+// decryptReader opens sealed chunks one at a time. The final chunk is sealed
+// with a distinct nonce so truncation at a chunk boundary is detected.
+type decryptReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	hdr     encHeader
+	buf     []byte
+	plain   []byte
+	counter uint32
+	done    bool
+	err     error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.next()
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.src, d.buf)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one only if nothing follows it.
+		if _, peekErr := d.src.Peek(1); peekErr == io.EOF {
+			d.done = true
+		}
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.hdr.noncePrefix, d.counter, d.done), d.buf[:n], nil)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d failed authentication", ErrInvalidEncryptedObject, d.counter)
+	}
+
+	d.plain = plain
+	d.counter++
+	return nil
+}
+
+func chunkNonce(prefix [noncePrefixSize]byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix[:])
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+/* Key wrapping */
+
+func wrapKey(master []byte, keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+func unwrapKey(master []byte, keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrInvalidEncryptedObject
+	}
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to unwrap data key", ErrInvalidEncryptedObject)
+	}
+	return dataKey, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/* Key providers */
+
+// StaticKeyProvider serves master keys from memory. The current key is used
+// for new objects, older keys are kept around to read objects until they
+// have been re-wrapped.
+type StaticKeyProvider struct {
+	currentID string
+	keys      map[string][]byte
+}
+
+var _ domain.KeyProvider = (*StaticKeyProvider)(nil)
+
+// NewStaticKeyProvider creates a key provider from a set of 32 byte AES-256
+// master keys. currentID must be one of the keys.
+func NewStaticKeyProvider(currentID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, fmt.Errorf("current key %q not found", currentID)
+	}
+	for id, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("master key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	return &StaticKeyProvider{currentID: currentID, keys: keys}, nil
+}
+
+// CurrentKey implements domain.KeyProvider.
+func (s *StaticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	return s.currentID, s.keys[s.currentID], nil
+}
+
+// Key implements domain.KeyProvider.
+func (s *StaticKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", id)
+	}
+	return key, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,217 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupEncryptionTest(t *testing.T, chunkSize int) (*EncryptedBackend, *mocks.MemoryBackend, map[string][]byte) {
+	keys := map[string][]byte{"k1": randomBytes(t, 32), "k2": randomBytes(t, 32)}
+	provider, err := NewStaticKeyProvider("k1", keys)
+	assert.NoError(t, err)
+
+	inner := mocks.NewMemoryBackend("memory")
+	log := logger.NewLogger("", true, false)
+	return NewEncryptedBackend(log, inner, provider, chunkSize, false), inner, keys
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestEncryptedPutGet(t *testing.T) {
+	ctx := t.Context()
+
+	for _, size := range []int{0, 1, 63, 64, 65, 128, 1000} {
+		enc, inner, _ := setupEncryptionTest(t, 64)
+		data := randomBytes(t, size)
+
+		assert.NoError(t, enc.Put(ctx, "file.bin", data))
+
+		raw := inner.Objects()["file.bin"]
+		assert.Greater(t, len(raw), size)
+		assert.True(t, bytes.HasPrefix(raw, []byte(encMagic)))
+
+		got, err := enc.Get(ctx, "file.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got, "size %d", size)
+
+		rc, err := enc.Stream(ctx, "file.bin")
+		assert.NoError(t, err)
+		streamed, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.NoError(t, rc.Close())
+		assert.Equal(t, data, streamed, "size %d", size)
+	}
+}
+
+// statMemoryBackend is a memory backend that reports object metadata.
+type statMemoryBackend struct {
+	*mocks.MemoryBackend
+	modified time.Time
+}
+
+func (s *statMemoryBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	data, err := s.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &model.FileInfo{Size: int64(len(data)), LastModified: s.modified}, nil
+}
+
+func TestEncryptedStat(t *testing.T) {
+	enc, inner, _ := setupEncryptionTest(t, 64)
+	modified := time.Now().Add(-time.Hour)
+	enc.inner = &statMemoryBackend{MemoryBackend: inner, modified: modified}
+	ctx := t.Context()
+
+	for _, size := range []int{0, 1, 63, 64, 65, 128, 1000} {
+		assert.NoError(t, enc.Put(ctx, "file.bin", randomBytes(t, size)))
+
+		info, err := enc.Stat(ctx, "file.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), info.Size, "size %d", size)
+		assert.Equal(t, modified, info.LastModified)
+	}
+
+	// Plaintext objects keep their size when allowed
+	assert.NoError(t, inner.Put(ctx, "legacy.txt", []byte("plain")))
+	_, err := enc.Stat(ctx, "legacy.txt")
+	assert.ErrorIs(t, err, ErrInvalidEncryptedObject)
+	enc.allowPlaintext = true
+	info, err := enc.Stat(ctx, "legacy.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+}
+
+func TestEncryptedPlaintextPassthrough(t *testing.T) {
+	enc, inner, _ := setupEncryptionTest(t, 64)
+	ctx := t.Context()
+
+	assert.NoError(t, inner.Put(ctx, "legacy.txt", []byte("plain old data")))
+
+	// Plaintext objects are refused unless allowed
+	_, err := enc.Get(ctx, "legacy.txt")
+	assert.ErrorIs(t, err, ErrInvalidEncryptedObject)
+	_, err = enc.Stream(ctx, "legacy.txt")
+	assert.ErrorIs(t, err, ErrInvalidEncryptedObject)
+
+	enc.allowPlaintext = true
+	got, err := enc.Get(ctx, "legacy.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("plain old data"), got)
+
+	rc, err := enc.Stream(ctx, "legacy.txt")
+	assert.NoError(t, err)
+	streamed, _ := io.ReadAll(rc)
+	assert.Equal(t, []byte("plain old data"), streamed)
+}
+
+func TestEncryptedDetectsTampering(t *testing.T) {
+	enc, inner, _ := setupEncryptionTest(t, 64)
+	ctx := t.Context()
+
+	data := randomBytes(t, 200)
+	assert.NoError(t, enc.Put(ctx, "file.bin", data))
+
+	raw := inner.Objects()["file.bin"]
+
+	// Flip a byte in the body
+	flipped := append([]byte(nil), raw...)
+	flipped[len(flipped)-5] ^= 0xff
+	assert.NoError(t, inner.Put(ctx, "file.bin", flipped))
+	_, err := enc.Get(ctx, "file.bin")
+	assert.ErrorIs(t, err, ErrInvalidEncryptedObject)
+
+	// Truncate at a chunk boundary
+	truncated := raw[:len(raw)-(200-3*64)-16]
+	assert.NoError(t, inner.Put(ctx, "file.bin", truncated))
+	_, err = enc.Get(ctx, "file.bin")
+	assert.ErrorIs(t, err, ErrInvalidEncryptedObject)
+}
+
+func TestEncryptedRewrap(t *testing.T) {
+	enc, inner, keys := setupEncryptionTest(t, 64)
+	ctx := t.Context()
+
+	data := randomBytes(t, 300)
+	assert.NoError(t, enc.Put(ctx, "a/file.bin", data))
+	assert.NoError(t, inner.Put(ctx, "a/plain.txt", []byte("plain")))
+
+	// Rotate to k2
+	rotated, err := NewStaticKeyProvider("k2", keys)
+	assert.NoError(t, err)
+	enc.keys = rotated
+
+	n, err := enc.RewrapAll(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	keyID, err := enc.objectKeyID(ctx, "a/file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, "k2", keyID)
+
+	// Old key is no longer needed
+	onlyNew, err := NewStaticKeyProvider("k2", map[string][]byte{"k2": keys["k2"]})
+	assert.NoError(t, err)
+	enc.keys = onlyNew
+
+	got, err := enc.Get(ctx, "a/file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Running again is a no-op
+	n, err = enc.RewrapAll(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestEncryptedRewrapKeepsConcurrentWrites(t *testing.T) {
+	enc, inner, keys := setupEncryptionTest(t, 64)
+	ctx := t.Context()
+
+	assert.NoError(t, enc.Put(ctx, "file.bin", []byte("old")))
+
+	rotated, err := NewStaticKeyProvider("k2", keys)
+	assert.NoError(t, err)
+	enc.keys = rotated
+
+	// A write lands while the object is being re-wrapped
+	written := make(chan error, 1)
+	var once sync.Once
+	enc.inner = &hookedBackend{FileBackend: inner, onGet: func(path string) {
+		once.Do(func() {
+			go func() { written <- enc.Put(ctx, path, []byte("new")) }()
+			time.Sleep(20 * time.Millisecond)
+		})
+	}}
+
+	_, err = enc.RewrapAll(ctx, "")
+	assert.NoError(t, err)
+	assert.NoError(t, <-written)
+
+	got, err := enc.Get(ctx, "file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), got)
+}
+
+func TestStaticKeyProviderValidation(t *testing.T) {
+	_, err := NewStaticKeyProvider("missing", map[string][]byte{"k1": make([]byte, 32)})
+	assert.Error(t, err)
+
+	_, err = NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 16)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,671 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/klauspost/reedsolomon"
+)
+
+const (
+	shardMagic     = "BKTS"
+	shardVersion   = 1
+	shardHeaderLen = len(shardMagic) + 4 + 8 + 8 + 4
+	shardSumOffset = shardHeaderLen - 4
+	shardSuffix    = ".shard"
+	shardProbe     = ".buckt-probe"
+)
+
+// ErrUnrecoverableObject is returned when fewer shards than data shards survive.
+var ErrUnrecoverableObject = errors.New("not enough shards left to reconstruct object")
+
+var shardName = regexp.MustCompile(`\.shard\d+$`)
+
+// ErasureBackend stores every object as k data and m parity Reed-Solomon
+// shards spread over several directories, usually one per disk. Shard i of
+// an object is stored in directory i mod len(dirs) as <path>.shard<i>, so
+// the object survives the loss of up to m shards.
+//
+// Every shard starts with a header:
+//
+//	magic(4) | version(1) | k(1) | m(1) | index(1) | generation(8) | size(8) | crc32(4) | payload...
+//
+// The checksum covers the header before it and the payload. The generation
+// identifies the write a shard belongs to, so shards left over from an older
+// version of the object are never mixed with newer ones.
+type ErasureBackend struct {
+	logger      domain.BucktLogger
+	dirs        []string
+	k, m        int
+	writeQuorum int
+	enc         reedsolomon.Encoder
+
+	// paths serialises writes of the same object, so the shards of
+	// concurrent writes are not interleaved.
+	paths pathLocks
+}
+
+var _ domain.FileBackend = (*ErasureBackend)(nil)
+var _ domain.StatBackend = (*ErasureBackend)(nil)
+
+type shardHeader struct {
+	k, m, index int
+	generation  uint64
+	size        int64
+	sum         uint32
+}
+
+// shardSet holds the shards of the newest complete generation of an object.
+type shardSet struct {
+	header shardHeader
+	shards [][]byte
+	// missing lists the indices of shards that are absent, corrupt or stale.
+	missing []int
+}
+
+// NewErasureBackend creates an ErasureBackend over dirs. writeQuorum is the
+// number of shards a write needs, it defaults to dataShards+1 so a new object
+// always survives the loss of a shard.
+func NewErasureBackend(logger domain.BucktLogger, dirs []string, dataShards, parityShards, writeQuorum int) (*ErasureBackend, error) {
+	logger.Infof("🧩 Initialising erasure coded backend with %d+%d shards over %d disks", dataShards, parityShards, len(dirs))
+
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("erasure coding needs at least one directory")
+	}
+	if dataShards < 1 || parityShards < 1 {
+		return nil, fmt.Errorf("erasure coding needs at least one data and one parity shard")
+	}
+	if dataShards+parityShards > 255 {
+		return nil, fmt.Errorf("erasure coding supports at most 255 shards")
+	}
+	if writeQuorum == 0 {
+		writeQuorum = dataShards + 1
+	}
+	if writeQuorum < dataShards || writeQuorum > dataShards+parityShards {
+		return nil, fmt.Errorf("erasure coding write quorum must be between %d and %d shards", dataShards, dataShards+parityShards)
+	}
+
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, logger.WrapError("failed to create erasure encoder", err)
+	}
+
+	if len(dirs) < dataShards+parityShards {
+		logger.Warn(fmt.Sprintf("⚠️ %d disks for %d shards, some disks hold several shards of an object", len(dirs), dataShards+parityShards))
+	}
+
+	for _, dir := range dirs {
+		// A disk that is offline at startup is reported by DiskHealth and rebuilt by Heal.
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logger.Errorf("failed to create erasure directory %s: %v", dir, err)
+		}
+	}
+
+	return &ErasureBackend{
+		logger:      logger,
+		dirs:        dirs,
+		k:           dataShards,
+		m:           parityShards,
+		writeQuorum: writeQuorum,
+		enc:         enc,
+	}, nil
+}
+
+// Name implements domain.FileBackend.
+func (e *ErasureBackend) Name() string {
+	return "erasure"
+}
+
+// Put implements domain.FileBackend.
+// It succeeds once the write quorum of shards was written.
+func (e *ErasureBackend) Put(ctx context.Context, path string, data []byte) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	// Split reuses the input buffer, and cannot split an empty one.
+	buf := make([]byte, max(len(data), 1))
+	copy(buf, data)
+
+	shards, err := e.enc.Split(buf)
+	if err != nil {
+		return e.logger.WrapError("failed to split object", err)
+	}
+	if err := e.enc.Encode(shards); err != nil {
+		return e.logger.WrapError("failed to encode parity", err)
+	}
+
+	defer e.paths.lock(path)()
+
+	header := shardHeader{k: e.k, m: e.m, generation: uint64(time.Now().UnixNano()), size: int64(len(data))}
+
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = e.writeShard(path, i, header, shards[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var failed int
+	for _, err := range errs {
+		if err != nil {
+			failed++
+		}
+	}
+
+	if written := len(shards) - failed; written < e.writeQuorum {
+		return e.logger.WrapError(fmt.Sprintf("failed to write enough shards: %d of %d written, quorum is %d", written, len(shards), e.writeQuorum), errors.Join(errs...))
+	}
+	if failed > 0 {
+		e.logger.Warn(fmt.Sprintf("⚠️ %s written with %d missing shards: %v", path, failed, errors.Join(errs...)))
+	}
+	return nil
+}
+
+// Get implements domain.FileBackend.
+// Missing or corrupt data shards are reconstructed from the parity shards.
+func (e *ErasureBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	set, err := e.readShards(path)
+	if err != nil {
+		return nil, e.logger.WrapError("failed to read shards", err)
+	}
+
+	if set.header.size == 0 {
+		return []byte{}, nil
+	}
+
+	for _, i := range set.missing {
+		if i < e.k {
+			if err := e.enc.ReconstructData(set.shards); err != nil {
+				return nil, e.logger.WrapError("failed to reconstruct object", err)
+			}
+			break
+		}
+	}
+
+	var out bytes.Buffer
+	out.Grow(int(set.header.size))
+	if err := e.enc.Join(&out, set.shards, int(set.header.size)); err != nil {
+		return nil, e.logger.WrapError("failed to join shards", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Stream implements domain.FileBackend.
+// Shards have to be decoded together, so the object is assembled in memory.
+func (e *ErasureBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, err := e.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Stat implements domain.StatBackend using the header of the first readable shard.
+func (e *ErasureBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	for i := 0; i < e.k+e.m; i++ {
+		f, err := os.Open(e.shardPath(path, i))
+		if err != nil {
+			continue
+		}
+
+		hdr := make([]byte, shardHeaderLen)
+		_, err = io.ReadFull(f, hdr)
+		info, statErr := f.Stat()
+		f.Close()
+		if err != nil || statErr != nil {
+			continue
+		}
+
+		header, ok := parseShardHeader(hdr)
+		if !ok {
+			continue
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return &model.FileInfo{
+			Size:         header.size,
+			LastModified: info.ModTime(),
+			ContentType:  contentType,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+}
+
+// List implements domain.FileBackend.
+// It returns every object that has at least one shard on any disk.
+func (e *ErasureBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	seen := map[string]struct{}{}
+	var errs []error
+	for _, dir := range e.dirs {
+		err := e.walkShards(dir, prefix, func(rel string) {
+			seen[shardName.ReplaceAllString(rel, "")] = struct{}{}
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+	}
+	if len(errs) == len(e.dirs) {
+		return nil, e.logger.WrapError("failed to list files", errors.Join(errs...))
+	}
+
+	files := make([]string, 0, len(seen))
+	for p := range seen {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Delete implements domain.FileBackend.
+// Shards on failed disks are left behind if too few remain to rebuild the object.
+func (e *ErasureBackend) Delete(ctx context.Context, path string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	defer e.paths.lock(path)()
+
+	var errs []error
+	for i := 0; i < e.k+e.m; i++ {
+		if err := os.Remove(e.shardPath(path, i)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) >= e.k {
+		return e.logger.WrapError("failed to delete file", errors.Join(errs...))
+	}
+	if len(errs) > 0 {
+		e.logger.Warn(fmt.Sprintf("⚠️ %s deleted but %d shards could not be removed: %v", path, len(errs), errors.Join(errs...)))
+	}
+	return nil
+}
+
+// Exists implements domain.FileBackend.
+func (e *ErasureBackend) Exists(ctx context.Context, path string) (bool, error) {
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	default:
+	}
+
+	var errs []error
+	for i := 0; i < e.k+e.m; i++ {
+		_, err := os.Stat(e.shardPath(path, i))
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == e.k+e.m {
+		return false, e.logger.WrapError("failed to stat file", errors.Join(errs...))
+	}
+	return false, nil
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (e *ErasureBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	var errs []error
+	for _, dir := range e.dirs {
+		if err := os.RemoveAll(filepath.Join(dir, prefix)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+	}
+
+	if len(errs)*e.shardsPerDir() >= e.k {
+		return e.logger.WrapError("failed to delete folder", errors.Join(errs...))
+	}
+	if len(errs) > 0 {
+		e.logger.Warn(fmt.Sprintf("⚠️ %s deleted but %d disks failed: %v", prefix, len(errs), errors.Join(errs...)))
+	}
+	return nil
+}
+
+// Move implements domain.FileBackend.
+// Shards missing at the old path are not moved and have to be rebuilt by Heal.
+func (e *ErasureBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	defer e.paths.lockPair(oldPath, newPath)()
+
+	ok, err := e.Exists(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return e.logger.WrapError("source file does not exist", fmt.Errorf("%s: %w", oldPath, fs.ErrNotExist))
+	}
+
+	// Clear the destination so stale shards of an overwritten object are not mixed in.
+	for i := 0; i < e.k+e.m; i++ {
+		os.Remove(e.shardPath(newPath, i))
+	}
+
+	var moved int
+	var errs []error
+	for i := 0; i < e.k+e.m; i++ {
+		oldShard, newShard := e.shardPath(oldPath, i), e.shardPath(newPath, i)
+		if _, err := os.Stat(oldShard); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(newShard), 0755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Rename(oldShard, newShard); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		moved++
+	}
+
+	if moved < e.k {
+		return e.logger.WrapError("failed to move file", errors.Join(errs...))
+	}
+	if len(errs) > 0 {
+		e.logger.Warn(fmt.Sprintf("⚠️ %s moved to %s but %d shards failed: %v", oldPath, newPath, len(errs), errors.Join(errs...)))
+	}
+	return nil
+}
+
+// Heal rebuilds missing, corrupt and stale shards of every object under prefix,
+// for example after a failed disk has been replaced.
+func (e *ErasureBackend) Heal(ctx context.Context, prefix string) (model.HealReport, error) {
+	var report model.HealReport
+
+	paths, err := e.List(ctx, prefix)
+	if err != nil {
+		return report, err
+	}
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Scanned++
+
+		rebuilt, ok := e.heal(path)
+		if !ok {
+			report.Unrecoverable = append(report.Unrecoverable, path)
+			continue
+		}
+		if rebuilt > 0 {
+			report.Healed++
+			report.Shards += rebuilt
+		}
+	}
+
+	return report, nil
+}
+
+// heal rebuilds the missing shards of path, it returns how many were rebuilt
+// and false if the object cannot be rebuilt.
+func (e *ErasureBackend) heal(path string) (int, bool) {
+	defer e.paths.lock(path)()
+
+	set, err := e.readShards(path)
+	if err != nil {
+		e.logger.Errorf("cannot heal %s: %v", path, err)
+		return 0, false
+	}
+	if len(set.missing) == 0 {
+		return 0, true
+	}
+
+	if err := e.enc.Reconstruct(set.shards); err != nil {
+		e.logger.Errorf("failed to reconstruct %s: %v", path, err)
+		return 0, false
+	}
+
+	var rebuilt int
+	for _, i := range set.missing {
+		if err := e.writeShard(path, i, set.header, set.shards[i]); err != nil {
+			e.logger.Errorf("failed to rebuild shard %d of %s: %v", i, path, err)
+			continue
+		}
+		rebuilt++
+	}
+	return rebuilt, true
+}
+
+// DiskHealth reports whether every disk is reachable and writable, and how
+// many shards it holds.
+func (e *ErasureBackend) DiskHealth(ctx context.Context) []model.DiskHealth {
+	health := make([]model.DiskHealth, len(e.dirs))
+	for i, dir := range e.dirs {
+		h := model.DiskHealth{Path: dir}
+
+		if info, err := os.Stat(dir); err != nil {
+			h.Error = err.Error()
+		} else if !info.IsDir() {
+			h.Error = "not a directory"
+		} else {
+			h.Online = true
+		}
+
+		if h.Online {
+			probe := filepath.Join(dir, shardProbe)
+			if err := os.WriteFile(probe, []byte{}, 0644); err != nil {
+				h.Error = err.Error()
+			} else {
+				os.Remove(probe)
+				h.Writable = true
+			}
+
+			if err := e.walkShards(dir, "", func(string) { h.Shards++ }); err != nil && h.Error == "" {
+				h.Error = err.Error()
+			}
+		}
+
+		health[i] = h
+	}
+	return health
+}
+
+// readShards reads and validates all shards of path and keeps the newest
+// generation with enough shards to rebuild the object.
+func (e *ErasureBackend) readShards(path string) (*shardSet, error) {
+	n := e.k + e.m
+	headers := make([]*shardHeader, n)
+	payloads := make([][]byte, n)
+
+	var found bool
+	var errs []error
+	for i := range n {
+		raw, err := os.ReadFile(e.shardPath(path, i))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		found = true
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		header, ok := parseShardHeader(raw)
+		if !ok || header.k != e.k || header.m != e.m || header.index != i ||
+			shardChecksum(raw[:shardSumOffset], raw[shardHeaderLen:]) != header.sum {
+			e.logger.Errorf("shard %d of %s is corrupt", i, path)
+			continue
+		}
+		headers[i], payloads[i] = &header, raw[shardHeaderLen:]
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+
+	counts := map[uint64]int{}
+	var chosen *shardHeader
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		counts[h.generation]++
+		if counts[h.generation] >= e.k && (chosen == nil || h.generation > chosen.generation) {
+			chosen = h
+		}
+	}
+	if chosen == nil {
+		return nil, fmt.Errorf("%s: %w", path, errors.Join(append([]error{ErrUnrecoverableObject}, errs...)...))
+	}
+
+	set := &shardSet{header: *chosen, shards: make([][]byte, n)}
+	for i, h := range headers {
+		if h != nil && h.generation == chosen.generation {
+			set.shards[i] = payloads[i]
+		} else {
+			set.missing = append(set.missing, i)
+		}
+	}
+	return set, nil
+}
+
+func (e *ErasureBackend) writeShard(path string, index int, header shardHeader, payload []byte) error {
+	header.index = index
+
+	out := make([]byte, shardHeaderLen, shardHeaderLen+len(payload))
+	copy(out, shardMagic)
+	out[4] = shardVersion
+	out[5] = byte(header.k)
+	out[6] = byte(header.m)
+	out[7] = byte(header.index)
+	binary.BigEndian.PutUint64(out[8:], header.generation)
+	binary.BigEndian.PutUint64(out[16:], uint64(header.size))
+	binary.BigEndian.PutUint32(out[shardSumOffset:], shardChecksum(out[:shardSumOffset], payload))
+	out = append(out, payload...)
+
+	return writeFileAtomic(e.shardPath(path, index), out)
+}
+
+// shardChecksum returns the checksum of a shard with the header up to the checksum.
+func shardChecksum(header, payload []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(header), crc32.IEEETable, payload)
+}
+
+func parseShardHeader(b []byte) (shardHeader, bool) {
+	if len(b) < shardHeaderLen || string(b[:4]) != shardMagic || b[4] != shardVersion {
+		return shardHeader{}, false
+	}
+	return shardHeader{
+		k:          int(b[5]),
+		m:          int(b[6]),
+		index:      int(b[7]),
+		generation: binary.BigEndian.Uint64(b[8:]),
+		size:       int64(binary.BigEndian.Uint64(b[16:])),
+		sum:        binary.BigEndian.Uint32(b[shardSumOffset:]),
+	}, true
+}
+
+func (e *ErasureBackend) shardPath(path string, index int) string {
+	return filepath.Join(e.dirs[index%len(e.dirs)], path+shardSuffix+strconv.Itoa(index))
+}
+
+// shardsPerDir is the largest number of shards of one object stored on a single disk.
+func (e *ErasureBackend) shardsPerDir() int {
+	return (e.k + e.m + len(e.dirs) - 1) / len(e.dirs)
+}
+
+// walkShards calls fn with the path of every shard under prefix in dir, relative to dir.
+func (e *ErasureBackend) walkShards(dir, prefix string, fn func(rel string)) error {
+	root := filepath.Join(dir, prefix)
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !shardName.MatchString(path) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fn(rel)
+		return nil
+	})
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place.
+// The temporary file has a unique name, so concurrent writes of the same path
+// never write to the same file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
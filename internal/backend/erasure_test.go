@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupErasureTest(t *testing.T, disks, k, m int) (*ErasureBackend, []string) {
+	var dirs []string
+	for range disks {
+		dirs = append(dirs, t.TempDir())
+	}
+
+	log := logger.NewLogger("", true, false)
+	eb, err := NewErasureBackend(log, dirs, k, m, 0)
+	assert.NoError(t, err)
+	return eb, dirs
+}
+
+func TestErasurePutGet(t *testing.T) {
+	ctx := t.Context()
+	eb, dirs := setupErasureTest(t, 4, 2, 2)
+
+	for _, size := range []int{0, 1, 7, 1000, 4096} {
+		data := randomBytes(t, size)
+		assert.NoError(t, eb.Put(ctx, "dir/file.bin", data))
+
+		got, err := eb.Get(ctx, "dir/file.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, data, got, "size %d", size)
+
+		info, err := eb.Stat(ctx, "dir/file.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), info.Size)
+	}
+
+	for i, dir := range dirs {
+		_, err := os.Stat(filepath.Join(dir, "dir", "file.bin.shard"+string(rune('0'+i))))
+		assert.NoError(t, err)
+	}
+
+	_, err := eb.Get(ctx, "missing.bin")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestErasureReconstruct(t *testing.T) {
+	ctx := t.Context()
+	eb, dirs := setupErasureTest(t, 4, 2, 2)
+	data := randomBytes(t, 5000)
+	assert.NoError(t, eb.Put(ctx, "file.bin", data))
+
+	// Lose a whole disk and corrupt a shard on another one.
+	assert.NoError(t, os.RemoveAll(dirs[0]))
+	shard := filepath.Join(dirs[1], "file.bin.shard1")
+	raw, err := os.ReadFile(shard)
+	assert.NoError(t, err)
+	raw[len(raw)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(shard, raw, 0644))
+
+	got, err := eb.Get(ctx, "file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	rc, err := eb.Stream(ctx, "file.bin")
+	assert.NoError(t, err)
+	streamed, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, data, streamed)
+
+	health := eb.DiskHealth(ctx)
+	assert.False(t, health[0].Online)
+	assert.True(t, health[1].Online)
+	assert.True(t, health[1].Writable)
+	assert.Equal(t, 1, health[2].Shards)
+
+	// Replace the disk and heal.
+	assert.NoError(t, os.MkdirAll(dirs[0], 0755))
+	report, err := eb.Heal(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Scanned)
+	assert.Equal(t, 1, report.Healed)
+	assert.Equal(t, 2, report.Shards)
+	assert.Empty(t, report.Unrecoverable)
+
+	set, err := eb.readShards("file.bin")
+	if assert.NoError(t, err) {
+		assert.Empty(t, set.missing)
+	}
+
+	// Losing more than m shards is unrecoverable.
+	for _, dir := range dirs[:3] {
+		assert.NoError(t, os.RemoveAll(dir))
+	}
+	_, err = eb.Get(ctx, "file.bin")
+	assert.ErrorIs(t, err, ErrUnrecoverableObject)
+}
+
+func TestErasureStaleShards(t *testing.T) {
+	ctx := t.Context()
+	eb, dirs := setupErasureTest(t, 3, 2, 1)
+
+	assert.NoError(t, eb.Put(ctx, "file.txt", []byte("version one")))
+	stale, err := os.ReadFile(filepath.Join(dirs[2], "file.txt.shard2"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, eb.Put(ctx, "file.txt", []byte("version two, longer")))
+	assert.NoError(t, os.WriteFile(filepath.Join(dirs[2], "file.txt.shard2"), stale, 0644))
+
+	got, err := eb.Get(ctx, "file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("version two, longer"), got)
+}
+
+func TestErasureConcurrentPut(t *testing.T) {
+	ctx := t.Context()
+	eb, dirs := setupErasureTest(t, 3, 2, 1)
+
+	versions := make([][]byte, 8)
+	var wg sync.WaitGroup
+	for i := range versions {
+		versions[i] = randomBytes(t, 1000+i)
+		wg.Add(1)
+		go func(data []byte) {
+			defer wg.Done()
+			assert.NoError(t, eb.Put(ctx, "file.bin", data))
+		}(versions[i])
+	}
+	wg.Wait()
+
+	// Every shard belongs to the same write, so no parity is spent on a mix
+	set, err := eb.readShards("file.bin")
+	if assert.NoError(t, err) {
+		assert.Empty(t, set.missing)
+	}
+
+	got, err := eb.Get(ctx, "file.bin")
+	assert.NoError(t, err)
+	assert.Contains(t, versions, got)
+
+	for _, dir := range dirs {
+		tmps, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+		assert.NoError(t, err)
+		assert.Empty(t, tmps)
+	}
+}
+
+func TestErasureListMoveDelete(t *testing.T) {
+	ctx := t.Context()
+	eb, _ := setupErasureTest(t, 2, 2, 2)
+
+	assert.NoError(t, eb.Put(ctx, "a/one.txt", []byte("one")))
+	assert.NoError(t, eb.Put(ctx, "a/two.txt", []byte("two")))
+	assert.NoError(t, eb.Put(ctx, "b/three.txt", []byte("three")))
+
+	files, err := eb.List(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/one.txt", "a/two.txt"}, files)
+
+	assert.NoError(t, eb.Move(ctx, "a/one.txt", "b/one.txt"))
+	ok, err := eb.Exists(ctx, "a/one.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	got, err := eb.Get(ctx, "b/one.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("one"), got)
+
+	assert.Error(t, eb.Move(ctx, "a/missing.txt", "b/missing.txt"))
+
+	assert.NoError(t, eb.Delete(ctx, "a/two.txt"))
+	assert.NoError(t, eb.DeleteFolder(ctx, "b"))
+
+	files, err = eb.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestErasureWriteQuorum(t *testing.T) {
+	ctx := t.Context()
+	eb, dirs := setupErasureTest(t, 3, 2, 1)
+	assert.Equal(t, 3, eb.writeQuorum)
+
+	// A disk that cannot be written leaves only the data shards
+	assert.NoError(t, os.RemoveAll(dirs[2]))
+	assert.NoError(t, os.WriteFile(dirs[2], nil, 0644))
+	assert.Error(t, eb.Put(ctx, "file.bin", []byte("hello")))
+
+	eb.writeQuorum = 2
+	assert.NoError(t, eb.Put(ctx, "file.bin", []byte("hello")))
+
+	log := logger.NewLogger("", true, false)
+	for _, quorum := range []int{1, 4} {
+		_, err := NewErasureBackend(log, dirs, 2, 1, quorum)
+		assert.Error(t, err)
+	}
+}
+
+func TestErasureHeaderChecksum(t *testing.T) {
+	ctx := t.Context()
+	eb, dirs := setupErasureTest(t, 3, 2, 1)
+	data := randomBytes(t, 1000)
+	assert.NoError(t, eb.Put(ctx, "file.bin", data))
+
+	// A flipped size in the header is caught like a flipped payload
+	shard := filepath.Join(dirs[0], "file.bin.shard0")
+	raw, err := os.ReadFile(shard)
+	assert.NoError(t, err)
+	raw[23] ^= 0xff
+	assert.NoError(t, os.WriteFile(shard, raw, 0644))
+
+	set, err := eb.readShards("file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, set.missing)
+
+	got, err := eb.Get(ctx, "file.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -23,6 +23,8 @@ type LocalFileSystemService struct {
 	cache    domain.LRUCache
 }
 
+var _ domain.StatBackend = (*LocalFileSystemService)(nil)
+
 func NewLocalFileSystemService(logger domain.BucktLogger, mediaDir string, cache domain.LRUCache) domain.FileBackend {
 	logger.Info("🚀 Initialising local file system backend")
 	return &LocalFileSystemService{
@@ -82,6 +84,7 @@ func (bfs *LocalFileSystemService) Put(ctx context.Context, path string, data []
 		return bfs.logger.WrapError("failed to rename temp file", err)
 	}
 
+	bfs.cache.Remove(filePath)
 	return nil
 }
 
@@ -129,6 +132,10 @@ func (bfs *LocalFileSystemService) List(ctx context.Context, prefix string) ([]s
 
 	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
+			// A missing prefix simply has no files, like an empty bucket prefix.
+			if path == dirPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
 			return err
 		}
 		if !d.IsDir() {
@@ -172,6 +179,7 @@ func (bfs *LocalFileSystemService) Delete(ctx context.Context, path string) erro
 		return bfs.logger.WrapError("failed to delete file", err)
 	}
 
+	bfs.cache.Remove(filePath)
 	return nil
 }
 
@@ -249,6 +257,9 @@ func (bfs *LocalFileSystemService) Move(ctx context.Context, oldPath, newPath st
 		return bfs.logger.WrapError("failed to create directory", err)
 	}
 
+	bfs.cache.Remove(oldFilePath)
+	bfs.cache.Remove(newFilePath)
+
 	if err := os.Rename(oldFilePath, newFilePath); err != nil {
 		var linkErr *os.LinkError
 		if errors.As(err, &linkErr) && linkErr.Err == syscall.EXDEV {
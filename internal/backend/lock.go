@@ -0,0 +1,67 @@
+package backend
+
+import "sync"
+
+// pathLocks serialises read-modify-write cycles on the same path against the
+// writes of this instance, different paths are locked independently. The zero
+// value is ready to use.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lock locks path and returns the function unlocking it.
+func (l *pathLocks) lock(path string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+	pl, ok := l.locks[path]
+	if !ok {
+		pl = &pathLock{}
+		l.locks[path] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.mu.Lock()
+	return func() {
+		pl.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if pl.refs--; pl.refs == 0 {
+			delete(l.locks, path)
+		}
+	}
+}
+
+// busy reports whether path is locked or waited for.
+func (l *pathLocks) busy(path string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.locks[path]
+	return ok
+}
+
+// lockPair locks two paths in a fixed order, so moves in opposite directions
+// cannot deadlock.
+func (l *pathLocks) lockPair(a, b string) (unlock func()) {
+	if a == b {
+		return l.lock(a)
+	}
+	if b < a {
+		a, b = b, a
+	}
+	unlockA := l.lock(a)
+	unlockB := l.lock(b)
+	return func() {
+		unlockB()
+		unlockA()
+	}
+}
@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// hookedBackend calls onGet before every Get, to write concurrently with a
+// read-modify-write cycle.
+type hookedBackend struct {
+	domain.FileBackend
+	onGet func(path string)
+}
+
+func (h *hookedBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	if h.onGet != nil {
+		h.onGet(path)
+	}
+	return h.FileBackend.Get(ctx, path)
+}
+
+func TestPathLocks(t *testing.T) {
+	var locks pathLocks
+
+	unlock := locks.lock("a")
+	other := locks.lock("b") // Other paths are not blocked
+	other()
+
+	locked := make(chan struct{})
+	go func() {
+		defer locks.lockPair("b", "a")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("path was locked twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer locks.lockPair("a", "b")()
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, locks.locks)
+}
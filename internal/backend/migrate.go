@@ -2,29 +2,139 @@ package backend
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
 )
 
+const (
+	// migrationPageSize is the number of queued objects read at once.
+	migrationPageSize = 1000
+
+	// migrationPromoteTimeout bounds background promotions.
+	migrationPromoteTimeout = time.Minute
+
+	// migrationPromoteWorkers is the number of background promotions run at once.
+	migrationPromoteWorkers = 4
+
+	// migrationPromoteQueueSize bounds the reads waiting for a background
+	// promotion. Reads that do not fit are not promoted, MigrateAll copies them.
+	migrationPromoteQueueSize = 256
+)
+
+type promotionJob struct {
+	path string
+	data []byte
+}
+
+// MigrationBackendService moves objects from a source to a target backend
+// while both stay in use. Writes go to both backends, reads are served by the
+// source and fall back on the target, deletes and moves apply to both.
+// MigrateAll copies the objects that were stored before the migration started
+// and verifies every copy before the source may be deleted. Copies and writes
+// of the same path through this instance do not interleave.
+//
+// In lazy mode reads are served by the target instead, objects missing there
+// are read from the source and promoted to the target, leaving MigrateAll
+// with the objects that are not read.
+//
+// Once everything is copied the migration is cut over to the target in
+// phases, see Cutover. The phase is shared by all instances through the
+// database.
 type MigrationBackendService struct {
 	logger domain.BucktLogger
 
-	primaryBackend   domain.FileBackend
-	secondaryBackend domain.FileBackend
+	primaryBackend   domain.FileBackend // source
+	secondaryBackend domain.FileBackend // target
+
+	cfg        model.MigrationConfig
+	migrations domain.MigrationRepository
+
+	direct migrationPair // Copies made while serving reads
+	bulk   migrationPair // Copies made by migrations, throttled when configured
 
-	// migrating atomic.Bool // indicates an active migration
-	// stats     migrationStats
+	// paths serialises copies with the writes of the same path, so a copy
+	// cannot put content read before a write over it
+	paths pathLocks
+
+	migrating atomic.Bool
+	runs      sync.Mutex // Serialises starting runs
+
+	promotions       singleflight.Group
+	promotionQueue   chan promotionJob
+	promoters        sync.WaitGroup
+	promotionMu      sync.RWMutex // Guards sending to promotionQueue against closing it
+	promotionsClosed bool
+
+	cutoverMu     sync.Mutex // Guards cutover and cutoverLoaded, never held across queries
+	cutover       model.CutoverPhase
+	cutoverLoaded time.Time
+	cutoverFetch  singleflight.Group
 }
 
-func NewMigrationBackend(bucktLogger domain.BucktLogger, primary domain.FileBackend, secondary domain.FileBackend) domain.MigratableBackend {
-	bucktLogger.Info("🚀 Initialising local file system backend")
-	return &MigrationBackendService{
+var _ domain.MigratableBackend = (*MigrationBackendService)(nil)
+var _ domain.RepairableBackend = (*MigrationBackendService)(nil)
+var _ domain.StatBackend = (*MigrationBackendService)(nil)
+
+func NewMigrationBackend(bucktLogger domain.BucktLogger, primary domain.FileBackend, secondary domain.FileBackend, cfg model.MigrationConfig, migrations domain.MigrationRepository, throttle *Throttle) domain.MigratableBackend {
+	bucktLogger.Info("🚀 Initialising migration backend")
+
+	if cfg.ID == "" {
+		cfg.ID = primary.Name() + "->" + secondary.Name()
+	}
+
+	d := &MigrationBackendService{
 		logger:           bucktLogger,
 		primaryBackend:   primary,
 		secondaryBackend: secondary,
+		cfg:              cfg.WithDefaults(),
+		migrations:       migrations,
+		cutover:          model.CutoverDualWrite,
+	}
+
+	d.direct = migrationPair{source: primary, target: secondary, paths: &d.paths}
+	d.bulk = d.direct
+	if throttle != nil {
+		d.bulk = migrationPair{source: NewThrottledBackend(primary, throttle), target: NewThrottledBackend(secondary, throttle), paths: &d.paths}
 	}
+
+	// Lazy reads are promoted in the background unless they wait for it
+	if d.cfg.Lazy && !d.cfg.LazySync {
+		d.promotionQueue = make(chan promotionJob, migrationPromoteQueueSize)
+		for range migrationPromoteWorkers {
+			d.promoters.Add(1)
+			go d.promoteWork()
+		}
+	}
+
+	return d
+}
+
+// Close implements domain.MigratableBackend.
+// It waits for the queued promotions to finish.
+func (d *MigrationBackendService) Close() {
+	d.promotionMu.Lock()
+	if d.promotionQueue != nil && !d.promotionsClosed {
+		close(d.promotionQueue)
+	}
+	d.promotionsClosed = true
+	d.promotionMu.Unlock()
+
+	d.promoters.Wait()
 }
 
 func (d *MigrationBackendService) Name() string {
@@ -32,30 +142,47 @@ func (d *MigrationBackendService) Name() string {
 }
 
 // Put implements domain.FileBackend.
+// The write only fails when the target fails, the source is left behind. Its
+// stale copy is deleted then, so a migration cannot copy it over the newer
+// target. The source is written until it is decommissioned, so every phase
+// before can be rolled back without copying the target back.
 func (d *MigrationBackendService) Put(ctx context.Context, path string, data []byte) error {
-	// Try to put the file in the primary backend
-	if err := d.primaryBackend.Put(ctx, path, data); err != nil {
-		d.logger.Errorf("Failed to put file in primary backend: %v", err)
+	defer d.paths.lock(path)()
+
+	var stale bool
+	if !d.decommissioned(ctx) {
+		if err := d.primaryBackend.Put(ctx, path, data); err != nil {
+			d.logger.Errorf("Failed to put file in primary backend: %v", err)
+			stale = true
+		}
 	}
 
-	// If the primary backend fails, try the secondary backend
 	if err := d.secondaryBackend.Put(ctx, path, data); err != nil {
 		d.logger.Errorf("⚠️ Failed to mirror to secondary: %v", err)
 		return err
 	}
+	if stale {
+		d.dropStale(ctx, path)
+	}
 	return nil
 }
 
 // Get implements domain.FileBackend.
+// Reads are served by the source until the cutover reads from the target.
 func (d *MigrationBackendService) Get(ctx context.Context, path string) ([]byte, error) {
-	// Try to get the file from the primary backend
+	switch phase := d.phase(ctx); {
+	case phase.Order() >= model.CutoverTargetOnly.Order():
+		return d.secondaryBackend.Get(ctx, path)
+	case phase == model.CutoverReadTarget || d.cfg.Lazy:
+		return d.getTargetFirst(ctx, path)
+	}
+
 	data, err := d.primaryBackend.Get(ctx, path)
 	if err != nil {
-		d.logger.Errorf("Failed to get file from primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
+		d.logFallback("get file", err)
 		data, err = d.secondaryBackend.Get(ctx, path)
 		if err != nil {
-			d.logger.Errorf("Failed to get file from secondary backend: %v", err)
+			d.logFailure("get file", err)
 			return nil, err
 		}
 	}
@@ -63,106 +190,743 @@ func (d *MigrationBackendService) Get(ctx context.Context, path string) ([]byte,
 }
 
 // List implements domain.FileBackend.
+// The paths of both backends are merged, objects written while the source
+// failed are only in the target.
 func (d *MigrationBackendService) List(ctx context.Context, prefix string) ([]string, error) {
-	// Try to list files from the primary backend
-	paths, err := d.primaryBackend.List(ctx, prefix)
-	if err != nil {
-		d.logger.Errorf("Failed to list files from primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
-		paths, err = d.secondaryBackend.List(ctx, prefix)
-		if err != nil {
-			d.logger.Errorf("Failed to list files from secondary backend: %v", err)
-			return nil, err
+	if d.targetOnly(ctx) {
+		return d.secondaryBackend.List(ctx, prefix)
+	}
+
+	primary, primaryErr := d.primaryBackend.List(ctx, prefix)
+	if primaryErr != nil {
+		d.logger.Errorf("Failed to list files from primary backend: %v", primaryErr)
+	}
+	secondary, secondaryErr := d.secondaryBackend.List(ctx, prefix)
+	if secondaryErr != nil {
+		d.logger.Errorf("Failed to list files from secondary backend: %v", secondaryErr)
+	}
+	if primaryErr != nil && secondaryErr != nil {
+		return nil, errors.Join(primaryErr, secondaryErr)
+	}
+
+	listed := make(map[string]struct{}, len(primary))
+	paths := make([]string, 0, len(primary))
+	for _, p := range append(primary, secondary...) {
+		if _, ok := listed[p]; !ok {
+			listed[p] = struct{}{}
+			paths = append(paths, p)
 		}
 	}
+	sort.Strings(paths)
 	return paths, nil
 }
 
 // Stream implements domain.FileBackend.
+// Reads are served by the source until the cutover reads from the target.
 func (d *MigrationBackendService) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
-	// Try to stream the file from the primary backend
+	switch phase := d.phase(ctx); {
+	case phase.Order() >= model.CutoverTargetOnly.Order():
+		return d.secondaryBackend.Stream(ctx, path)
+	case phase == model.CutoverReadTarget || d.cfg.Lazy:
+		return d.streamTargetFirst(ctx, path)
+	}
+
 	reader, err := d.primaryBackend.Stream(ctx, path)
 	if err != nil {
-		d.logger.Errorf("Failed to stream file from primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
+		d.logFallback("stream file", err)
 		reader, err = d.secondaryBackend.Stream(ctx, path)
 		if err != nil {
-			d.logger.Errorf("Failed to stream file from secondary backend: %v", err)
+			d.logFailure("stream file", err)
 			return nil, err
 		}
 	}
 	return reader, nil
 }
 
+// Stat implements domain.StatBackend.
+// Objects are stated in the source with the target as fallback until the
+// cutover to the target. Backends that cannot stat objects are read instead,
+// their info has no modification time.
+func (d *MigrationBackendService) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	if d.targetOnly(ctx) {
+		return statObject(ctx, d.secondaryBackend, path)
+	}
+
+	info, err := statObject(ctx, d.primaryBackend, path)
+	if err != nil {
+		d.logFallback("stat file", err)
+		info, err = statObject(ctx, d.secondaryBackend, path)
+		if err != nil {
+			d.logFailure("stat file", err)
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// getTargetFirst reads path from the target and falls back on the source. In
+// lazy mode objects missing from the target are promoted, objects the target
+// fails to serve are read from the source without promoting them.
+func (d *MigrationBackendService) getTargetFirst(ctx context.Context, path string) ([]byte, error) {
+	data, err := d.secondaryBackend.Get(ctx, path)
+	if err == nil {
+		return data, nil
+	}
+	missing := errors.Is(err, fs.ErrNotExist)
+	if !missing {
+		d.logger.Errorf("Failed to get file from secondary backend: %v", err)
+	}
+
+	data, err = d.primaryBackend.Get(ctx, path)
+	if err != nil {
+		d.logFailure("get file", err)
+		return nil, err
+	}
+
+	if missing && d.cfg.Lazy {
+		if d.cfg.LazySync {
+			d.promote(ctx, path, data)
+		} else {
+			d.promoteAsync(path, data)
+		}
+	}
+	return data, nil
+}
+
+// streamTargetFirst is getTargetFirst for streams, synchronous promotions
+// stream the new copy.
+func (d *MigrationBackendService) streamTargetFirst(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := d.secondaryBackend.Stream(ctx, path)
+	if err == nil {
+		return reader, nil
+	}
+	missing := errors.Is(err, fs.ErrNotExist) && d.cfg.Lazy
+	if !errors.Is(err, fs.ErrNotExist) {
+		d.logger.Errorf("Failed to stream file from secondary backend: %v", err)
+	}
+
+	if missing && d.cfg.LazySync {
+		if d.promote(ctx, path, nil) == nil {
+			if reader, err := d.secondaryBackend.Stream(ctx, path); err == nil {
+				return reader, nil
+			}
+		}
+		missing = false // A failed promotion is not retried in the background
+	}
+
+	reader, err = d.primaryBackend.Stream(ctx, path)
+	if err != nil {
+		d.logFailure("stream file", err)
+		return nil, err
+	}
+
+	if missing {
+		d.promoteAsync(path, nil)
+	}
+	return reader, nil
+}
+
+// promoteAsync queues path to be promoted in the background. It is dropped
+// when the queue is full, MigrateAll copies it instead.
+func (d *MigrationBackendService) promoteAsync(path string, data []byte) {
+	d.promotionMu.RLock()
+	defer d.promotionMu.RUnlock()
+	if d.promotionQueue == nil || d.promotionsClosed {
+		return
+	}
+
+	select {
+	case d.promotionQueue <- promotionJob{path: path, data: data}:
+	default:
+		d.logger.Warn(fmt.Sprintf("promotion queue is full, %s is copied by the next migration", path))
+	}
+}
+
+func (d *MigrationBackendService) promoteWork() {
+	defer d.promoters.Done()
+
+	for job := range d.promotionQueue {
+		ctx, cancel := context.WithTimeout(context.Background(), migrationPromoteTimeout)
+		d.promote(ctx, job.path, job.data)
+		cancel()
+	}
+}
+
+// promote copies path to the target and records it as promoted in the active
+// run, so MigrateAll does not copy it again. data is the content of the
+// source, nil reads it. Concurrent promotions of the same path are shared.
+func (d *MigrationBackendService) promote(ctx context.Context, path string, data []byte) error {
+	_, err, _ := d.promotions.Do(path, func() (any, error) {
+		if data == nil {
+			var err error
+			if data, err = d.primaryBackend.Get(ctx, path); err != nil {
+				return nil, err
+			}
+		}
+
+		copied, err := d.direct.copyRead(ctx, path, data)
+		if err != nil {
+			return nil, err
+		}
+
+		run, _, err := d.activeRun(ctx)
+		if err != nil {
+			return nil, err
+		}
+		row := &model.MigrationModel{
+			RunID:          run.ID,
+			ObjectKey:      path,
+			Size:           copied.size,
+			ChecksumSHA256: copied.checksum,
+			Status:         model.MigrationStatusCommitted,
+			Attempts:       1,
+			Promoted:       true,
+		}
+		if err := d.migrations.Upsert(ctx, row); err != nil {
+			return nil, err
+		}
+
+		d.deleteSource(ctx, path, copied)
+		return nil, nil
+	})
+	if err != nil {
+		d.logger.Errorf("failed to promote %s to %s: %v", path, d.secondaryBackend.Name(), err)
+	}
+	return err
+}
+
 // Move implements domain.FileBackend.
+// The object is moved in both backends, it only has to exist in one of them.
 func (d *MigrationBackendService) Move(ctx context.Context, oldPath string, newPath string) error {
-	// Try to move the file in the primary backend
-	if err := d.primaryBackend.Move(ctx, oldPath, newPath); err != nil {
-		d.logger.Errorf("Failed to move file in primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
-		if err := d.secondaryBackend.Move(ctx, oldPath, newPath); err != nil {
-			d.logger.Errorf("Failed to move file in secondary backend: %v", err)
-			return err
-		}
+	defer d.paths.lockPair(oldPath, newPath)()
+
+	if d.decommissioned(ctx) {
+		return d.secondaryBackend.Move(ctx, oldPath, newPath)
 	}
-	return nil
+	return d.both("move file", func(b domain.FileBackend) error {
+		return b.Move(ctx, oldPath, newPath)
+	})
 }
 
 // Exists implements domain.FileBackend.
 func (d *MigrationBackendService) Exists(ctx context.Context, path string) (bool, error) {
-	// Check if the file exists in the primary backend
+	if d.targetOnly(ctx) {
+		return d.secondaryBackend.Exists(ctx, path)
+	}
+
 	exists, err := d.primaryBackend.Exists(ctx, path)
 	if err != nil {
 		d.logger.Errorf("Failed to check existence in primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
-		exists, err = d.secondaryBackend.Exists(ctx, path)
-		if err != nil {
-			d.logger.Errorf("Failed to check existence in secondary backend: %v", err)
-			return false, err
-		}
+	}
+	if exists {
+		return true, nil
+	}
+
+	exists, secondaryErr := d.secondaryBackend.Exists(ctx, path)
+	if secondaryErr != nil {
+		d.logger.Errorf("Failed to check existence in secondary backend: %v", secondaryErr)
+		return false, secondaryErr
 	}
 	return exists, nil
 }
 
 // Delete implements domain.FileBackend.
+// The object is deleted from both backends, so the migration cannot bring it back.
 func (d *MigrationBackendService) Delete(ctx context.Context, path string) error {
-	// Try to delete the file in the primary backend
-	if err := d.primaryBackend.Delete(ctx, path); err != nil {
-		d.logger.Errorf("Failed to delete file in primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
-		if err := d.secondaryBackend.Delete(ctx, path); err != nil {
-			d.logger.Errorf("Failed to delete file in secondary backend: %v", err)
-			return err
-		}
+	defer d.paths.lock(path)()
+
+	if d.decommissioned(ctx) {
+		return d.secondaryBackend.Delete(ctx, path)
 	}
-	return nil
+	return d.both("delete file", func(b domain.FileBackend) error {
+		return b.Delete(ctx, path)
+	})
 }
 
 // DeleteFolder implements domain.FileBackend.
 func (d *MigrationBackendService) DeleteFolder(ctx context.Context, prefix string) error {
-	// Try to delete the folder in the primary backend
-	if err := d.primaryBackend.DeleteFolder(ctx, prefix); err != nil {
-		d.logger.Errorf("Failed to delete folder in primary backend: %v", err)
-		// If the primary backend fails, try the secondary backend
-		if err := d.secondaryBackend.DeleteFolder(ctx, prefix); err != nil {
-			d.logger.Errorf("Failed to delete folder in secondary backend: %v", err)
-			return err
+	if d.decommissioned(ctx) {
+		return d.secondaryBackend.DeleteFolder(ctx, prefix)
+	}
+	return d.both("delete folder", func(b domain.FileBackend) error {
+		return b.DeleteFolder(ctx, prefix)
+	})
+}
+
+// both runs op against the source and the target. Objects missing from one
+// of them are not an error, objects missing from both are.
+func (d *MigrationBackendService) both(action string, op func(b domain.FileBackend) error) error {
+	primaryErr := op(d.primaryBackend)
+	secondaryErr := op(d.secondaryBackend)
+
+	primaryMissing := errors.Is(primaryErr, fs.ErrNotExist)
+	secondaryMissing := errors.Is(secondaryErr, fs.ErrNotExist)
+	switch {
+	case primaryMissing && secondaryMissing:
+		return primaryErr
+	case primaryMissing:
+		primaryErr = nil
+	case secondaryMissing:
+		secondaryErr = nil
+	}
+
+	if primaryErr != nil {
+		d.logger.Errorf("Failed to %s in primary backend: %v", action, primaryErr)
+	}
+	if secondaryErr != nil {
+		d.logger.Errorf("Failed to %s in secondary backend: %v", action, secondaryErr)
+	}
+	return errors.Join(primaryErr, secondaryErr)
+}
+
+// logFallback logs a failed read of the source, objects that were not
+// migrated back yet are expected to be missing.
+func (d *MigrationBackendService) logFallback(action string, err error) {
+	if !errors.Is(err, fs.ErrNotExist) {
+		d.logger.Errorf("Failed to %s from primary backend: %v", action, err)
+	}
+}
+
+func (d *MigrationBackendService) logFailure(action string, err error) {
+	if !errors.Is(err, fs.ErrNotExist) {
+		d.logger.Errorf("Failed to %s from secondary backend: %v", action, err)
+	}
+}
+
+// RepairObject implements domain.RepairableBackend.
+// The copy of one backend that passes verify is written over the other one.
+func (d *MigrationBackendService) RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error) {
+	check := func(b domain.FileBackend) bool {
+		rc, err := b.Stream(ctx, path)
+		if err != nil {
+			return false
 		}
+		defer rc.Close()
+		return verify(rc)
 	}
-	return nil
+
+	from, to := d.primaryBackend, d.secondaryBackend
+	if d.decommissioned(ctx) {
+		return check(to), nil
+	}
+	switch {
+	case check(from):
+	case check(to):
+		from, to = to, from
+	default:
+		return false, nil
+	}
+
+	data, err := from.Get(ctx, path)
+	if err != nil {
+		return false, err
+	}
+	if check(to) {
+		return true, nil
+	}
+	if err := to.Put(ctx, path, data); err != nil {
+		return false, d.logger.WrapError("failed to repair "+path+" on "+to.Name(), err)
+	}
+	d.logger.Infof("🩹 Repaired %s on %s from %s", path, to.Name(), from.Name())
+	return true, nil
 }
 
 // MigrateAll implements domain.MigratableBackend.
+// Every object of the source is copied to the target, unless the target
+// already holds the same content, and committed once the copy is read back
+// and matches the size and checksum of the source. Objects are copied
+// concurrently and failed copies and verifications are retried with backoff.
+// Only one migration runs at a time, and none once the source is decommissioned.
+//
+// The progress of every object is recorded in the database. A run that was
+// interrupted or had failures is resumed by the next call: objects that were
+// committed are skipped, all others are copied again.
 func (d *MigrationBackendService) MigrateAll(ctx context.Context) error {
-	return fmt.Errorf("MigrateAll not implemented")
+	return d.Migrate(ctx, model.MigrateOptions{})
+}
+
+// Migrate is MigrateAll for the objects under a prefix, with hooks to pause
+// the run and follow its progress. Objects already copied keep being copied
+// while the run waits.
+func (d *MigrationBackendService) Migrate(ctx context.Context, opts model.MigrateOptions) error {
+	if d.decommissioned(ctx) {
+		return errSourceDecommissioned
+	}
+	if !d.migrating.CompareAndSwap(false, true) {
+		return errs.ErrMigrationRunning
+	}
+	defer d.migrating.Store(false)
+
+	run, resumed, err := d.activeRun(ctx)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		d.logger.Infof("🔄 Resuming migration %s", run.ID)
+	}
+
+	paths, err := d.bulk.source.List(ctx, opts.Prefix)
+	if err != nil {
+		return d.logger.WrapError("failed to list objects to migrate", err)
+	}
+	if err := d.migrations.Enqueue(ctx, run.ID, paths); err != nil {
+		return d.logger.WrapError("failed to queue objects to migrate", err)
+	}
+
+	if opts.Queued != nil {
+		total, err := d.migrations.CountPending(ctx, run.ID, opts.Prefix)
+		if err != nil {
+			return d.logger.WrapError("failed to count objects to migrate", err)
+		}
+		opts.Queued(total)
+	}
+
+	d.logger.Infof("🔄 Migrating %d objects from %s to %s", len(paths), d.primaryBackend.Name(), d.secondaryBackend.Name())
+
+	concurrency := d.cfg.Concurrency
+	if opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
+	}
+
+	var failed atomic.Int64
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+
+	var after string
+	for ctx.Err() == nil {
+		rows, err := d.migrations.GetPending(ctx, run.ID, opts.Prefix, after, migrationPageSize)
+		if err != nil {
+			g.Wait()
+			return d.logger.WrapError("failed to get objects to migrate", err)
+		}
+
+		for _, row := range rows {
+			if opts.Wait != nil {
+				if err := opts.Wait(ctx); err != nil {
+					g.Wait()
+					return err
+				}
+			}
+
+			g.Go(func() error {
+				err := d.migrateObject(ctx, row)
+				if err != nil && ctx.Err() != nil {
+					return nil // Cancelled, the object stays queued
+				}
+				if err != nil {
+					d.logger.Errorf("failed to migrate %s: %v", row.ObjectKey, err)
+					failed.Add(1)
+				}
+				if opts.Progress != nil {
+					opts.Progress(row.ObjectKey, row.Size, err)
+				}
+				return nil
+			})
+		}
+
+		if len(rows) < migrationPageSize {
+			break
+		}
+		after = rows[len(rows)-1].ObjectKey
+	}
+	g.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("failed to migrate %d objects, they are retried by the next migration", n)
+	}
+
+	if opts.Prefix == "" {
+		if err := d.migrations.CompleteRun(ctx, run.ID, time.Now()); err != nil {
+			return d.logger.WrapError("failed to complete migration", err)
+		}
+	}
+
+	d.logger.Infof("✅ Migrated %d objects from %s to %s", len(paths), d.primaryBackend.Name(), d.secondaryBackend.Name())
+	return nil
+}
+
+// activeRun returns the active run of the migration, starting a new one when
+// there is none. resumed reports whether the run existed.
+func (d *MigrationBackendService) activeRun(ctx context.Context) (run *model.MigrationRunModel, resumed bool, err error) {
+	d.runs.Lock()
+	defer d.runs.Unlock()
+
+	run, err = d.migrations.GetActiveRun(ctx, d.cfg.ID)
+	if err == nil {
+		return run, true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, d.logger.WrapError("failed to get migration", err)
+	}
+
+	run = &model.MigrationRunModel{MigrationID: d.cfg.ID, Source: d.primaryBackend.Name(), Target: d.secondaryBackend.Name(), Status: model.MigrationRunActive}
+	if err := d.migrations.CreateRun(ctx, run); err != nil {
+		return nil, false, d.logger.WrapError("failed to start migration", err)
+	}
+	return run, false, nil
+}
+
+// migrateObject copies the object of row and records every attempt and the
+// outcome. Objects deleted from the source since they were queued need no copy.
+func (d *MigrationBackendService) migrateObject(ctx context.Context, row *model.MigrationModel) error {
+	var copied verifiedCopy
+
+	err := d.retry(ctx, func() error {
+		row.Status = model.MigrationStatusUploading
+		row.Attempts++
+		if err := d.migrations.Update(ctx, row); err != nil {
+			return err
+		}
+
+		var err error
+		copied, err = d.bulk.copyObject(ctx, row.ObjectKey, func() error {
+			row.Status = model.MigrationStatusVerifying
+			return d.migrations.Update(ctx, row)
+		})
+		if err != nil {
+			row.LastError = err.Error()
+			if errors.Is(err, errCopyMismatch) {
+				row.VerifyFailures++
+			}
+		}
+		return err
+	})
+
+	// The outcome is recorded even when the migration is cancelled
+	cancelled := ctx.Err() != nil
+	ctx = context.WithoutCancel(ctx)
+
+	switch {
+	case err == nil:
+		row.Status = model.MigrationStatusCommitted
+		row.Size = copied.size
+		row.ChecksumSHA256 = copied.checksum
+		row.LastError = ""
+		if err := d.migrations.Update(ctx, row); err != nil {
+			return err
+		}
+		d.deleteSource(ctx, row.ObjectKey, copied)
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		row.Status = model.MigrationStatusCommitted
+		row.LastError = "object was deleted from the source"
+		return d.migrations.Update(ctx, row)
+	case cancelled:
+		// Copies cut short by the cancellation did not fail, the object stays
+		// queued for the next migration
+		row.Status = model.MigrationStatusQueued
+		if updateErr := d.migrations.Update(ctx, row); updateErr != nil {
+			d.logger.Errorf("failed to record migration of %s: %v", row.ObjectKey, updateErr)
+		}
+		return err
+	default:
+		row.Status = model.MigrationStatusFailed
+		if updateErr := d.migrations.Update(ctx, row); updateErr != nil {
+			d.logger.Errorf("failed to record migration of %s: %v", row.ObjectKey, updateErr)
+		}
+		return err
+	}
 }
 
 // MigrateFile implements domain.MigratableBackend.
+// The object is copied unless the target already holds the same content and
+// the copy is verified, failed copies are retried with backoff. Objects
+// migrated one at a time are not recorded.
 func (d *MigrationBackendService) MigrateFile(ctx context.Context, path string) error {
-	return fmt.Errorf("MigrateFile not implemented")
+	if d.decommissioned(ctx) {
+		return errSourceDecommissioned
+	}
+
+	var copied verifiedCopy
+	err := d.retry(ctx, func() error {
+		var err error
+		copied, err = d.bulk.copyObject(ctx, path, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	d.deleteSource(ctx, path, copied)
+	return nil
+}
+
+// dropStale deletes the copy of path the source kept after failing a write
+// the target took.
+func (d *MigrationBackendService) dropStale(ctx context.Context, path string) {
+	if err := d.primaryBackend.Delete(ctx, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		d.logger.Errorf("failed to delete stale %s from %s: %v", path, d.primaryBackend.Name(), err)
+	}
+}
+
+// deleteSource deletes a verified copy from the source when configured. Both
+// objects are read again first and the source is only deleted while both
+// still hold the copied content, a write since may have left them apart.
+func (d *MigrationBackendService) deleteSource(ctx context.Context, path string, copied verifiedCopy) {
+	if !d.cfg.DeleteSource {
+		return
+	}
+	defer d.paths.lock(path)()
+
+	source, err := digest(ctx, d.bulk.source, path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			d.logger.Errorf("failed to read migrated %s from %s: %v", path, d.primaryBackend.Name(), err)
+		}
+		return
+	}
+	target, err := digest(ctx, d.bulk.target, path)
+	if err != nil {
+		d.logger.Errorf("failed to read migrated %s from %s: %v", path, d.secondaryBackend.Name(), err)
+		return
+	}
+	if source != copied || target != copied {
+		d.logger.Warn(fmt.Sprintf("⚠️ Keeping %s in %s, it changed since it was copied", path, d.primaryBackend.Name()))
+		return
+	}
+
+	if err := d.primaryBackend.Delete(ctx, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		d.logger.Errorf("failed to delete migrated %s from %s: %v", path, d.primaryBackend.Name(), err)
+	}
+}
+
+// retry runs op until it succeeds, the object turns out to be missing or the
+// retries are used up, doubling the wait between attempts.
+func (d *MigrationBackendService) retry(ctx context.Context, op func() error) error {
+	backoff := d.cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || errors.Is(err, fs.ErrNotExist) || attempt == d.cfg.Retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// errCopyMismatch is returned when the copy in the target differs from the source.
+var errCopyMismatch = errors.New("copy does not match the source")
+
+// verifiedCopy is the size and SHA-256 checksum of content both backends hold.
+type verifiedCopy struct {
+	size     int64
+	checksum string
+}
+
+// migrationPair is the source and target objects are copied between.
+type migrationPair struct {
+	source domain.FileBackend
+	target domain.FileBackend
+	paths  *pathLocks // Shared with the writes of the migration backend
+}
+
+// copyObject copies the object at path to the target, unless the target
+// already holds the same content, and reads the copy back to compare its size
+// and checksum with the source. verifying is called before the copy is read
+// back. Only the source reports fs.ErrNotExist. Writes of the path wait for
+// the copy.
+func (p migrationPair) copyObject(ctx context.Context, path string, verifying func() error) (verifiedCopy, error) {
+	defer p.paths.lock(path)()
+
+	data, err := p.source.Get(ctx, path)
+	if err != nil {
+		return verifiedCopy{}, err
+	}
+	return p.copyData(ctx, path, data, verifying)
+}
+
+// copyRead is copyObject for content read from the source before the path
+// was locked. A target written since holds newer content and is kept.
+func (p migrationPair) copyRead(ctx context.Context, path string, data []byte) (verifiedCopy, error) {
+	defer p.paths.lock(path)()
+
+	if got, err := p.digestTarget(ctx, path); err == nil {
+		return got, nil
+	}
+	return p.copyData(ctx, path, data, nil)
+}
+
+// copyData copies content read from the source, the path must be locked.
+func (p migrationPair) copyData(ctx context.Context, path string, data []byte, verifying func() error) (verifiedCopy, error) {
+	sum := sha256.Sum256(data)
+	want := verifiedCopy{size: int64(len(data)), checksum: hex.EncodeToString(sum[:])}
+
+	if got, err := p.digestTarget(ctx, path); err == nil && got == want {
+		return want, nil
+	}
+
+	if err := p.target.Put(ctx, path, data); err != nil {
+		return verifiedCopy{}, err
+	}
+
+	if verifying != nil {
+		if err := verifying(); err != nil {
+			return verifiedCopy{}, err
+		}
+	}
+
+	got, err := p.digestTarget(ctx, path)
+	if err != nil {
+		return verifiedCopy{}, fmt.Errorf("failed to verify copy: %v", err)
+	}
+	if got != want {
+		return verifiedCopy{}, fmt.Errorf("%w: %d bytes with checksum %s, expected %d bytes with checksum %s", errCopyMismatch, got.size, got.checksum, want.size, want.checksum)
+	}
+	return want, nil
+}
+
+// digestTarget reads the object at path in the target and returns its size and checksum.
+func (p migrationPair) digestTarget(ctx context.Context, path string) (verifiedCopy, error) {
+	return digest(ctx, p.target, path)
+}
+
+// digest reads the object at path in b and returns its size and checksum.
+func digest(ctx context.Context, b domain.FileBackend, path string) (verifiedCopy, error) {
+	rc, err := b.Stream(ctx, path)
+	if err != nil {
+		return verifiedCopy{}, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		return verifiedCopy{}, err
+	}
+	return verifiedCopy{size: n, checksum: hex.EncodeToString(h.Sum(nil))}, nil
 }
 
 // MigrationStatus implements domain.MigratableBackend.
+// It reports the progress of the run of the migration that was started last,
+// also by another instance sharing the database.
 func (d *MigrationBackendService) MigrationStatus(ctx context.Context) (completed int64, total int64) {
-	return 0, 0
+	run, err := d.migrations.GetLatestRunFor(ctx, d.cfg.ID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			d.logger.Errorf("failed to get migration: %v", err)
+		}
+		return 0, 0
+	}
+
+	counts, err := d.migrations.CountByStatus(ctx, run.ID)
+	if err != nil {
+		d.logger.Errorf("failed to count migrated objects: %v", err)
+		return 0, 0
+	}
+
+	for status, n := range counts {
+		if status == model.MigrationStatusCommitted {
+			completed += n
+		}
+		total += n
+	}
+	return completed, total
 }
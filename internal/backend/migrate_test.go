@@ -0,0 +1,556 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/database"
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/internal/repository"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sync/errgroup"
+)
+
+func setupMigrationTest(t *testing.T) (*MigrationBackendService, *mocks.MemoryBackend, *mocks.FaultyBackend) {
+	source := mocks.NewMemoryBackend("source")
+	target := mocks.NewFaultyBackend(mocks.NewMemoryBackend("target"))
+
+	mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{Concurrency: 4, Retries: 2, RetryBackoff: time.Millisecond})
+	return mb, source, target
+}
+
+func newTestMigrationBackend(t *testing.T, source, target domain.FileBackend, cfg model.MigrationConfig) (*MigrationBackendService, *database.DB) {
+	log := logger.NewLogger("", true, false)
+
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		sqlDB.Close()
+	})
+
+	db, err := database.NewDB(sqlDB, model.SQLite, log, false)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Migrate())
+
+	mb := NewMigrationBackend(log, source, target, cfg, repository.NewMigrationRepository(db), nil)
+	t.Cleanup(mb.Close)
+	return mb.(*MigrationBackendService), db
+}
+
+// corruptingBackend truncates the next writes, like a target that lost data in transit.
+type corruptingBackend struct {
+	*mocks.MemoryBackend
+	corrupt atomic.Int32
+}
+
+func (c *corruptingBackend) Put(ctx context.Context, path string, data []byte) error {
+	if c.corrupt.Add(-1) >= 0 {
+		data = data[:len(data)/2]
+	}
+	return c.MemoryBackend.Put(ctx, path, data)
+}
+
+func TestMigrateAll(t *testing.T) {
+	ctx := t.Context()
+	mb, source, target := setupMigrationTest(t)
+
+	for i := range 20 {
+		assert.NoError(t, source.Put(ctx, fmt.Sprintf("old/%d.txt", i), []byte("old")))
+	}
+	assert.NoError(t, mb.Put(ctx, "new.txt", []byte("new")))
+
+	assert.NoError(t, mb.MigrateAll(ctx))
+
+	completed, total := mb.MigrationStatus(ctx)
+	assert.Equal(t, int64(21), total)
+	assert.Equal(t, int64(21), completed)
+	assert.Len(t, target.FileBackend.(*mocks.MemoryBackend).Objects(), 21)
+
+	// Objects already in the target are not copied again
+	puts := target.Calls("Put")
+	assert.NoError(t, mb.MigrateAll(ctx))
+	assert.Equal(t, puts, target.Calls("Put"))
+}
+
+func TestMigrateFileRetries(t *testing.T) {
+	ctx := t.Context()
+	mb, source, target := setupMigrationTest(t)
+
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("a")))
+
+	target.FailOn("Put", errors.New("throttled"))
+	assert.Error(t, mb.MigrateFile(ctx, "a.txt"))
+	assert.Equal(t, 3, target.Calls("Put"))
+
+	target.Heal()
+	assert.NoError(t, mb.MigrateFile(ctx, "a.txt"))
+	data, err := target.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), data)
+}
+
+func TestMigrateAllResumes(t *testing.T) {
+	ctx := t.Context()
+	mb, source, target := setupMigrationTest(t)
+
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("a")))
+	assert.NoError(t, source.Put(ctx, "b.txt", []byte("bb")))
+
+	target.FailOn("Put", errors.New("throttled"))
+	assert.Error(t, mb.MigrateAll(ctx))
+
+	completed, total := mb.MigrationStatus(ctx)
+	assert.Equal(t, int64(2), total)
+	assert.Zero(t, completed)
+
+	run, err := mb.migrations.GetLatestRun(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, model.MigrationRunActive, run.Status)
+
+	rows, err := mb.migrations.GetPending(ctx, run.ID, "", "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, model.MigrationStatusFailed, rows[0].Status)
+		assert.Equal(t, 3, rows[0].Attempts)
+		assert.Equal(t, "throttled", rows[0].LastError)
+	}
+
+	// A copy that was interrupted by a crash is picked up again
+	rows[1].Status = model.MigrationStatusUploading
+	assert.NoError(t, mb.migrations.Update(ctx, rows[1]))
+
+	target.Heal()
+	assert.NoError(t, mb.MigrateAll(ctx))
+
+	resumed, err := mb.migrations.GetLatestRun(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, run.ID, resumed.ID)
+	assert.Equal(t, model.MigrationRunCompleted, resumed.Status)
+	assert.NotNil(t, resumed.CompletedAt)
+
+	counts, err := mb.migrations.CountByStatus(ctx, run.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[model.MigrationStatus]int64{model.MigrationStatusCommitted: 2}, counts)
+
+	// The next migration is a new run
+	assert.NoError(t, mb.MigrateAll(ctx))
+	next, err := mb.migrations.GetLatestRun(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, run.ID, next.ID)
+}
+
+func TestMigratePrefix(t *testing.T) {
+	ctx := t.Context()
+	mb, source, target := setupMigrationTest(t)
+
+	for _, path := range []string{"docs/a.txt", "docs/b.txt", "img/c.png"} {
+		assert.NoError(t, source.Put(ctx, path, []byte(path)))
+	}
+
+	var queued, waits int64
+	var copied []string
+	var mu sync.Mutex
+	assert.NoError(t, mb.Migrate(ctx, model.MigrateOptions{
+		Prefix:      "docs/",
+		Concurrency: 1,
+		Wait: func(ctx context.Context) error {
+			waits++
+			return nil
+		},
+		Queued: func(total int64) { queued = total },
+		Progress: func(path string, size int64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(path)), size)
+			copied = append(copied, path)
+		},
+	}))
+
+	assert.Equal(t, int64(2), queued)
+	assert.Equal(t, int64(2), waits)
+	assert.ElementsMatch(t, []string{"docs/a.txt", "docs/b.txt"}, copied)
+	assert.NotContains(t, target.FileBackend.(*mocks.MemoryBackend).Objects(), "img/c.png")
+
+	// A prefix does not complete the migration
+	run, err := mb.migrations.GetLatestRun(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, model.MigrationRunActive, run.Status)
+
+	// A pause that ends in an error stops the run
+	stop := errors.New("stopped")
+	assert.ErrorIs(t, mb.Migrate(ctx, model.MigrateOptions{Wait: func(ctx context.Context) error { return stop }}), stop)
+
+	assert.NoError(t, mb.MigrateAll(ctx))
+	run, err = mb.migrations.GetLatestRun(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, model.MigrationRunCompleted, run.Status)
+}
+
+func TestMigrateCancelled(t *testing.T) {
+	mb, source, _ := setupMigrationTest(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	for _, path := range []string{"a.txt", "b.txt", "c.txt"} {
+		assert.NoError(t, source.Put(ctx, path, []byte(path)))
+	}
+
+	// The run is cancelled once the first object is copied
+	var reported []string
+	err := mb.Migrate(ctx, model.MigrateOptions{
+		Concurrency: 1,
+		Progress: func(path string, size int64, err error) {
+			assert.NoError(t, err)
+			reported = append(reported, path)
+			cancel()
+		},
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"a.txt"}, reported)
+
+	// The objects cut short are queued, not failed
+	run, err := mb.migrations.GetLatestRun(t.Context())
+	assert.NoError(t, err)
+	counts, err := mb.migrations.CountByStatus(t.Context(), run.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, map[model.MigrationStatus]int64{model.MigrationStatusCommitted: 1, model.MigrationStatusQueued: 2}, counts)
+}
+
+func TestMigrateAllVerifies(t *testing.T) {
+	ctx := t.Context()
+	cfg := model.MigrationConfig{Concurrency: 1, Retries: 2, RetryBackoff: time.Millisecond, DeleteSource: true}
+
+	t.Run("retries a corrupt copy", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := &corruptingBackend{MemoryBackend: mocks.NewMemoryBackend("target")}
+		mb, db := newTestMigrationBackend(t, source, target, cfg)
+
+		assert.NoError(t, source.Put(ctx, "a.txt", []byte("hello world")))
+		target.corrupt.Store(1)
+
+		assert.NoError(t, mb.MigrateAll(ctx))
+		assert.Equal(t, []byte("hello world"), target.Objects()["a.txt"])
+		assert.NotContains(t, source.Objects(), "a.txt") // deleted once verified
+
+		run, err := mb.migrations.GetLatestRun(ctx)
+		assert.NoError(t, err)
+		var row model.MigrationModel
+		assert.NoError(t, db.Where("run_id = ?", run.ID).First(&row).Error)
+		assert.Equal(t, model.MigrationStatusCommitted, row.Status)
+		assert.Equal(t, 2, row.Attempts)
+		assert.Equal(t, 1, row.VerifyFailures)
+		assert.Equal(t, int64(len("hello world")), row.Size)
+		assert.NotEmpty(t, row.ChecksumSHA256)
+	})
+
+	t.Run("keeps the source when the copy never matches", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := &corruptingBackend{MemoryBackend: mocks.NewMemoryBackend("target")}
+		mb, _ := newTestMigrationBackend(t, source, target, cfg)
+
+		assert.NoError(t, source.Put(ctx, "a.txt", []byte("hello world")))
+		target.corrupt.Store(100)
+
+		assert.Error(t, mb.MigrateAll(ctx))
+		assert.Contains(t, source.Objects(), "a.txt")
+
+		run, err := mb.migrations.GetLatestRun(ctx)
+		assert.NoError(t, err)
+		rows, err := mb.migrations.GetPending(ctx, run.ID, "", "", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, rows, 1) {
+			assert.Equal(t, model.MigrationStatusFailed, rows[0].Status)
+			assert.Equal(t, 3, rows[0].VerifyFailures)
+			assert.Contains(t, rows[0].LastError, "copy does not match the source")
+		}
+	})
+
+	t.Run("replaces a different copy in the target", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := mocks.NewMemoryBackend("target")
+		mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{})
+
+		assert.NoError(t, source.Put(ctx, "a.txt", []byte("new")))
+		assert.NoError(t, target.Put(ctx, "a.txt", []byte("stale")))
+
+		assert.NoError(t, mb.MigrateFile(ctx, "a.txt"))
+		assert.Equal(t, []byte("new"), target.Objects()["a.txt"])
+		assert.Contains(t, source.Objects(), "a.txt")
+	})
+}
+
+func TestMigrationLazy(t *testing.T) {
+	ctx := t.Context()
+
+	t.Run("promotes objects read from the source", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := mocks.NewFaultyBackend(mocks.NewMemoryBackend("target"))
+		mb, db := newTestMigrationBackend(t, source, target, model.MigrationConfig{Lazy: true})
+
+		assert.NoError(t, source.Put(ctx, "hot.txt", []byte("hot")))
+		assert.NoError(t, source.Put(ctx, "cold.txt", []byte("cold")))
+
+		data, err := mb.Get(ctx, "hot.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("hot"), data)
+		mb.Close()
+
+		var row model.MigrationModel
+		assert.NoError(t, db.Where("object_key = ?", "hot.txt").First(&row).Error)
+		assert.True(t, row.Promoted)
+		assert.Equal(t, model.MigrationStatusCommitted, row.Status)
+		assert.Equal(t, 1, target.Calls("Put"))
+
+		// The bulk migration only copies the cold object
+		assert.NoError(t, mb.MigrateAll(ctx))
+		assert.Equal(t, 2, target.Calls("Put"))
+		assert.Len(t, target.FileBackend.(*mocks.MemoryBackend).Objects(), 2)
+
+		completed, total := mb.MigrationStatus(ctx)
+		assert.Equal(t, int64(2), completed)
+		assert.Equal(t, int64(2), total)
+	})
+
+	t.Run("drops promotions when the queue is full", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("source")
+		target := mocks.NewMemoryBackend("target")
+
+		// Promotions block reading the source until released
+		release := make(chan struct{})
+		source := &hookedBackend{FileBackend: memory, onGet: func(string) { <-release }}
+		mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{Lazy: true})
+
+		reads := migrationPromoteWorkers + migrationPromoteQueueSize + 1
+		for i := range reads {
+			path := fmt.Sprintf("%d.txt", i)
+			assert.NoError(t, memory.Put(ctx, path, []byte(path)))
+			rc, err := mb.Stream(ctx, path)
+			assert.NoError(t, err)
+			rc.Close()
+
+			// The workers take the first reads before the queue fills
+			if i < migrationPromoteWorkers {
+				assert.Eventually(t, func() bool { return len(mb.promotionQueue) == 0 }, 5*time.Second, time.Millisecond)
+			}
+		}
+
+		close(release)
+		mb.Close()
+		assert.Len(t, target.Objects(), reads-1)
+
+		// Reads after Close are not promoted
+		assert.NoError(t, memory.Put(ctx, "late.txt", []byte("late")))
+		rc, err := mb.Stream(ctx, "late.txt")
+		assert.NoError(t, err)
+		rc.Close()
+		assert.Len(t, target.Objects(), reads-1)
+	})
+
+	t.Run("dedupes concurrent promotions", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := mocks.NewFaultyBackend(mocks.NewMemoryBackend("target"))
+		mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{Lazy: true, LazySync: true})
+
+		assert.NoError(t, source.Put(ctx, "a.txt", []byte("hello")))
+
+		var g errgroup.Group
+		for range 10 {
+			g.Go(func() error {
+				rc, err := mb.Stream(ctx, "a.txt")
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				data, err := io.ReadAll(rc)
+				if err == nil && string(data) != "hello" {
+					err = fmt.Errorf("read %q", data)
+				}
+				return err
+			})
+		}
+		assert.NoError(t, g.Wait())
+		assert.Equal(t, 1, target.Calls("Put"))
+	})
+
+	t.Run("serves the source when the target fails", func(t *testing.T) {
+		source := mocks.NewMemoryBackend("source")
+		target := mocks.NewFaultyBackend(mocks.NewMemoryBackend("target"))
+		mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{Lazy: true, LazySync: true})
+
+		assert.NoError(t, source.Put(ctx, "a.txt", []byte("a")))
+		target.FailOn("Get", errors.New("unavailable"))
+
+		data, err := mb.Get(ctx, "a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("a"), data)
+		assert.Zero(t, target.Calls("Put"))
+	})
+}
+
+func TestMigrationDeleteAndMove(t *testing.T) {
+	ctx := t.Context()
+	mb, source, target := setupMigrationTest(t)
+
+	assert.NoError(t, mb.Put(ctx, "both.txt", []byte("both")))
+	assert.NoError(t, source.Put(ctx, "source.txt", []byte("source")))
+
+	// Moves apply to every backend the object is in
+	assert.NoError(t, mb.Move(ctx, "both.txt", "moved.txt"))
+	assert.NoError(t, mb.Move(ctx, "source.txt", "moved-source.txt"))
+	assert.Error(t, mb.Move(ctx, "missing.txt", "other.txt"))
+
+	for _, exists := range []func() (bool, error){
+		func() (bool, error) { return source.Exists(ctx, "moved.txt") },
+		func() (bool, error) { return target.Exists(ctx, "moved.txt") },
+		func() (bool, error) { return source.Exists(ctx, "moved-source.txt") },
+	} {
+		ok, err := exists()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	// A deleted object is gone from the target too, a migration cannot bring it back
+	assert.NoError(t, mb.Delete(ctx, "moved.txt"))
+	ok, err := mb.Exists(ctx, "moved.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NotContains(t, target.FileBackend.(*mocks.MemoryBackend).Objects(), "moved.txt")
+}
+
+// streamHookedBackend calls onStream before every Stream, to write
+// concurrently with a copy that compares the target before writing it.
+type streamHookedBackend struct {
+	domain.FileBackend
+	onStream func(path string)
+}
+
+func (h *streamHookedBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	if h.onStream != nil {
+		h.onStream(path)
+	}
+	return h.FileBackend.Stream(ctx, path)
+}
+
+func TestMigrateFileConcurrentWrite(t *testing.T) {
+	ctx := t.Context()
+	source := mocks.NewMemoryBackend("source")
+	target := mocks.NewMemoryBackend("target")
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("old")))
+
+	// A write lands after the copy read the source
+	hooked := &streamHookedBackend{FileBackend: target}
+	mb, _ := newTestMigrationBackend(t, source, hooked, model.MigrationConfig{})
+	written := make(chan error, 1)
+	var once sync.Once
+	hooked.onStream = func(path string) {
+		once.Do(func() {
+			go func() { written <- mb.Put(ctx, path, []byte("new")) }()
+			time.Sleep(20 * time.Millisecond)
+		})
+	}
+
+	assert.NoError(t, mb.MigrateFile(ctx, "a.txt"))
+	assert.NoError(t, <-written)
+
+	data, err := target.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), data)
+}
+
+func TestMigrateFileKeepsChangedSource(t *testing.T) {
+	ctx := t.Context()
+	source := mocks.NewMemoryBackend("source")
+	target := mocks.NewMemoryBackend("target")
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("old")))
+
+	// The source changes between the copy and its deletion
+	hooked := &streamHookedBackend{FileBackend: source}
+	hooked.onStream = func(path string) {
+		assert.NoError(t, source.Put(ctx, path, []byte("changed")))
+	}
+	mb, _ := newTestMigrationBackend(t, hooked, target, model.MigrationConfig{DeleteSource: true})
+
+	assert.NoError(t, mb.MigrateFile(ctx, "a.txt"))
+
+	data, err := source.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("changed"), data)
+}
+
+func TestMigrationPutDropsStaleSource(t *testing.T) {
+	ctx := t.Context()
+	memory := mocks.NewMemoryBackend("source")
+	source := mocks.NewFaultyBackend(memory)
+	target := mocks.NewMemoryBackend("target")
+	assert.NoError(t, memory.Put(ctx, "a.txt", []byte("old")))
+	mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{})
+
+	source.FailOn("Put", errors.New("source down"))
+	assert.NoError(t, mb.Put(ctx, "a.txt", []byte("new")))
+
+	// The stale source copy cannot be migrated over the newer target
+	exists, err := memory.Exists(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	data, err := mb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), data)
+}
+
+func TestMigrationListAndExists(t *testing.T) {
+	ctx := t.Context()
+	mb, source, target := setupMigrationTest(t)
+
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("a")))
+	assert.NoError(t, target.Put(ctx, "b.txt", []byte("b")))
+
+	paths, err := mb.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "b.txt"}, paths)
+
+	ok, err := mb.Exists(ctx, "b.txt")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	data, err := mb.Get(ctx, "b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("b"), data)
+}
+
+func TestMigrationStat(t *testing.T) {
+	ctx := t.Context()
+	sourceModified := time.Now().Add(-48 * time.Hour)
+	source := &statMemoryBackend{MemoryBackend: mocks.NewMemoryBackend("source"), modified: sourceModified}
+	target := mocks.NewMemoryBackend("target")
+	mb, _ := newTestMigrationBackend(t, source, target, model.MigrationConfig{})
+
+	assert.NoError(t, source.Put(ctx, "a.txt", []byte("aa")))
+	assert.NoError(t, target.Put(ctx, "b.txt", []byte("bbb")))
+
+	info, err := mb.Stat(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), info.Size)
+	assert.Equal(t, sourceModified, info.LastModified)
+
+	// The target cannot stat objects, so the age of its objects is unknown
+	info, err = mb.Stat(ctx, "b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), info.Size)
+	assert.True(t, info.LastModified.IsZero())
+
+	_, err = mb.Stat(ctx, "missing.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
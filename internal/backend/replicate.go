@@ -0,0 +1,639 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+const (
+	// replicaFailureThreshold is the number of consecutive failures after which
+	// a replica is considered unhealthy.
+	replicaFailureThreshold = 3
+
+	// replicaCooldown is how long an unhealthy replica is tried last for reads.
+	replicaCooldown = 30 * time.Second
+
+	// replicaRepairTimeout bounds background read-repairs.
+	replicaRepairTimeout = time.Minute
+
+	// replicaRepairWorkers is the number of background read-repairs run at once.
+	replicaRepairWorkers = 2
+
+	// replicaRepairQueueSize bounds the reads waiting for a read-repair. Reads
+	// that do not fit are not checked, anti-entropy repairs them.
+	replicaRepairQueueSize = 256
+
+	// replicaDigestEvery is how often read-repairs compare the checksums of
+	// copies that have the same size: one check in replicaDigestEvery.
+	// Anti-entropy always compares them.
+	replicaDigestEvery = 8
+)
+
+// errNoMajority is returned by Repair when the copies of an object differ and
+// no version is held by more replicas than any other.
+var errNoMajority = errors.New("replicas disagree without a majority")
+
+// ReplicatedBackend fans writes out to N child backends and succeeds once the
+// write quorum has acknowledged them. Reads are served by the first healthy
+// replica, after which the other replicas are compared by checksum in the
+// background and missing or divergent copies are rewritten with the majority
+// version.
+//
+// Deletes are not tombstoned: an object deleted while a replica was
+// unreachable can be restored to the others by a later repair. Use a write
+// quorum equal to the number of replicas if that matters.
+type ReplicatedBackend struct {
+	logger      domain.BucktLogger
+	replicas    []*replica
+	writeQuorum int
+
+	repairQueue   chan string
+	repairers     sync.WaitGroup
+	repairMu      sync.RWMutex        // Guards sending to repairQueue against closing it
+	repairsQueued map[string]struct{} // Paths queued or being repaired
+	repairsClosed bool
+	pending       sync.WaitGroup // Queued read-repairs that did not finish
+	paths         pathLocks
+
+	checks      atomic.Uint64 // Divergence checks, to sample checksum comparisons
+	digestEvery uint64
+}
+
+var _ domain.FileBackend = (*ReplicatedBackend)(nil)
+var _ domain.StatBackend = (*ReplicatedBackend)(nil)
+var _ domain.RepairableBackend = (*ReplicatedBackend)(nil)
+
+// replica tracks the health of a single child backend.
+type replica struct {
+	domain.FileBackend
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// NewReplicatedBackend creates a ReplicatedBackend over the given replicas.
+// A writeQuorum outside 1..len(replicas) defaults to a majority.
+func NewReplicatedBackend(logger domain.BucktLogger, writeQuorum int, replicas ...domain.FileBackend) *ReplicatedBackend {
+	logger.Infof("🪞 Initialising replicated backend with %d replicas", len(replicas))
+
+	if writeQuorum <= 0 || writeQuorum > len(replicas) {
+		writeQuorum = len(replicas)/2 + 1
+	}
+
+	rb := &ReplicatedBackend{
+		logger:      logger,
+		writeQuorum: writeQuorum,
+		digestEvery: replicaDigestEvery,
+	}
+	for _, r := range replicas {
+		rb.replicas = append(rb.replicas, &replica{FileBackend: r})
+	}
+
+	if len(rb.replicas) >= 2 {
+		rb.repairQueue = make(chan string, replicaRepairQueueSize)
+		rb.repairsQueued = make(map[string]struct{})
+		for range replicaRepairWorkers {
+			rb.repairers.Add(1)
+			go rb.repairWork()
+		}
+	}
+	return rb
+}
+
+// Close waits for the queued read-repairs to finish.
+func (r *ReplicatedBackend) Close() {
+	r.repairMu.Lock()
+	if r.repairQueue != nil && !r.repairsClosed {
+		close(r.repairQueue)
+	}
+	r.repairsClosed = true
+	r.repairMu.Unlock()
+
+	r.repairers.Wait()
+}
+
+// Name implements domain.FileBackend.
+func (r *ReplicatedBackend) Name() string {
+	names := make([]string, len(r.replicas))
+	for i, rep := range r.replicas {
+		names[i] = rep.Name()
+	}
+	return "replicated(" + strings.Join(names, ",") + ")"
+}
+
+// Put implements domain.FileBackend.
+func (r *ReplicatedBackend) Put(ctx context.Context, path string, data []byte) error {
+	defer r.paths.lock(path)()
+	return r.fanOut("put", func(rep *replica) error {
+		return rep.Put(ctx, path, data)
+	})
+}
+
+// Get implements domain.FileBackend.
+func (r *ReplicatedBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	var errs []error
+	for _, rep := range r.ordered() {
+		data, err := rep.Get(ctx, path)
+		if err == nil {
+			rep.record(nil)
+			r.repairAsync(path)
+			return data, nil
+		}
+		if !r.missing(ctx, rep, path, err) {
+			rep.record(err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+	}
+	return nil, r.logger.WrapError("failed to read from any replica", errors.Join(errs...))
+}
+
+// Stream implements domain.FileBackend.
+func (r *ReplicatedBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	var errs []error
+	for i, rep := range r.ordered() {
+		rc, err := rep.Stream(ctx, path)
+		if err == nil {
+			rep.record(nil)
+			// Only repair when an earlier replica could not serve the object,
+			// streaming is used for large files we do not want to read twice.
+			if i > 0 {
+				r.repairAsync(path)
+			}
+			return rc, nil
+		}
+		if !r.missing(ctx, rep, path, err) {
+			rep.record(err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+	}
+	return nil, r.logger.WrapError("failed to stream from any replica", errors.Join(errs...))
+}
+
+// Stat implements domain.StatBackend, using the first replica that supports it.
+func (r *ReplicatedBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	var errs []error
+	for _, rep := range r.ordered() {
+		stater, ok := rep.FileBackend.(domain.StatBackend)
+		if !ok {
+			continue
+		}
+		info, err := stater.Stat(ctx, path)
+		if err == nil {
+			return info, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+	}
+	if len(errs) == 0 {
+		return statByReading(ctx, r, path)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// List implements domain.FileBackend.
+// It returns the union of the objects listed by every reachable replica.
+func (r *ReplicatedBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	listed, err := r.listAll(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(listed))
+	for p := range listed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Delete implements domain.FileBackend.
+func (r *ReplicatedBackend) Delete(ctx context.Context, path string) error {
+	defer r.paths.lock(path)()
+	return r.fanOut("delete", func(rep *replica) error {
+		return rep.Delete(ctx, path)
+	})
+}
+
+// Exists implements domain.FileBackend.
+func (r *ReplicatedBackend) Exists(ctx context.Context, path string) (bool, error) {
+	var errs []error
+	for _, rep := range r.ordered() {
+		ok, err := rep.Exists(ctx, path)
+		rep.record(err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+			continue
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if len(errs) == len(r.replicas) {
+		return false, errors.Join(errs...)
+	}
+	return false, nil
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (r *ReplicatedBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	return r.fanOut("delete folder", func(rep *replica) error {
+		return rep.DeleteFolder(ctx, prefix)
+	})
+}
+
+// Move implements domain.FileBackend.
+func (r *ReplicatedBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	defer r.paths.lockPair(oldPath, newPath)()
+	return r.fanOut("move", func(rep *replica) error {
+		return rep.Move(ctx, oldPath, newPath)
+	})
+}
+
+// Repair compares the copies of path on every replica and rewrites the ones
+// that are missing or differ from the majority. It returns the number of
+// copies rewritten. Replicas that fail to answer are left untouched. Copies
+// that differ without a majority are left untouched too, errNoMajority is
+// returned and RepairObject has to tell which copy is good.
+func (r *ReplicatedBackend) Repair(ctx context.Context, path string) (int, error) {
+	defer r.paths.lock(path)()
+
+	type copyState struct {
+		data    []byte
+		sum     [sha256.Size]byte
+		present bool
+		err     error
+	}
+
+	copies := make([]copyState, len(r.replicas))
+	var wg sync.WaitGroup
+	for i, rep := range r.replicas {
+		wg.Add(1)
+		go func(i int, rep *replica) {
+			defer wg.Done()
+			data, err := rep.Get(ctx, path)
+			switch {
+			case err == nil:
+				copies[i] = copyState{data: data, sum: sha256.Sum256(data), present: true}
+			case r.missing(ctx, rep, path, err):
+				copies[i] = copyState{}
+			default:
+				copies[i] = copyState{err: err}
+			}
+		}(i, rep)
+	}
+	wg.Wait()
+
+	// Pick the checksum held by most replicas
+	votes := map[[sha256.Size]byte]int{}
+	winner := -1
+	for i, c := range copies {
+		if !c.present {
+			continue
+		}
+		votes[c.sum]++
+		if winner == -1 || votes[c.sum] > votes[copies[winner].sum] {
+			winner = i
+		}
+	}
+	if winner == -1 {
+		return 0, nil
+	}
+	for sum, n := range votes {
+		if sum != copies[winner].sum && n == votes[copies[winner].sum] {
+			return 0, fmt.Errorf("%w: %s", errNoMajority, path)
+		}
+	}
+
+	var repaired int
+	var errs []error
+	for i, c := range copies {
+		if c.err != nil || (c.present && c.sum == copies[winner].sum) {
+			continue
+		}
+		rep := r.replicas[i]
+		if err := rep.Put(ctx, path, copies[winner].data); err != nil {
+			rep.record(err)
+			errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+			continue
+		}
+		r.logger.Infof("🩹 Repaired %s on replica %s", path, rep.Name())
+		repaired++
+	}
+
+	return repaired, errors.Join(errs...)
+}
+
+// RepairObject implements domain.RepairableBackend.
+// Unlike Repair, copies are not compared with each other but with verify, so
+// a copy held by a minority of the replicas can win. Replicas that fail to
+// answer are left untouched.
+func (r *ReplicatedBackend) RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error) {
+	defer r.paths.lock(path)()
+
+	good := -1
+	var bad []int
+	for i, rep := range r.replicas {
+		rc, err := rep.Stream(ctx, path)
+		if err != nil {
+			if r.missing(ctx, rep, path, err) {
+				bad = append(bad, i)
+			}
+			continue
+		}
+		ok := verify(rc)
+		rc.Close()
+
+		switch {
+		case !ok:
+			bad = append(bad, i)
+		case good == -1:
+			good = i
+		}
+	}
+	if good == -1 {
+		return false, nil
+	}
+
+	data, err := r.replicas[good].Get(ctx, path)
+	if err != nil {
+		return false, err
+	}
+
+	var errs []error
+	for _, i := range bad {
+		rep := r.replicas[i]
+		if err := rep.Put(ctx, path, data); err != nil {
+			rep.record(err)
+			errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+			continue
+		}
+		r.logger.Infof("🩹 Repaired %s on replica %s from %s", path, rep.Name(), r.replicas[good].Name())
+	}
+
+	return len(errs) == 0, errors.Join(errs...)
+}
+
+// AntiEntropy reconciles all objects under prefix across the replicas.
+func (r *ReplicatedBackend) AntiEntropy(ctx context.Context, prefix string) (model.ReplicationReport, error) {
+	var report model.ReplicationReport
+
+	listed, err := r.listAll(ctx, prefix)
+	if err != nil {
+		return report, err
+	}
+
+	paths := make([]string, 0, len(listed))
+	for p := range listed {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		report.Scanned++
+		n, err := r.Repair(ctx, p)
+		report.Repaired += n
+		if err != nil {
+			r.logger.Errorf("failed to reconcile %s: %v", p, err)
+			report.Failed++
+		}
+	}
+
+	return report, nil
+}
+
+// fanOut runs op against every replica concurrently and succeeds once the
+// write quorum has acknowledged it.
+func (r *ReplicatedBackend) fanOut(action string, op func(rep *replica) error) error {
+	errs := make([]error, len(r.replicas))
+	var wg sync.WaitGroup
+	for i, rep := range r.replicas {
+		wg.Add(1)
+		go func(i int, rep *replica) {
+			defer wg.Done()
+			err := op(rep)
+			rep.record(err)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", rep.Name(), err)
+			}
+		}(i, rep)
+	}
+	wg.Wait()
+
+	var acks int
+	for _, err := range errs {
+		if err == nil {
+			acks++
+		}
+	}
+
+	if acks < r.writeQuorum {
+		return r.logger.WrapError(
+			fmt.Sprintf("failed to %s: %d of %d replicas acknowledged, quorum is %d", action, acks, len(r.replicas), r.writeQuorum),
+			errors.Join(errs...),
+		)
+	}
+	if acks < len(r.replicas) {
+		r.logger.Warn(fmt.Sprintf("⚠️ %s reached quorum but %d replicas failed: %v", action, len(r.replicas)-acks, errors.Join(errs...)))
+	}
+	return nil
+}
+
+// listAll lists prefix on every replica and returns the union of the paths.
+// It only fails if no replica could be listed.
+func (r *ReplicatedBackend) listAll(ctx context.Context, prefix string) (map[string]struct{}, error) {
+	listed := map[string]struct{}{}
+	var errs []error
+	for _, rep := range r.replicas {
+		paths, err := rep.List(ctx, prefix)
+		rep.record(err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", rep.Name(), err))
+			continue
+		}
+		for _, p := range paths {
+			listed[p] = struct{}{}
+		}
+	}
+	if len(errs) == len(r.replicas) {
+		return nil, r.logger.WrapError("failed to list any replica", errors.Join(errs...))
+	}
+	return listed, nil
+}
+
+// ordered returns the replicas with healthy ones first, preserving their configured order.
+func (r *ReplicatedBackend) ordered() []*replica {
+	healthy := make([]*replica, 0, len(r.replicas))
+	var unhealthy []*replica
+	for _, rep := range r.replicas {
+		if rep.healthy() {
+			healthy = append(healthy, rep)
+		} else {
+			unhealthy = append(unhealthy, rep)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// missing reports whether err means the object does not exist on the replica,
+// rather than the replica failing.
+func (r *ReplicatedBackend) missing(ctx context.Context, rep *replica, path string, err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	ok, existsErr := rep.Exists(ctx, path)
+	return existsErr == nil && !ok
+}
+
+// repairAsync queues path to be repaired in the background when the replicas
+// diverge. Paths already queued are not queued again, paths being written are
+// skipped as the write brings the replicas in line. Reads are dropped when the
+// queue is full, anti-entropy repairs them instead.
+func (r *ReplicatedBackend) repairAsync(path string) {
+	if r.paths.busy(path) {
+		return
+	}
+
+	r.repairMu.Lock()
+	defer r.repairMu.Unlock()
+	if r.repairQueue == nil || r.repairsClosed {
+		return
+	}
+	if _, ok := r.repairsQueued[path]; ok {
+		return
+	}
+
+	select {
+	case r.repairQueue <- path:
+		r.repairsQueued[path] = struct{}{}
+		r.pending.Add(1)
+	default:
+		r.logger.Warn(fmt.Sprintf("read-repair queue is full, %s is repaired by the next anti-entropy pass", path))
+	}
+}
+
+func (r *ReplicatedBackend) repairWork() {
+	defer r.repairers.Done()
+
+	for path := range r.repairQueue {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaRepairTimeout)
+		if r.divergent(ctx, path) {
+			if _, err := r.Repair(ctx, path); err != nil {
+				r.logger.Errorf("read-repair of %s failed: %v", path, err)
+			}
+		}
+		cancel()
+
+		r.repairMu.Lock()
+		delete(r.repairsQueued, path)
+		r.repairMu.Unlock()
+		r.pending.Done()
+	}
+}
+
+// divergent reports whether the replicas may hold different copies of path.
+// When every replica supports Stat the sizes are compared first, and the
+// checksums of copies with the same size only in one check every
+// digestEvery. Replicas that fail to answer are ignored.
+func (r *ReplicatedBackend) divergent(ctx context.Context, path string) bool {
+	stat := true
+	for _, rep := range r.replicas {
+		if _, ok := rep.FileBackend.(domain.StatBackend); !ok {
+			stat = false
+			break
+		}
+	}
+
+	if stat {
+		if r.differ(ctx, path, true) {
+			return true
+		}
+		if r.checks.Add(1)%r.digestEvery != 0 {
+			return false
+		}
+	}
+	return r.differ(ctx, path, false)
+}
+
+// differ reports whether the sizes of the copies of path differ when stat is
+// set, and their checksums otherwise. Missing copies differ.
+func (r *ReplicatedBackend) differ(ctx context.Context, path string, stat bool) bool {
+	var want string
+	for _, rep := range r.replicas {
+		digest, err := rep.digest(ctx, path, stat)
+		if err != nil {
+			if r.missing(ctx, rep, path, err) {
+				return true
+			}
+			continue
+		}
+		if want != "" && digest != want {
+			return true
+		}
+		want = digest
+	}
+	return false
+}
+
+// digest returns the size of the copy of path when stat is set, and its
+// checksum otherwise.
+func (rep *replica) digest(ctx context.Context, path string, stat bool) (string, error) {
+	if stat {
+		info, err := rep.FileBackend.(domain.StatBackend).Stat(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(info.Size, 10), nil
+	}
+
+	rc, err := rep.Stream(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (rep *replica) healthy() bool {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return time.Now().After(rep.downUntil)
+}
+
+// record updates the health of the replica with the outcome of an operation.
+func (rep *replica) record(err error) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		rep.failures = 0
+		return
+	}
+
+	rep.failures++
+	if rep.failures >= replicaFailureThreshold {
+		rep.downUntil = time.Now().Add(replicaCooldown)
+	}
+}
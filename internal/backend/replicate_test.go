@@ -0,0 +1,278 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupReplicationTest(t *testing.T, writeQuorum int) (*ReplicatedBackend, []*mocks.MemoryBackend, []*mocks.FaultyBackend) {
+	var memories []*mocks.MemoryBackend
+	var faulties []*mocks.FaultyBackend
+
+	for _, name := range []string{"a", "b", "c"} {
+		m := mocks.NewMemoryBackend(name)
+		f := mocks.NewFaultyBackend(m)
+		memories = append(memories, m)
+		faulties = append(faulties, f)
+	}
+
+	log := logger.NewLogger("", true, false)
+	rb := NewReplicatedBackend(log, writeQuorum, faulties[0], faulties[1], faulties[2])
+	t.Cleanup(rb.Close)
+	return rb, memories, faulties
+}
+
+func TestReplicatedPutQuorum(t *testing.T) {
+	ctx := t.Context()
+	rb, memories, faulties := setupReplicationTest(t, 0)
+	assert.Equal(t, 2, rb.writeQuorum)
+
+	faulties[2].FailOn("Put", errors.New("disk full"))
+	assert.NoError(t, rb.Put(ctx, "a.txt", []byte("hello")))
+	assert.Contains(t, memories[0].Objects(), "a.txt")
+	assert.Contains(t, memories[1].Objects(), "a.txt")
+	assert.NotContains(t, memories[2].Objects(), "a.txt")
+
+	faulties[1].FailOn("Put", errors.New("disk full"))
+	assert.Error(t, rb.Put(ctx, "b.txt", []byte("hello")))
+}
+
+func TestReplicatedReadRepairQueue(t *testing.T) {
+	ctx := t.Context()
+	memories := []*mocks.MemoryBackend{mocks.NewMemoryBackend("a"), mocks.NewMemoryBackend("b")}
+	for _, m := range memories {
+		assert.NoError(t, m.Put(ctx, "a.txt", []byte("hello")))
+	}
+
+	// Read-repairs block comparing the copies until released
+	release := make(chan struct{})
+	hooked := &streamHookedBackend{FileBackend: memories[0], onStream: func(string) { <-release }}
+	rb := NewReplicatedBackend(logger.NewLogger("", true, false), 0, hooked, memories[1])
+	t.Cleanup(rb.Close)
+
+	// Reads of a path waiting for its read-repair do not queue it again
+	for range 5 {
+		rb.repairAsync("a.txt")
+	}
+	assert.Eventually(t, func() bool { return len(rb.repairQueue) == 0 }, 5*time.Second, time.Millisecond)
+	rb.repairMu.RLock()
+	assert.Len(t, rb.repairsQueued, 1)
+	rb.repairMu.RUnlock()
+
+	close(release)
+	rb.pending.Wait()
+
+	// Reads after Close are not repaired
+	rb.Close()
+	assert.NoError(t, memories[1].Put(ctx, "a.txt", []byte("rotten")))
+	_, err := rb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	rb.pending.Wait()
+	assert.Equal(t, []byte("rotten"), memories[1].Objects()["a.txt"])
+}
+
+func TestReplicatedReadRepairSameSize(t *testing.T) {
+	ctx := t.Context()
+	var replicas []domain.FileBackend
+	var memories []*mocks.MemoryBackend
+	for _, name := range []string{"a", "b", "c"} {
+		m := mocks.NewMemoryBackend(name)
+		memories = append(memories, m)
+		replicas = append(replicas, &statMemoryBackend{MemoryBackend: m})
+	}
+	rb := NewReplicatedBackend(logger.NewLogger("", true, false), 0, replicas...)
+	t.Cleanup(rb.Close)
+
+	assert.NoError(t, rb.Put(ctx, "a.txt", []byte("good")))
+	assert.NoError(t, memories[2].Put(ctx, "a.txt", []byte("goOd")))
+
+	// Sizes alone do not tell the copies apart
+	assert.False(t, rb.differ(ctx, "a.txt", true))
+	assert.True(t, rb.differ(ctx, "a.txt", false))
+
+	// Checksums are compared on the sampled reads
+	for range replicaDigestEvery {
+		_, err := rb.Get(ctx, "a.txt")
+		assert.NoError(t, err)
+		rb.pending.Wait()
+	}
+	assert.Equal(t, []byte("good"), memories[2].Objects()["a.txt"])
+}
+
+func TestReplicatedRepairWithoutMajority(t *testing.T) {
+	ctx := t.Context()
+	memories := []*mocks.MemoryBackend{mocks.NewMemoryBackend("a"), mocks.NewMemoryBackend("b")}
+	rb := NewReplicatedBackend(logger.NewLogger("", true, false), 0, memories[0], memories[1])
+	t.Cleanup(rb.Close)
+
+	assert.NoError(t, memories[0].Put(ctx, "a.txt", []byte("rotten")))
+	assert.NoError(t, memories[1].Put(ctx, "a.txt", []byte("good")))
+
+	// A tie is not broken in favour of the first replica
+	n, err := rb.Repair(ctx, "a.txt")
+	assert.ErrorIs(t, err, errNoMajority)
+	assert.Zero(t, n)
+	assert.Equal(t, []byte("good"), memories[1].Objects()["a.txt"])
+
+	repaired, err := rb.RepairObject(ctx, "a.txt", func(r io.Reader) bool {
+		data, err := io.ReadAll(r)
+		return err == nil && string(data) == "good"
+	})
+	assert.NoError(t, err)
+	assert.True(t, repaired)
+	assert.Equal(t, []byte("good"), memories[0].Objects()["a.txt"])
+}
+
+func TestReplicatedGetFailover(t *testing.T) {
+	ctx := t.Context()
+	rb, _, faulties := setupReplicationTest(t, 0)
+
+	assert.NoError(t, rb.Put(ctx, "a.txt", []byte("hello")))
+
+	faulties[0].FailOn("Get", errors.New("connection refused"))
+	faulties[0].FailOn("Exists", errors.New("connection refused"))
+
+	for range replicaFailureThreshold {
+		data, err := rb.Get(ctx, "a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("hello"), data)
+	}
+	rb.pending.Wait()
+
+	// The failing replica is now unhealthy and tried last.
+	assert.Equal(t, "b", rb.ordered()[0].Name())
+}
+
+func TestReplicatedReadRepair(t *testing.T) {
+	ctx := t.Context()
+	rb, memories, _ := setupReplicationTest(t, 0)
+
+	assert.NoError(t, memories[0].Put(ctx, "a.txt", []byte("good")))
+	assert.NoError(t, memories[1].Put(ctx, "a.txt", []byte("good")))
+	assert.NoError(t, memories[2].Put(ctx, "a.txt", []byte("rotten")))
+	assert.NoError(t, memories[1].Put(ctx, "b.txt", []byte("only on b")))
+
+	data, err := rb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("good"), data)
+
+	// Missing on the first replica, served by the second one.
+	data, err = rb.Get(ctx, "b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("only on b"), data)
+
+	rb.pending.Wait()
+
+	for _, m := range memories {
+		assert.Equal(t, []byte("good"), m.Objects()["a.txt"], m.Name())
+		assert.Equal(t, []byte("only on b"), m.Objects()["b.txt"], m.Name())
+	}
+}
+
+func TestReplicatedAntiEntropy(t *testing.T) {
+	ctx := t.Context()
+	rb, memories, faulties := setupReplicationTest(t, 0)
+
+	assert.NoError(t, memories[0].Put(ctx, "dir/a.txt", []byte("a")))
+	assert.NoError(t, memories[1].Put(ctx, "dir/b.txt", []byte("b")))
+	assert.NoError(t, memories[2].Put(ctx, "dir/b.txt", []byte("b")))
+	assert.NoError(t, memories[2].Put(ctx, "other/c.txt", []byte("c")))
+
+	// Replicas that cannot be read are left alone.
+	faulties[2].FailOn("Get", errors.New("timeout"))
+	faulties[2].FailOn("Exists", errors.New("timeout"))
+
+	report, err := rb.AntiEntropy(ctx, "dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Scanned)
+	assert.Equal(t, 2, report.Repaired)
+	assert.Equal(t, 0, report.Failed)
+
+	assert.Equal(t, []byte("a"), memories[1].Objects()["dir/a.txt"])
+	assert.Equal(t, []byte("b"), memories[0].Objects()["dir/b.txt"])
+	assert.NotContains(t, memories[2].Objects(), "dir/a.txt")
+	assert.NotContains(t, memories[0].Objects(), "other/c.txt")
+
+	faulties[2].Heal()
+	report, err = rb.AntiEntropy(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Scanned)
+	assert.Equal(t, 3, report.Repaired)
+
+	paths, err := rb.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/a.txt", "dir/b.txt", "other/c.txt"}, paths)
+}
+
+func TestReplicatedMoveAndDelete(t *testing.T) {
+	ctx := t.Context()
+	rb, memories, faulties := setupReplicationTest(t, 3)
+
+	assert.NoError(t, rb.Put(ctx, "a.txt", []byte("hello")))
+	assert.NoError(t, rb.Move(ctx, "a.txt", "b.txt"))
+	for _, m := range memories {
+		assert.Equal(t, []byte("hello"), m.Objects()["b.txt"])
+	}
+
+	faulties[0].FailOn("Delete", errors.New("read-only"))
+	assert.Error(t, rb.Delete(ctx, "b.txt"))
+
+	faulties[0].Heal()
+	assert.NoError(t, rb.Delete(ctx, "b.txt"))
+
+	ok, err := rb.Exists(ctx, "b.txt")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestReplicatedReadRepairSkipsConsistentCopies(t *testing.T) {
+	ctx := t.Context()
+	rb, _, faulties := setupReplicationTest(t, 0)
+
+	assert.NoError(t, rb.Put(ctx, "a.txt", []byte("hello")))
+	_, err := rb.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	rb.pending.Wait()
+
+	// The copies were compared by checksum, not read into memory
+	assert.Equal(t, 1, faulties[0].Calls("Get"))
+	assert.Zero(t, faulties[1].Calls("Get"))
+	assert.Zero(t, faulties[2].Calls("Get"))
+	assert.Equal(t, 1, faulties[2].Calls("Put"))
+}
+
+func TestReplicatedRepairKeepsConcurrentWrites(t *testing.T) {
+	ctx := t.Context()
+	memories := []*mocks.MemoryBackend{mocks.NewMemoryBackend("a"), mocks.NewMemoryBackend("b"), mocks.NewMemoryBackend("c")}
+	assert.NoError(t, memories[0].Put(ctx, "a.txt", []byte("old")))
+	assert.NoError(t, memories[1].Put(ctx, "a.txt", []byte("old")))
+
+	// A write lands while the copies are being compared
+	var rb *ReplicatedBackend
+	written := make(chan error, 1)
+	var once sync.Once
+	hooked := &hookedBackend{FileBackend: memories[0], onGet: func(path string) {
+		once.Do(func() {
+			go func() { written <- rb.Put(ctx, path, []byte("new")) }()
+			time.Sleep(20 * time.Millisecond)
+		})
+	}}
+	rb = NewReplicatedBackend(logger.NewLogger("", true, false), 0, hooked, memories[1], memories[2])
+
+	n, err := rb.Repair(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, <-written)
+
+	for _, m := range memories {
+		assert.Equal(t, []byte("new"), m.Objects()["a.txt"], m.Name())
+	}
+}
@@ -0,0 +1,278 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+// throttleChunkSize bounds the bytes read from a throttled stream at once, so
+// large reads are spread over time instead of waiting up front.
+const throttleChunkSize = 32 * 1024
+
+// Throttle limits the bandwidth and operations of background jobs with token
+// buckets. The limits follow the time-of-day windows of the configuration,
+// which can be replaced at runtime.
+type Throttle struct {
+	mu    sync.Mutex
+	cfg   model.ThrottleConfig
+	bytes tokenBucket
+	ops   tokenBucket
+
+	now func() time.Time
+}
+
+func NewThrottle(cfg model.ThrottleConfig) (*Throttle, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Throttle{cfg: cfg, now: time.Now}, nil
+}
+
+// SetConfig replaces the limits and windows, waits in progress are not shortened.
+func (t *Throttle) SetConfig(cfg model.ThrottleConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+	return nil
+}
+
+// Config returns the limits and windows.
+func (t *Throttle) Config() model.ThrottleConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg
+}
+
+// Limits returns the limits that apply now.
+func (t *Throttle) Limits() model.ThrottleLimits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg.LimitsAt(t.now())
+}
+
+// WaitOp waits until an operation may start.
+func (t *Throttle) WaitOp(ctx context.Context) error {
+	return sleep(ctx, t.reserve(0, 1))
+}
+
+// WaitBytes waits until n bytes may be transferred.
+func (t *Throttle) WaitBytes(ctx context.Context, n int) error {
+	return sleep(ctx, t.reserve(int64(n), 0))
+}
+
+// reserve takes the bytes and operations from the buckets and returns how
+// long to wait before they are available.
+func (t *Throttle) reserve(bytes int64, ops float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	limits := t.cfg.LimitsAt(now)
+
+	var wait time.Duration
+	if bytes > 0 {
+		wait = max(wait, t.bytes.take(now, float64(limits.BytesPerSecond), float64(bytes)))
+	}
+	if ops > 0 {
+		wait = max(wait, t.ops.take(now, limits.OpsPerSecond, ops))
+	}
+	return wait
+}
+
+// tokenBucket holds up to a second worth of tokens. Takes beyond the tokens
+// left go into debt, which the following takes wait for.
+type tokenBucket struct {
+	rate   float64 // Tokens per second
+	tokens float64
+	last   time.Time
+}
+
+// take takes n tokens at the given rate and returns how long to wait before
+// they are available. A rate of zero is unlimited.
+func (b *tokenBucket) take(now time.Time, rate, n float64) time.Duration {
+	if rate <= 0 {
+		b.rate = 0
+		return 0
+	}
+
+	if b.rate != rate {
+		// New limits start with a full bucket
+		b.rate = rate
+		b.tokens = rate
+	} else {
+		b.tokens = min(rate, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// ThrottledBackend passes every call and the bytes read and written through a
+// Throttle. Operations are backend calls, bytes are waited for one chunk at a
+// time.
+type ThrottledBackend struct {
+	domain.FileBackend
+	throttle *Throttle
+}
+
+var _ domain.FileBackend = (*ThrottledBackend)(nil)
+var _ domain.StatBackend = (*ThrottledBackend)(nil)
+var _ domain.RepairableBackend = (*ThrottledBackend)(nil)
+
+func NewThrottledBackend(inner domain.FileBackend, throttle *Throttle) domain.FileBackend {
+	return &ThrottledBackend{FileBackend: inner, throttle: throttle}
+}
+
+// Put implements domain.FileBackend.
+// The object is written at once after the bytes were waited for.
+func (t *ThrottledBackend) Put(ctx context.Context, path string, data []byte) error {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return err
+	}
+	for chunk := range slices.Chunk(data, throttleChunkSize) {
+		if err := t.throttle.WaitBytes(ctx, len(chunk)); err != nil {
+			return err
+		}
+	}
+	return t.FileBackend.Put(ctx, path, data)
+}
+
+// Get implements domain.FileBackend.
+// The object is streamed, so the bytes are waited for as they are read.
+func (t *ThrottledBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	rc, err := t.Stream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Stream implements domain.FileBackend.
+func (t *ThrottledBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return nil, err
+	}
+	rc, err := t.FileBackend.Stream(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledReader{ReadCloser: rc, ctx: ctx, throttle: t.throttle}, nil
+}
+
+// List implements domain.FileBackend.
+func (t *ThrottledBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return nil, err
+	}
+	return t.FileBackend.List(ctx, prefix)
+}
+
+// Exists implements domain.FileBackend.
+func (t *ThrottledBackend) Exists(ctx context.Context, path string) (bool, error) {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return false, err
+	}
+	return t.FileBackend.Exists(ctx, path)
+}
+
+// Move implements domain.FileBackend.
+func (t *ThrottledBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return err
+	}
+	return t.FileBackend.Move(ctx, oldPath, newPath)
+}
+
+// Delete implements domain.FileBackend.
+func (t *ThrottledBackend) Delete(ctx context.Context, path string) error {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return err
+	}
+	return t.FileBackend.Delete(ctx, path)
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (t *ThrottledBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return err
+	}
+	return t.FileBackend.DeleteFolder(ctx, prefix)
+}
+
+// Stat implements domain.StatBackend.
+// Backends that cannot report metadata are read through the throttle instead.
+func (t *ThrottledBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	stater, ok := t.FileBackend.(domain.StatBackend)
+	if !ok {
+		return statByReading(ctx, t, path)
+	}
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return nil, err
+	}
+	return stater.Stat(ctx, path)
+}
+
+// RepairObject implements domain.RepairableBackend when the inner backend does,
+// the copies are read through the throttle.
+func (t *ThrottledBackend) RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error) {
+	repairer, ok := t.FileBackend.(domain.RepairableBackend)
+	if !ok {
+		return false, nil
+	}
+	if err := t.throttle.WaitOp(ctx); err != nil {
+		return false, err
+	}
+	return repairer.RepairObject(ctx, path, func(r io.Reader) bool {
+		return verify(&throttledReader{ReadCloser: io.NopCloser(r), ctx: ctx, throttle: t.throttle})
+	})
+}
+
+// throttledReader waits for the bytes it read.
+type throttledReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	throttle *Throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.throttle.WaitBytes(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleWindows(t *testing.T) {
+	night := model.ThrottleLimits{}
+	day := model.ThrottleLimits{BytesPerSecond: 1 << 20, OpsPerSecond: 10}
+	lunch := model.ThrottleLimits{BytesPerSecond: 2 << 20}
+	cfg := model.ThrottleConfig{
+		Limits: day,
+		Windows: []model.ThrottleWindow{
+			{From: "22:00", To: "06:00", Limits: night},
+			{From: "12:00", To: "13:30", Limits: lunch},
+		},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	at := func(clock string) time.Time {
+		tm, err := time.ParseInLocation("15:04", clock, time.Local)
+		assert.NoError(t, err)
+		return tm
+	}
+	for clock, want := range map[string]model.ThrottleLimits{
+		"21:59": day,
+		"22:00": night,
+		"00:00": night,
+		"05:59": night,
+		"06:00": day,
+		"12:00": lunch,
+		"13:29": lunch,
+		"13:30": day,
+	} {
+		assert.Equal(t, want, cfg.LimitsAt(at(clock)), clock)
+	}
+
+	for _, invalid := range []model.ThrottleConfig{
+		{Windows: []model.ThrottleWindow{{From: "25:00", To: "06:00"}}},
+		{Windows: []model.ThrottleWindow{{From: "22:00", To: "6"}}},
+		{Limits: model.ThrottleLimits{BytesPerSecond: -1}},
+	} {
+		assert.Error(t, invalid.Validate())
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	throttle, err := NewThrottle(model.ThrottleConfig{Limits: model.ThrottleLimits{BytesPerSecond: 100, OpsPerSecond: 2}})
+	assert.NoError(t, err)
+
+	now := time.Now()
+	throttle.now = func() time.Time { return now }
+
+	// A second worth of tokens is available at once, more has to be waited for
+	assert.Zero(t, throttle.reserve(100, 0))
+	assert.Equal(t, 500*time.Millisecond, throttle.reserve(50, 0))
+	assert.Zero(t, throttle.reserve(0, 2))
+	assert.Equal(t, 500*time.Millisecond, throttle.reserve(0, 1))
+
+	// Tokens refill over time
+	now = now.Add(2 * time.Second)
+	assert.Zero(t, throttle.reserve(100, 1))
+
+	// Limits can be changed at runtime and lifted
+	assert.NoError(t, throttle.SetConfig(model.ThrottleConfig{Limits: model.ThrottleLimits{BytesPerSecond: 10}}))
+	assert.Equal(t, time.Second, throttle.reserve(20, 0))
+	assert.Zero(t, throttle.reserve(0, 100))
+
+	assert.NoError(t, throttle.SetConfig(model.ThrottleConfig{}))
+	assert.Zero(t, throttle.reserve(1<<30, 1000))
+	assert.True(t, throttle.Limits().Unlimited())
+
+	assert.Error(t, throttle.SetConfig(model.ThrottleConfig{Limits: model.ThrottleLimits{OpsPerSecond: -1}}))
+}
+
+func TestThrottledBackend(t *testing.T) {
+	inner := mocks.NewFaultyBackend(mocks.NewMemoryBackend("inner"))
+	throttle, err := NewThrottle(model.ThrottleConfig{})
+	assert.NoError(t, err)
+	tb := NewThrottledBackend(inner, throttle)
+
+	ctx := t.Context()
+	data := make([]byte, 100*1024)
+	assert.NoError(t, tb.Put(ctx, "a.bin", data))
+
+	rc, err := tb.Stream(ctx, "a.bin")
+	assert.NoError(t, err)
+	read, err := io.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Len(t, read, len(data))
+	assert.NoError(t, rc.Close())
+
+	// Calls that would have to wait give up with the context
+	assert.NoError(t, throttle.SetConfig(model.ThrottleConfig{Limits: model.ThrottleLimits{BytesPerSecond: 1024}}))
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, tb.Put(cancelled, "b.bin", data), context.Canceled)
+	assert.Equal(t, 1, inner.Calls("Put"))
+
+	deadline, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = tb.Get(deadline, "a.bin")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestThrottledBackendChunks(t *testing.T) {
+	throttle, err := NewThrottle(model.ThrottleConfig{Limits: model.ThrottleLimits{BytesPerSecond: 4 * throttleChunkSize}})
+	assert.NoError(t, err)
+	inner := mocks.NewMemoryBackend("inner")
+	tb := NewThrottledBackend(inner, throttle)
+
+	ctx := t.Context()
+	data := make([]byte, 8*throttleChunkSize)
+	assert.NoError(t, inner.Put(ctx, "a.bin", data))
+
+	// A full bucket lets the first chunks through before the read is cut short
+	deadline, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = tb.Get(deadline, "a.bin")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Writes wait one chunk at a time and are not made once cancelled
+	assert.ErrorIs(t, tb.Put(deadline, "b.bin", data), context.DeadlineExceeded)
+	exists, err := inner.Exists(ctx, "b.bin")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestThrottledBackendForwards(t *testing.T) {
+	throttle, err := NewThrottle(model.ThrottleConfig{})
+	assert.NoError(t, err)
+	ctx := t.Context()
+
+	primary := mocks.NewMemoryBackend("primary")
+	replica := mocks.NewMemoryBackend("replica")
+	rb := NewReplicatedBackend(logger.NewLogger("", true, false), 2, primary, replica)
+	tb := NewThrottledBackend(rb, throttle)
+	assert.NoError(t, tb.Put(ctx, "a.txt", []byte("hello")))
+	assert.NoError(t, primary.Put(ctx, "a.txt", []byte("hellO")))
+
+	repaired, err := tb.(domain.RepairableBackend).RepairObject(ctx, "a.txt", func(r io.Reader) bool {
+		data, err := io.ReadAll(r)
+		return err == nil && string(data) == "hello"
+	})
+	assert.NoError(t, err)
+	assert.True(t, repaired)
+	data, err := primary.Get(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	info, err := tb.(domain.StatBackend).Stat(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+
+	// Backends without metadata are read instead, and have no copies to repair from
+	plain := NewThrottledBackend(primary, throttle)
+	info, err = plain.(domain.StatBackend).Stat(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+
+	repaired, err = plain.(domain.RepairableBackend).RepairObject(ctx, "a.txt", func(io.Reader) bool { return true })
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+}
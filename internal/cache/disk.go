@@ -0,0 +1,421 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+const (
+	diskIndexFile  = "buckt-cache-index.json"
+	diskEntryExt   = ".cache"
+	diskPendingExt = ".cache-pending"
+
+	// diskIndexFlushDelay is how long changes to the index are collected
+	// before it is saved, so a crash loses at most this much of it.
+	diskIndexFlushDelay = time.Second
+
+	// diskLowWater is the fraction of the size limit eviction frees down to,
+	// so that eviction does not run on every insert once the cache is full.
+	diskLowWater = 0.9
+)
+
+// DiskCache is a size bounded cache of byte blobs stored as files in a directory.
+// Entries are evicted by least recent or least frequent use, and the index is
+// saved shortly after it changes and on Close so the cache survives restarts.
+//
+// The directory may hold other files, the cache only ever removes files it
+// wrote itself, recognised by their names.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+	policy  model.EvictionPolicy
+
+	mu      sync.Mutex
+	entries map[string]*diskEntry
+	size    int64
+	flush   *time.Timer // Pending save of the index, nil when saved
+	closed  bool
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type diskEntry struct {
+	Key        string    `json:"key"`
+	File       string    `json:"file"`
+	Size       int64     `json:"size"`
+	LastAccess time.Time `json:"last_access"`
+	Uses       uint64    `json:"uses"`
+}
+
+// NewDiskCache opens the cache in dir, creating it if needed. The persisted
+// index is reconciled with the files on disk: entries without a file are
+// dropped and cache files without an entry are removed.
+func NewDiskCache(dir string, maxSize int64, policy model.EvictionPolicy) (*DiskCache, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("disk cache size must be positive")
+	}
+	if policy == "" {
+		policy = model.EvictLRU
+	}
+	if policy != model.EvictLRU && policy != model.EvictLFU {
+		return nil, errors.New("unknown eviction policy " + string(policy))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	dc := &DiskCache{
+		dir:     dir,
+		maxSize: maxSize,
+		policy:  policy,
+		entries: map[string]*diskEntry{},
+	}
+	if err := dc.load(); err != nil {
+		return nil, err
+	}
+
+	dc.mu.Lock()
+	dc.evict()
+	dc.mu.Unlock()
+
+	return dc, nil
+}
+
+// Get returns the cached value of key.
+func (dc *DiskCache) Get(key string) ([]byte, bool) {
+	entry, ok := dc.touch(key)
+	if !ok {
+		dc.misses.Add(1)
+		return nil, false
+	}
+
+	data, err := os.ReadFile(filepath.Join(dc.dir, entry.File))
+	if err != nil || int64(len(data)) != entry.Size {
+		dc.Remove(key)
+		dc.misses.Add(1)
+		return nil, false
+	}
+
+	dc.hits.Add(1)
+	return data, true
+}
+
+// Open returns a reader over the cached value of key. Caller must Close.
+func (dc *DiskCache) Open(key string) (io.ReadCloser, bool) {
+	entry, ok := dc.touch(key)
+	if !ok {
+		dc.misses.Add(1)
+		return nil, false
+	}
+
+	f, err := os.Open(filepath.Join(dc.dir, entry.File))
+	if err != nil {
+		dc.Remove(key)
+		dc.misses.Add(1)
+		return nil, false
+	}
+
+	dc.hits.Add(1)
+	return f, true
+}
+
+// Add stores value under key, evicting other entries if needed.
+// Values larger than the cache are not stored.
+func (dc *DiskCache) Add(key string, value []byte) error {
+	w, err := dc.Create(key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Commit()
+}
+
+// Create returns a writer that adds key to the cache once committed.
+func (dc *DiskCache) Create(key string) (*DiskCacheWriter, error) {
+	f, err := os.CreateTemp(dc.dir, "*"+diskPendingExt)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskCacheWriter{dc: dc, key: key, f: f}, nil
+}
+
+// Remove drops key from the cache.
+func (dc *DiskCache) Remove(key string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.remove(key)
+}
+
+// RemovePrefix drops every key starting with prefix from the cache.
+func (dc *DiskCache) RemovePrefix(prefix string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	for key := range dc.entries {
+		if strings.HasPrefix(key, prefix) {
+			dc.remove(key)
+		}
+	}
+}
+
+// Stats fills in the disk counters of stats.
+func (dc *DiskCache) Stats(stats *model.CacheStats) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	stats.DiskHits = dc.hits.Load()
+	stats.DiskMisses = dc.misses.Load()
+	stats.DiskEvictions = dc.evictions.Load()
+	stats.DiskEntries = len(dc.entries)
+	stats.DiskSize = dc.size
+}
+
+// Close persists the index.
+func (dc *DiskCache) Close() error {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.closed = true
+	if dc.flush != nil {
+		dc.flush.Stop()
+		dc.flush = nil
+	}
+	return dc.save()
+}
+
+// changed schedules saving the index, the caller must hold the lock.
+func (dc *DiskCache) changed() {
+	if dc.flush != nil || dc.closed {
+		return
+	}
+	dc.flush = time.AfterFunc(diskIndexFlushDelay, func() {
+		dc.mu.Lock()
+		defer dc.mu.Unlock()
+		if dc.closed {
+			return
+		}
+		dc.flush = nil
+		// A failed save is retried with the next change, or by Close.
+		dc.save()
+	})
+}
+
+// save writes the index, the caller must hold the lock.
+func (dc *DiskCache) save() error {
+	entries := make([]*diskEntry, 0, len(dc.entries))
+	for _, e := range dc.entries {
+		entries = append(entries, e)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(dc.dir, diskIndexFile+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dc.dir, diskIndexFile))
+}
+
+// DiskCacheWriter streams a value into the cache.
+type DiskCacheWriter struct {
+	dc      *DiskCache
+	key     string
+	f       *os.File
+	size    int64
+	aborted bool
+}
+
+// Write implements io.Writer. Writing past the cache size aborts the entry.
+func (w *DiskCacheWriter) Write(p []byte) (int, error) {
+	if w.aborted {
+		return len(p), nil
+	}
+	if w.size+int64(len(p)) > w.dc.maxSize {
+		w.Abort()
+		return len(p), nil
+	}
+
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		w.Abort()
+	}
+	return n, err
+}
+
+// Commit adds the written value to the cache.
+func (w *DiskCacheWriter) Commit() error {
+	if w.aborted {
+		return nil
+	}
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	dc := w.dc
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	file := fileName(w.key)
+	if err := os.Rename(w.f.Name(), filepath.Join(dc.dir, file)); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+
+	if old, ok := dc.entries[w.key]; ok {
+		dc.size -= old.Size
+	}
+	dc.entries[w.key] = &diskEntry{Key: w.key, File: file, Size: w.size, LastAccess: time.Now(), Uses: 1}
+	dc.size += w.size
+	dc.evict()
+	dc.changed()
+
+	return nil
+}
+
+// Abort discards the written value.
+func (w *DiskCacheWriter) Abort() {
+	if w.aborted {
+		return
+	}
+	w.aborted = true
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// touch looks up key and records the access.
+func (dc *DiskCache) touch(key string) (diskEntry, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	entry, ok := dc.entries[key]
+	if !ok {
+		return diskEntry{}, false
+	}
+
+	entry.LastAccess = time.Now()
+	entry.Uses++
+	return *entry, true
+}
+
+// remove drops key, the caller must hold the lock.
+func (dc *DiskCache) remove(key string) {
+	entry, ok := dc.entries[key]
+	if !ok {
+		return
+	}
+	os.Remove(filepath.Join(dc.dir, entry.File))
+	dc.size -= entry.Size
+	delete(dc.entries, key)
+	dc.changed()
+}
+
+// evict removes entries until the cache fits, the caller must hold the lock.
+func (dc *DiskCache) evict() {
+	if dc.size <= dc.maxSize {
+		return
+	}
+
+	victims := make([]*diskEntry, 0, len(dc.entries))
+	for _, e := range dc.entries {
+		victims = append(victims, e)
+	}
+	sort.Slice(victims, func(i, j int) bool {
+		a, b := victims[i], victims[j]
+		if dc.policy == model.EvictLFU && a.Uses != b.Uses {
+			return a.Uses < b.Uses
+		}
+		return a.LastAccess.Before(b.LastAccess)
+	})
+
+	target := int64(float64(dc.maxSize) * diskLowWater)
+	for _, e := range victims {
+		if dc.size <= target {
+			break
+		}
+		dc.remove(e.Key)
+		dc.evictions.Add(1)
+	}
+}
+
+// load reads the persisted index and reconciles it with the directory.
+func (dc *DiskCache) load() error {
+	data, err := os.ReadFile(filepath.Join(dc.dir, diskIndexFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var entries []*diskEntry
+	if len(data) > 0 {
+		// A corrupt index only loses the cached content.
+		if json.Unmarshal(data, &entries) != nil {
+			entries = nil
+		}
+	}
+
+	files, err := os.ReadDir(dc.dir)
+	if err != nil {
+		return err
+	}
+	onDisk := map[string]int64{}
+	for _, f := range files {
+		if info, err := f.Info(); err == nil && !f.IsDir() {
+			onDisk[f.Name()] = info.Size()
+		}
+	}
+
+	known := map[string]bool{diskIndexFile: true}
+	for _, e := range entries {
+		if size, ok := onDisk[e.File]; ok && size == e.Size && e.File == fileName(e.Key) {
+			dc.entries[e.Key] = e
+			dc.size += e.Size
+			known[e.File] = true
+		}
+	}
+
+	for name := range onDisk {
+		if !known[name] && ownFile(name) {
+			os.Remove(filepath.Join(dc.dir, name))
+		}
+	}
+	return nil
+}
+
+// ownFile reports whether name is an entry or a pending entry of a cache.
+func ownFile(name string) bool {
+	if strings.HasSuffix(name, diskPendingExt) || name == diskIndexFile+".tmp" {
+		return true
+	}
+	hash, ok := strings.CutSuffix(name, diskEntryExt)
+	if !ok || len(hash) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskEntryExt
+}
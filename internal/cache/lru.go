@@ -56,6 +56,12 @@ func (fc *FileCache) Get(key string) (value []byte, ok bool) {
 	return nil, false
 }
 
+func (fc *FileCache) Remove(key string) {
+	if fc.cache != nil {
+		fc.cache.Del(key)
+	}
+}
+
 func (fc *FileCache) Hits() uint64 {
 	return fc.hits.Load()
 }
@@ -150,5 +150,30 @@ func (db *DB) Migrate() error {
 	}
 	db.log.GetLogger().Println("✅ FileModel migrated")
 
+	if err := db.AutoMigrate(&model.RenditionModel{}); err != nil {
+		return db.log.WrapErrorf("❌ failed to migrate RenditionModel: %w", err)
+	}
+	db.log.GetLogger().Println("✅ RenditionModel migrated")
+
+	if err := db.AutoMigrate(&model.OperationModel{}); err != nil {
+		return db.log.WrapErrorf("❌ failed to migrate OperationModel: %w", err)
+	}
+	db.log.GetLogger().Println("✅ OperationModel migrated")
+
+	if err := db.AutoMigrate(&model.MigrationRunModel{}); err != nil {
+		return db.log.WrapErrorf("❌ failed to migrate MigrationRunModel: %w", err)
+	}
+	db.log.GetLogger().Println("✅ MigrationRunModel migrated")
+
+	if err := db.AutoMigrate(&model.MigrationModel{}); err != nil {
+		return db.log.WrapErrorf("❌ failed to migrate MigrationModel: %w", err)
+	}
+	db.log.GetLogger().Println("✅ MigrationModel migrated")
+
+	if err := db.AutoMigrate(&model.MigrationCutoverModel{}); err != nil {
+		return db.log.WrapErrorf("❌ failed to migrate MigrationCutoverModel: %w", err)
+	}
+	db.log.GetLogger().Println("✅ MigrationCutoverModel migrated")
+
 	return nil
 }
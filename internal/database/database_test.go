@@ -1,7 +1,9 @@
 package database_test
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"testing"
 
 	"github.com/Rhaqim/buckt/internal/database"
@@ -77,3 +79,46 @@ func TestDB_Migrate(t *testing.T) {
 	err = db.Migrate()
 	assert.NoError(t, err)
 }
+
+func TestDB_RunInTx(t *testing.T) {
+	log := logger.NewLogger("", true, false)
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	defer sqlDB.Close()
+
+	db, err := database.NewDB(sqlDB, model.SQLite, log, false)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Exec("CREATE TABLE items (name TEXT)").Error)
+
+	count := func() (n int64) {
+		db.Table("items").Count(&n)
+		return n
+	}
+
+	var committed, rolledBack bool
+	err = db.RunInTx(t.Context(), func(ctx context.Context) error {
+		assert.NoError(t, db.WithContext(ctx).Exec("INSERT INTO items VALUES ('a')").Error)
+		database.OnRollback(ctx, func(context.Context) error { rolledBack = true; return nil })
+		return database.AfterCommit(ctx, func(context.Context) error { committed = true; return nil })
+	})
+	assert.NoError(t, err)
+	assert.True(t, committed)
+	assert.False(t, rolledBack)
+	assert.EqualValues(t, 1, count())
+
+	committed = false
+	err = db.RunInTx(t.Context(), func(ctx context.Context) error {
+		assert.NoError(t, db.WithContext(ctx).Exec("INSERT INTO items VALUES ('b')").Error)
+		database.OnRollback(ctx, func(context.Context) error { rolledBack = true; return nil })
+		_ = database.AfterCommit(ctx, func(context.Context) error { committed = true; return nil })
+		return errors.New("boom")
+	})
+	assert.EqualError(t, err, "boom")
+	assert.False(t, committed)
+	assert.True(t, rolledBack)
+	assert.EqualValues(t, 1, count())
+
+	// Without a transaction the work runs right away
+	assert.NoError(t, database.AfterCommit(t.Context(), func(context.Context) error { committed = true; return nil }))
+	assert.True(t, committed)
+}
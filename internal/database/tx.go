@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// afterCommitConcurrency bounds the hooks run concurrently after a commit.
+const afterCommitConcurrency = 8
+
+type txKey struct{}
+
+// txState is the transaction carried by the context of RunInTx and the work
+// deferred until it is committed or rolled back.
+type txState struct {
+	tx *gorm.DB
+
+	mu          sync.Mutex
+	afterCommit []func(context.Context) error
+	onRollback  []func(context.Context) error
+}
+
+// WithContext returns the transaction of ctx when it is running inside
+// RunInTx, the database otherwise. Repositories use it for every query so they
+// take part in transactions without knowing about them.
+func (db *DB) WithContext(ctx context.Context) *gorm.DB {
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx.WithContext(ctx)
+	}
+	return db.DB.WithContext(ctx)
+}
+
+// Detached returns the database for queries that must not take part in the
+// transaction of ctx: they are committed right away, even when the transaction
+// is rolled back. SQLite allows a single writer and a write on another
+// connection would wait for the transaction to end, so there the queries join
+// the transaction as with WithContext.
+func (db *DB) Detached(ctx context.Context) *gorm.DB {
+	if db.Dialector.Name() == "sqlite" {
+		return db.WithContext(ctx)
+	}
+	return db.DB.WithContext(ctx)
+}
+
+// RunInTx runs fn in a transaction carried by its context. The transaction is
+// committed when fn returns nil and rolled back otherwise. Calls nested in fn
+// join the outer transaction.
+//
+// Work registered with AfterCommit runs once the transaction is committed, work
+// registered with OnRollback once it is rolled back. Their errors are logged,
+// the outcome of the transaction is already decided.
+func (db *DB) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*txState); ok {
+		return fn(ctx)
+	}
+
+	state := &txState{}
+	err := db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		state.tx = tx
+		return fn(context.WithValue(ctx, txKey{}, state))
+	})
+
+	// Hooks must not see the finished transaction
+	hooks := state.afterCommit
+	if err != nil {
+		hooks = state.onRollback
+	}
+	db.runHooks(context.WithoutCancel(ctx), hooks)
+
+	return err
+}
+
+func (db *DB) runHooks(ctx context.Context, hooks []func(context.Context) error) {
+	var g errgroup.Group
+	g.SetLimit(afterCommitConcurrency)
+
+	for _, hook := range hooks {
+		g.Go(func() error {
+			if err := hook(ctx); err != nil {
+				db.log.Errorf("transaction hook failed: %v", err)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// AfterCommit runs fn once the transaction of ctx is committed, or right away
+// when ctx carries no transaction. Use it for work that cannot be rolled back,
+// such as deleting or moving files in the backend.
+func AfterCommit(ctx context.Context, fn func(ctx context.Context) error) error {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return fn(ctx)
+	}
+
+	state.mu.Lock()
+	state.afterCommit = append(state.afterCommit, fn)
+	state.mu.Unlock()
+	return nil
+}
+
+// OnRollback runs fn if the transaction of ctx is rolled back, to undo work
+// done outside of it such as writing files to the backend. It does nothing
+// when ctx carries no transaction.
+func OnRollback(ctx context.Context, fn func(ctx context.Context) error) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	if !ok {
+		return
+	}
+
+	state.mu.Lock()
+	state.onRollback = append(state.onRollback, fn)
+	state.mu.Unlock()
+}
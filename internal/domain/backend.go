@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+
+	"github.com/Rhaqim/buckt/internal/model"
 )
 
 type FileBackend interface {
@@ -37,17 +39,55 @@ type FileBackend interface {
 	Move(ctx context.Context, oldPath, newPath string) error
 }
 
+// StatBackend is implemented by backends that can report object metadata
+// without reading the whole object.
+type StatBackend interface {
+	Stat(ctx context.Context, path string) (*model.FileInfo, error)
+}
+
+// RepairableBackend is implemented by backends that keep more than one copy
+// of every object, so a copy that is corrupt or missing can be rewritten from
+// another one.
+type RepairableBackend interface {
+	// RepairObject reads the copies of path until one passes verify and writes
+	// it over the copies that are missing or do not pass. It returns false when
+	// no copy passes.
+	RepairObject(ctx context.Context, path string, verify func(r io.Reader) bool) (bool, error)
+}
+
 type MigratableBackend interface {
 	FileBackend
 
-	// Kicks off a background migration of all existing files
+	// Copies all existing files to the target, returns once they are copied
 	MigrateAll(ctx context.Context) error
 
+	// Copies the existing files under a prefix to the target, returns once they are copied
+	Migrate(ctx context.Context, opts model.MigrateOptions) error
+
 	// Migrate a specific file (used for lazy migration on access)
 	MigrateFile(ctx context.Context, path string) error
 
 	// Progress info for observability
 	MigrationStatus(ctx context.Context) (completed int64, total int64)
+
+	// Moves the cutover to the given phase, forward only once the migration is verified
+	Cutover(ctx context.Context, phase model.CutoverPhase) error
+
+	// The cutover phase shared by all instances
+	CutoverPhase(ctx context.Context) (model.CutoverPhase, error)
+
+	// Waits for the background promotions to finish
+	Close()
+}
+
+// KeyProvider supplies the master keys used to wrap per-object data keys
+// for encryption at rest.
+type KeyProvider interface {
+	// CurrentKey returns the ID and bytes of the master key new objects are wrapped with.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+
+	// Key returns the master key with the given ID, used to unwrap existing objects.
+	Key(ctx context.Context, id string) ([]byte, error)
 }
 
 type PlaceholderBackend struct {
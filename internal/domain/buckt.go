@@ -20,6 +20,7 @@ type CacheManager interface {
 type LRUCache interface {
 	Add(key string, value []byte) (evicted bool)
 	Get(key string) (value []byte, ok bool)
+	Remove(key string)
 	Hits() uint64
 	Misses() uint64
 	Close()
@@ -2,16 +2,26 @@ package domain
 
 import (
 	"context"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/model"
 	"github.com/google/uuid"
 )
 
+// Transactor runs work in a database transaction carried by the context.
+type Transactor interface {
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 type FolderRepository interface {
 	Create(ctx context.Context, folder *model.FolderModel) (string, error)
 	GetFolder(ctx context.Context, folder_id uuid.UUID) (*model.FolderModel, error)
 	GetRootFolder(ctx context.Context, user_id string) (*model.FolderModel, error)
+	// GetFolderByPath returns the folder at the path, also when it is soft deleted.
+	GetFolderByPath(ctx context.Context, path string) (*model.FolderModel, error)
 	GetFolders(ctx context.Context, parent_id uuid.UUID) ([]model.FolderModel, error)
+	// GetFoldersPage returns up to limit folders, also soft deleted ones, ordered by ID and starting after the given one.
+	GetFoldersPage(ctx context.Context, after uuid.UUID, limit int) ([]*model.FolderModel, error)
 	MoveFolder(ctx context.Context, folder_id, new_parent_id uuid.UUID) error
 	RenameFolder(ctx context.Context, user_id string, folder_id uuid.UUID, new_name string) error
 	DeleteFolder(ctx context.Context, folder_id uuid.UUID) (parent_id string, err error)
@@ -25,7 +35,69 @@ type FileRepository interface {
 	MoveFile(ctx context.Context, file_id, new_parent_id uuid.UUID) (string, string, error)
 	RenameFile(ctx context.Context, file_id uuid.UUID, new_name string) error
 	RestoreFile(ctx context.Context, parent_id uuid.UUID, name string) (*model.FileModel, error)
+	// GetFileByName returns the file with the name in the folder, also when it is soft deleted.
+	GetFileByName(ctx context.Context, parent_id uuid.UUID, name string) (*model.FileModel, error)
+	// GetFileByPath returns the file at the path, also when it is soft deleted.
+	GetFileByPath(ctx context.Context, path string) (*model.FileModel, error)
 	Update(ctx context.Context, file *model.FileModel) error
 	DeleteFile(ctx context.Context, id uuid.UUID) error
 	ScrubFile(ctx context.Context, id uuid.UUID) error
+	GetFilesByScanStatus(ctx context.Context, status model.ScanStatus) ([]*model.FileModel, error)
+	UpdateScanStatus(ctx context.Context, id uuid.UUID, hash string, status model.ScanStatus, signature string) error
+	// GetFilesPage returns up to limit files, also soft deleted ones, ordered by ID and starting after the given one.
+	GetFilesPage(ctx context.Context, after uuid.UUID, limit int) ([]*model.FileModel, error)
+	UpdateIntegrity(ctx context.Context, id uuid.UUID, status model.IntegrityStatus) error
+	// GetFilesToVerify returns up to limit files, also soft deleted ones, not verified since before, the least recently verified first.
+	GetFilesToVerify(ctx context.Context, before time.Time, limit int) ([]*model.FileModel, error)
+	// RecordVerification stores the outcome of a verification while the file still has the verified content, identified by its hash.
+	RecordVerification(ctx context.Context, id uuid.UUID, hash, new_hash string, status model.IntegrityStatus, verified_at time.Time) error
+}
+
+type RenditionRepository interface {
+	Save(ctx context.Context, rendition *model.RenditionModel) error
+	GetRendition(ctx context.Context, file_id uuid.UUID, name string) (*model.RenditionModel, error)
+	GetRenditions(ctx context.Context, file_id uuid.UUID) ([]model.RenditionModel, error)
+	DeleteRenditions(ctx context.Context, file_id uuid.UUID) error
+	GetRenditionPaths(ctx context.Context) ([]string, error)
+}
+
+// OperationRepository stores the operation journal.
+type OperationRepository interface {
+	Create(ctx context.Context, op *model.OperationModel) error
+	GetOperations(ctx context.Context) ([]*model.OperationModel, error)
+	// GetExpired returns the operations of instances other than owner whose
+	// lease was last renewed before cutoff.
+	GetExpired(ctx context.Context, owner uuid.UUID, cutoff time.Time) ([]*model.OperationModel, error)
+	// Renew renews the lease of every operation of owner.
+	Renew(ctx context.Context, owner uuid.UUID) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// MigrationRepository stores the progress of backend migrations, one row per
+// object and run.
+type MigrationRepository interface {
+	CreateRun(ctx context.Context, run *model.MigrationRunModel) error
+	// GetActiveRun returns the latest run of the migration that has objects left to copy.
+	GetActiveRun(ctx context.Context, migration_id string) (*model.MigrationRunModel, error)
+	// GetLatestRun returns the run that was started last.
+	GetLatestRun(ctx context.Context) (*model.MigrationRunModel, error)
+	// GetLatestRunFor returns the run of the migration that was started last.
+	GetLatestRunFor(ctx context.Context, migration_id string) (*model.MigrationRunModel, error)
+	CompleteRun(ctx context.Context, run_id uuid.UUID, completed_at time.Time) error
+	// Enqueue adds the keys that are not in the run yet as queued.
+	Enqueue(ctx context.Context, run_id uuid.UUID, keys []string) error
+	// GetPending returns up to limit objects of the run under prefix that are not committed, ordered by key and starting after the given one.
+	GetPending(ctx context.Context, run_id uuid.UUID, prefix, after string, limit int) ([]*model.MigrationModel, error)
+	// CountPending returns the number of objects of the run under prefix that are not committed.
+	CountPending(ctx context.Context, run_id uuid.UUID, prefix string) (int64, error)
+	Update(ctx context.Context, migration *model.MigrationModel) error
+	// Upsert stores the object of the run, replacing the progress recorded for its key.
+	Upsert(ctx context.Context, migration *model.MigrationModel) error
+	CountByStatus(ctx context.Context, run_id uuid.UUID) (map[model.MigrationStatus]int64, error)
+
+	GetCutover(ctx context.Context, migration_id string) (*model.MigrationCutoverModel, error)
+	// SetCutoverPhase moves the cutover of the migration to phase if it
+	// is still in from, a missing cutover is in model.CutoverDualWrite. It
+	// returns false when the phase was changed by someone else.
+	SetCutoverPhase(ctx context.Context, migration_id string, from, phase model.CutoverPhase) (bool, error)
 }
@@ -3,6 +3,7 @@ package domain
 import (
 	"context"
 	"io"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/model"
 )
@@ -16,12 +17,19 @@ type FolderService interface {
 	RenameFolder(ctx context.Context, user_id, folder_id, new_name string) error
 	DeleteFolder(ctx context.Context, folder_id string) (string, error)
 	ScrubFolder(ctx context.Context, user_id, folder_id string) (string, error)
+	RecoverOperations(ctx context.Context) (int, error)
+	RetryOperations(ctx context.Context) (int, error)
 }
 
 type FileService interface {
 	CreateFile(ctx context.Context, user_id, parent_id, file_name, content_type string, file_data []byte) (string, error)
+	// CheckUpload applies the upload policy before content is read and returns the content type to store.
+	CheckUpload(ctx context.Context, user_id, parent_id, file_name, content_type string, size int64, head []byte) (string, error)
+	// ReferenceFile indexes the object at path in place, as if it was uploaded.
+	ReferenceFile(ctx context.Context, user_id, parent_id, file_name, path string) (*model.FileModel, error)
 	GetFile(ctx context.Context, file_id string) (*model.FileModel, error)
 	GetFileStream(ctx context.Context, file_id string) (*model.FileModel, io.ReadCloser, error)
+	GetFileInfo(ctx context.Context, file_id string) (*model.FileModel, error)
 	GetFiles(ctx context.Context, parent_id string) ([]model.FileModel, error)
 	GetFilesMetadata(ctx context.Context, parent_id string) ([]model.FileModel, error)
 	MoveFile(ctx context.Context, file_id, new_parent_id string) error
@@ -29,4 +37,91 @@ type FileService interface {
 	UpdateFile(ctx context.Context, user_id, file_id, new_file_name string, new_file_data []byte) error
 	DeleteFile(ctx context.Context, file_id string) (string, error)
 	ScrubFile(ctx context.Context, file_id string) (string, error)
+	RescanPending(ctx context.Context) (int, error)
+	RecoverOperations(ctx context.Context) (int, error)
+	RetryOperations(ctx context.Context) (int, error)
+	// Close waits for queued scans to finish.
+	Close()
+}
+
+type ArchiveService interface {
+	ArchiveFolder(ctx context.Context, user_id, folder_id string, w io.Writer, format model.ArchiveFormat) error
+	ArchiveFiles(ctx context.Context, user_id string, file_ids []string, w io.Writer, format model.ArchiveFormat) error
+	ExtractArchive(ctx context.Context, user_id, parent_id string, r io.Reader, conflict model.ConflictPolicy) (model.ExtractReport, error)
+}
+
+// ImportService adopts trees of objects that were not stored through Buckt.
+type ImportService interface {
+	ImportTree(ctx context.Context, user_id string, backend FileBackend, prefix, dest_folder_id string, opts model.ImportOptions) (model.ImportReport, error)
+}
+
+// ExportService moves whole instances between databases and backends.
+type ExportService interface {
+	Export(ctx context.Context, w io.Writer, opts model.ExportOptions) (model.ExportReport, error)
+	Import(ctx context.Context, r io.Reader, opts model.InstanceImportOptions) (model.InstanceImportReport, error)
+}
+
+type BatchService interface {
+	BatchDelete(ctx context.Context, user_id string, ids []string, opts model.BatchOptions) ([]model.BatchResult, error)
+	BatchScrub(ctx context.Context, user_id string, ids []string, opts model.BatchOptions) ([]model.BatchResult, error)
+	BatchMove(ctx context.Context, user_id string, ids []string, new_parent_id string, opts model.BatchOptions) ([]model.BatchResult, error)
+	BatchUpload(ctx context.Context, user_id, parent_id string, files []model.BatchUpload, opts model.BatchOptions) ([]model.BatchResult, error)
+}
+
+// IntegrityService verifies stored content against its checksum.
+type IntegrityService interface {
+	VerifyFile(ctx context.Context, file_id string) (model.VerifyResult, error)
+	// VerifyFiles verifies up to limit files not verified within max_age.
+	VerifyFiles(ctx context.Context, max_age time.Duration, limit int) (model.VerifyReport, error)
+	// RebaselineFile records the checksum of the stored content of a file as
+	// its hash, for files whose hash is unverified.
+	RebaselineFile(ctx context.Context, file_id string) (model.VerifyResult, error)
+}
+
+// MetadataService keeps the metadata sidecars of files and folders in the
+// backend and indexes them again when the database is lost.
+type MetadataService interface {
+	WriteFileMetadata(ctx context.Context, file *model.FileModel, parent *model.FolderModel) error
+	DeleteFileMetadata(ctx context.Context, path string) error
+	WriteFolderMetadata(ctx context.Context, folder *model.FolderModel) error
+	DeleteFolderMetadata(ctx context.Context, folder_id string) error
+	// RebuildIndex recreates the folders and files described by the sidecars in the backend.
+	RebuildIndex(ctx context.Context, opts model.RebuildOptions) (model.RebuildReport, error)
+}
+
+// ReconcileService finds objects without files and files without objects.
+type ReconcileService interface {
+	Reconcile(ctx context.Context, opts model.ReconcileOptions) (model.ReconcileReport, error)
+}
+
+type RenditionService interface {
+	// Enqueue generates the renditions of an uploaded file in the background.
+	Enqueue(file *model.FileModel, data []byte)
+	GetRendition(ctx context.Context, file_id, name string) (*model.RenditionModel, error)
+	DeleteRenditions(ctx context.Context, file *model.FileModel) error
+	// Close waits for queued renditions to be generated.
+	Close()
+}
+
+// MigrationService runs a migration in the background and lets it be paused,
+// resumed, cancelled and followed.
+type MigrationService interface {
+	Start(prefix string, opts model.MigrationOptions) error
+	Pause() error
+	Resume() error
+	Cancel() error
+	Progress() model.MigrationProgress
+	// Subscribe returns a channel receiving the events of migrations and a
+	// function that ends the subscription.
+	Subscribe() (<-chan model.MigrationEvent, func())
+	// Close cancels the running migration and waits for it and the
+	// background promotions to stop.
+	Close()
+}
+
+// Scanner checks file content for malware before it can be downloaded.
+type Scanner interface {
+	// Scan reads r to the end and reports whether it contains a threat.
+	// An error means the content could not be scanned, not that it is infected.
+	Scan(ctx context.Context, r io.Reader) (model.ScanResult, error)
 }
@@ -8,4 +8,36 @@ var (
 	ErrInvalidUUID    = errors.New("invalid UUID")
 	ErrFileNotFound   = errors.New("file not found")
 	ErrFolderNotFound = errors.New("folder not found")
+
+	ErrRenditionNotFound = errors.New("rendition not found")
+
+	// Upload policy violations
+	ErrFileTooLarge        = errors.New("file too large")
+	ErrExtensionNotAllowed = errors.New("file extension not allowed")
+	ErrMIMETypeNotAllowed  = errors.New("content type not allowed")
+	ErrMIMETypeMismatch    = errors.New("content type does not match file content")
+
+	// Archive extraction
+	ErrUnsupportedArchive   = errors.New("unsupported archive format")
+	ErrArchiveLimitExceeded = errors.New("archive exceeds the extraction limits")
+
+	// Imports
+	ErrImportReference = errors.New("objects can only be referenced in place in the backend of the client")
+	ErrInvalidExport   = errors.New("archive is not a supported instance export")
+
+	// Batch operations
+	ErrBatchRolledBack = errors.New("batch was rolled back")
+
+	// Background migrations
+	ErrMigrationRunning    = errors.New("a migration is already running")
+	ErrMigrationNotRunning = errors.New("no migration is running")
+	ErrMigrationNotPaused  = errors.New("the migration is not paused")
+
+	// Migration cutover
+	ErrInvalidCutover     = errors.New("invalid cutover phase")
+	ErrCutoverNotVerified = errors.New("migration is not verified for the cutover")
+
+	// Antivirus scanning
+	ErrFileInfected    = errors.New("file is infected")
+	ErrFileScanPending = errors.New("file has not been scanned yet")
 )
@@ -5,6 +5,7 @@ import (
 	"io"
 
 	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
 )
 
 type Backend struct {
@@ -69,6 +70,11 @@ func (m *MigrationBackend) MigrateAll(ctx context.Context) error {
 	return nil
 }
 
+// Migrate implements domain.MigratableBackend.
+func (m *MigrationBackend) Migrate(ctx context.Context, opts model.MigrateOptions) error {
+	return nil
+}
+
 // MigrateFile implements domain.MigratableBackend.
 func (m *MigrationBackend) MigrateFile(ctx context.Context, path string) error {
 	return nil
@@ -78,3 +84,16 @@ func (m *MigrationBackend) MigrateFile(ctx context.Context, path string) error {
 func (m *MigrationBackend) MigrationStatus(ctx context.Context) (completed int64, total int64) {
 	return 0, 0
 }
+
+// Cutover implements domain.MigratableBackend.
+func (m *MigrationBackend) Cutover(ctx context.Context, phase model.CutoverPhase) error {
+	return nil
+}
+
+// CutoverPhase implements domain.MigratableBackend.
+func (m *MigrationBackend) CutoverPhase(ctx context.Context) (model.CutoverPhase, error) {
+	return model.CutoverDualWrite, nil
+}
+
+// Close implements domain.MigratableBackend.
+func (m *MigrationBackend) Close() {}
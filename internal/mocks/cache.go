@@ -50,5 +50,6 @@ func NewNoopLRUCache() domain.LRUCache {
 func (m *NoopLRUCache) Close()                            {}
 func (M *NoopLRUCache) Get(key string) ([]byte, bool)     { return nil, false }
 func (M *NoopLRUCache) Add(key string, value []byte) bool { return true }
+func (M *NoopLRUCache) Remove(key string)                 {}
 func (M *NoopLRUCache) Hits() uint64                      { return 0 }
 func (M *NoopLRUCache) Misses() uint64                    { return 0 }
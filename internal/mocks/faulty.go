@@ -0,0 +1,127 @@
+package mocks
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+)
+
+// FaultyBackend wraps a domain.FileBackend and fails selected operations on demand.
+// Operations are identified by their method name, e.g. "Put" or "Delete".
+type FaultyBackend struct {
+	domain.FileBackend
+
+	mu       sync.Mutex
+	failures map[string]error
+	calls    map[string]int
+}
+
+var _ domain.FileBackend = (*FaultyBackend)(nil)
+
+func NewFaultyBackend(inner domain.FileBackend) *FaultyBackend {
+	return &FaultyBackend{
+		FileBackend: inner,
+		failures:    map[string]error{},
+		calls:       map[string]int{},
+	}
+}
+
+// FailOn makes every call to op return err until Heal is called.
+func (f *FaultyBackend) FailOn(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[op] = err
+}
+
+// Heal stops injecting failures for the given operations, or all of them when none are given.
+func (f *FaultyBackend) Heal(ops ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(ops) == 0 {
+		f.failures = map[string]error{}
+		return
+	}
+	for _, op := range ops {
+		delete(f.failures, op)
+	}
+}
+
+// Calls returns how many times op has been called.
+func (f *FaultyBackend) Calls(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+func (f *FaultyBackend) fault(op string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[op]++
+	return f.failures[op]
+}
+
+// Put implements domain.FileBackend.
+func (f *FaultyBackend) Put(ctx context.Context, path string, data []byte) error {
+	if err := f.fault("Put"); err != nil {
+		return err
+	}
+	return f.FileBackend.Put(ctx, path, data)
+}
+
+// Get implements domain.FileBackend.
+func (f *FaultyBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	if err := f.fault("Get"); err != nil {
+		return nil, err
+	}
+	return f.FileBackend.Get(ctx, path)
+}
+
+// List implements domain.FileBackend.
+func (f *FaultyBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := f.fault("List"); err != nil {
+		return nil, err
+	}
+	return f.FileBackend.List(ctx, prefix)
+}
+
+// Stream implements domain.FileBackend.
+func (f *FaultyBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := f.fault("Stream"); err != nil {
+		return nil, err
+	}
+	return f.FileBackend.Stream(ctx, path)
+}
+
+// Delete implements domain.FileBackend.
+func (f *FaultyBackend) Delete(ctx context.Context, path string) error {
+	if err := f.fault("Delete"); err != nil {
+		return err
+	}
+	return f.FileBackend.Delete(ctx, path)
+}
+
+// Exists implements domain.FileBackend.
+func (f *FaultyBackend) Exists(ctx context.Context, path string) (bool, error) {
+	if err := f.fault("Exists"); err != nil {
+		return false, err
+	}
+	return f.FileBackend.Exists(ctx, path)
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (f *FaultyBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	if err := f.fault("DeleteFolder"); err != nil {
+		return err
+	}
+	return f.FileBackend.DeleteFolder(ctx, prefix)
+}
+
+// Move implements domain.FileBackend.
+func (f *FaultyBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	if err := f.fault("Move"); err != nil {
+		return err
+	}
+	return f.FileBackend.Move(ctx, oldPath, newPath)
+}
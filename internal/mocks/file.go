@@ -21,6 +21,23 @@ func (m *FileService) CreateFile(ctx context.Context, user_id, parent_id, file_n
 	return args.String(0), args.Error(1)
 }
 
+// CheckUpload implements domain.FileService.
+func (m *FileService) CheckUpload(ctx context.Context, user_id, parent_id, file_name, content_type string, size int64, head []byte) (string, error) {
+	args := m.Called(user_id, parent_id, file_name, content_type, size, head)
+	return args.String(0), args.Error(1)
+}
+
+// ReferenceFile implements domain.FileService.
+func (m *FileService) ReferenceFile(ctx context.Context, user_id, parent_id, file_name, path string) (*model.FileModel, error) {
+	args := m.Called(user_id, parent_id, file_name, path)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*model.FileModel), args.Error(1)
+}
+
 func (m *FileService) GetFilesMetadata(ctx context.Context, parent_id string) ([]model.FileModel, error) {
 	args := m.Called(parent_id)
 	return args.Get(0).([]model.FileModel), args.Error(1)
@@ -37,6 +54,17 @@ func (m *FileService) GetFile(ctx context.Context, file_id string) (*model.FileM
 	return args.Get(0).(*model.FileModel), args.Error(1)
 }
 
+// GetFileInfo implements domain.FileService.
+func (m *FileService) GetFileInfo(ctx context.Context, file_id string) (*model.FileModel, error) {
+	args := m.Called(file_id)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*model.FileModel), args.Error(1)
+}
+
 func (m *FileService) GetFileStream(ctx context.Context, file_id string) (*model.FileModel, io.ReadCloser, error) {
 	args := m.Called(file_id)
 
@@ -82,3 +110,24 @@ func (m *FileService) ScrubFile(ctx context.Context, file_id string) (string, er
 	args := m.Called(file_id)
 	return args.String(0), args.Error(1)
 }
+
+// RescanPending implements domain.FileService.
+func (m *FileService) RescanPending(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+// RecoverOperations implements domain.FileService.
+func (m *FileService) RecoverOperations(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+// RetryOperations implements domain.FileService.
+func (m *FileService) RetryOperations(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+// Close implements domain.FileService.
+func (m *FileService) Close() {}
@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"context"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/domain"
 	"github.com/Rhaqim/buckt/internal/model"
@@ -48,6 +49,28 @@ func (m *FileRepository) RestoreFile(ctx context.Context, parent_id uuid.UUID, n
 	return args.Get(0).(*model.FileModel), args.Error(1)
 }
 
+// GetFileByPath implements domain.FileRepository.
+func (m *FileRepository) GetFileByPath(ctx context.Context, path string) (*model.FileModel, error) {
+	args := m.Called(path)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*model.FileModel), args.Error(1)
+}
+
+// GetFileByName implements domain.FileRepository.
+func (m *FileRepository) GetFileByName(ctx context.Context, parent_id uuid.UUID, name string) (*model.FileModel, error) {
+	args := m.Called(parent_id, name)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*model.FileModel), args.Error(1)
+}
+
 func (m *FileRepository) GetFiles(ctx context.Context, parentID uuid.UUID) ([]*model.FileModel, error) {
 	args := m.Called(parentID)
 	return args.Get(0).([]*model.FileModel), args.Error(1)
@@ -67,3 +90,33 @@ func (m *FileRepository) ScrubFile(ctx context.Context, fileID uuid.UUID) error
 	args := m.Called(fileID)
 	return args.Error(0)
 }
+
+func (m *FileRepository) GetFilesByScanStatus(ctx context.Context, status model.ScanStatus) ([]*model.FileModel, error) {
+	args := m.Called(status)
+	return args.Get(0).([]*model.FileModel), args.Error(1)
+}
+
+func (m *FileRepository) UpdateScanStatus(ctx context.Context, fileID uuid.UUID, hash string, status model.ScanStatus, signature string) error {
+	args := m.Called(fileID, hash, status, signature)
+	return args.Error(0)
+}
+
+func (m *FileRepository) GetFilesPage(ctx context.Context, after uuid.UUID, limit int) ([]*model.FileModel, error) {
+	args := m.Called(after, limit)
+	return args.Get(0).([]*model.FileModel), args.Error(1)
+}
+
+func (m *FileRepository) UpdateIntegrity(ctx context.Context, fileID uuid.UUID, status model.IntegrityStatus) error {
+	args := m.Called(fileID, status)
+	return args.Error(0)
+}
+
+func (m *FileRepository) GetFilesToVerify(ctx context.Context, before time.Time, limit int) ([]*model.FileModel, error) {
+	args := m.Called(before, limit)
+	return args.Get(0).([]*model.FileModel), args.Error(1)
+}
+
+func (m *FileRepository) RecordVerification(ctx context.Context, fileID uuid.UUID, hash, newHash string, status model.IntegrityStatus, verifiedAt time.Time) error {
+	args := m.Called(fileID, hash, newHash, status)
+	return args.Error(0)
+}
@@ -66,3 +66,15 @@ func (m *FolderService) ScrubFolder(ctx context.Context, user_id string, folder_
 	args := m.Called(user_id, folder_id)
 	return args.String(0), args.Error(1)
 }
+
+// RecoverOperations implements domain.FolderService.
+func (m *FolderService) RecoverOperations(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+// RetryOperations implements domain.FolderService.
+func (m *FolderService) RetryOperations(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
@@ -24,6 +24,22 @@ func (m *FolderRepository) GetRootFolder(ctx context.Context, user_id string) (*
 	return args.Get(0).(*model.FolderModel), args.Error(1)
 }
 
+// GetFolderByPath implements domain.FolderRepository.
+func (m *FolderRepository) GetFolderByPath(ctx context.Context, path string) (*model.FolderModel, error) {
+	args := m.Called(path)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*model.FolderModel), args.Error(1)
+}
+
+func (m *FolderRepository) GetFoldersPage(ctx context.Context, after uuid.UUID, limit int) ([]*model.FolderModel, error) {
+	args := m.Called(after, limit)
+	return args.Get(0).([]*model.FolderModel), args.Error(1)
+}
+
 func (m *FolderRepository) Create(ctx context.Context, folder *model.FolderModel) (string, error) {
 	args := m.Called(folder)
 	return args.Get(0).(string), args.Error(1)
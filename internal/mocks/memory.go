@@ -0,0 +1,129 @@
+package mocks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+)
+
+// MemoryBackend is an in-memory domain.FileBackend for tests.
+type MemoryBackend struct {
+	NameVal string
+
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+var _ domain.FileBackend = (*MemoryBackend)(nil)
+
+func NewMemoryBackend(name string) *MemoryBackend {
+	return &MemoryBackend{NameVal: name, objects: map[string][]byte{}}
+}
+
+// Objects returns a copy of the stored objects keyed by path.
+func (m *MemoryBackend) Objects() map[string][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := make(map[string][]byte, len(m.objects))
+	for k, v := range m.objects {
+		out[k] = append([]byte(nil), v...)
+	}
+	return out
+}
+
+// Name implements domain.FileBackend.
+func (m *MemoryBackend) Name() string {
+	return m.NameVal
+}
+
+// Put implements domain.FileBackend.
+func (m *MemoryBackend) Put(ctx context.Context, path string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[path] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get implements domain.FileBackend.
+func (m *MemoryBackend) Get(ctx context.Context, path string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.objects[path]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", path, fs.ErrNotExist)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// List implements domain.FileBackend.
+func (m *MemoryBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var paths []string
+	for p := range m.objects {
+		if strings.HasPrefix(p, prefix) {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Stream implements domain.FileBackend.
+func (m *MemoryBackend) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, err := m.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Delete implements domain.FileBackend.
+func (m *MemoryBackend) Delete(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, path)
+	return nil
+}
+
+// Exists implements domain.FileBackend.
+func (m *MemoryBackend) Exists(ctx context.Context, path string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.objects[path]
+	return ok, nil
+}
+
+// DeleteFolder implements domain.FileBackend.
+func (m *MemoryBackend) DeleteFolder(ctx context.Context, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	for p := range m.objects {
+		if strings.HasPrefix(p, prefix) {
+			delete(m.objects, p)
+		}
+	}
+	return nil
+}
+
+// Move implements domain.FileBackend.
+func (m *MemoryBackend) Move(ctx context.Context, oldPath string, newPath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[oldPath]
+	if !ok {
+		return fmt.Errorf("%s: %w", oldPath, fs.ErrNotExist)
+	}
+	m.objects[newPath] = data
+	delete(m.objects, oldPath)
+	return nil
+}
@@ -0,0 +1,42 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type OperationRepository struct {
+	mock.Mock
+}
+
+var _ domain.OperationRepository = (*OperationRepository)(nil)
+
+func (m *OperationRepository) Create(ctx context.Context, op *model.OperationModel) error {
+	args := m.Called(op)
+	return args.Error(0)
+}
+
+func (m *OperationRepository) GetOperations(ctx context.Context) ([]*model.OperationModel, error) {
+	args := m.Called()
+	return args.Get(0).([]*model.OperationModel), args.Error(1)
+}
+
+func (m *OperationRepository) GetExpired(ctx context.Context, owner uuid.UUID, cutoff time.Time) ([]*model.OperationModel, error) {
+	args := m.Called(owner, cutoff)
+	return args.Get(0).([]*model.OperationModel), args.Error(1)
+}
+
+func (m *OperationRepository) Renew(ctx context.Context, owner uuid.UUID) error {
+	args := m.Called(owner)
+	return args.Error(0)
+}
+
+func (m *OperationRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
@@ -0,0 +1,46 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+)
+
+type RenditionRepository struct {
+	mock.Mock
+}
+
+var _ domain.RenditionRepository = (*RenditionRepository)(nil)
+
+func (m *RenditionRepository) Save(ctx context.Context, rendition *model.RenditionModel) error {
+	args := m.Called(rendition)
+	return args.Error(0)
+}
+
+func (m *RenditionRepository) GetRendition(ctx context.Context, fileID uuid.UUID, name string) (*model.RenditionModel, error) {
+	args := m.Called(fileID, name)
+
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+
+	return args.Get(0).(*model.RenditionModel), args.Error(1)
+}
+
+func (m *RenditionRepository) GetRenditions(ctx context.Context, fileID uuid.UUID) ([]model.RenditionModel, error) {
+	args := m.Called(fileID)
+	return args.Get(0).([]model.RenditionModel), args.Error(1)
+}
+
+func (m *RenditionRepository) DeleteRenditions(ctx context.Context, fileID uuid.UUID) error {
+	args := m.Called(fileID)
+	return args.Error(0)
+}
+
+func (m *RenditionRepository) GetRenditionPaths(ctx context.Context) ([]string, error) {
+	args := m.Called()
+	return args.Get(0).([]string), args.Error(1)
+}
@@ -0,0 +1,95 @@
+package model
+
+// ArchiveFormat is the format of a folder or file selection download.
+type ArchiveFormat string
+
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ContentType returns the MIME type of the format.
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// ConflictPolicy decides what happens to an extracted file when a file with
+// the same name already exists in the folder.
+type ConflictPolicy string
+
+const (
+	// ConflictSkip keeps the existing file and leaves the entry out.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the content of the existing file.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictRename stores the entry next to the existing file as "name (n).ext".
+	ConflictRename ConflictPolicy = "rename"
+)
+
+// ExtractLimits bound the archives that can be extracted, to protect against
+// archive bombs. Zero values use the defaults.
+type ExtractLimits struct {
+	// MaxTotalSize is the total number of bytes extracted, defaults to 1GB.
+	MaxTotalSize int64
+	// MaxEntries is the number of files and folders in the archive, defaults to 10000.
+	MaxEntries int
+	// MaxRatio is the largest compression ratio of an entry, defaults to 100.
+	MaxRatio float64
+}
+
+// WithDefaults returns the limits with zero values replaced by the defaults.
+func (l ExtractLimits) WithDefaults() ExtractLimits {
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = 1 << 30
+	}
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = 10000
+	}
+	if l.MaxRatio <= 0 {
+		l.MaxRatio = 100
+	}
+	return l
+}
+
+// ExtractAction is what happened to an archive entry or imported object.
+type ExtractAction string
+
+const (
+	ExtractCreated     ExtractAction = "created"
+	ExtractOverwritten ExtractAction = "overwritten"
+	ExtractRenamed     ExtractAction = "renamed"
+	ExtractExisting    ExtractAction = "existing"   // folders that already existed
+	ExtractUnchanged   ExtractAction = "unchanged"  // imported files whose content is already stored
+	ExtractReferenced  ExtractAction = "referenced" // imported files indexed where they are stored
+	ExtractSkipped     ExtractAction = "skipped"
+)
+
+// ExtractEntry reports the outcome of a single archive entry.
+type ExtractEntry struct {
+	Path   string        `json:"path"`           // Path of the entry in the archive
+	ID     string        `json:"id,omitempty"`   // ID of the file or folder created or updated
+	Name   string        `json:"name,omitempty"` // Name the entry was stored under, when renamed
+	IsDir  bool          `json:"is_dir"`
+	Action ExtractAction `json:"action"`
+	Reason string        `json:"reason,omitempty"` // Why the entry was skipped
+}
+
+// ExtractReport is the outcome of extracting an archive.
+type ExtractReport struct {
+	Entries []ExtractEntry `json:"entries"`
+	Bytes   int64          `json:"bytes"` // Bytes extracted
+}
+
+// Count returns the number of entries with the given action.
+func (r ExtractReport) Count(action ExtractAction) int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Action == action {
+			n++
+		}
+	}
+	return n
+}
@@ -8,3 +8,62 @@ type FileInfo struct {
 	ETag         string
 	ContentType  string
 }
+
+// ReplicationReport summarises an anti-entropy pass over replicated backends.
+type ReplicationReport struct {
+	// Scanned is the number of distinct objects found across all replicas.
+	Scanned int
+	// Repaired is the number of replica copies that were missing or divergent and rewritten.
+	Repaired int
+	// Failed is the number of objects that could not be reconciled.
+	Failed int
+}
+
+// HealReport summarises a heal pass over erasure-coded storage.
+type HealReport struct {
+	// Scanned is the number of objects checked.
+	Scanned int
+	// Healed is the number of objects that had shards rebuilt.
+	Healed int
+	// Shards is the number of shards that were rebuilt.
+	Shards int
+	// Unrecoverable lists objects with fewer shards left than needed to rebuild them.
+	Unrecoverable []string
+}
+
+// DiskHealth reports the state of a single disk used by erasure-coded storage.
+type DiskHealth struct {
+	Path     string
+	Online   bool
+	Writable bool
+	// Shards is the number of shards stored on the disk.
+	Shards int
+	Error  string
+}
+
+// EvictionPolicy selects which entries a bounded cache evicts first.
+type EvictionPolicy string
+
+const (
+	// EvictLRU evicts the least recently used entries first.
+	EvictLRU EvictionPolicy = "lru"
+	// EvictLFU evicts the least frequently used entries first.
+	EvictLFU EvictionPolicy = "lfu"
+)
+
+// CacheStats reports the hit and miss counters of the backend caches.
+type CacheStats struct {
+	// L1Hits and L1Misses count lookups in the in-memory cache.
+	L1Hits   uint64
+	L1Misses uint64
+
+	// DiskHits and DiskMisses count lookups in the on-disk cache.
+	DiskHits   uint64
+	DiskMisses uint64
+
+	// DiskEvictions counts entries evicted from the on-disk cache.
+	DiskEvictions uint64
+	// DiskEntries and DiskSize describe the current content of the on-disk cache.
+	DiskEntries int
+	DiskSize    int64
+}
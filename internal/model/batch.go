@@ -0,0 +1,38 @@
+package model
+
+// BatchOptions configures a batch operation.
+type BatchOptions struct {
+	// Atomic runs the batch in a single transaction: either every item is
+	// applied or none is. Items are then processed one after the other.
+	Atomic bool
+	// Concurrency bounds the items processed at once, defaults to 8.
+	Concurrency int
+}
+
+// BatchItemType is the kind of item a batch result is about.
+type BatchItemType string
+
+const (
+	BatchFile   BatchItemType = "file"
+	BatchFolder BatchItemType = "folder"
+)
+
+// BatchResult is the outcome of a single item of a batch.
+type BatchResult struct {
+	ID    string        `json:"id,omitempty"`   // ID of the item, of the created file for uploads
+	Name  string        `json:"name,omitempty"` // Name of the uploaded file
+	Type  BatchItemType `json:"type,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// OK reports whether the item was applied.
+func (r BatchResult) OK() bool {
+	return r.Error == ""
+}
+
+// BatchUpload is a file of a batch upload.
+type BatchUpload struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExportVersion is the format version of instance exports.
+const ExportVersion = 1
+
+// ExportOptions configure an export of a whole instance.
+type ExportOptions struct {
+	// ManifestOnly leaves the blobs out, for moving the index to another
+	// database while the objects stay in the same backend.
+	ManifestOnly bool
+	// Progress is called after every file written to the export.
+	Progress func(ExportProgress)
+}
+
+// InstanceImportOptions configure an import of an instance export.
+type InstanceImportOptions struct {
+	// RemapIDs gives the imported folders and files new IDs instead of the
+	// ones they were exported with, so an export can be imported next to the
+	// instance it was taken from.
+	RemapIDs bool
+	// MaxFileSize is the largest blob restored, blobs are read into memory
+	// to be verified before they are stored. Defaults to 1GB.
+	MaxFileSize int64
+	// Progress is called after every file restored from the export.
+	Progress func(ExportProgress)
+}
+
+// ExportProgress reports how far an export or import got.
+type ExportProgress struct {
+	Files      int   `json:"files"` // Files handled so far
+	TotalFiles int   `json:"total_files"`
+	Bytes      int64 `json:"bytes"` // Blob bytes handled so far
+}
+
+// ExportManifest describes the content of an instance export. It is the first
+// entry of the archive, followed by the blobs of the files unless the export
+// is manifest only.
+type ExportManifest struct {
+	Version      int              `json:"version"`
+	CreatedAt    time.Time        `json:"created_at"`
+	ManifestOnly bool             `json:"manifest_only"`
+	Users        []string         `json:"users"`   // IDs of the users owning folders
+	Folders      []FolderMetadata `json:"folders"` // Parents before their children
+	Files        []ExportFile     `json:"files"`
+}
+
+// ExportFile is a file in an instance export.
+type ExportFile struct {
+	FileMetadata
+	ScanStatus ScanStatus `json:"scan_status,omitempty"`
+	Signature  string     `json:"signature,omitempty"`
+	// Blob is the archive entry holding the content, empty for manifest only exports.
+	Blob string `json:"blob,omitempty"`
+}
+
+// ExportReport summarises an export.
+type ExportReport struct {
+	Users   int   `json:"users"`
+	Folders int   `json:"folders"`
+	Files   int   `json:"files"`
+	Bytes   int64 `json:"bytes"` // Blob bytes written
+}
+
+// ExportConflict is a folder or file of an export that could not be imported.
+type ExportConflict struct {
+	ID     uuid.UUID `json:"id"`
+	Path   string    `json:"path"`
+	Reason string    `json:"reason"`
+}
+
+// InstanceImportReport summarises an import of an instance export.
+type InstanceImportReport struct {
+	FoldersCreated int `json:"folders_created"`
+	// FoldersExisting are folders whose path was already indexed, their
+	// content is imported into the existing folder.
+	FoldersExisting int              `json:"folders_existing"`
+	FilesCreated    int              `json:"files_created"`
+	Bytes           int64            `json:"bytes"` // Blob bytes stored
+	Conflicts       []ExportConflict `json:"conflicts,omitempty"`
+	// IDs maps the exported IDs to the IDs they were imported as, for the
+	// folders and files that got a different one.
+	IDs map[uuid.UUID]uuid.UUID `json:"ids,omitempty"`
+}
@@ -8,21 +8,29 @@ import (
 )
 
 type FileModel struct {
-	ID          uuid.UUID      `gorm:"type:uuid;primaryKey" json:"id"`                                       // File ID
-	Name        string         `gorm:"not null;uniqueIndex:idx_file_parent_name" json:"name"`                // File name
-	Path        string         `gorm:"not null;unique" json:"path"`                                          // File path
-	ContentType string         `gorm:"not null" json:"content_type"`                                         // MIME type (e.g., image/png, application/pdf)
-	Size        int64          `gorm:"not null" json:"size"`                                                 // File size in bytes
-	ParentID    uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_file_parent_name" json:"parent_id"` // Foreign key to FolderModel
-	Hash        string         `gorm:"not null;index" json:"hash"`                                           // Hash of the file for integrity checks and uniqueness
-	Data        []byte         `gorm:"-" json:"data"`                                                        // File data
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+	ID          uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`                                            // File ID
+	Name        string           `gorm:"not null;uniqueIndex:idx_file_parent_name" json:"name"`                     // File name
+	Path        string           `gorm:"not null;unique" json:"path"`                                               // File path
+	ContentType string           `gorm:"not null" json:"content_type"`                                              // MIME type (e.g., image/png, application/pdf)
+	Size        int64            `gorm:"not null" json:"size"`                                                      // File size in bytes
+	ParentID    uuid.UUID        `gorm:"type:uuid;not null;uniqueIndex:idx_file_parent_name" json:"parent_id"`      // Foreign key to FolderModel
+	Hash        string           `gorm:"not null;index" json:"hash"`                                                // SHA-256 checksum of the content
+	ScanStatus  ScanStatus       `gorm:"index" json:"scan_status,omitempty"`                                        // Antivirus scan status
+	Signature   string           `json:"signature,omitempty"`                                                       // Threat detected by the scanner
+	Integrity   IntegrityStatus  `gorm:"index" json:"integrity,omitempty"`                                          // State of the stored content
+	VerifiedAt  *time.Time       `gorm:"index" json:"verified_at,omitempty"`                                        // Last time the content matched Hash
+	Data        []byte           `gorm:"-" json:"data"`                                                             // File data
+	Renditions  []RenditionModel `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE" json:"renditions,omitempty"` // Resized versions of images
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt   `gorm:"index" json:"deleted_at"`
 }
 
-// BeforeCreate hook for FileModel to add a prefixed UUID
+// BeforeCreate hook for FileModel to add a prefixed UUID, unless the ID was
+// assigned up front to journal the creation.
 func (file *FileModel) BeforeCreate(tx *gorm.DB) (err error) {
-	file.ID = uuid.New()
+	if file.ID == uuid.Nil {
+		file.ID = uuid.New()
+	}
 	return
 }
@@ -21,8 +21,11 @@ type FolderModel struct {
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
 
-// BeforeCreate hook for FolderModel to add a prefixed UUID
+// BeforeCreate hook for FolderModel to add a prefixed UUID, unless the ID was
+// assigned up front to restore the folder from its sidecar.
 func (folder *FolderModel) BeforeCreate(tx *gorm.DB) (err error) {
-	folder.ID = uuid.New()
+	if folder.ID == uuid.Nil {
+		folder.ID = uuid.New()
+	}
 	return
 }
@@ -0,0 +1,30 @@
+package model
+
+// ImportMode decides how the objects of an imported tree are stored.
+type ImportMode string
+
+const (
+	// ImportCopy stores a copy of every object through the regular upload path.
+	ImportCopy ImportMode = "copy"
+	// ImportReference indexes objects already in the backend of the client where they are.
+	ImportReference ImportMode = "reference"
+)
+
+// ImportOptions configure an import of an existing tree of objects.
+type ImportOptions struct {
+	Mode ImportMode // ImportCopy when empty
+	// Conflict decides what happens to an object whose name is taken by a
+	// different file, ConflictSkip when empty. Files with the same content
+	// are never imported twice.
+	Conflict ConflictPolicy
+	// After resumes an interrupted import after the given key, see ImportReport.LastKey.
+	After string
+}
+
+// ImportReport is the outcome of importing a tree.
+type ImportReport struct {
+	ExtractReport
+	// LastKey is the last object key that was handled, objects are handled in
+	// key order.
+	LastKey string `json:"last_key,omitempty"`
+}
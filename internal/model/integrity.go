@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IntegrityStatus is the state of the stored content of a file.
+type IntegrityStatus string
+
+const (
+	// IntegrityUnknown marks files whose content has not been verified yet.
+	IntegrityUnknown IntegrityStatus = ""
+	// IntegrityOK marks files whose content matched their checksum when last verified.
+	IntegrityOK IntegrityStatus = "ok"
+	// IntegrityCorrupt marks files whose content no longer matches their checksum.
+	IntegrityCorrupt IntegrityStatus = "corrupt"
+	// IntegrityMissing marks files whose object is gone from the backend.
+	IntegrityMissing IntegrityStatus = "missing"
+	// IntegrityUnverified marks files uploaded before checksums covered the
+	// content whose content does not match their recorded hash. The hash may be
+	// stale rather than the content corrupt, so they keep it until they are
+	// re-baselined explicitly.
+	IntegrityUnverified IntegrityStatus = "unverified"
+)
+
+// VerifyResult is the outcome of verifying the content of a file.
+type VerifyResult struct {
+	FileID     uuid.UUID       `json:"file_id"`
+	Status     IntegrityStatus `json:"status"`
+	Expected   string          `json:"expected"`         // Checksum recorded for the file
+	Actual     string          `json:"actual,omitempty"` // Checksum of the stored content
+	Repaired   bool            `json:"repaired"`         // Whether the content was restored from another copy
+	Migrated   bool            `json:"migrated"`         // Whether a checksum of an older format was replaced
+	VerifiedAt time.Time       `json:"verified_at"`
+}
+
+// VerifyReport summarises a pass of the integrity checker.
+type VerifyReport struct {
+	Verified   int `json:"verified"`
+	OK         int `json:"ok"`
+	Corrupt    int `json:"corrupt"`
+	Missing    int `json:"missing"`
+	Unverified int `json:"unverified"`
+	Repaired   int `json:"repaired"`
+	Failed     int `json:"failed"`
+}
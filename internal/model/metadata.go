@@ -0,0 +1,66 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MetadataVersion is the format version of the metadata sidecars.
+const MetadataVersion = 1
+
+// FileMetadata is the sidecar written for the object of a file, so the
+// file can be indexed again when the database is lost.
+type FileMetadata struct {
+	Version     int        `json:"version"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Path        string     `json:"path"`
+	ParentID    uuid.UUID  `json:"parent_id"`
+	ParentPath  string     `json:"parent_path"`
+	Owner       string     `json:"owner"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Hash        string     `json:"hash"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// FolderMetadata is the sidecar of a folder. Folders have no object, their
+// sidecars are kept under a common prefix keyed by folder ID.
+type FolderMetadata struct {
+	Version     int        `json:"version"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Path        string     `json:"path"`
+	ParentID    *uuid.UUID `json:"parent_id,omitempty"`
+	Owner       string     `json:"owner"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+// RebuildOptions configure a rebuild of the index from the metadata sidecars.
+type RebuildOptions struct {
+	DryRun bool // Report what would be indexed without writing anything
+}
+
+// RebuildConflict is a sidecar that could not be indexed.
+type RebuildConflict struct {
+	Sidecar string    `json:"sidecar"`
+	ID      uuid.UUID `json:"id"`
+	Path    string    `json:"path"`
+	Reason  string    `json:"reason"`
+}
+
+// RebuildReport summarises a rebuild of the index.
+type RebuildReport struct {
+	DryRun         bool              `json:"dry_run"`
+	Sidecars       int               `json:"sidecars"`
+	FoldersCreated int               `json:"folders_created"`
+	FilesCreated   int               `json:"files_created"`
+	Indexed        int               `json:"indexed"` // Files and folders that were already indexed
+	Conflicts      []RebuildConflict `json:"conflicts,omitempty"`
+}
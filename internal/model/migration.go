@@ -1,19 +1,162 @@
 package model
 
 import (
+	"context"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-type MigrationBackend string
+// MigrationConfig tunes how existing objects are copied from the source to the
+// target backend of a migration. Zero values use the defaults.
+type MigrationConfig struct {
+	// ID identifies the migration in the database, so the runs and cutover
+	// phases of migrations sharing it are kept apart. Every instance running
+	// the migration must use the same ID. Defaults to "<source>-><target>"
+	// with the names of the backends, set it when another migration between
+	// backends of the same names shares the database.
+	ID string
+	// Concurrency is the number of objects copied at once, defaults to 8.
+	Concurrency int
+	// Retries is how often a failed copy is retried, defaults to 3.
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled for every next
+	// one, defaults to 500ms.
+	RetryBackoff time.Duration
+	// DeleteSource deletes objects from the source once their copy in the
+	// target is verified.
+	DeleteSource bool
+	// Lazy serves reads from the target and promotes objects that are only in
+	// the source to the target when they are read, so MigrateAll is left with
+	// the objects nobody reads.
+	Lazy bool
+	// LazySync promotes objects before the read returns instead of in the
+	// background.
+	LazySync bool
+	// CutoverRefresh is how often the cutover phase is reloaded from the
+	// database to pick up transitions made by other instances, defaults to 10s.
+	// Instances can be a phase apart for that long after a transition, which
+	// is safe as every phase before CutoverDecommissioned writes both backends.
+	CutoverRefresh time.Duration
+}
+
+// WithDefaults returns the configuration with zero values replaced by the defaults.
+func (c MigrationConfig) WithDefaults() MigrationConfig {
+	if c.Concurrency <= 0 {
+		c.Concurrency = 8
+	}
+	if c.Retries <= 0 {
+		c.Retries = 3
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 500 * time.Millisecond
+	}
+	if c.CutoverRefresh <= 0 {
+		c.CutoverRefresh = 10 * time.Second
+	}
+	return c
+}
+
+// MigrateOptions steer a single run of the migration backend.
+type MigrateOptions struct {
+	// Prefix limits the run to the objects under it. Runs limited to a prefix
+	// do not complete the migration.
+	Prefix string
+	// Concurrency overrides MigrationConfig.Concurrency when set.
+	Concurrency int
+	// Wait is called before every object is copied and blocks while the run
+	// is paused. An error stops the run.
+	Wait func(ctx context.Context) error
+	// Queued is called with the number of objects left to copy once they are queued.
+	Queued func(total int64)
+	// Progress is called after every object, with the bytes copied or the
+	// error it failed with. Objects cut short by a cancellation are not
+	// reported, they stay queued.
+	Progress func(path string, size int64, err error)
+}
+
+// MigrationOptions configure a migration started in the background.
+type MigrationOptions struct {
+	// Concurrency overrides MigrationConfig.Concurrency when set.
+	Concurrency int
+}
+
+// MigrationState is the state of a migration started in the background.
+type MigrationState string
 
 const (
-	MigrationBackendLocal MigrationBackend = "local"
-	MigrationBackendS3    MigrationBackend = "s3"
+	MigrationIdle      MigrationState = "idle"
+	MigrationRunning   MigrationState = "running"
+	MigrationPaused    MigrationState = "paused"
+	MigrationCompleted MigrationState = "completed"
+	MigrationFailed    MigrationState = "failed"
+	MigrationCancelled MigrationState = "cancelled"
 )
 
+// Done reports whether the migration stopped.
+func (s MigrationState) Done() bool {
+	return s == MigrationCompleted || s == MigrationFailed || s == MigrationCancelled
+}
+
+// MigrationProgress reports how far a migration started in the background got.
+type MigrationProgress struct {
+	State     MigrationState `json:"state"`
+	Prefix    string         `json:"prefix"`
+	Total     int64          `json:"total"`     // Objects to copy
+	Completed int64          `json:"completed"` // Objects copied or found in the target
+	Failed    int64          `json:"failed"`    // Objects that failed, they are retried by the next migration
+	Bytes     int64          `json:"bytes"`     // Bytes copied
+	// Throughput is the number of bytes copied per second while running.
+	Throughput float64 `json:"throughput"`
+	// ETA is the estimated time left while running, zero when unknown.
+	ETA       time.Duration `json:"eta"`
+	StartedAt time.Time     `json:"started_at"`
+	Error     string        `json:"error,omitempty"` // Why the migration failed
+}
+
+// MigrationEvent is sent to subscribers whenever the progress of a migration
+// started in the background changes.
+type MigrationEvent struct {
+	Progress MigrationProgress `json:"progress"`
+	// Path is the object the event is about, empty for changes of the state.
+	Path string `json:"path,omitempty"`
+	// Error is why the object failed to migrate.
+	Error string `json:"error,omitempty"`
+}
+
+// MigrationRunStatus is the state of a migration run.
+type MigrationRunStatus string
+
+const (
+	// MigrationRunActive marks runs that have objects left to copy, they are
+	// resumed by the next MigrateAll.
+	MigrationRunActive MigrationRunStatus = "active"
+	// MigrationRunCompleted marks runs that copied every object.
+	MigrationRunCompleted MigrationRunStatus = "completed"
+)
+
+// MigrationRunModel is a migration of all objects from a source to a target backend.
+type MigrationRunModel struct {
+	ID          uuid.UUID          `gorm:"type:uuid;primaryKey" json:"id"`
+	MigrationID string             `gorm:"not null;index" json:"migration_id"` // MigrationConfig.ID of the migration
+	Source      string             `gorm:"not null" json:"source"`             // Name of the source backend
+	Target      string             `gorm:"not null" json:"target"`             // Name of the target backend
+	Status      MigrationRunStatus `gorm:"not null;index" json:"status"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// BeforeCreate hook for MigrationRunModel to add a UUID
+func (run *MigrationRunModel) BeforeCreate(tx *gorm.DB) (err error) {
+	if run.ID == uuid.Nil {
+		run.ID = uuid.New()
+	}
+	return
+}
+
+// MigrationStatus is the state of an object in a migration run.
 type MigrationStatus string
 
 const (
@@ -24,22 +167,76 @@ const (
 	MigrationStatusFailed    MigrationStatus = "failed"
 )
 
+// MigrationModel is the progress of a single object in a migration run.
 type MigrationModel struct {
-	ID             uuid.UUID        `gorm:"type:uuid;primaryKey" json:"id"`
-	FileID         uuid.UUID        `gorm:"type:uuid" json:"file_id"`
-	Backend        MigrationBackend `gorm:"backend" json:"backend"`
-	ObjectKey      string           `gorm:"object_key" json:"object_key"`
-	Size           int64            `gorm:"size" json:"size"`
-	ChecksumSHA256 string           `gorm:"checksum_sha256" json:"checksum_sha256"`
-	Status         MigrationStatus  `gorm:"status" json:"status"`
-	Version        int              `gorm:"version" json:"version"`
-	CreatedAt      time.Time        `gorm:"created_at" json:"created_at"`
-	UpdatedAt      time.Time        `gorm:"updated_at" json:"updated_at"`
-	DeletedAt      gorm.DeletedAt   `gorm:"index" json:"deleted_at"`
-}
-
-// BeforeCreate hook for MigrationModel to add a prefixed UUID
+	ID             uuid.UUID       `gorm:"type:uuid;primaryKey" json:"id"`
+	RunID          uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex:idx_migration_run_key" json:"run_id"` // Foreign key to MigrationRunModel
+	ObjectKey      string          `gorm:"not null;uniqueIndex:idx_migration_run_key" json:"object_key"`
+	Size           int64           `json:"size"`
+	ChecksumSHA256 string          `json:"checksum_sha256"` // Checksum of the copied content
+	Status         MigrationStatus `gorm:"not null;index" json:"status"`
+	Attempts       int             `json:"attempts"`
+	VerifyFailures int             `json:"verify_failures"`                        // Copies that did not match the source
+	Promoted       bool            `gorm:"not null;default:false" json:"promoted"` // Copied when it was read instead of by MigrateAll
+	LastError      string          `gorm:"type:text" json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// BeforeCreate hook for MigrationModel to add a UUID
 func (migration *MigrationModel) BeforeCreate(tx *gorm.DB) (err error) {
-	migration.ID = uuid.New()
+	if migration.ID == uuid.Nil {
+		migration.ID = uuid.New()
+	}
+	return
+}
+
+// CutoverPhase is how far a migration switched from the source to the target
+// backend. Phases are passed in order, every phase before
+// CutoverDecommissioned can be rolled back to an earlier one.
+type CutoverPhase string
+
+const (
+	// CutoverDualWrite writes to both backends and reads from the source. It
+	// is the phase every migration starts in.
+	CutoverDualWrite CutoverPhase = "dual_write"
+	// CutoverReadTarget writes to both backends and reads from the target,
+	// falling back on the source.
+	CutoverReadTarget CutoverPhase = "read_target"
+	// CutoverTargetOnly reads the target only. Writes, deletes and moves still
+	// apply to the source so a rollback finds it up to date.
+	CutoverTargetOnly CutoverPhase = "target_only"
+	// CutoverDecommissioned no longer touches the source, it cannot be rolled back.
+	CutoverDecommissioned CutoverPhase = "decommissioned"
+)
+
+// CutoverPhases are the cutover phases in the order they are passed.
+var CutoverPhases = []CutoverPhase{CutoverDualWrite, CutoverReadTarget, CutoverTargetOnly, CutoverDecommissioned}
+
+// Order returns the position of the phase in CutoverPhases, -1 for unknown phases.
+func (p CutoverPhase) Order() int {
+	for i, phase := range CutoverPhases {
+		if phase == p {
+			return i
+		}
+	}
+	return -1
+}
+
+// MigrationCutoverModel is the cutover phase of a migration, shared by all
+// instances using the database.
+type MigrationCutoverModel struct {
+	ID          uuid.UUID    `gorm:"type:uuid;primaryKey" json:"id"`
+	MigrationID string       `gorm:"not null;uniqueIndex" json:"migration_id"` // MigrationConfig.ID of the migration
+	Phase       CutoverPhase `gorm:"not null" json:"phase"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// BeforeCreate hook for MigrationCutoverModel to add a UUID
+func (cutover *MigrationCutoverModel) BeforeCreate(tx *gorm.DB) (err error) {
+	if cutover.ID == uuid.Nil {
+		cutover.ID = uuid.New()
+	}
 	return
 }
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OperationType is a mutation that touches both the database and the backend.
+type OperationType string
+
+const (
+	// OperationCreateFile writes a new object, then inserts its row.
+	OperationCreateFile OperationType = "create_file"
+	// OperationReplaceFile writes new content to a staging object, updates the
+	// row, then moves the staging object into place.
+	OperationReplaceFile OperationType = "replace_file"
+	// OperationScrubFile deletes the row, then the object.
+	OperationScrubFile OperationType = "scrub_file"
+	// OperationMoveFile updates the row, then moves the object from OldPath to Path.
+	OperationMoveFile OperationType = "move_file"
+	// OperationScrubFolder deletes the row, then the objects under Path.
+	OperationScrubFolder OperationType = "scrub_folder"
+	// OperationDeleteFolder trashes the row, then writes the sidecar of the folder.
+	OperationDeleteFolder OperationType = "delete_folder"
+)
+
+// OperationModel is an entry of the operation journal. It is recorded before
+// the first step of a mutation and deleted once the last one has run, so the
+// entries left after a crash are the mutations to complete or roll back.
+//
+// Entries are leased by the instance recording them, which renews HeartbeatAt
+// while it runs. Instances sharing the database only recover the entries whose
+// lease has expired, so they never roll back a mutation still running elsewhere.
+type OperationModel struct {
+	ID          uuid.UUID     `gorm:"type:uuid;primaryKey" json:"id"`
+	Type        OperationType `gorm:"not null" json:"type"`
+	FileID      uuid.UUID     `gorm:"type:uuid;index" json:"file_id"`
+	FolderID    uuid.UUID     `gorm:"type:uuid" json:"folder_id,omitempty"` // Set instead of FileID for folder operations
+	Path        string        `json:"path"`                                 // Object written or deleted
+	StagingPath string        `json:"staging_path,omitempty"`               // Object holding new content until it is moved to Path
+	OldPath     string        `json:"old_path,omitempty"`                   // Object of the previous content, deleted once replaced or moved
+	Hash        string        `json:"hash,omitempty"`                       // Hash of the file once the operation is applied
+	Owner       uuid.UUID     `gorm:"type:uuid;index" json:"owner"`         // Instance running the operation
+	HeartbeatAt time.Time     `gorm:"index" json:"heartbeat_at"`            // Last renewal of the lease of Owner
+	CreatedAt   time.Time     `json:"created_at"`
+}
+
+// BeforeCreate hook for OperationModel to add a UUID
+func (op *OperationModel) BeforeCreate(tx *gorm.DB) (err error) {
+	if op.ID == uuid.Nil {
+		op.ID = uuid.New()
+	}
+	return
+}
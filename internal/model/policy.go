@@ -0,0 +1,43 @@
+package model
+
+// MIMEMismatchAction decides what happens when the declared content type of an
+// upload does not match the content type sniffed from its first bytes.
+type MIMEMismatchAction string
+
+const (
+	// MIMEMismatchIgnore keeps the declared content type.
+	MIMEMismatchIgnore MIMEMismatchAction = ""
+	// MIMEMismatchReject rejects the upload.
+	MIMEMismatchReject MIMEMismatchAction = "reject"
+	// MIMEMismatchCorrect replaces the declared content type with the sniffed one.
+	MIMEMismatchCorrect MIMEMismatchAction = "correct"
+)
+
+// UploadPolicy restricts which files can be uploaded.
+// Zero values mean no restriction.
+type UploadPolicy struct {
+	// MaxSize is the maximum size of a file in bytes.
+	MaxSize int64
+	// MaxSizePerUser overrides MaxSize for the given user IDs, it can be
+	// larger or smaller than MaxSize.
+	MaxSizePerUser map[string]int64
+	// MaxSizePerFolder overrides MaxSize for files created directly in the
+	// given folder IDs. When a user and a folder limit both apply the smaller
+	// one is used.
+	MaxSizePerFolder map[string]int64
+
+	// AllowedExtensions lists the only extensions that can be uploaded, e.g. ".png".
+	AllowedExtensions []string
+	// DeniedExtensions lists extensions that cannot be uploaded.
+	DeniedExtensions []string
+
+	// AllowedMIMETypes lists the only content types that can be uploaded.
+	// Entries ending in "/*" match a whole top level type, e.g. "image/*".
+	AllowedMIMETypes []string
+	// DeniedMIMETypes lists content types that cannot be uploaded.
+	DeniedMIMETypes []string
+
+	// OnMIMEMismatch decides what happens when the declared content type
+	// does not match the one sniffed from the file content.
+	OnMIMEMismatch MIMEMismatchAction
+}
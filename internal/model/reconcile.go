@@ -0,0 +1,63 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultGracePeriod is how old a stray object must be before it is deleted,
+// so objects of uploads still in flight are left alone.
+const DefaultGracePeriod = 24 * time.Hour
+
+// ReconcileOptions select what a reconciliation does besides reporting.
+type ReconcileOptions struct {
+	// DeleteStray deletes objects no file refers to once they are older than GracePeriod.
+	DeleteStray bool `json:"delete_stray"`
+	// GracePeriod is how old a stray object must be to be deleted, DefaultGracePeriod when 0.
+	GracePeriod time.Duration `json:"grace_period"`
+	// MarkMissing marks files whose object is gone as IntegrityMissing.
+	MarkMissing bool `json:"mark_missing"`
+}
+
+// WithDefaults returns the options with unset values replaced by their defaults.
+func (o ReconcileOptions) WithDefaults() ReconcileOptions {
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = DefaultGracePeriod
+	}
+	return o
+}
+
+// StrayObject is an object in the backend no file or rendition refers to.
+type StrayObject struct {
+	Path         string    `json:"path"`
+	Size         int64     `json:"size,omitempty"`
+	LastModified time.Time `json:"last_modified,omitzero"`
+	Deleted      bool      `json:"deleted"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// MissingFile is a file whose object is gone from the backend.
+type MissingFile struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	Path   string    `json:"path"`
+	Marked bool      `json:"marked"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// ReconcileReport is the outcome of comparing the backend with the file table.
+type ReconcileReport struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+
+	// Objects and Files are the number of objects and files compared.
+	Objects int `json:"objects"`
+	Files   int `json:"files"`
+
+	Stray   []StrayObject `json:"stray"`
+	Missing []MissingFile `json:"missing"`
+
+	// Found is the number of files marked missing whose object is back.
+	Found int `json:"found"`
+}
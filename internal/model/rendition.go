@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RenditionSize configures a rendition generated for every uploaded image.
+type RenditionSize struct {
+	Name  string // Name used to request the rendition, e.g. "thumb"
+	Width uint   // Maximum width in pixels, the aspect ratio is kept
+}
+
+type RenditionModel struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	FileID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_rendition_file_name" json:"file_id"` // Foreign key to FileModel
+	Name        string    `gorm:"not null;uniqueIndex:idx_rendition_file_name" json:"name"`              // Rendition name, e.g. thumb
+	Path        string    `gorm:"not null" json:"path"`                                                  // Path in the backend
+	Hash        string    `gorm:"not null" json:"hash"`                                                  // Hash of the original the rendition was generated from
+	ContentType string    `gorm:"not null" json:"content_type"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Size        int64     `json:"size"`
+	Data        []byte    `gorm:"-" json:"data"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// BeforeCreate hook for RenditionModel to add a UUID
+func (rendition *RenditionModel) BeforeCreate(tx *gorm.DB) (err error) {
+	rendition.ID = uuid.New()
+	return
+}
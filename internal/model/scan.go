@@ -0,0 +1,32 @@
+package model
+
+// ScanStatus is the antivirus scan state of a file.
+type ScanStatus string
+
+const (
+	// ScanStatusNone marks files stored without a scanner configured.
+	ScanStatusNone ScanStatus = ""
+	// ScanStatusPending marks files waiting for an asynchronous scan, they cannot be downloaded yet.
+	ScanStatusPending ScanStatus = "pending"
+	// ScanStatusClean marks files the scanner found nothing in.
+	ScanStatusClean ScanStatus = "clean"
+	// ScanStatusInfected marks quarantined files, they are kept but cannot be downloaded.
+	ScanStatusInfected ScanStatus = "infected"
+)
+
+// ScanMode decides when uploads are scanned.
+type ScanMode string
+
+const (
+	// ScanSync scans uploads before they are stored and rejects infected files.
+	ScanSync ScanMode = "sync"
+	// ScanAsync stores uploads as pending and scans them in the background,
+	// infected files are quarantined.
+	ScanAsync ScanMode = "async"
+)
+
+// ScanResult is the verdict of a scanner.
+type ScanResult struct {
+	Infected  bool
+	Signature string // Name of the detected threat
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// ThrottleLimits bound the bandwidth and backend operations of background
+// jobs. Zero values are unlimited.
+type ThrottleLimits struct {
+	BytesPerSecond int64   `json:"bytes_per_second"`
+	OpsPerSecond   float64 `json:"ops_per_second"`
+}
+
+// Unlimited reports whether neither the bandwidth nor the operations are limited.
+func (l ThrottleLimits) Unlimited() bool {
+	return l.BytesPerSecond <= 0 && l.OpsPerSecond <= 0
+}
+
+// ThrottleWindow replaces the limits during a time of day.
+type ThrottleWindow struct {
+	// From and To are local times of day as "15:04". Windows where To is not
+	// after From wrap around midnight, "22:00" to "06:00" is the night.
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Limits ThrottleLimits `json:"limits"`
+}
+
+// Contains reports whether the time of day of t is in the window, From
+// included and To excluded. The window must be valid.
+func (w ThrottleWindow) Contains(t time.Time) bool {
+	from, _ := parseTimeOfDay(w.From)
+	to, _ := parseTimeOfDay(w.To)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if from < to {
+		return from <= now && now < to
+	}
+	return now >= from || now < to
+}
+
+// ThrottleConfig limits background jobs, such as migrations, integrity
+// checks, reconciliation and exports, so they leave bandwidth for everything
+// else.
+type ThrottleConfig struct {
+	Limits ThrottleLimits `json:"limits"`
+	// Windows replace Limits during times of day, the first window containing
+	// the current time applies.
+	Windows []ThrottleWindow `json:"windows,omitempty"`
+}
+
+// Validate checks the limits and times of day.
+func (c ThrottleConfig) Validate() error {
+	limits := []ThrottleLimits{c.Limits}
+	for i, w := range c.Windows {
+		if _, err := parseTimeOfDay(w.From); err != nil {
+			return fmt.Errorf("window %d: invalid from: %w", i, err)
+		}
+		if _, err := parseTimeOfDay(w.To); err != nil {
+			return fmt.Errorf("window %d: invalid to: %w", i, err)
+		}
+		limits = append(limits, w.Limits)
+	}
+
+	for _, l := range limits {
+		if l.BytesPerSecond < 0 || l.OpsPerSecond < 0 {
+			return fmt.Errorf("limits cannot be negative")
+		}
+	}
+	return nil
+}
+
+// LimitsAt returns the limits that apply at t.
+func (c ThrottleConfig) LimitsAt(t time.Time) ThrottleLimits {
+	for _, w := range c.Windows {
+		if w.Contains(t) {
+			return w.Limits
+		}
+	}
+	return c.Limits
+}
+
+// parseTimeOfDay parses "15:04" into the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
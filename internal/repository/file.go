@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/Rhaqim/buckt/internal/database"
 	"github.com/Rhaqim/buckt/internal/domain"
@@ -22,29 +23,43 @@ func NewFileRepository(db *database.DB) domain.FileRepository {
 // Create implements domain.FileRepository.
 // Subtle: this method shadows the method (*DB).Create of FileRepository.DB.WithContext(ctx).
 func (f *FileRepository) Create(ctx context.Context, file *model.FileModel) error {
-	return f.db.DB.WithContext(ctx).Create(file).Error
+	return f.db.WithContext(ctx).Create(file).Error
 }
 
 // RestoreFileByPath implements domain.FileRepository.
 // if it already exists, overwrite it and set the deleted_at to nil
 func (f *FileRepository) RestoreFile(ctx context.Context, parent_id uuid.UUID, name string) (*model.FileModel, error) {
 	var file model.FileModel
-	err := f.db.DB.WithContext(ctx).Unscoped().Model(&model.FileModel{}).Where("parent_id = ? AND name = ?", parent_id, name).Update("deleted_at", nil).Scan(&file).Error
+	err := f.db.WithContext(ctx).Unscoped().Model(&model.FileModel{}).Where("parent_id = ? AND name = ?", parent_id, name).Update("deleted_at", nil).Scan(&file).Error
 
 	return &file, err
 }
 
+// GetFileByName implements domain.FileRepository.
+func (f *FileRepository) GetFileByName(ctx context.Context, parent_id uuid.UUID, name string) (*model.FileModel, error) {
+	var file model.FileModel
+	err := f.db.WithContext(ctx).Unscoped().Where("parent_id = ? AND name = ?", parent_id, name).First(&file).Error
+	return &file, err
+}
+
+// GetFileByPath implements domain.FileRepository.
+func (f *FileRepository) GetFileByPath(ctx context.Context, path string) (*model.FileModel, error) {
+	var file model.FileModel
+	err := f.db.WithContext(ctx).Unscoped().Where("path = ?", path).First(&file).Error
+	return &file, err
+}
+
 // GetFile implements domain.FileRepository.
 func (f *FileRepository) GetFile(ctx context.Context, id uuid.UUID) (*model.FileModel, error) {
 	var file model.FileModel
-	err := f.db.DB.WithContext(ctx).First(&file, id).Error
+	err := f.db.WithContext(ctx).First(&file, id).Error
 	return &file, err
 }
 
 // GetFiles implements domain.FileRepository.
 func (f *FileRepository) GetFiles(ctx context.Context, parent_id uuid.UUID) ([]*model.FileModel, error) {
 	var files []*model.FileModel
-	err := f.db.DB.WithContext(ctx).Where("parent_id = ?", parent_id).Find(&files).Error
+	err := f.db.WithContext(ctx).Where("parent_id = ?", parent_id).Find(&files).Error
 	return files, err
 }
 
@@ -52,7 +67,7 @@ func (f *FileRepository) GetFiles(ctx context.Context, parent_id uuid.UUID) ([]*
 func (f *FileRepository) MoveFile(ctx context.Context, file_id uuid.UUID, new_parent_id uuid.UUID) (string, string, error) { // TODO: MOdify function to accept file_id, new_parent_id, and new_name
 	var newParentFolder model.FolderModel
 
-	if err := f.db.DB.WithContext(ctx).Where("id = ?", new_parent_id).First(&newParentFolder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Where("id = ?", new_parent_id).First(&newParentFolder).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return "", "", fmt.Errorf("parent folder not found")
 		}
@@ -60,7 +75,7 @@ func (f *FileRepository) MoveFile(ctx context.Context, file_id uuid.UUID, new_pa
 	}
 
 	var file model.FileModel
-	if err := f.db.DB.WithContext(ctx).First(&file, file_id).Error; err != nil {
+	if err := f.db.WithContext(ctx).First(&file, file_id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return "", "", fmt.Errorf("file not found")
 		}
@@ -72,7 +87,7 @@ func (f *FileRepository) MoveFile(ctx context.Context, file_id uuid.UUID, new_pa
 	file.ParentID = new_parent_id
 	file.Path = newParentFolder.Path + "/" + file.Name
 
-	if err := f.db.DB.WithContext(ctx).Save(&file).Error; err != nil {
+	if err := f.db.WithContext(ctx).Save(&file).Error; err != nil {
 		return "", "", err
 	}
 
@@ -82,7 +97,7 @@ func (f *FileRepository) MoveFile(ctx context.Context, file_id uuid.UUID, new_pa
 // RenameFile implements domain.FileRepository.
 func (f *FileRepository) RenameFile(ctx context.Context, file_id uuid.UUID, new_name string) error {
 	var file model.FileModel
-	if err := f.db.DB.WithContext(ctx).First(&file, file_id).Error; err != nil {
+	if err := f.db.WithContext(ctx).First(&file, file_id).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return fmt.Errorf("file not found")
 		}
@@ -91,21 +106,63 @@ func (f *FileRepository) RenameFile(ctx context.Context, file_id uuid.UUID, new_
 
 	file.Name = new_name
 
-	return f.db.DB.WithContext(ctx).Save(&file).Error
+	return f.db.WithContext(ctx).Save(&file).Error
 }
 
 // Update implements domain.FileRepository.
 // Subtle: this method shadows the method (*DB).Update of FileRepository.DB.WithContext(ctx).
 func (f *FileRepository) Update(ctx context.Context, file *model.FileModel) error {
-	return f.db.DB.WithContext(ctx).Save(file).Error
+	return f.db.WithContext(ctx).Save(file).Error
 }
 
 // DeleteFile implements domain.FileRepository.
 func (f *FileRepository) DeleteFile(ctx context.Context, id uuid.UUID) error {
-	return f.db.DB.WithContext(ctx).Delete(&model.FileModel{}, id).Error
+	return f.db.WithContext(ctx).Delete(&model.FileModel{}, id).Error
 }
 
 // ScrubFile implements domain.FileRepository.
 func (f *FileRepository) ScrubFile(ctx context.Context, id uuid.UUID) error {
-	return f.db.DB.WithContext(ctx).Unscoped().Delete(&model.FileModel{}, id).Error
+	return f.db.WithContext(ctx).Unscoped().Delete(&model.FileModel{}, id).Error
+}
+
+// GetFilesByScanStatus implements domain.FileRepository.
+func (f *FileRepository) GetFilesByScanStatus(ctx context.Context, status model.ScanStatus) ([]*model.FileModel, error) {
+	var files []*model.FileModel
+	err := f.db.WithContext(ctx).Where("scan_status = ?", status).Find(&files).Error
+	return files, err
+}
+
+// UpdateScanStatus implements domain.FileRepository.
+// The status is only recorded while the file still has the scanned content, identified by its hash.
+func (f *FileRepository) UpdateScanStatus(ctx context.Context, id uuid.UUID, hash string, status model.ScanStatus, signature string) error {
+	return f.db.WithContext(ctx).Model(&model.FileModel{}).Where("id = ? AND hash = ?", id, hash).
+		Updates(map[string]any{"scan_status": status, "signature": signature}).Error
+}
+
+// GetFilesPage implements domain.FileRepository.
+func (f *FileRepository) GetFilesPage(ctx context.Context, after uuid.UUID, limit int) ([]*model.FileModel, error) {
+	var files []*model.FileModel
+	err := f.db.WithContext(ctx).Unscoped().Where("id > ?", after).Order("id").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// UpdateIntegrity implements domain.FileRepository.
+func (f *FileRepository) UpdateIntegrity(ctx context.Context, id uuid.UUID, status model.IntegrityStatus) error {
+	return f.db.WithContext(ctx).Unscoped().Model(&model.FileModel{}).Where("id = ?", id).
+		Update("integrity", status).Error
+}
+
+// GetFilesToVerify implements domain.FileRepository.
+func (f *FileRepository) GetFilesToVerify(ctx context.Context, before time.Time, limit int) ([]*model.FileModel, error) {
+	var files []*model.FileModel
+	err := f.db.WithContext(ctx).Unscoped().
+		Where("verified_at IS NULL OR verified_at < ?", before).
+		Order("verified_at IS NOT NULL, verified_at").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// RecordVerification implements domain.FileRepository.
+func (f *FileRepository) RecordVerification(ctx context.Context, id uuid.UUID, hash, new_hash string, status model.IntegrityStatus, verified_at time.Time) error {
+	return f.db.WithContext(ctx).Unscoped().Model(&model.FileModel{}).Where("id = ? AND hash = ?", id, hash).
+		Updates(map[string]any{"hash": new_hash, "integrity": status, "verified_at": verified_at}).Error
 }
@@ -23,7 +23,7 @@ func NewFolderRepository(db *database.DB) domain.FolderRepository {
 // Create implements domain.FolderRepository.
 // Subtle: this method shadows the method (*DB).Create of FolderRepository.DB.WithContext(ctx).
 func (f *FolderRepository) Create(ctx context.Context, folder *model.FolderModel) (string, error) {
-	if err := f.db.DB.WithContext(ctx).Create(folder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Create(folder).Error; err != nil {
 		return "", err
 	}
 	return folder.ID.String(), nil
@@ -32,7 +32,7 @@ func (f *FolderRepository) Create(ctx context.Context, folder *model.FolderModel
 // GetFolder implements domain.FolderRepository.
 func (f *FolderRepository) GetFolder(ctx context.Context, folder_id uuid.UUID) (*model.FolderModel, error) {
 	var folder model.FolderModel
-	err := f.db.DB.WithContext(ctx).Preload("Folders").Preload("Files").Where("id = ?", folder_id).First(&folder).Error
+	err := f.db.WithContext(ctx).Preload("Folders").Preload("Files").Where("id = ?", folder_id).First(&folder).Error
 	return &folder, err
 }
 
@@ -43,7 +43,7 @@ func (f *FolderRepository) GetRootFolder(ctx context.Context, user_id string) (*
 
 	root_folder := "root_folder"
 
-	err := f.db.DB.WithContext(ctx).Preload("Folders").Preload("Files").Where("name = ? AND user_id = ?", root_folder, user_id).First(&root).Error
+	err := f.db.WithContext(ctx).Preload("Folders").Preload("Files").Where("name = ? AND user_id = ?", root_folder, user_id).First(&root).Error
 	if err != nil {
 		if err.Error() != "record not found" {
 			return nil, err
@@ -51,7 +51,7 @@ func (f *FolderRepository) GetRootFolder(ctx context.Context, user_id string) (*
 
 		path := "/" + user_id + "/" + root_folder
 
-		if err := f.db.DB.WithContext(ctx).Create(&model.FolderModel{
+		if err := f.db.WithContext(ctx).Create(&model.FolderModel{
 			UserID:      user_id,
 			Name:        root_folder,
 			Description: "Root folder",
@@ -66,10 +66,24 @@ func (f *FolderRepository) GetRootFolder(ctx context.Context, user_id string) (*
 	return &root, nil
 }
 
+// GetFolderByPath implements domain.FolderRepository.
+func (f *FolderRepository) GetFolderByPath(ctx context.Context, path string) (*model.FolderModel, error) {
+	var folder model.FolderModel
+	err := f.db.WithContext(ctx).Unscoped().Where("path = ?", path).First(&folder).Error
+	return &folder, err
+}
+
+// GetFoldersPage implements domain.FolderRepository.
+func (f *FolderRepository) GetFoldersPage(ctx context.Context, after uuid.UUID, limit int) ([]*model.FolderModel, error) {
+	var folders []*model.FolderModel
+	err := f.db.WithContext(ctx).Unscoped().Where("id > ?", after).Order("id").Limit(limit).Find(&folders).Error
+	return folders, err
+}
+
 // GetFolders implements domain.FolderRepository.
 func (f *FolderRepository) GetFolders(ctx context.Context, parent_id uuid.UUID) ([]model.FolderModel, error) {
 	var folders []model.FolderModel
-	err := f.db.DB.WithContext(ctx).Where("parent_id = ?", parent_id).Find(&folders).Error
+	err := f.db.WithContext(ctx).Where("parent_id = ?", parent_id).Find(&folders).Error
 	return folders, err
 }
 
@@ -77,7 +91,7 @@ func (f *FolderRepository) GetFolders(ctx context.Context, parent_id uuid.UUID)
 func (f *FolderRepository) MoveFolder(ctx context.Context, folder_id uuid.UUID, new_parent_id uuid.UUID) error {
 	var newParentFolder model.FolderModel
 
-	if err := f.db.DB.WithContext(ctx).Where("id = ?", new_parent_id).First(&newParentFolder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Where("id = ?", new_parent_id).First(&newParentFolder).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return fmt.Errorf("parent folder not found")
 		}
@@ -85,7 +99,7 @@ func (f *FolderRepository) MoveFolder(ctx context.Context, folder_id uuid.UUID,
 	}
 
 	var folder model.FolderModel
-	if err := f.db.DB.WithContext(ctx).Where("id = ?", folder_id).First(&folder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Where("id = ?", folder_id).First(&folder).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return fmt.Errorf("folder not found")
 		}
@@ -106,7 +120,7 @@ func (f *FolderRepository) MoveFolder(ctx context.Context, folder_id uuid.UUID,
 	}
 
 	// Update both `path` and `parent_id`
-	return f.db.DB.WithContext(ctx).Model(&folder).Updates(map[string]interface{}{
+	return f.db.WithContext(ctx).Model(&folder).Updates(map[string]interface{}{
 		"path":      newPath,
 		"parent_id": newParentFolder.ID,
 	}).Error
@@ -117,7 +131,7 @@ func (f *FolderRepository) MoveFolder(ctx context.Context, folder_id uuid.UUID,
 func (f *FolderRepository) RenameFolder(ctx context.Context, user_id string, folder_id uuid.UUID, new_name string) error {
 	// get the folder to rename
 	var folder model.FolderModel
-	if err := f.db.DB.WithContext(ctx).Where("id = ? AND user_id = ?", folder_id, user_id).First(&folder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Where("id = ? AND user_id = ?", folder_id, user_id).First(&folder).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return fmt.Errorf("folder not found")
 		}
@@ -126,7 +140,7 @@ func (f *FolderRepository) RenameFolder(ctx context.Context, user_id string, fol
 
 	// update the folder name and path
 	newPath := strings.TrimSuffix(folder.Path, "/"+folder.Name) + "/" + new_name
-	return f.db.DB.WithContext(ctx).Model(&folder).Updates(map[string]interface{}{
+	return f.db.WithContext(ctx).Model(&folder).Updates(map[string]interface{}{
 		"name": new_name,
 		"path": newPath,
 	}).Error
@@ -140,7 +154,7 @@ func (f *FolderRepository) DeleteFolder(ctx context.Context, folder_id uuid.UUID
 	}
 
 	// delete the folder
-	if err := f.db.DB.WithContext(ctx).Delete(&folder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Delete(&folder).Error; err != nil {
 		return "", err
 	}
 
@@ -150,7 +164,7 @@ func (f *FolderRepository) DeleteFolder(ctx context.Context, folder_id uuid.UUID
 // ScrubFolder implements domain.FolderRepository.
 func (f *FolderRepository) ScrubFolder(ctx context.Context, user_id string, folder_id uuid.UUID) (parent_id string, err error) {
 	var folder model.FolderModel
-	if err := f.db.DB.WithContext(ctx).Where("id = ? AND user_id = ?", folder_id, user_id).First(&folder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Where("id = ? AND user_id = ?", folder_id, user_id).First(&folder).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return "", fmt.Errorf("folder not found")
 		}
@@ -158,7 +172,7 @@ func (f *FolderRepository) ScrubFolder(ctx context.Context, user_id string, fold
 	}
 
 	// delete the folder
-	if err := f.db.DB.WithContext(ctx).Unscoped().Delete(&folder).Error; err != nil {
+	if err := f.db.WithContext(ctx).Unscoped().Delete(&folder).Error; err != nil {
 		return "", err
 	}
 
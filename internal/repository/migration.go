@@ -1,45 +1,155 @@
 package repository
 
 import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Rhaqim/buckt/internal/database"
+	"github.com/Rhaqim/buckt/internal/domain"
 	"github.com/Rhaqim/buckt/internal/model"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-type MigrationRepository interface {
-	CreateMigration(migration *model.MigrationModel) error
-	GetMigration(id uuid.UUID) (*model.MigrationModel, error)
-	UpdateMigration(migration *model.MigrationModel) error
-	DeleteMigration(id uuid.UUID) error
+// enqueueBatchSize bounds the rows inserted by a single statement.
+const enqueueBatchSize = 500
+
+type MigrationRepository struct {
+	db *database.DB
+}
+
+func NewMigrationRepository(db *database.DB) domain.MigrationRepository {
+	return &MigrationRepository{db: db}
+}
+
+// CreateRun implements domain.MigrationRepository.
+func (m *MigrationRepository) CreateRun(ctx context.Context, run *model.MigrationRunModel) error {
+	return m.db.WithContext(ctx).Create(run).Error
+}
+
+// GetActiveRun implements domain.MigrationRepository.
+func (m *MigrationRepository) GetActiveRun(ctx context.Context, migration_id string) (*model.MigrationRunModel, error) {
+	var run model.MigrationRunModel
+	err := m.db.WithContext(ctx).
+		Where("migration_id = ? AND status = ?", migration_id, model.MigrationRunActive).
+		Order("created_at DESC").First(&run).Error
+	return &run, err
 }
 
-type migrationRepository struct {
-	db *gorm.DB
+// GetLatestRun implements domain.MigrationRepository.
+func (m *MigrationRepository) GetLatestRun(ctx context.Context) (*model.MigrationRunModel, error) {
+	var run model.MigrationRunModel
+	err := m.db.WithContext(ctx).Order("created_at DESC").First(&run).Error
+	return &run, err
 }
 
-func NewMigrationRepository(db *gorm.DB) MigrationRepository {
-	return &migrationRepository{
-		db: db,
+// GetLatestRunFor implements domain.MigrationRepository.
+func (m *MigrationRepository) GetLatestRunFor(ctx context.Context, migration_id string) (*model.MigrationRunModel, error) {
+	var run model.MigrationRunModel
+	err := m.db.WithContext(ctx).Where("migration_id = ?", migration_id).
+		Order("created_at DESC").First(&run).Error
+	return &run, err
+}
+
+// CompleteRun implements domain.MigrationRepository.
+func (m *MigrationRepository) CompleteRun(ctx context.Context, run_id uuid.UUID, completed_at time.Time) error {
+	return m.db.WithContext(ctx).Model(&model.MigrationRunModel{}).Where("id = ?", run_id).
+		Updates(map[string]any{"status": model.MigrationRunCompleted, "completed_at": completed_at}).Error
+}
+
+// Enqueue implements domain.MigrationRepository.
+func (m *MigrationRepository) Enqueue(ctx context.Context, run_id uuid.UUID, keys []string) error {
+	if len(keys) == 0 {
+		return nil
 	}
+
+	rows := make([]model.MigrationModel, len(keys))
+	for i, key := range keys {
+		rows[i] = model.MigrationModel{RunID: run_id, ObjectKey: key, Status: model.MigrationStatusQueued}
+	}
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, enqueueBatchSize).Error
 }
 
-func (repo *migrationRepository) CreateMigration(migration *model.MigrationModel) error {
-	return repo.db.Create(migration).Error
+// GetPending implements domain.MigrationRepository.
+func (m *MigrationRepository) GetPending(ctx context.Context, run_id uuid.UUID, prefix, after string, limit int) ([]*model.MigrationModel, error) {
+	var rows []*model.MigrationModel
+	err := m.pending(ctx, run_id, prefix).Where("object_key > ?", after).
+		Order("object_key").Limit(limit).Find(&rows).Error
+	return rows, err
 }
 
-func (repo *migrationRepository) GetMigration(id uuid.UUID) (*model.MigrationModel, error) {
-	var migration model.MigrationModel
-	err := repo.db.First(&migration, "id = ?", id).Error
+// CountPending implements domain.MigrationRepository.
+func (m *MigrationRepository) CountPending(ctx context.Context, run_id uuid.UUID, prefix string) (int64, error) {
+	var n int64
+	err := m.pending(ctx, run_id, prefix).Count(&n).Error
+	return n, err
+}
+
+// pending selects the objects of the run under prefix that are not committed.
+func (m *MigrationRepository) pending(ctx context.Context, run_id uuid.UUID, prefix string) *gorm.DB {
+	query := m.db.WithContext(ctx).Model(&model.MigrationModel{}).
+		Where("run_id = ? AND status <> ?", run_id, model.MigrationStatusCommitted)
+	if prefix != "" {
+		query = query.Where("SUBSTR(object_key, 1, ?) = ?", utf8.RuneCountInString(prefix), prefix)
+	}
+	return query
+}
+
+// Update implements domain.MigrationRepository.
+func (m *MigrationRepository) Update(ctx context.Context, migration *model.MigrationModel) error {
+	return m.db.WithContext(ctx).Save(migration).Error
+}
+
+// Upsert implements domain.MigrationRepository.
+func (m *MigrationRepository) Upsert(ctx context.Context, migration *model.MigrationModel) error {
+	return m.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "run_id"}, {Name: "object_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"size", "checksum_sha256", "status", "attempts", "verify_failures", "promoted", "last_error", "updated_at",
+		}),
+	}).Create(migration).Error
+}
+
+// CountByStatus implements domain.MigrationRepository.
+func (m *MigrationRepository) CountByStatus(ctx context.Context, run_id uuid.UUID) (map[model.MigrationStatus]int64, error) {
+	var counts []struct {
+		Status model.MigrationStatus
+		Count  int64
+	}
+	err := m.db.WithContext(ctx).Model(&model.MigrationModel{}).
+		Select("status, COUNT(*) AS count").Where("run_id = ?", run_id).
+		Group("status").Scan(&counts).Error
 	if err != nil {
 		return nil, err
 	}
-	return &migration, nil
+
+	byStatus := make(map[model.MigrationStatus]int64, len(counts))
+	for _, c := range counts {
+		byStatus[c.Status] = c.Count
+	}
+	return byStatus, nil
 }
 
-func (repo *migrationRepository) UpdateMigration(migration *model.MigrationModel) error {
-	return repo.db.Save(migration).Error
+// GetCutover implements domain.MigrationRepository.
+func (m *MigrationRepository) GetCutover(ctx context.Context, migration_id string) (*model.MigrationCutoverModel, error) {
+	var cutover model.MigrationCutoverModel
+	err := m.db.WithContext(ctx).Where("migration_id = ?", migration_id).First(&cutover).Error
+	return &cutover, err
 }
 
-func (repo *migrationRepository) DeleteMigration(id uuid.UUID) error {
-	return repo.db.Delete(&model.MigrationModel{}, id).Error
+// SetCutoverPhase implements domain.MigrationRepository.
+func (m *MigrationRepository) SetCutoverPhase(ctx context.Context, migration_id string, from, phase model.CutoverPhase) (bool, error) {
+	db := m.db.WithContext(ctx)
+
+	cutover := model.MigrationCutoverModel{MigrationID: migration_id, Phase: model.CutoverDualWrite}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&cutover).Error; err != nil {
+		return false, err
+	}
+
+	result := db.Model(&model.MigrationCutoverModel{}).
+		Where("migration_id = ? AND phase = ?", migration_id, from).
+		Update("phase", phase)
+	return result.RowsAffected == 1, result.Error
 }
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/database"
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+)
+
+type OperationRepository struct {
+	db *database.DB
+}
+
+func NewOperationRepository(db *database.DB) domain.OperationRepository {
+	return &OperationRepository{db: db}
+}
+
+// Create implements domain.OperationRepository.
+// Operations are recorded outside of the transaction of ctx, see database.DB.Detached.
+func (o *OperationRepository) Create(ctx context.Context, op *model.OperationModel) error {
+	return o.db.Detached(ctx).Create(op).Error
+}
+
+// GetOperations implements domain.OperationRepository.
+// Operations are returned in the order they were recorded.
+func (o *OperationRepository) GetOperations(ctx context.Context) ([]*model.OperationModel, error) {
+	var ops []*model.OperationModel
+	err := o.db.WithContext(ctx).Order("created_at").Find(&ops).Error
+	return ops, err
+}
+
+// GetExpired implements domain.OperationRepository.
+// Operations are returned in the order they were recorded.
+func (o *OperationRepository) GetExpired(ctx context.Context, owner uuid.UUID, cutoff time.Time) ([]*model.OperationModel, error) {
+	var ops []*model.OperationModel
+	err := o.db.WithContext(ctx).Where("owner <> ? AND heartbeat_at < ?", owner, cutoff).Order("created_at").Find(&ops).Error
+	return ops, err
+}
+
+// Renew implements domain.OperationRepository.
+func (o *OperationRepository) Renew(ctx context.Context, owner uuid.UUID) error {
+	return o.db.Detached(ctx).Model(&model.OperationModel{}).Where("owner = ?", owner).Update("heartbeat_at", time.Now()).Error
+}
+
+// Delete implements domain.OperationRepository.
+func (o *OperationRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return o.db.Detached(ctx).Delete(&model.OperationModel{}, id).Error
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Rhaqim/buckt/internal/database"
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+type RenditionRepository struct {
+	db *database.DB
+}
+
+func NewRenditionRepository(db *database.DB) domain.RenditionRepository {
+	return &RenditionRepository{db: db}
+}
+
+// Save implements domain.RenditionRepository.
+// An existing rendition with the same file and name is replaced.
+func (r *RenditionRepository) Save(ctx context.Context, rendition *model.RenditionModel) error {
+	return r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"path", "hash", "content_type", "width", "height", "size", "updated_at"}),
+	}).Create(rendition).Error
+}
+
+// GetRendition implements domain.RenditionRepository.
+func (r *RenditionRepository) GetRendition(ctx context.Context, file_id uuid.UUID, name string) (*model.RenditionModel, error) {
+	var rendition model.RenditionModel
+	err := r.db.WithContext(ctx).Where("file_id = ? AND name = ?", file_id, name).First(&rendition).Error
+	return &rendition, err
+}
+
+// GetRenditions implements domain.RenditionRepository.
+func (r *RenditionRepository) GetRenditions(ctx context.Context, file_id uuid.UUID) ([]model.RenditionModel, error) {
+	var renditions []model.RenditionModel
+	err := r.db.WithContext(ctx).Where("file_id = ?", file_id).Find(&renditions).Error
+	return renditions, err
+}
+
+// DeleteRenditions implements domain.RenditionRepository.
+func (r *RenditionRepository) DeleteRenditions(ctx context.Context, file_id uuid.UUID) error {
+	return r.db.WithContext(ctx).Where("file_id = ?", file_id).Delete(&model.RenditionModel{}).Error
+}
+
+// GetRenditionPaths implements domain.RenditionRepository.
+func (r *RenditionRepository) GetRenditionPaths(ctx context.Context) ([]string, error) {
+	var paths []string
+	err := r.db.WithContext(ctx).Model(&model.RenditionModel{}).Pluck("path", &paths).Error
+	return paths, err
+}
@@ -0,0 +1,156 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+// chunkSize is the size of the chunks streamed to clamd, it must stay below
+// the StreamMaxLength of the daemon.
+const chunkSize = 64 * 1024
+
+// ClamdScanner scans content with a clamd daemon using the INSTREAM command.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+var _ domain.Scanner = (*ClamdScanner)(nil)
+
+// NewClamdScanner returns a scanner for the clamd daemon at address, either
+// "host:port" or "unix:/path/to/clamd.sock". A timeout of 0 defaults to one minute.
+func NewClamdScanner(address string, timeout time.Duration) *ClamdScanner {
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+	}
+
+	return &ClamdScanner{network: network, address: address, timeout: timeout}
+}
+
+// Scan implements domain.Scanner.
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (model.ScanResult, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return model.ScanResult{}, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return model.ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+
+	// The content is sent as length prefixed chunks, terminated by an empty chunk.
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				// clamd closes the connection when the stream exceeds its limit,
+				// the reply explains why.
+				if reply, rerr := readReply(conn); rerr == nil {
+					return parseReply(reply)
+				}
+				return model.ScanResult{}, fmt.Errorf("clamd: %w", werr)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return model.ScanResult{}, fmt.Errorf("failed to read content: %w", err)
+		}
+	}
+
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return model.ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return model.ScanResult{}, fmt.Errorf("clamd: %w", err)
+	}
+	return parseReply(reply)
+}
+
+// Ping checks that the daemon is reachable.
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return fmt.Errorf("clamd: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func (c *ClamdScanner) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	return conn, nil
+}
+
+// readReply reads a null terminated reply.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(err == io.EOF && reply != "") {
+		return "", err
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// parseReply parses the reply to INSTREAM:
+//
+//	stream: OK
+//	stream: Eicar-Test-Signature FOUND
+//	INSTREAM size limit exceeded. ERROR
+func parseReply(reply string) (model.ScanResult, error) {
+	body := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case body == "OK":
+		return model.ScanResult{}, nil
+	case strings.HasSuffix(body, " FOUND"):
+		return model.ScanResult{Infected: true, Signature: strings.TrimSuffix(body, " FOUND")}, nil
+	case strings.HasSuffix(body, " ERROR"):
+		return model.ScanResult{}, fmt.Errorf("clamd: %s", strings.TrimSuffix(body, " ERROR"))
+	default:
+		return model.ScanResult{}, fmt.Errorf("clamd: unexpected reply %q", reply)
+	}
+}
@@ -0,0 +1,128 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks enough of the clamd protocol for the tests: PING and
+// INSTREAM, flagging streams that contain the EICAR test string.
+func fakeClamd(t *testing.T, maxStream int) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleClamd(conn, maxStream)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func handleClamd(conn net.Conn, maxStream int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+				return
+			}
+			if data.Len() > maxStream {
+				conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				return
+			}
+		}
+
+		if strings.Contains(data.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamdScan(t *testing.T) {
+	ctx := t.Context()
+	scanner := NewClamdScanner(fakeClamd(t, 1<<20), 0)
+
+	assert.NoError(t, scanner.Ping(ctx))
+
+	result, err := scanner.Scan(ctx, strings.NewReader("hello world"))
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	// The signature is found across chunk boundaries.
+	infected := append(bytes.Repeat([]byte("a"), chunkSize-10), eicar...)
+	result, err = scanner.Scan(ctx, bytes.NewReader(infected))
+	assert.NoError(t, err)
+	assert.True(t, result.Infected)
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+
+	result, err = scanner.Scan(ctx, bytes.NewReader(nil))
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+}
+
+func TestClamdScanErrors(t *testing.T) {
+	ctx := t.Context()
+
+	scanner := NewClamdScanner(fakeClamd(t, 100), 0)
+	_, err := scanner.Scan(ctx, bytes.NewReader(make([]byte, 1000)))
+	assert.ErrorContains(t, err, "size limit exceeded")
+
+	// Nothing listens on a closed listener.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = NewClamdScanner(addr, 0).Scan(ctx, strings.NewReader("hello"))
+	assert.Error(t, err)
+}
+
+func TestParseReply(t *testing.T) {
+	result, err := parseReply("stream: OK")
+	assert.NoError(t, err)
+	assert.False(t, result.Infected)
+
+	result, err = parseReply("stream: Win.Test.EICAR_HDB-1 FOUND")
+	assert.NoError(t, err)
+	assert.Equal(t, "Win.Test.EICAR_HDB-1", result.Signature)
+
+	_, err = parseReply("garbage")
+	assert.Error(t, err)
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+// ArchiveService streams folders and file selections as zip or tar.gz archives
+// and extracts uploaded archives into folders. Files are copied from the
+// backend into the archive one at a time, nothing is buffered in memory or on disk.
+type ArchiveService struct {
+	logger domain.BucktLogger
+
+	folderService domain.FolderService
+	fileService   domain.FileService
+
+	limits model.ExtractLimits
+}
+
+func NewArchiveService(
+	bucktLogger domain.BucktLogger,
+
+	folderService domain.FolderService,
+	fileService domain.FileService,
+
+	limits model.ExtractLimits,
+) domain.ArchiveService {
+	bucktLogger.Info("🚀 Initialising archive services")
+	return &ArchiveService{
+		logger: bucktLogger,
+
+		folderService: folderService,
+		fileService:   fileService,
+
+		limits: limits.WithDefaults(),
+	}
+}
+
+// ArchiveFolder implements domain.ArchiveService.
+// Entries are named relative to the folder. Files that are infected or not
+// scanned yet are left out.
+func (a *ArchiveService) ArchiveFolder(ctx context.Context, user_id, folder_id string, w io.Writer, format model.ArchiveFormat) error {
+	folder, err := ownedFolder(ctx, a.folderService, user_id, folder_id)
+	if err != nil {
+		return err
+	}
+
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	if err := a.writeFolder(ctx, aw, folder.ID.String(), ""); err != nil {
+		aw.Close()
+		return err
+	}
+
+	return aw.Close()
+}
+
+// ArchiveFiles implements domain.ArchiveService.
+// Files are written to the root of the archive, files with the same name are
+// numbered with a name no other entry uses.
+func (a *ArchiveService) ArchiveFiles(ctx context.Context, user_id string, file_ids []string, w io.Writer, format model.ArchiveFormat) error {
+	aw, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{})
+	for _, file_id := range file_ids {
+		if err := a.writeFileByID(ctx, aw, user_id, file_id, names); err != nil {
+			aw.Close()
+			return err
+		}
+	}
+
+	return aw.Close()
+}
+
+func (a *ArchiveService) writeFolder(ctx context.Context, aw archiveWriter, folder_id, prefix string) error {
+	files, err := a.fileService.GetFilesMetadata(ctx, folder_id)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := a.writeFile(ctx, aw, &file, prefix+file.Name); err != nil {
+			return err
+		}
+	}
+
+	folders, err := a.folderService.GetFolders(ctx, folder_id)
+	if err != nil {
+		return err
+	}
+
+	for _, folder := range folders {
+		dir := prefix + folder.Name + "/"
+		if err := aw.addDir(dir, folder.UpdatedAt); err != nil {
+			return err
+		}
+		if err := a.writeFolder(ctx, aw, folder.ID.String(), dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *ArchiveService) writeFileByID(ctx context.Context, aw archiveWriter, user_id, file_id string, names map[string]struct{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Check the owner before anything of the file is read
+	file, err := a.fileService.GetFileInfo(ctx, file_id)
+	if err != nil {
+		if isBlocked(err) {
+			a.logger.Warn(fmt.Sprintf("skipping file %s in archive: %v", file_id, err))
+			return nil
+		}
+		return err
+	}
+
+	if _, err := ownedFolder(ctx, a.folderService, user_id, file.ParentID.String()); err != nil {
+		return errs.ErrFileNotFound
+	}
+
+	name := file.Name
+	if _, taken := names[name]; taken {
+		name = freeName(names, name)
+	}
+	names[name] = struct{}{}
+
+	return a.writeFile(ctx, aw, file, name)
+}
+
+func (a *ArchiveService) writeFile(ctx context.Context, aw archiveWriter, file *model.FileModel, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The entry is described by the version that is streamed
+	current, stream, err := a.fileService.GetFileStream(ctx, file.ID.String())
+	if err != nil {
+		if isBlocked(err) {
+			a.logger.Warn(fmt.Sprintf("skipping file %s in archive: %v", file.ID, err))
+			return nil
+		}
+		return err
+	}
+	defer stream.Close()
+
+	return copyEntry(aw, name, current, stream)
+}
+
+// ownedFolder returns the folder if it belongs to the user, the root folder when folder_id is empty.
+func ownedFolder(ctx context.Context, folderService domain.FolderService, user_id, folder_id string) (*model.FolderModel, error) {
+	if folder_id == "" {
+		return folderService.GetRootFolder(ctx, user_id)
+	}
+
+	folder, err := folderService.GetFolder(ctx, user_id, folder_id)
+	if err != nil {
+		return nil, err
+	}
+
+	// GetFolder falls back on the root folder of the user
+	if folder.ID.String() != folder_id || folder.UserID != user_id {
+		return nil, errs.ErrFolderNotFound
+	}
+
+	return folder, nil
+}
+
+func copyEntry(aw archiveWriter, name string, file *model.FileModel, r io.Reader) error {
+	w, err := aw.addFile(name, file.Size, file.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", name, err)
+	}
+	return nil
+}
+
+// isBlocked reports whether the error is returned for files that cannot be downloaded.
+func isBlocked(err error) bool {
+	return errors.Is(err, errs.ErrFileInfected) || errors.Is(err, errs.ErrFileScanPending)
+}
+
+type archiveWriter interface {
+	addDir(name string, modTime time.Time) error
+	addFile(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, format model.ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case model.ArchiveZip, "":
+		return &zipArchive{zw: zip.NewWriter(w)}, nil
+	case model.ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchive{gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (z *zipArchive) addDir(name string, modTime time.Time) error {
+	_, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Modified: modTime})
+	return err
+}
+
+func (z *zipArchive) addFile(name string, size int64, modTime time.Time) (io.Writer, error) {
+	return z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+}
+
+func (z *zipArchive) Close() error {
+	return z.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarArchive) addDir(name string, modTime time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755, ModTime: modTime})
+}
+
+func (t *tarArchive) addFile(name string, size int64, modTime time.Time) (io.Writer, error) {
+	if err := t.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: modTime}); err != nil {
+		return nil, err
+	}
+	return t.tw, nil
+}
+
+func (t *tarArchive) Close() error {
+	if err := t.tw.Close(); err != nil {
+		t.gz.Close()
+		return err
+	}
+	return t.gz.Close()
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveFiles(t *testing.T) {
+	fileService := new(mocks.FileService)
+	folderService := new(mocks.FolderService)
+	a := NewArchiveService(logger.NewLogger("", true, false), folderService, fileService, model.ExtractLimits{})
+
+	mine := &model.FolderModel{ID: uuid.New(), UserID: "user1"}
+	theirs := &model.FolderModel{ID: uuid.New(), UserID: "user2"}
+	folderService.On("GetFolder", "user1", mine.ID.String()).Return(mine, nil)
+	folderService.On("GetFolder", "user1", theirs.ID.String()).Return(nil, errs.ErrFolderNotFound)
+
+	var ids []string
+	addFile := func(name string, parent *model.FolderModel) {
+		file := &model.FileModel{ID: uuid.New(), Name: name, ParentID: parent.ID, Size: int64(len(name))}
+		ids = append(ids, file.ID.String())
+		fileService.On("GetFileInfo", file.ID.String()).Return(file, nil)
+		fileService.On("GetFileStream", file.ID.String()).Return(file, io.NopCloser(strings.NewReader(name)), nil).Maybe()
+	}
+
+	// Numbered names must not clash with the names of other files
+	addFile("a.txt", mine)
+	addFile("a (1).txt", mine)
+	addFile("a.txt", mine)
+
+	var buf bytes.Buffer
+	assert.NoError(t, a.ArchiveFiles(t.Context(), "user1", ids, &buf, model.ArchiveZip))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"a.txt", "a (1).txt", "a (2).txt"}, names)
+
+	// Files of other users are refused before their content is read
+	addFile("secret.txt", theirs)
+	secret := ids[len(ids)-1]
+	assert.ErrorIs(t, a.ArchiveFiles(t.Context(), "user1", []string{secret}, io.Discard, model.ArchiveZip), errs.ErrFileNotFound)
+	fileService.AssertNotCalled(t, "GetFileStream", secret)
+}
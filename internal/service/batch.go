@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+)
+
+// defaultBatchConcurrency bounds the items of a batch processed at once.
+const defaultBatchConcurrency = 8
+
+// BatchService applies deletes, moves and uploads to many files and folders at
+// once. Items are processed concurrently and fail on their own, or one after
+// the other in a single transaction when the batch is atomic.
+type BatchService struct {
+	logger domain.BucktLogger
+
+	tx         domain.Transactor
+	folderRepo domain.FolderRepository
+	fileRepo   domain.FileRepository
+
+	folderService domain.FolderService
+	fileService   domain.FileService
+}
+
+func NewBatchService(
+	bucktLogger domain.BucktLogger,
+
+	tx domain.Transactor,
+	folderRepository domain.FolderRepository,
+	fileRepository domain.FileRepository,
+
+	folderService domain.FolderService,
+	fileService domain.FileService,
+) domain.BatchService {
+	bucktLogger.Info("🚀 Initialising batch services")
+	return &BatchService{
+		logger: bucktLogger,
+
+		tx:         tx,
+		folderRepo: folderRepository,
+		fileRepo:   fileRepository,
+
+		folderService: folderService,
+		fileService:   fileService,
+	}
+}
+
+// BatchDelete implements domain.BatchService.
+func (b *BatchService) BatchDelete(ctx context.Context, user_id string, ids []string, opts model.BatchOptions) ([]model.BatchResult, error) {
+	results := idResults(ids)
+	return b.run(ctx, results, opts, func(ctx context.Context, i int) error {
+		r := &results[i]
+		itemType, err := b.resolve(ctx, user_id, r.ID)
+		if err != nil {
+			return err
+		}
+		r.Type = itemType
+
+		if itemType == model.BatchFolder {
+			_, err = b.folderService.DeleteFolder(ctx, r.ID)
+		} else {
+			_, err = b.fileService.DeleteFile(ctx, r.ID)
+		}
+		return err
+	})
+}
+
+// BatchScrub implements domain.BatchService.
+// Content is deleted from the backend once the database rows are gone, after
+// the commit of an atomic batch.
+func (b *BatchService) BatchScrub(ctx context.Context, user_id string, ids []string, opts model.BatchOptions) ([]model.BatchResult, error) {
+	results := idResults(ids)
+	return b.run(ctx, results, opts, func(ctx context.Context, i int) error {
+		r := &results[i]
+		itemType, err := b.resolve(ctx, user_id, r.ID)
+		if err != nil {
+			return err
+		}
+		r.Type = itemType
+
+		if itemType == model.BatchFolder {
+			_, err = b.folderService.ScrubFolder(ctx, user_id, r.ID)
+		} else {
+			_, err = b.fileService.ScrubFile(ctx, r.ID)
+		}
+		return err
+	})
+}
+
+// BatchMove implements domain.BatchService.
+// Objects are moved after the commit of an atomic batch, moves the backend
+// fails are journaled and retried in the background.
+func (b *BatchService) BatchMove(ctx context.Context, user_id string, ids []string, new_parent_id string, opts model.BatchOptions) ([]model.BatchResult, error) {
+	parent, err := ownedFolder(ctx, b.folderService, user_id, new_parent_id)
+	if err != nil {
+		return nil, err
+	}
+
+	results := idResults(ids)
+	return b.run(ctx, results, opts, func(ctx context.Context, i int) error {
+		r := &results[i]
+		itemType, err := b.resolve(ctx, user_id, r.ID)
+		if err != nil {
+			return err
+		}
+		r.Type = itemType
+
+		if itemType == model.BatchFolder {
+			return b.folderService.MoveFolder(ctx, r.ID, parent.ID.String())
+		}
+		return b.fileService.MoveFile(ctx, r.ID, parent.ID.String())
+	})
+}
+
+// BatchUpload implements domain.BatchService.
+// Files written to the backend are deleted again when an atomic batch is
+// rolled back.
+func (b *BatchService) BatchUpload(ctx context.Context, user_id, parent_id string, files []model.BatchUpload, opts model.BatchOptions) ([]model.BatchResult, error) {
+	parent, err := ownedFolder(ctx, b.folderService, user_id, parent_id)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]model.BatchResult, len(files))
+	for i, file := range files {
+		results[i] = model.BatchResult{Name: file.Name, Type: model.BatchFile}
+	}
+
+	return b.run(ctx, results, opts, func(ctx context.Context, i int) error {
+		id, err := b.fileService.CreateFile(ctx, user_id, parent.ID.String(), files[i].Name, files[i].ContentType, files[i].Data)
+		if err != nil {
+			return err
+		}
+		results[i].ID = id
+		return nil
+	})
+}
+
+// run calls fn with the index of every result. Failures are recorded in the
+// result of the item; only atomic batches return an error, once they are
+// rolled back.
+func (b *BatchService) run(ctx context.Context, results []model.BatchResult, opts model.BatchOptions, fn func(ctx context.Context, i int) error) ([]model.BatchResult, error) {
+	if opts.Atomic {
+		return b.runAtomic(ctx, results, fn)
+	}
+
+	limit := opts.Concurrency
+	if limit <= 0 {
+		limit = defaultBatchConcurrency
+	}
+
+	var g errgroup.Group
+	g.SetLimit(limit)
+
+	for i := range results {
+		g.Go(func() error {
+			err := ctx.Err()
+			if err == nil {
+				err = fn(ctx, i)
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	return results, nil
+}
+
+func (b *BatchService) runAtomic(ctx context.Context, results []model.BatchResult, fn func(ctx context.Context, i int) error) ([]model.BatchResult, error) {
+	initial := slices.Clone(results)
+
+	failed := -1
+	err := b.tx.RunInTx(ctx, func(ctx context.Context) error {
+		for i := range results {
+			if err := fn(ctx, i); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		return results, nil
+	}
+
+	// Nothing was applied, report the item that failed and roll back the others
+	copy(results, initial)
+	for i := range results {
+		results[i].Error = errs.ErrBatchRolledBack.Error()
+	}
+	if failed < 0 {
+		return results, b.logger.WrapError("failed to commit batch", err)
+	}
+
+	results[failed].Error = err.Error()
+	return results, fmt.Errorf("%w: item %d failed: %w", errs.ErrBatchRolledBack, failed, err)
+}
+
+// resolve returns whether id is a file or a folder, and checks that it belongs to the user.
+func (b *BatchService) resolve(ctx context.Context, user_id, id string) (model.BatchItemType, error) {
+	itemID, err := uuid.Parse(id)
+	if err != nil {
+		return "", errs.ErrInvalidUUID
+	}
+
+	if folder, err := b.folderRepo.GetFolder(ctx, itemID); err == nil {
+		if folder.UserID != user_id {
+			return "", errs.ErrFolderNotFound
+		}
+		return model.BatchFolder, nil
+	}
+
+	file, err := b.fileRepo.GetFile(ctx, itemID)
+	if err != nil {
+		return "", errs.ErrFileNotFound
+	}
+
+	parent, err := b.folderRepo.GetFolder(ctx, file.ParentID)
+	if err != nil || parent.UserID != user_id {
+		return "", errs.ErrFileNotFound
+	}
+	return model.BatchFile, nil
+}
+
+func idResults(ids []string) []model.BatchResult {
+	results := make([]model.BatchResult, len(ids))
+	for i, id := range ids {
+		results[i] = model.BatchResult{ID: id}
+	}
+	return results
+}
@@ -0,0 +1,582 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// exportManifestName is the first entry of an instance export.
+	exportManifestName = "manifest.json"
+
+	// exportBlobPrefix holds the content of the files of an instance export.
+	exportBlobPrefix = "blobs/"
+
+	// exportPageSize is the number of folders or files read at once.
+	exportPageSize = 1000
+
+	// defaultMaxImportFileSize is the largest blob restored by default.
+	defaultMaxImportFileSize = 1 << 30
+)
+
+// ExportService writes whole instances as tar.gz archives and imports them
+// again, into any database and backend. Renditions are left out, they are
+// generated again on demand.
+type ExportService struct {
+	logger domain.BucktLogger
+
+	folderRepo domain.FolderRepository
+	fileRepo   domain.FileRepository
+
+	backend  domain.FileBackend
+	metadata domain.MetadataService // nil unless sidecars are written
+}
+
+func NewExportService(
+	bucktLogger domain.BucktLogger,
+
+	folderRepository domain.FolderRepository,
+	fileRepository domain.FileRepository,
+
+	backend domain.FileBackend,
+	metadata domain.MetadataService,
+) domain.ExportService {
+	bucktLogger.Info("🚀 Initialising export services")
+	return &ExportService{
+		logger: bucktLogger,
+
+		folderRepo: folderRepository,
+		fileRepo:   fileRepository,
+
+		backend:  backend,
+		metadata: metadata,
+	}
+}
+
+// Export implements domain.ExportService.
+// Soft deleted folders and files are exported too. The manifest is built in
+// memory before the blobs are streamed from the backend one at a time. The
+// export fails when the object of a file is missing, see Reconcile.
+func (e *ExportService) Export(ctx context.Context, w io.Writer, opts model.ExportOptions) (model.ExportReport, error) {
+	var report model.ExportReport
+
+	manifest, err := e.manifest(ctx, opts)
+	if err != nil {
+		return report, err
+	}
+	report.Users = len(manifest.Users)
+	report.Folders = len(manifest.Folders)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return report, e.logger.WrapError("failed to encode manifest", err)
+	}
+
+	aw, err := newArchiveWriter(w, model.ArchiveTarGz)
+	if err != nil {
+		return report, err
+	}
+
+	manifestWriter, err := aw.addFile(exportManifestName, int64(len(data)), manifest.CreatedAt)
+	if err != nil {
+		return report, e.logger.WrapError("failed to write manifest", err)
+	}
+	if _, err := manifestWriter.Write(data); err != nil {
+		return report, e.logger.WrapError("failed to write manifest", err)
+	}
+
+	progress := model.ExportProgress{TotalFiles: len(manifest.Files)}
+	for i := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		file := &manifest.Files[i]
+		if file.Blob != "" {
+			n, err := e.writeBlob(ctx, aw, file)
+			if err != nil {
+				return report, e.logger.WrapError("failed to export file "+file.ID.String(), err)
+			}
+			report.Bytes += n
+			progress.Bytes += n
+		}
+
+		report.Files++
+		progress.Files++
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		return report, e.logger.WrapError("failed to finish export", err)
+	}
+
+	return report, nil
+}
+
+// manifest describes every folder and file of the instance.
+func (e *ExportService) manifest(ctx context.Context, opts model.ExportOptions) (*model.ExportManifest, error) {
+	manifest := &model.ExportManifest{
+		Version:      model.ExportVersion,
+		CreatedAt:    time.Now().UTC(),
+		ManifestOnly: opts.ManifestOnly,
+		Users:        []string{},
+		Folders:      []model.FolderMetadata{},
+		Files:        []model.ExportFile{},
+	}
+
+	folders := map[uuid.UUID]*model.FolderModel{}
+	var after uuid.UUID
+	for {
+		page, err := e.folderRepo.GetFoldersPage(ctx, after, exportPageSize)
+		if err != nil {
+			return nil, e.logger.WrapError("failed to get folders", err)
+		}
+		for _, folder := range page {
+			folders[folder.ID] = folder
+		}
+		if len(page) < exportPageSize {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+
+	metas := make([]*model.FolderMetadata, 0, len(folders))
+	users := map[string]struct{}{}
+	for _, folder := range folders {
+		meta := folderMetadata(folder)
+		metas = append(metas, &meta)
+		users[folder.UserID] = struct{}{}
+	}
+	slices.SortFunc(metas, parentsFirst)
+	for _, meta := range metas {
+		manifest.Folders = append(manifest.Folders, *meta)
+	}
+	for user := range users {
+		manifest.Users = append(manifest.Users, user)
+	}
+	slices.Sort(manifest.Users)
+
+	after = uuid.Nil
+	for {
+		page, err := e.fileRepo.GetFilesPage(ctx, after, exportPageSize)
+		if err != nil {
+			return nil, e.logger.WrapError("failed to get files", err)
+		}
+		for _, file := range page {
+			exported := model.ExportFile{
+				FileMetadata: fileMetadata(file, folders[file.ParentID]),
+				ScanStatus:   file.ScanStatus,
+				Signature:    file.Signature,
+			}
+			if !opts.ManifestOnly {
+				exported.Blob = exportBlobPrefix + file.ID.String()
+			}
+			manifest.Files = append(manifest.Files, exported)
+		}
+		if len(page) < exportPageSize {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+
+	return manifest, nil
+}
+
+// writeBlob writes the content of file and returns its size. The object is
+// read before the entry is started, the size in the tar header must be the
+// size of what follows even when the object changed since it was indexed.
+func (e *ExportService) writeBlob(ctx context.Context, aw archiveWriter, file *model.ExportFile) (int64, error) {
+	data, err := e.backend.Get(ctx, file.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := aw.addFile(file.Blob, int64(len(data)), file.UpdatedAt)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return 0, fmt.Errorf("failed to export %s: %w", file.Path, err)
+	}
+	return int64(len(data)), nil
+}
+
+// Import implements domain.ExportService.
+// Folders whose path is already indexed are merged with the existing folder
+// when it has the same owner. Files whose path or name is taken are reported
+// as conflicts and left out, as are blobs that do not match the checksum of
+// their file. Files of a manifest only export are indexed when their object
+// is in the backend.
+//
+// The manifest is not trusted: paths must be clean and stay inside their
+// folders, and folders belong to the owner of their parent, root folders to
+// the user their path is named after.
+func (e *ExportService) Import(ctx context.Context, r io.Reader, opts model.InstanceImportOptions) (model.InstanceImportReport, error) {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = defaultMaxImportFileSize
+	}
+
+	im := &instanceImport{
+		ExportService: e,
+		opts:          opts,
+		report:        model.InstanceImportReport{IDs: map[uuid.UUID]uuid.UUID{}},
+		folderIDs:     map[uuid.UUID]uuid.UUID{},
+		folders:       map[uuid.UUID]*model.FolderModel{},
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return im.report, fmt.Errorf("%w: %v", errs.ErrInvalidExport, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return im.report, err
+	}
+	im.progress.TotalFiles = len(manifest.Files)
+
+	folders := make([]*model.FolderMetadata, len(manifest.Folders))
+	for i := range manifest.Folders {
+		folders[i] = &manifest.Folders[i]
+	}
+	slices.SortFunc(folders, parentsFirst)
+
+	for _, meta := range folders {
+		if err := ctx.Err(); err != nil {
+			return im.report, err
+		}
+		im.restoreFolder(ctx, meta)
+	}
+
+	blobs := make(map[string]*model.ExportFile, len(manifest.Files))
+	for i := range manifest.Files {
+		if file := &manifest.Files[i]; file.Blob != "" {
+			blobs[file.Blob] = file
+		}
+	}
+
+	restored := make(map[uuid.UUID]struct{}, len(manifest.Files))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return im.report, e.logger.WrapError("failed to read export", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return im.report, err
+		}
+
+		file, ok := blobs[hdr.Name]
+		if !ok {
+			continue
+		}
+		delete(blobs, hdr.Name)
+		restored[file.ID] = struct{}{}
+		im.restoreFile(ctx, file, &blobEntry{Reader: tr, size: hdr.Size})
+	}
+
+	for i := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return im.report, err
+		}
+
+		file := &manifest.Files[i]
+		if _, ok := restored[file.ID]; ok {
+			continue
+		}
+		im.restoreFile(ctx, file, nil)
+	}
+
+	return im.report, nil
+}
+
+// readManifest reads the manifest, which must be the first entry of the export.
+func readManifest(tr *tar.Reader) (*model.ExportManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errs.ErrInvalidExport, err)
+	}
+	if hdr.Name != exportManifestName {
+		return nil, fmt.Errorf("%w: the first entry is %s", errs.ErrInvalidExport, hdr.Name)
+	}
+
+	var manifest model.ExportManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", errs.ErrInvalidExport, err)
+	}
+	if manifest.Version < 1 || manifest.Version > model.ExportVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", errs.ErrInvalidExport, manifest.Version)
+	}
+
+	return &manifest, nil
+}
+
+// instanceImport is the state of a single Import run.
+type instanceImport struct {
+	*ExportService
+
+	opts     model.InstanceImportOptions
+	report   model.InstanceImportReport
+	progress model.ExportProgress
+
+	// folderIDs maps the exported folder IDs to the folders they are indexed as
+	folderIDs map[uuid.UUID]uuid.UUID
+	folders   map[uuid.UUID]*model.FolderModel
+}
+
+// blobEntry is the archive entry holding the content of a file.
+type blobEntry struct {
+	io.Reader
+	size int64
+}
+
+func (im *instanceImport) restoreFolder(ctx context.Context, meta *model.FolderMetadata) {
+	if _, ok := importPath(meta.Path); !ok || !validName(meta.Name) {
+		im.conflict(meta.ID, meta.Path, "invalid folder path")
+		return
+	}
+
+	var parent *model.FolderModel
+	if meta.ParentID != nil {
+		parentID, ok := im.folderIDs[*meta.ParentID]
+		if !ok {
+			im.conflict(meta.ID, meta.Path, "parent folder was not imported")
+			return
+		}
+		parent = im.folders[parentID]
+	}
+
+	owner, ok := folderOwner(meta.Path, parent)
+	if !ok || (parent != nil && meta.Path != path.Join(parent.Path, meta.Name)) {
+		im.conflict(meta.ID, meta.Path, "path is outside the parent folder")
+		return
+	}
+	if meta.Owner != owner {
+		im.conflict(meta.ID, meta.Path, "owner does not match the folder path")
+		return
+	}
+
+	existing, err := im.folderRepo.GetFolderByPath(ctx, meta.Path)
+	switch {
+	case err == nil && existing.UserID != owner:
+		im.conflict(meta.ID, meta.Path, "path is indexed as a folder of another user")
+		return
+	case err == nil:
+		im.mapID(meta.ID, existing.ID)
+		im.folderIDs[meta.ID] = existing.ID
+		im.folders[existing.ID] = existing
+		im.report.FoldersExisting++
+		return
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		im.conflict(meta.ID, meta.Path, err.Error())
+		return
+	}
+
+	folder := &model.FolderModel{
+		ID:          im.newID(meta.ID),
+		UserID:      owner,
+		Name:        meta.Name,
+		Description: meta.Description,
+		Path:        meta.Path,
+		CreatedAt:   meta.CreatedAt,
+		UpdatedAt:   meta.UpdatedAt,
+		DeletedAt:   softDeleted(meta.DeletedAt),
+	}
+	if parent != nil {
+		folder.ParentID = &parent.ID
+	}
+
+	if _, err := im.folderRepo.Create(ctx, folder); err != nil {
+		im.conflict(meta.ID, meta.Path, err.Error())
+		return
+	}
+
+	im.mapID(meta.ID, folder.ID)
+	im.folderIDs[meta.ID] = folder.ID
+	im.folders[folder.ID] = folder
+	im.report.FoldersCreated++
+
+	if im.metadata != nil {
+		if err := im.metadata.WriteFolderMetadata(ctx, folder); err != nil {
+			im.logger.Errorf("failed to write metadata of %s: %v", folder.ID, err)
+		}
+	}
+}
+
+// restoreFile indexes the file, storing its content first when the export
+// has a blob for it.
+func (im *instanceImport) restoreFile(ctx context.Context, meta *model.ExportFile, blob *blobEntry) {
+	defer im.advance()
+
+	parentID, ok := im.folderIDs[meta.ParentID]
+	if !ok {
+		im.conflict(meta.ID, meta.Path, "parent folder was not imported")
+		return
+	}
+
+	// Nested paths are those of non flat namespaces, below their folder
+	cleaned, ok := importPath(meta.Path)
+	if !ok || !validName(meta.Name) || (strings.Contains(cleaned, "/") && meta.Path != path.Join(im.folders[parentID].Path, meta.Name)) {
+		im.conflict(meta.ID, meta.Path, "invalid file path")
+		return
+	}
+
+	if existing, err := im.fileRepo.GetFileByPath(ctx, meta.Path); err == nil {
+		im.conflict(meta.ID, meta.Path, "path is indexed as file "+existing.ID.String())
+		return
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		im.conflict(meta.ID, meta.Path, err.Error())
+		return
+	}
+	if existing, err := im.fileRepo.GetFileByName(ctx, parentID, meta.Name); err == nil {
+		im.conflict(meta.ID, meta.Path, "name is indexed as file "+existing.ID.String())
+		return
+	}
+
+	file := &model.FileModel{
+		ID:          im.newID(meta.ID),
+		Name:        meta.Name,
+		Path:        meta.Path,
+		ContentType: meta.ContentType,
+		Size:        meta.Size,
+		ParentID:    parentID,
+		Hash:        meta.Hash,
+		ScanStatus:  meta.ScanStatus,
+		Signature:   meta.Signature,
+		CreatedAt:   meta.CreatedAt,
+		UpdatedAt:   meta.UpdatedAt,
+		DeletedAt:   softDeleted(meta.DeletedAt),
+	}
+
+	if blob != nil {
+		if blob.size != meta.Size || blob.size > im.opts.MaxFileSize {
+			im.conflict(meta.ID, meta.Path, fmt.Sprintf("blob of %d bytes does not match the file or exceeds the limit of %d bytes", blob.size, im.opts.MaxFileSize))
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(blob, blob.size))
+		if err != nil {
+			im.conflict(meta.ID, meta.Path, "failed to read blob: "+err.Error())
+			return
+		}
+
+		content, legacy, _ := checksums(bytes.NewReader(data), meta.Path)
+		if meta.Hash != content && meta.Hash != legacy {
+			im.conflict(meta.ID, meta.Path, "blob does not match the checksum of the file")
+			return
+		}
+
+		if err := im.backend.Put(ctx, file.Path, data); err != nil {
+			im.conflict(meta.ID, meta.Path, "failed to store blob: "+err.Error())
+			return
+		}
+
+		verifiedAt := time.Now()
+		file.Hash = content
+		file.Integrity = model.IntegrityOK
+		file.VerifiedAt = &verifiedAt
+		im.report.Bytes += int64(len(data))
+		im.progress.Bytes += int64(len(data))
+	} else {
+		exists, err := im.backend.Exists(ctx, file.Path)
+		if err != nil {
+			im.conflict(meta.ID, meta.Path, err.Error())
+			return
+		}
+		if !exists {
+			im.conflict(meta.ID, meta.Path, "object is missing")
+			return
+		}
+	}
+
+	if err := im.fileRepo.Create(ctx, file); err != nil {
+		im.conflict(meta.ID, meta.Path, err.Error())
+		return
+	}
+
+	im.mapID(meta.ID, file.ID)
+	im.report.FilesCreated++
+
+	if im.metadata != nil {
+		if err := im.metadata.WriteFileMetadata(ctx, file, im.folders[parentID]); err != nil {
+			im.logger.Errorf("failed to write metadata of %s: %v", file.ID, err)
+		}
+	}
+}
+
+// importPath returns the cleaned form of an object or folder path of an
+// export, without its leading slash. Paths that are not clean, climb out of
+// the backend or are reserved for sidecars are refused.
+func importPath(p string) (string, bool) {
+	trimmed := strings.TrimPrefix(p, "/")
+	cleaned, ok := cleanEntryPath(trimmed)
+	if !ok || cleaned == "" || cleaned != trimmed || strings.HasPrefix(cleaned+"/", metadataPrefix) {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// validName reports whether name is a single path element.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// folderOwner returns the user an imported folder belongs to: the owner of
+// its parent, or for root folders the user their path is named after.
+func folderOwner(p string, parent *model.FolderModel) (string, bool) {
+	if parent != nil {
+		return parent.UserID, true
+	}
+
+	cleaned, _ := importPath(p)
+	owner, rest, ok := strings.Cut(cleaned, "/")
+	return owner, ok && !strings.Contains(rest, "/")
+}
+
+// newID returns the ID an exported folder or file is created with.
+func (im *instanceImport) newID(id uuid.UUID) uuid.UUID {
+	if im.opts.RemapIDs {
+		return uuid.New()
+	}
+	return id
+}
+
+func (im *instanceImport) mapID(exported, imported uuid.UUID) {
+	if exported != imported {
+		im.report.IDs[exported] = imported
+	}
+}
+
+func (im *instanceImport) advance() {
+	im.progress.Files++
+	if im.opts.Progress != nil {
+		im.opts.Progress(im.progress)
+	}
+}
+
+func (im *instanceImport) conflict(id uuid.UUID, path, reason string) {
+	im.report.Conflicts = append(im.report.Conflicts, model.ExportConflict{ID: id, Path: path, Reason: reason})
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadManifest(t *testing.T) {
+	archive := func(name, content string) *tar.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(content)), Mode: 0644}))
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+		assert.NoError(t, tw.Close())
+		return tar.NewReader(&buf)
+	}
+
+	manifest, err := readManifest(archive(exportManifestName, `{"version": 1, "users": ["u1"]}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"u1"}, manifest.Users)
+	}
+
+	for name, tr := range map[string]*tar.Reader{
+		"newer version": archive(exportManifestName, `{"version": 2}`),
+		"no version":    archive(exportManifestName, `{}`),
+		"not json":      archive(exportManifestName, `manifest`),
+		"blob first":    archive(exportBlobPrefix+"1234", `{"version": 1}`),
+		"empty archive": tar.NewReader(bytes.NewReader(nil)),
+	} {
+		_, err := readManifest(tr)
+		assert.ErrorIs(t, err, errs.ErrInvalidExport, name)
+	}
+}
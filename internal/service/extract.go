@@ -0,0 +1,425 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+)
+
+// ratioFloor is the output below which the compression ratio is not checked,
+// small files of repetitive text compress far better than the limit.
+const ratioFloor = 1 << 20
+
+// ExtractArchive implements domain.ArchiveService.
+// It creates the folders and files of a zip, tar or tar.gz archive under the
+// parent folder. Entries that would escape the folder, links and uploads
+// rejected by the upload policy or the scanner are skipped and reported.
+// When a limit is exceeded extraction stops; what was extracted so far is kept
+// and reported.
+func (a *ArchiveService) ExtractArchive(ctx context.Context, user_id, parent_id string, r io.Reader, conflict model.ConflictPolicy) (model.ExtractReport, error) {
+	var report model.ExtractReport
+
+	switch conflict {
+	case "":
+		conflict = model.ConflictSkip
+	case model.ConflictSkip, model.ConflictOverwrite, model.ConflictRename:
+	default:
+		return report, fmt.Errorf("unknown conflict policy %q", conflict)
+	}
+
+	parent, err := ownedFolder(ctx, a.folderService, user_id, parent_id)
+	if err != nil {
+		return report, err
+	}
+
+	input := &countingReader{r: r}
+	ar, err := openArchive(input, a.limits)
+	if err != nil {
+		return report, a.logger.WrapError("failed to open archive", err)
+	}
+	defer ar.Close()
+
+	x := &extractor{
+		ArchiveService: a,
+		user_id:        user_id,
+		conflict:       conflict,
+		limits:         a.limits,
+		input:          input,
+		report:         &report,
+	}
+	x.tree = newFolderTree(a.folderService, a.fileService, user_id, parent.ID.String(), func(name, id string, action model.ExtractAction) {
+		report.Entries = append(report.Entries, model.ExtractEntry{Path: name + "/", ID: id, IsDir: true, Action: action})
+	})
+
+	for entries := 0; ; entries++ {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		entry, err := ar.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, a.logger.WrapError("failed to read archive", err)
+		}
+
+		if entries >= x.limits.MaxEntries {
+			return report, fmt.Errorf("%w: more than %d entries", errs.ErrArchiveLimitExceeded, x.limits.MaxEntries)
+		}
+
+		if err := x.extract(ctx, entry); err != nil {
+			return report, err
+		}
+	}
+
+	a.logger.Infof("📦 Extracted %d bytes into folder %s", report.Bytes, parent.ID)
+	return report, nil
+}
+
+// extractor holds the state of a single extraction.
+type extractor struct {
+	*ArchiveService
+
+	user_id  string
+	conflict model.ConflictPolicy
+	limits   model.ExtractLimits
+	input    *countingReader
+
+	tree *folderTree
+
+	report *model.ExtractReport
+}
+
+func (x *extractor) extract(ctx context.Context, entry *archiveEntry) error {
+	name, ok := cleanEntryPath(entry.name)
+	if !ok {
+		x.skip(entry.name, entry.isDir, "path escapes the target folder")
+		return nil
+	}
+	if name == "" {
+		return nil
+	}
+
+	if entry.isDir {
+		_, err := x.tree.folder(ctx, name)
+		return err
+	}
+
+	if !entry.regular {
+		x.skip(entry.name, false, "not a regular file")
+		return nil
+	}
+
+	if remaining := x.limits.MaxTotalSize - x.report.Bytes; entry.size > remaining {
+		return fmt.Errorf("%w: %s is larger than the %d bytes left", errs.ErrArchiveLimitExceeded, entry.name, remaining)
+	}
+
+	data, err := x.read(entry)
+	if err != nil {
+		return err
+	}
+
+	dir, base := path.Split(name)
+	folderID, err := x.tree.folder(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return err
+	}
+
+	files, err := x.tree.folderFiles(ctx, folderID)
+	if err != nil {
+		return err
+	}
+
+	action := model.ExtractCreated
+	fileName := base
+	if existing, exists := files[base]; exists {
+		existingID := existing.ID.String()
+		switch x.conflict {
+		case model.ConflictSkip:
+			x.skip(entry.name, false, "file already exists")
+			return nil
+		case model.ConflictOverwrite:
+			if err := x.fileService.UpdateFile(ctx, x.user_id, existingID, base, data); err != nil {
+				return x.rejected(entry.name, err)
+			}
+			x.report.Entries = append(x.report.Entries, model.ExtractEntry{Path: entry.name, ID: existingID, Action: model.ExtractOverwritten})
+			return nil
+		case model.ConflictRename:
+			action = model.ExtractRenamed
+			fileName = freeName(files, base)
+		}
+	}
+
+	fileID, err := x.fileService.CreateFile(ctx, x.user_id, folderID, fileName, detectContentType(fileName, data), data)
+	if err != nil {
+		return x.rejected(entry.name, err)
+	}
+	files[fileName] = &model.FileModel{ID: uuid.MustParse(fileID), Name: fileName}
+
+	result := model.ExtractEntry{Path: entry.name, ID: fileID, Action: action}
+	if action == model.ExtractRenamed {
+		result.Name = fileName
+	}
+	x.report.Entries = append(x.report.Entries, result)
+	return nil
+}
+
+// read reads the content of an entry, enforcing the size and ratio limits on
+// the bytes actually decompressed rather than the sizes the archive declares.
+func (x *extractor) read(entry *archiveEntry) ([]byte, error) {
+	rc, err := entry.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", entry.name, err)
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := rc.Read(chunk)
+		buf.Write(chunk[:n])
+		x.report.Bytes += int64(n)
+
+		if x.report.Bytes > x.limits.MaxTotalSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", errs.ErrArchiveLimitExceeded, x.limits.MaxTotalSize)
+		}
+		if x.exceedsRatio(entry, int64(buf.Len())) {
+			return nil, fmt.Errorf("%w: %s is compressed more than %g:1", errs.ErrArchiveLimitExceeded, entry.name, x.limits.MaxRatio)
+		}
+
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.name, err)
+		}
+	}
+}
+
+// exceedsRatio checks zip entries against their own compressed size and
+// compressed tarballs, which have no per entry size, against the input read.
+func (x *extractor) exceedsRatio(entry *archiveEntry, written int64) bool {
+	if entry.compressed > 0 {
+		return written > ratioFloor && float64(written) > float64(entry.compressed)*x.limits.MaxRatio
+	}
+	if entry.streamCompressed {
+		return x.report.Bytes > ratioFloor && float64(x.report.Bytes) > float64(x.input.n)*x.limits.MaxRatio
+	}
+	return false
+}
+
+func (x *extractor) skip(name string, isDir bool, reason string) {
+	x.report.Entries = append(x.report.Entries, model.ExtractEntry{Path: name, IsDir: isDir, Action: model.ExtractSkipped, Reason: reason})
+}
+
+// rejected records uploads refused by the upload policy or the scanner and
+// returns any other error.
+func (x *extractor) rejected(name string, err error) error {
+	if isRejection(err) {
+		x.skip(name, false, err.Error())
+		return nil
+	}
+	return err
+}
+
+// isRejection reports whether an upload was refused by the upload policy or the scanner.
+func isRejection(err error) bool {
+	for _, rejection := range []error{
+		errs.ErrFileTooLarge, errs.ErrExtensionNotAllowed, errs.ErrMIMETypeNotAllowed, errs.ErrMIMETypeMismatch, errs.ErrFileInfected,
+	} {
+		if errors.Is(err, rejection) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanEntryPath returns the slash separated path of an entry relative to the
+// target folder, or false when the entry is absolute or climbs out of it.
+func cleanEntryPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") || (len(name) > 1 && name[1] == ':') {
+		return "", false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false
+		}
+	}
+
+	name = path.Clean(name)
+	if name == "." {
+		return "", true
+	}
+	return name, true
+}
+
+// freeName returns "name (n).ext" for the lowest n not in files.
+func freeName[V any](files map[string]V, name string) string {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if _, exists := files[candidate]; !exists {
+			return candidate
+		}
+	}
+}
+
+type archiveEntry struct {
+	name    string
+	isDir   bool
+	regular bool
+	// size is the declared size, only used to fail early.
+	size int64
+	// compressed is the compressed size of zip entries.
+	compressed int64
+	// streamCompressed is set for entries of a compressed tarball.
+	streamCompressed bool
+	open             func() (io.ReadCloser, error)
+}
+
+type archiveReader interface {
+	// next returns the next entry, io.EOF after the last one.
+	next() (*archiveEntry, error)
+	Close() error
+}
+
+// openArchive detects the format of r from its first bytes.
+func openArchive(r io.Reader, limits model.ExtractLimits) (archiveReader, error) {
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(262)
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return openZip(br, limits)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &tarReader{tr: tar.NewReader(gz), gz: gz}, nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return &tarReader{tr: tar.NewReader(br)}, nil
+	default:
+		return nil, errs.ErrUnsupportedArchive
+	}
+}
+
+// openZip spools the archive to a temporary file, the central directory of a
+// zip is at its end.
+func openZip(r io.Reader, limits model.ExtractLimits) (archiveReader, error) {
+	f, err := os.CreateTemp("", "buckt-extract-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	za := &zipReader{f: f}
+
+	size, err := io.Copy(f, io.LimitReader(r, limits.MaxTotalSize+1))
+	if err != nil {
+		za.Close()
+		return nil, err
+	}
+	if size > limits.MaxTotalSize {
+		za.Close()
+		return nil, fmt.Errorf("%w: archive is larger than %d bytes", errs.ErrArchiveLimitExceeded, limits.MaxTotalSize)
+	}
+
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		za.Close()
+		return nil, err
+	}
+	if len(zr.File) > limits.MaxEntries {
+		za.Close()
+		return nil, fmt.Errorf("%w: more than %d entries", errs.ErrArchiveLimitExceeded, limits.MaxEntries)
+	}
+
+	za.files = zr.File
+	return za, nil
+}
+
+type zipReader struct {
+	f     *os.File
+	files []*zip.File
+}
+
+func (z *zipReader) next() (*archiveEntry, error) {
+	if len(z.files) == 0 {
+		return nil, io.EOF
+	}
+	file := z.files[0]
+	z.files = z.files[1:]
+
+	mode := file.Mode()
+	return &archiveEntry{
+		name:       file.Name,
+		isDir:      mode.IsDir(),
+		regular:    mode.IsRegular(),
+		size:       int64(file.UncompressedSize64),
+		compressed: max(int64(file.CompressedSize64), 1),
+		open:       file.Open,
+	}, nil
+}
+
+func (z *zipReader) Close() error {
+	z.f.Close()
+	return os.Remove(z.f.Name())
+}
+
+type tarReader struct {
+	tr *tar.Reader
+	gz *gzip.Reader
+}
+
+func (t *tarReader) next() (*archiveEntry, error) {
+	hdr, err := t.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return &archiveEntry{
+		name:             hdr.Name,
+		isDir:            hdr.Typeflag == tar.TypeDir,
+		regular:          hdr.Typeflag == tar.TypeReg,
+		size:             hdr.Size,
+		streamCompressed: t.gz != nil,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(t.tr), nil
+		},
+	}, nil
+}
+
+func (t *tarReader) Close() error {
+	if t.gz != nil {
+		return t.gz.Close()
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from the uploaded archive.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanEntryPath(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.txt":          "a.txt",
+		"docs/":          "docs",
+		"./docs//b.txt":  "docs/b.txt",
+		`docs\win.txt`:   "docs/win.txt",
+		"./":             "",
+		"../evil.txt":    "!",
+		"docs/../../x":   "!",
+		"/etc/passwd":    "!",
+		`C:\windows\x`:   "!",
+		`..\..\evil.txt`: "!",
+	} {
+		got, ok := cleanEntryPath(name)
+		if want == "!" {
+			assert.False(t, ok, name)
+			continue
+		}
+		assert.True(t, ok, name)
+		assert.Equal(t, want, got, name)
+	}
+}
+
+func TestFreeName(t *testing.T) {
+	files := map[string]string{"a.txt": "1", "a (1).txt": "2"}
+	assert.Equal(t, "a (2).txt", freeName(files, "a.txt"))
+	assert.Equal(t, "README (1)", freeName(map[string]string{}, "README"))
+}
@@ -2,15 +2,18 @@ package service
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/Rhaqim/buckt/internal/database"
 	"github.com/Rhaqim/buckt/internal/domain"
 	"github.com/Rhaqim/buckt/internal/model"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type FileService struct {
@@ -23,6 +26,57 @@ type FileService struct {
 
 	folderService domain.FolderService
 	fileBackend   domain.FileBackend
+
+	uploadPolicy *model.UploadPolicy
+
+	scanner     domain.Scanner
+	scanMode    model.ScanMode
+	scans       chan scanJob
+	scanWorkers sync.WaitGroup
+	scanMu      sync.RWMutex // Guards sending to scans against closing it
+	scansClosed bool
+
+	renditions domain.RenditionService
+
+	ops operations
+
+	metadata domain.MetadataService
+}
+
+// FileServiceOption configures optional behaviour of the FileService.
+type FileServiceOption func(*FileService)
+
+// WithUploadPolicy validates every upload against the given policy before it is stored.
+func WithUploadPolicy(policy *model.UploadPolicy) FileServiceOption {
+	return func(f *FileService) {
+		f.uploadPolicy = policy
+	}
+}
+
+// WithScanner scans every upload with the given scanner. In sync mode infected
+// uploads are rejected before anything is written, in async mode files are
+// stored as pending and cannot be downloaded until the scan has finished.
+func WithScanner(scanner domain.Scanner, mode model.ScanMode) FileServiceOption {
+	return func(f *FileService) {
+		f.scanner = scanner
+		f.scanMode = mode
+	}
+}
+
+// WithRenditions generates renditions of uploaded images and keeps them in sync
+// with updates and deletions.
+func WithRenditions(renditions domain.RenditionService) FileServiceOption {
+	return func(f *FileService) {
+		f.renditions = renditions
+	}
+}
+
+// WithMetadata writes a metadata sidecar for the object of every file and
+// keeps it in sync with renames, moves and deletions.
+func WithMetadata(metadata domain.MetadataService) FileServiceOption {
+	return func(f *FileService) {
+		f.metadata = metadata
+	}
 }
 
 func NewFileService(
@@ -35,9 +89,11 @@ func NewFileService(
 	fileBackend domain.FileBackend,
 
 	flatNameSpaces bool,
+
+	opts ...FileServiceOption,
 ) domain.FileService {
 	bucktLogger.Info("🚀 Initialising file services")
-	return &FileService{
+	fileService := &FileService{
 		logger: bucktLogger,
 
 		cache: cache,
@@ -48,7 +104,17 @@ func NewFileService(
 		fileBackend:   fileBackend,
 
 		flatNameSpaces: flatNameSpaces,
+
+		ops: operations{logger: bucktLogger},
 	}
+
+	for _, opt := range opts {
+		opt(fileService)
+	}
+
+	fileService.startScanWorkers()
+
+	return fileService
 }
 
 // CreateFile implements domain.FileService.
@@ -64,6 +130,17 @@ func (f *FileService) CreateFile(ctx context.Context, user_id, parent_id, file_n
 		}
 	}
 
+	// Validate the upload before anything is written
+	content_type, err = checkUploadPolicy(f.uploadPolicy, user_id, parentFolder.ID.String(), file_name, content_type, file_data)
+	if err != nil {
+		return "", f.logger.WrapError("upload rejected", err)
+	}
+
+	scanStatus, signature, err := f.scanUpload(ctx, file_data)
+	if err != nil {
+		return "", f.logger.WrapError("upload rejected", err)
+	}
+
 	// Get the file path
 	path := filepath.Join(parentFolder.Path, file_name)
 
@@ -74,43 +151,144 @@ func (f *FileService) CreateFile(ctx context.Context, user_id, parent_id, file_n
 	}
 
 	// Calculate the file hash, for data verification
-	combinedData := append([]byte(path), file_data...)
-	hash := fmt.Sprintf("%x", sha256.Sum256(combinedData))
+	hash := contentChecksum(file_data)
+	verifiedAt := time.Now()
 
 	// Size of the file
 	fileSize := int64(len(file_data))
 
-	// Create the file model
+	// An existing file of the same name, trashed or not, is restored instead
+	if existing, err := f.repo.GetFileByName(ctx, parentFolder.ID, file_name); err == nil {
+		file, err := f.repo.RestoreFile(ctx, existing.ParentID, existing.Name)
+		if err != nil {
+			return "", f.logger.WrapError("failed to restore file", err)
+		}
+		f.writeMetadata(ctx, file, parentFolder)
+		return file.ID.String(), nil
+	}
+
+	// Create the file model, its ID is known up front so the creation can be journaled
 	file := &model.FileModel{
+		ID:          uuid.New(),
 		ParentID:    parentFolder.ID,
 		Name:        file_name,
 		Path:        path,
 		Hash:        hash,
 		ContentType: content_type,
 		Size:        fileSize,
+		ScanStatus:  scanStatus,
+		Signature:   signature,
+		Integrity:   model.IntegrityOK,
+		VerifiedAt:  &verifiedAt,
+	}
+
+	op := &model.OperationModel{
+		Type:   model.OperationCreateFile,
+		FileID: file.ID,
+		Path:   file.Path,
+		Hash:   file.Hash,
+	}
+	if err := f.ops.begin(ctx, op); err != nil {
+		return "", err
+	}
+
+	// Write the file to the file system before the row points to it
+	if err := f.fileBackend.Put(ctx, file.Path, file_data); err != nil {
+		f.abort(ctx, op, file.Path)
+		return "", err
 	}
+	f.ops.onRollback(ctx, op, func(ctx context.Context) error {
+		return ignoreNotExist(f.fileBackend.Delete(ctx, file.Path))
+	})
 
 	// Create the file
-	err = f.repo.Create(ctx, file)
-	if err != nil {
-		if err.Error() == "UNIQUE constraint failed: file_models.name, file_models.parent_id" {
-			file, err = f.repo.RestoreFile(ctx, file.ParentID, file.Name)
-			if err != nil {
-				return "", f.logger.WrapError("failed to restore file", err)
-			}
-		} else {
-			return "", f.logger.WrapError("failed to create file", err)
-		}
-	} else {
-		// Write the file to the file system
-		if err := f.fileBackend.Put(ctx, file.Path, file_data); err != nil {
-			return "", err
+	if err := f.repo.Create(ctx, file); err != nil {
+		f.abort(ctx, op, file.Path)
+		return "", f.logger.WrapError("failed to create file", err)
+	}
+
+	// Background work must not see the row before it is committed
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		// Renditions of pending uploads are queued once the scan is clean
+		switch {
+		case scanStatus == model.ScanStatusPending:
+			f.scanAsync(ctx, file, file_data)
+		case f.renditions != nil:
+			f.renditions.Enqueue(file, file_data)
 		}
-	}
+		f.writeMetadata(ctx, file, parentFolder)
+		return f.ops.commit(ctx, op)
+	})
 
 	return file.ID.String(), nil
 }
 
+// CheckUpload implements domain.FileService.
+// It applies the upload policy to content known by its size and first bytes,
+// so content that would be refused is not read at all.
+func (f *FileService) CheckUpload(ctx context.Context, user_id, parent_id, file_name, content_type string, size int64, head []byte) (string, error) {
+	content_type, err := checkContentPolicy(f.uploadPolicy, user_id, parent_id, file_name, content_type, size, head)
+	if err != nil {
+		return "", f.logger.WrapError("upload rejected", err)
+	}
+	return content_type, nil
+}
+
+// ReferenceFile implements domain.FileService.
+// The object is streamed to compute its checksum and goes through the upload
+// policy and the scanner like an upload, but it is not copied. In async scan
+// mode the file stays pending until RescanPending scans it.
+func (f *FileService) ReferenceFile(ctx context.Context, user_id, parent_id, file_name, path string) (*model.FileModel, error) {
+	parentFolder, err := f.folderService.GetFolder(ctx, user_id, parent_id)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := f.fileBackend.Stream(ctx, path)
+	if err != nil {
+		return nil, f.logger.WrapError("failed to read file", err)
+	}
+	hash, size, head, err := inspect(rc)
+	rc.Close()
+	if err != nil {
+		return nil, f.logger.WrapError("failed to read file", err)
+	}
+
+	contentType, err := f.CheckUpload(ctx, user_id, parentFolder.ID.String(), file_name, detectContentType(file_name, head), size, head)
+	if err != nil {
+		return nil, err
+	}
+
+	scanStatus, signature, err := f.scanObject(ctx, path)
+	if err != nil {
+		return nil, f.logger.WrapError("upload rejected", err)
+	}
+
+	verifiedAt := time.Now()
+	file := &model.FileModel{
+		ParentID:    parentFolder.ID,
+		Name:        file_name,
+		Path:        path,
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        size,
+		ScanStatus:  scanStatus,
+		Signature:   signature,
+		Integrity:   model.IntegrityOK,
+		VerifiedAt:  &verifiedAt,
+	}
+	if err := f.repo.Create(ctx, file); err != nil {
+		return nil, f.logger.WrapError("failed to create file", err)
+	}
+
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		f.writeMetadata(ctx, file, parentFolder)
+		return nil
+	})
+
+	return file, nil
+}
+
 // GetFile implements domain.FileService.
 // Subtle: this method shadows the method (FileRepository).GetFile of FileService.repo.
 func (f *FileService) GetFile(ctx context.Context, file_id string) (*model.FileModel, error) {
@@ -153,6 +331,10 @@ func (f *FileService) GetFile(ctx context.Context, file_id string) (*model.FileM
 		}
 	}
 
+	if err := checkScanStatus(file); err != nil {
+		return nil, f.logger.WrapError("file cannot be downloaded", err)
+	}
+
 	data, err := f.fileBackend.Get(ctx, file.Path)
 	if err != nil {
 		return nil, f.logger.WrapError("failed to get file data", err)
@@ -163,12 +345,12 @@ func (f *FileService) GetFile(ctx context.Context, file_id string) (*model.FileM
 	return file, nil
 }
 
-// GetFileStream implements domain.FileService.
-// Subtle: this method shadows the method (FileBackend).GetFilStream of FileService.fileBackend.
-func (f *FileService) GetFileStream(ctx context.Context, file_id string) (*model.FileModel, io.ReadCloser, error) {
+// GetFileInfo implements domain.FileService.
+// It returns the metadata of a file that can be downloaded, without reading its content.
+func (f *FileService) GetFileInfo(ctx context.Context, file_id string) (*model.FileModel, error) {
 	fileID, err := uuid.Parse(file_id)
 	if err != nil {
-		return nil, nil, f.logger.WrapError("failed to parse uuid", err)
+		return nil, f.logger.WrapError("failed to parse uuid", err)
 	}
 
 	var file *model.FileModel
@@ -191,7 +373,7 @@ func (f *FileService) GetFileStream(ctx context.Context, file_id string) (*model
 	if file == nil {
 		file, err = f.repo.GetFile(ctx, fileID)
 		if err != nil {
-			return nil, nil, f.logger.WrapError("failed to get file metadata", err)
+			return nil, f.logger.WrapError("failed to get file metadata", err)
 		}
 
 		// Store metadata in cache (without file data)
@@ -201,6 +383,21 @@ func (f *FileService) GetFileStream(ctx context.Context, file_id string) (*model
 		}
 	}
 
+	if err := checkScanStatus(file); err != nil {
+		return nil, f.logger.WrapError("file cannot be downloaded", err)
+	}
+
+	return file, nil
+}
+
+// GetFileStream implements domain.FileService.
+// Subtle: this method shadows the method (FileBackend).GetFilStream of FileService.fileBackend.
+func (f *FileService) GetFileStream(ctx context.Context, file_id string) (*model.FileModel, io.ReadCloser, error) {
+	file, err := f.GetFileInfo(ctx, file_id)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Fetch actual file data separately
 	fileStream, err := f.fileBackend.Stream(ctx, file.Path)
 	if err != nil {
@@ -275,6 +472,12 @@ func (f *FileService) GetFiles(ctx context.Context, parent_id string) ([]model.F
 	// Fetch actual file data separately
 	var fileModels []model.FileModel
 	for _, file := range files {
+		// Infected and unscanned files are listed without their data
+		if checkScanStatus(file) != nil {
+			fileModels = append(fileModels, *file)
+			continue
+		}
+
 		fileData, err := f.fileBackend.Get(ctx, file.Path)
 		if err != nil {
 			return nil, f.logger.WrapError("failed to get file data", err)
@@ -304,13 +507,30 @@ func (f *FileService) MoveFile(ctx context.Context, file_id string, new_parent_i
 		return f.logger.WrapError("failed to move file", err)
 	}
 
-	if !f.flatNameSpaces {
-		// Move the file in the file system
-		if err := f.fileBackend.Move(ctx, oldPath, newPath); err != nil {
-			return f.logger.WrapError("failed to move file", err)
+	if !f.flatNameSpaces && oldPath != newPath {
+		// The row has moved already, the object is moved even if the journal fails
+		op := &model.OperationModel{
+			Type:    model.OperationMoveFile,
+			FileID:  fileID,
+			Path:    newPath,
+			OldPath: oldPath,
 		}
+		if err := f.ops.begin(ctx, op); err != nil {
+			f.logger.Errorf("moving %s without journal: %v", file_id, err)
+		}
+		f.ops.onRollback(ctx, op, nil)
+
+		// Move the file in the file system, once the move is committed
+		_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+			return f.ops.finish(ctx, op, f.finishMove)
+		})
 	}
 
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		f.refreshMetadata(ctx, fileID, oldPath)
+		return nil
+	})
+
 	return nil
 }
 
@@ -326,6 +546,11 @@ func (f *FileService) RenameFile(ctx context.Context, file_id string, new_name s
 		return f.logger.WrapError("failed to rename file", err)
 	}
 
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		f.refreshMetadata(ctx, fileID, "")
+		return nil
+	})
+
 	return nil
 }
 
@@ -347,27 +572,67 @@ func (f *FileService) UpdateFile(ctx context.Context, user_id, file_id string, n
 		return err
 	}
 
+	// Validate the new content before anything is written
+	contentType, err := checkUploadPolicy(f.uploadPolicy, user_id, parentFolder.ID.String(), new_file_name, file.ContentType, new_file_data)
+	if err != nil {
+		return f.logger.WrapError("update rejected", err)
+	}
+
+	scanStatus, signature, err := f.scanUpload(ctx, new_file_data)
+	if err != nil {
+		return f.logger.WrapError("update rejected", err)
+	}
+
 	// Get the new file path
 	newPath := parentFolder.Path + "/" + new_file_name
 
 	// Calculate the new file hash, for data verification
-	newHash := fmt.Sprintf("%x", sha256.Sum256(new_file_data))
+	newHash := contentChecksum(new_file_data)
+	verifiedAt := time.Now()
 
 	// Update the file model
+	oldPath := file.Path
 	file.Name = new_file_name
 	file.Path = newPath
 	file.Hash = newHash
-
-	// Update the file in the file system
-	if err := f.fileBackend.Put(ctx, newPath, new_file_data); err != nil {
+	file.Integrity = model.IntegrityOK
+	file.VerifiedAt = &verifiedAt
+	file.ContentType = contentType
+	file.ScanStatus = scanStatus
+	file.Signature = signature
+
+	// Write the new content next to the current one until the row is updated
+	if err := f.replaceContent(ctx, file, oldPath, new_file_data); err != nil {
 		return err
 	}
 
-	// Update the file
-	if err := f.repo.Update(ctx, file); err != nil {
-		return f.logger.WrapError("failed to update file", err)
+	// Drop the cached metadata so the new scan status applies
+	if f.cache != nil {
+		_ = f.cache.DeleteBucktValue(ctx, file_id)
 	}
 
+	// Replace the renditions of the previous content
+	if f.renditions != nil {
+		if err := f.renditions.DeleteRenditions(ctx, file); err != nil {
+			f.logger.Errorf("failed to delete renditions of %s: %v", file.ID, err)
+		}
+	}
+
+	_ = database.AfterCommit(ctx, func(context.Context) error {
+		switch {
+		case scanStatus == model.ScanStatusPending:
+			f.scanAsync(ctx, file, new_file_data)
+		case f.renditions != nil:
+			f.renditions.Enqueue(file, new_file_data)
+		}
+
+		f.writeMetadata(ctx, file, parentFolder)
+		if oldPath != file.Path {
+			f.deleteMetadata(ctx, oldPath)
+		}
+		return nil
+	})
+
 	return nil
 }
 
@@ -410,6 +675,15 @@ func (f *FileService) DeleteFile(ctx context.Context, file_id string) (string, e
 		return parentID, f.logger.WrapError("failed to delete file", err)
 	}
 
+	// The sidecar keeps the file in the trash when the index is rebuilt
+	if f.metadata != nil {
+		file.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+			f.writeMetadata(ctx, file, nil)
+			return nil
+		})
+	}
+
 	return file.ParentID.String(), nil
 }
 
@@ -445,15 +719,33 @@ func (f *FileService) ScrubFile(ctx context.Context, file_id string) (string, er
 		}
 	}
 
-	// Delete the file from the file system
-	if err := f.fileBackend.Delete(ctx, file.Path); err != nil {
+	if f.renditions != nil {
+		if err := f.renditions.DeleteRenditions(ctx, file); err != nil {
+			return parentID, err
+		}
+	}
+
+	op := &model.OperationModel{
+		Type:   model.OperationScrubFile,
+		FileID: file.ID,
+		Path:   file.Path,
+	}
+	if err := f.ops.begin(ctx, op); err != nil {
 		return parentID, err
 	}
 
+	f.ops.onRollback(ctx, op, nil)
+
 	// Delete the file
 	if err := f.repo.ScrubFile(ctx, fileID); err != nil {
+		_ = f.ops.commit(ctx, op)
 		return parentID, f.logger.WrapError("failed to delete file", err)
 	}
 
+	// Delete the file from the file system once the row is gone for good
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		return f.ops.finish(ctx, op, f.finishScrub)
+	})
+
 	return file.ParentID.String(), nil
 }
@@ -3,6 +3,7 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/Rhaqim/buckt/internal/domain"
@@ -12,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
 )
 
 type MockFileServices struct {
@@ -54,6 +56,9 @@ func TestCreateFile(t *testing.T) {
 	// Mock GetFolder to match the actual method call
 	mockSetUp.folderService.On("GetFolder", user_id, "parent_id").Return(parentFolder, nil)
 
+	// No file of the same name exists yet
+	mockSetUp.fileRepository.On("GetFileByName", parentFolder.ID, "file.txt").Return(nil, gorm.ErrRecordNotFound)
+
 	// Mock Put
 	mockSetUp.backend.On("Put", "/parent/folder/file.txt", []byte("file data")).Return(nil)
 
@@ -115,12 +120,22 @@ func TestUpdateFile(t *testing.T) {
 
 	mockSetUp.folderService.On("GetFolder", user_id, parentID.String()).Return(parentFolder, nil)
 
-	mockSetUp.backend.On("Put", "/parent/folder/new_file.txt", []byte("new file data")).Return(nil)
+	// The new content is staged, then moved into place once the row is updated
+	isStaging := mock.MatchedBy(func(path string) bool {
+		return strings.HasPrefix(path, "/parent/folder/new_file.txt.staging-")
+	})
+	mockSetUp.backend.On("Put", isStaging, []byte("new file data")).Return(nil)
+	mockSetUp.backend.On("Exists", isStaging).Return(true, nil)
+	mockSetUp.backend.On("Move", isStaging, "/parent/folder/new_file.txt").Return(nil)
+	mockSetUp.backend.On("Delete", "/parent/folder/file.txt").Return(nil)
 
 	mockSetUp.fileRepository.On("Update", mock.Anything).Return(nil)
 
+	mockSetUp.cacheManager.On("DeleteBucktValue", fileID.String()).Return(nil)
+
 	err := mockSetUp.fileService.UpdateFile(ctx, user_id, fileID.String(), "new_file.txt", []byte("new file data"))
 	assert.NoError(t, err)
+	mockSetUp.backend.AssertExpectations(t)
 }
 
 func TestDeleteFile(t *testing.T) {
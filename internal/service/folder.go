@@ -3,12 +3,15 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"path/filepath"
 
 	"github.com/Rhaqim/buckt/internal/constant"
+	"github.com/Rhaqim/buckt/internal/database"
 	"github.com/Rhaqim/buckt/internal/domain"
 	"github.com/Rhaqim/buckt/internal/model"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type FolderService struct {
@@ -19,6 +22,30 @@ type FolderService struct {
 	repo domain.FolderRepository
 
 	backend domain.FileBackend
+
+	metadata domain.MetadataService
+
+	ops operations
+}
+
+// FolderServiceOption configures optional behaviour of the FolderService.
+type FolderServiceOption func(*FolderService)
+
+// WithFolderMetadata writes a metadata sidecar for every folder and keeps it
+// in sync with renames, moves and deletions.
+func WithFolderMetadata(metadata domain.MetadataService) FolderServiceOption {
+	return func(f *FolderService) {
+		f.metadata = metadata
+	}
+}
+
+// WithFolderJournal records scrubs and deletions of folders before they run, as
+// WithJournal does for files.
+func WithFolderJournal(journal domain.OperationRepository, owner uuid.UUID) FolderServiceOption {
+	return func(f *FolderService) {
+		f.ops.journal = journal
+		f.ops.owner = owner
+	}
 }
 
 func NewFolderService(
@@ -26,14 +53,22 @@ func NewFolderService(
 	cacheManager domain.CacheManager,
 	folderRepository domain.FolderRepository,
 	backend domain.FileBackend,
+	opts ...FolderServiceOption,
 ) domain.FolderService {
 	bucktLogger.Info("🚀 Initialising folder services")
-	return &FolderService{
+	folderService := &FolderService{
 		logger:  bucktLogger,
 		cache:   cacheManager,
 		repo:    folderRepository,
 		backend: backend,
+		ops:     operations{logger: bucktLogger},
 	}
+
+	for _, opt := range opts {
+		opt(folderService)
+	}
+
+	return folderService
 }
 
 // CreateFolder implements domain.FolderService.
@@ -73,6 +108,11 @@ func (f *FolderService) CreateFolder(ctx context.Context, user_id, parent_id, fo
 		return "", f.logger.WrapError("failed to create folder", err)
 	}
 
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		f.writeMetadata(ctx, folder)
+		return nil
+	})
+
 	return new_folder_id, nil
 }
 
@@ -173,6 +213,11 @@ func (f *FolderService) MoveFolder(ctx context.Context, folder_id string, new_pa
 		return f.logger.WrapError("failed to move folder", err)
 	}
 
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		f.refreshMetadata(ctx, folderID)
+		return nil
+	})
+
 	return nil
 }
 
@@ -188,6 +233,11 @@ func (f *FolderService) RenameFolder(ctx context.Context, user_id string, folder
 		return f.logger.WrapError("failed to rename folder", err)
 	}
 
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		f.refreshMetadata(ctx, folderID)
+		return nil
+	})
+
 	return nil
 }
 
@@ -198,11 +248,35 @@ func (f *FolderService) DeleteFolder(ctx context.Context, folder_id string) (str
 		return "", f.logger.WrapError("failed to parse uuid", err)
 	}
 
+	// The sidecar keeps the folder in the trash when the index is rebuilt
+	var op *model.OperationModel
+	if f.metadata != nil {
+		folder, err := f.repo.GetFolder(ctx, folderID)
+		if err != nil {
+			return "", f.logger.WrapError("failed to get folder", err)
+		}
+
+		op = &model.OperationModel{Type: model.OperationDeleteFolder, FolderID: folder.ID, Path: folder.Path}
+		if err := f.ops.begin(ctx, op); err != nil {
+			return "", err
+		}
+		f.ops.onRollback(ctx, op, nil)
+	}
+
 	parent_id, err := f.repo.DeleteFolder(ctx, folderID)
 	if err != nil {
+		if op != nil {
+			_ = f.ops.commit(ctx, op)
+		}
 		return "", f.logger.WrapError("failed to delete folder", err)
 	}
 
+	if op != nil {
+		_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+			return f.ops.finish(ctx, op, f.finishDelete)
+		})
+	}
+
 	return parent_id, nil
 }
 
@@ -219,15 +293,113 @@ func (f *FolderService) ScrubFolder(ctx context.Context, user_id, folder_id stri
 		return "", f.logger.WrapError("failed to get folder", err)
 	}
 
-	err = f.backend.DeleteFolder(ctx, folder.Path)
-	if err != nil {
-		return "", f.logger.WrapError("failed to delete folder", err)
+	op := &model.OperationModel{Type: model.OperationScrubFolder, FolderID: folder.ID, Path: folder.Path}
+	if err := f.ops.begin(ctx, op); err != nil {
+		return "", err
 	}
+	f.ops.onRollback(ctx, op, nil)
 
 	parent_id, err := f.repo.ScrubFolder(ctx, user_id, folderID)
 	if err != nil {
+		_ = f.ops.commit(ctx, op)
 		return "", f.logger.WrapError("failed to scrub folder", err)
 	}
 
+	// Delete the folder from the file system, once the scrub is committed
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		return f.ops.finish(ctx, op, f.finishScrub)
+	})
+
 	return parent_id, nil
 }
+
+// finishScrub deletes the objects and the sidecar of a scrubbed folder once its row is gone.
+func (f *FolderService) finishScrub(ctx context.Context, op *model.OperationModel) error {
+	if err := ignoreNotExist(f.backend.DeleteFolder(ctx, op.Path)); err != nil {
+		return f.logger.WrapError("failed to delete folder", err)
+	}
+
+	if f.metadata != nil {
+		if err := f.metadata.DeleteFolderMetadata(ctx, op.FolderID.String()); err != nil {
+			return f.logger.WrapError("failed to delete folder metadata", err)
+		}
+	}
+
+	return f.ops.commit(ctx, op)
+}
+
+// finishDelete writes the sidecar of a trashed folder once its row is deleted.
+func (f *FolderService) finishDelete(ctx context.Context, op *model.OperationModel) error {
+	folder, err := f.repo.GetFolderByPath(ctx, op.Path)
+	if err != nil {
+		return f.logger.WrapError("failed to get folder", err)
+	}
+
+	if folder.ID == op.FolderID && f.metadata != nil {
+		if err := f.metadata.WriteFolderMetadata(ctx, folder); err != nil {
+			return f.logger.WrapError("failed to write folder metadata", err)
+		}
+	}
+
+	return f.ops.commit(ctx, op)
+}
+
+// RecoverOperations implements domain.FolderService.
+// Every folder operation left in the journal by an instance that stopped is
+// completed when its database step was applied and dropped otherwise, as
+// FileService.RecoverOperations does for files.
+func (f *FolderService) RecoverOperations(ctx context.Context) (int, error) {
+	return f.ops.recover(ctx, func(op *model.OperationModel) bool { return !isFileOperation(op) }, f.recoverOperation)
+}
+
+// RetryOperations implements domain.FolderService.
+// It finishes the operations whose steps after the commit failed.
+func (f *FolderService) RetryOperations(ctx context.Context) (int, error) {
+	return f.ops.retry(ctx, f.recoverOperation)
+}
+
+func (f *FolderService) recoverOperation(ctx context.Context, op *model.OperationModel) error {
+	folder, err := f.repo.GetFolderByPath(ctx, op.Path)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	switch op.Type {
+	case model.OperationScrubFolder:
+		// The objects are deleted after the row, unless a new folder took the path since
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return f.finishScrub(ctx, op)
+		}
+	case model.OperationDeleteFolder:
+		// The sidecar is written after the row is trashed
+		if err == nil && folder.ID == op.FolderID && folder.DeletedAt.Valid {
+			return f.finishDelete(ctx, op)
+		}
+	}
+
+	return f.ops.commit(ctx, op)
+}
+
+// writeMetadata writes the sidecar of folder, failures are only logged as for files.
+func (f *FolderService) writeMetadata(ctx context.Context, folder *model.FolderModel) {
+	if f.metadata == nil {
+		return
+	}
+	if err := f.metadata.WriteFolderMetadata(ctx, folder); err != nil {
+		f.logger.Errorf("failed to write metadata of folder %s: %v", folder.ID, err)
+	}
+}
+
+// refreshMetadata rewrites the sidecar of a folder after a rename or move.
+func (f *FolderService) refreshMetadata(ctx context.Context, folderID uuid.UUID) {
+	if f.metadata == nil {
+		return
+	}
+
+	folder, err := f.repo.GetFolder(ctx, folderID)
+	if err != nil {
+		f.logger.Errorf("failed to get folder %s: %v", folderID, err)
+		return
+	}
+	f.writeMetadata(ctx, folder)
+}
@@ -0,0 +1,426 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+)
+
+// ImportService adopts trees of objects that were stored without Buckt, such
+// as a directory of existing files or a bucket prefix, into a folder.
+type ImportService struct {
+	logger domain.BucktLogger
+
+	folderService domain.FolderService
+	fileService   domain.FileService
+
+	fileRepo domain.FileRepository
+
+	// fileBackend is the backend of the client, objects referenced in place live there.
+	fileBackend domain.FileBackend
+}
+
+func NewImportService(
+	bucktLogger domain.BucktLogger,
+
+	folderService domain.FolderService,
+	fileService domain.FileService,
+
+	fileRepository domain.FileRepository,
+
+	fileBackend domain.FileBackend,
+) domain.ImportService {
+	bucktLogger.Info("🚀 Initialising import services")
+	return &ImportService{
+		logger: bucktLogger,
+
+		folderService: folderService,
+		fileService:   fileService,
+
+		fileRepo: fileRepository,
+
+		fileBackend: fileBackend,
+	}
+}
+
+// ImportTree implements domain.ImportService.
+// Every object below prefix becomes a file in the folder of the same relative
+// path below dest_folder_id. Copies and objects referenced in place go through
+// the upload policy and scanner like any upload; referenced objects are
+// indexed where they are, with their checksum and content type computed from
+// their content.
+//
+// Objects are handled in key order and files with the same content are not
+// imported twice, so an import can be run again or resumed after
+// ImportReport.LastKey when it was interrupted.
+func (s *ImportService) ImportTree(ctx context.Context, user_id string, backend domain.FileBackend, prefix, dest_folder_id string, opts model.ImportOptions) (model.ImportReport, error) {
+	var report model.ImportReport
+
+	switch opts.Mode {
+	case "":
+		opts.Mode = model.ImportCopy
+	case model.ImportCopy, model.ImportReference:
+	default:
+		return report, fmt.Errorf("unknown import mode %q", opts.Mode)
+	}
+
+	switch opts.Conflict {
+	case "":
+		opts.Conflict = model.ConflictSkip
+	case model.ConflictSkip, model.ConflictOverwrite, model.ConflictRename:
+	default:
+		return report, fmt.Errorf("unknown conflict policy %q", opts.Conflict)
+	}
+
+	if opts.Mode == model.ImportReference {
+		if backend == nil {
+			backend = s.fileBackend
+		}
+		if backend != s.fileBackend {
+			return report, errs.ErrImportReference
+		}
+		if opts.Conflict == model.ConflictOverwrite {
+			return report, fmt.Errorf("conflict policy %q cannot be used when referencing objects", opts.Conflict)
+		}
+	}
+	if backend == nil {
+		return report, fmt.Errorf("no backend to import from")
+	}
+
+	dest, err := ownedFolder(ctx, s.folderService, user_id, dest_folder_id)
+	if err != nil {
+		return report, err
+	}
+
+	keys, err := backend.List(ctx, prefix)
+	if err != nil {
+		return report, s.logger.WrapError("failed to list objects", err)
+	}
+	slices.Sort(keys)
+
+	listed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		listed[objectKey(key)] = struct{}{}
+	}
+
+	root := objectKey(prefix)
+	if root == "." {
+		root = ""
+	}
+
+	im := &importer{
+		ImportService: s,
+		user_id:       user_id,
+		backend:       backend,
+		opts:          opts,
+		report:        &report,
+	}
+	im.tree = newFolderTree(s.folderService, s.fileService, user_id, dest.ID.String(), func(name, id string, action model.ExtractAction) {
+		report.Entries = append(report.Entries, model.ExtractEntry{Path: name + "/", ID: id, IsDir: true, Action: action})
+	})
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if opts.After != "" && key <= opts.After {
+			continue
+		}
+
+		k := objectKey(key)
+		if !internalObject(k, listed) {
+			if err := im.importObject(ctx, key, root, k); err != nil {
+				return report, err
+			}
+		}
+		report.LastKey = key
+	}
+
+	s.logger.Infof("📥 Imported %d objects of %s into folder %s", report.Count(model.ExtractCreated)+report.Count(model.ExtractReferenced), backend.Name(), dest.ID)
+	return report, nil
+}
+
+// importer holds the state of a single import.
+type importer struct {
+	*ImportService
+
+	user_id string
+	backend domain.FileBackend
+	opts    model.ImportOptions
+
+	tree *folderTree
+
+	report *model.ImportReport
+}
+
+func (im *importer) importObject(ctx context.Context, key, root, k string) error {
+	rel := k
+	if root != "" {
+		if !strings.HasPrefix(k, root+"/") {
+			im.skip(key, "outside the prefix folder")
+			return nil
+		}
+		rel = strings.TrimPrefix(k, root+"/")
+	}
+
+	name, ok := cleanEntryPath(rel)
+	if !ok || name == "" {
+		im.skip(key, "invalid object key")
+		return nil
+	}
+
+	if im.opts.Mode == model.ImportReference {
+		return im.reference(ctx, key, name)
+	}
+	return im.copy(ctx, key, name)
+}
+
+// copy stores the content of the object as a new file. The object is
+// streamed to tell whether it is stored at all, it is only read into memory
+// once it passed the upload policy, backends write whole objects.
+func (im *importer) copy(ctx context.Context, key, name string) error {
+	folderID, base, files, err := im.folder(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	hash, size, head, ok := im.inspect(ctx, key)
+	if !ok {
+		return nil
+	}
+
+	action := model.ExtractCreated
+	fileName := base
+	existing, exists := files[base]
+	if exists {
+		if existing.Hash == hash {
+			im.add(model.ExtractEntry{Path: key, ID: existing.ID.String(), Action: model.ExtractUnchanged})
+			return nil
+		}
+
+		switch im.opts.Conflict {
+		case model.ConflictSkip:
+			im.skip(key, "file already exists")
+			return nil
+		case model.ConflictOverwrite:
+			action = model.ExtractOverwritten
+		case model.ConflictRename:
+			if renamed := sameContent(files, base, hash); renamed != nil {
+				im.add(model.ExtractEntry{Path: key, ID: renamed.ID.String(), Name: renamed.Name, Action: model.ExtractUnchanged})
+				return nil
+			}
+			action = model.ExtractRenamed
+			fileName = freeName(files, base)
+		}
+	}
+
+	contentType, err := im.fileService.CheckUpload(ctx, im.user_id, folderID, fileName, detectContentType(fileName, head), size, head)
+	if err != nil {
+		return im.rejected(key, err)
+	}
+
+	data, err := im.backend.Get(ctx, key)
+	if err != nil {
+		im.skip(key, "failed to read object: "+err.Error())
+		return nil
+	}
+	if contentChecksum(data) != hash {
+		im.skip(key, "object changed while it was imported")
+		return nil
+	}
+
+	if action == model.ExtractOverwritten {
+		if err := im.fileService.UpdateFile(ctx, im.user_id, existing.ID.String(), base, data); err != nil {
+			return im.rejected(key, err)
+		}
+		existing.Hash = hash
+		im.report.Bytes += size
+		im.add(model.ExtractEntry{Path: key, ID: existing.ID.String(), Action: action})
+		return nil
+	}
+
+	fileID, err := im.fileService.CreateFile(ctx, im.user_id, folderID, fileName, contentType, data)
+	if err != nil {
+		return im.rejected(key, err)
+	}
+
+	files[fileName] = &model.FileModel{ID: uuid.MustParse(fileID), Name: fileName, Hash: hash}
+	im.report.Bytes += size
+
+	result := model.ExtractEntry{Path: key, ID: fileID, Action: action}
+	if action == model.ExtractRenamed {
+		result.Name = fileName
+	}
+	im.add(result)
+	return nil
+}
+
+// reference indexes the object where it is stored, through the upload policy
+// and the scanner like a copy.
+func (im *importer) reference(ctx context.Context, key, name string) error {
+	for _, p := range []string{key, "/" + objectKey(key)} {
+		if existing, err := im.fileRepo.GetFileByPath(ctx, p); err == nil {
+			im.add(model.ExtractEntry{Path: key, ID: existing.ID.String(), Action: model.ExtractUnchanged})
+			return nil
+		}
+	}
+
+	folderID, base, files, err := im.folder(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	fileName := base
+	if existing, exists := files[base]; exists {
+		hash, _, _, ok := im.inspect(ctx, key)
+		if !ok {
+			return nil
+		}
+		if existing.Hash == hash {
+			im.add(model.ExtractEntry{Path: key, ID: existing.ID.String(), Action: model.ExtractUnchanged})
+			return nil
+		}
+		if im.opts.Conflict == model.ConflictSkip {
+			im.skip(key, "file already exists")
+			return nil
+		}
+		fileName = freeName(files, base)
+	}
+
+	file, err := im.fileService.ReferenceFile(ctx, im.user_id, folderID, fileName, key)
+	if err != nil {
+		return im.rejected(key, err)
+	}
+	files[fileName] = file
+	im.report.Bytes += file.Size
+
+	result := model.ExtractEntry{Path: key, ID: file.ID.String(), Action: model.ExtractReferenced}
+	if fileName != base {
+		result.Name = fileName
+	}
+	im.add(result)
+	return nil
+}
+
+// inspect streams the object and returns the checksum, size and first bytes
+// of its content. Objects that cannot be read are skipped.
+func (im *importer) inspect(ctx context.Context, key string) (string, int64, []byte, bool) {
+	rc, err := im.backend.Stream(ctx, key)
+	if err != nil {
+		im.skip(key, "failed to read object: "+err.Error())
+		return "", 0, nil, false
+	}
+	defer rc.Close()
+
+	hash, size, head, err := inspect(rc)
+	if err != nil {
+		im.skip(key, "failed to read object: "+err.Error())
+		return "", 0, nil, false
+	}
+	return hash, size, head, true
+}
+
+// folder returns the folder of the file at the cleaned path, its base name
+// and the files already in it.
+func (im *importer) folder(ctx context.Context, name string) (string, string, map[string]*model.FileModel, error) {
+	dir, base := path.Split(name)
+	folderID, err := im.tree.folder(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	files, err := im.tree.folderFiles(ctx, folderID)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return folderID, base, files, nil
+}
+
+func (im *importer) add(entry model.ExtractEntry) {
+	im.report.Entries = append(im.report.Entries, entry)
+}
+
+func (im *importer) skip(key, reason string) {
+	im.add(model.ExtractEntry{Path: key, Action: model.ExtractSkipped, Reason: reason})
+}
+
+// rejected records uploads refused by the upload policy or the scanner and
+// returns any other error.
+func (im *importer) rejected(key string, err error) error {
+	if isRejection(err) {
+		im.skip(key, err.Error())
+		return nil
+	}
+	return err
+}
+
+// sameContent returns the file renamed from base by an earlier import of the
+// same content, if any.
+func sameContent(files map[string]*model.FileModel, base, hash string) *model.FileModel {
+	ext := path.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for name, file := range files {
+		if file.Hash == hash && strings.HasPrefix(name, stem+" (") && strings.HasSuffix(name, ")"+ext) {
+			return file
+		}
+	}
+	return nil
+}
+
+// internalObject reports whether the object belongs to another listed object,
+// such as its sidecar, renditions or a staged replacement, or to a folder.
+func internalObject(k string, listed map[string]struct{}) bool {
+	if strings.HasPrefix(k, metadataPrefix) {
+		return true
+	}
+
+	owner := ""
+	switch {
+	case strings.Contains(k, ".renditions/"):
+		owner = k[:strings.Index(k, ".renditions/")]
+	case strings.Contains(k, ".staging-"):
+		owner = k[:strings.LastIndex(k, ".staging-")]
+	default:
+		return false
+	}
+
+	_, ok := listed[owner]
+	return ok
+}
+
+// inspect reads r to the end and returns the checksum and size of its content
+// and its first bytes, for content type detection.
+func inspect(r io.Reader) (hash string, size int64, head []byte, err error) {
+	br := bufio.NewReaderSize(r, 512)
+	peeked, _ := br.Peek(512)
+	head = bytes.Clone(peeked)
+
+	sum := sha256.New()
+	if size, err = io.Copy(sum, br); err != nil {
+		return "", 0, nil, err
+	}
+	return hex.EncodeToString(sum.Sum(nil)), size, head, nil
+}
+
+// detectContentType guesses the content type of a file from its extension and
+// otherwise from its first bytes.
+func detectContentType(name string, head []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternalObject(t *testing.T) {
+	listed := map[string]struct{}{"docs/a.txt": {}}
+
+	for key, want := range map[string]bool{
+		"docs/a.txt":                      false,
+		metadataPath("docs/a.txt"):        true,
+		"docs/a.txt.renditions/thumb.png": true,
+		"docs/a.txt.staging-1234":         true,
+		".buckt/folders/1234.meta.json":   true,
+		"docs/a.txt.meta.json":            false, // an uploaded file named like a sidecar
+		"docs/c.txt.renditions/thumb.png": false,
+	} {
+		assert.Equal(t, want, internalObject(key, listed), key)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	data := []byte(strings.Repeat("hello world ", 100))
+
+	hash, size, head, err := inspect(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, contentChecksum(data), hash)
+	assert.Equal(t, int64(len(data)), size)
+	assert.Equal(t, data[:512], head)
+
+	hash, size, head, err = inspect(bytes.NewReader([]byte("hi")))
+	assert.NoError(t, err)
+	assert.Equal(t, contentChecksum([]byte("hi")), hash)
+	assert.Equal(t, int64(2), size)
+	assert.Equal(t, []byte("hi"), head)
+}
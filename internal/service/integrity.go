@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/backend"
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+)
+
+// IntegrityService re-reads stored content and compares it with the checksum
+// recorded when it was written. Corrupt and missing content is restored from
+// another copy when the backend keeps one, see domain.RepairableBackend.
+//
+// Files uploaded before checksums covered the content only carry a hash of
+// their path and content. It is replaced by a content checksum once it is
+// matched; when it cannot be matched, for example because the file was moved
+// since, the file is marked unverified and keeps its hash until it is
+// re-baselined with RebaselineFile.
+//
+// Content is read below the backend cache, a cached copy proves nothing about
+// the stored object.
+type IntegrityService struct {
+	logger domain.BucktLogger
+
+	cache domain.CacheManager
+	repo  domain.FileRepository
+
+	fileBackend domain.FileBackend
+}
+
+func NewIntegrityService(
+	bucktLogger domain.BucktLogger,
+
+	cache domain.CacheManager,
+	fileRepository domain.FileRepository,
+
+	fileBackend domain.FileBackend,
+) domain.IntegrityService {
+	bucktLogger.Info("🚀 Initialising integrity services")
+	return &IntegrityService{
+		logger: bucktLogger,
+
+		cache: cache,
+		repo:  fileRepository,
+
+		fileBackend: fileBackend,
+	}
+}
+
+// VerifyFile implements domain.IntegrityService.
+func (s *IntegrityService) VerifyFile(ctx context.Context, file_id string) (model.VerifyResult, error) {
+	fileID, err := uuid.Parse(file_id)
+	if err != nil {
+		return model.VerifyResult{}, s.logger.WrapError("failed to parse uuid", err)
+	}
+
+	file, err := s.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return model.VerifyResult{}, s.logger.WrapError("failed to get file", err)
+	}
+
+	return s.verify(ctx, file)
+}
+
+// VerifyFiles implements domain.IntegrityService.
+func (s *IntegrityService) VerifyFiles(ctx context.Context, max_age time.Duration, limit int) (model.VerifyReport, error) {
+	var report model.VerifyReport
+
+	files, err := s.repo.GetFilesToVerify(ctx, time.Now().Add(-max_age), limit)
+	if err != nil {
+		return report, s.logger.WrapError("failed to get files to verify", err)
+	}
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		result, err := s.verify(ctx, file)
+		if err != nil {
+			s.logger.Errorf("failed to verify %s: %v", file.ID, err)
+			report.Failed++
+			continue
+		}
+
+		report.Verified++
+		if result.Repaired {
+			report.Repaired++
+		}
+		switch result.Status {
+		case model.IntegrityOK:
+			report.OK++
+		case model.IntegrityCorrupt:
+			report.Corrupt++
+		case model.IntegrityMissing:
+			report.Missing++
+		case model.IntegrityUnverified:
+			report.Unverified++
+		}
+	}
+
+	return report, nil
+}
+
+// RebaselineFile implements domain.IntegrityService.
+func (s *IntegrityService) RebaselineFile(ctx context.Context, file_id string) (model.VerifyResult, error) {
+	fileID, err := uuid.Parse(file_id)
+	if err != nil {
+		return model.VerifyResult{}, s.logger.WrapError("failed to parse uuid", err)
+	}
+
+	file, err := s.repo.GetFile(ctx, fileID)
+	if err != nil {
+		return model.VerifyResult{}, s.logger.WrapError("failed to get file", err)
+	}
+
+	result := model.VerifyResult{FileID: file.ID, Expected: file.Hash}
+
+	status, actual, err := s.check(ctx, file)
+	if err != nil {
+		return result, err
+	}
+
+	newHash := file.Hash
+	if status != model.IntegrityMissing {
+		s.logger.Warn(fmt.Sprintf("⚠️ Re-baselining file %s on its stored content", file.ID))
+		status = model.IntegrityOK
+		result.Migrated = actual != file.Hash
+		newHash = actual
+	}
+
+	return result, s.record(ctx, file, &result, status, actual, newHash)
+}
+
+func (s *IntegrityService) verify(ctx context.Context, file *model.FileModel) (model.VerifyResult, error) {
+	result := model.VerifyResult{FileID: file.ID, Expected: file.Hash}
+
+	status, actual, err := s.check(ctx, file)
+	if err != nil {
+		return result, err
+	}
+
+	if status != model.IntegrityOK {
+		repaired, err := s.repair(ctx, file)
+		if err != nil {
+			s.logger.Errorf("failed to repair %s: %v", file.ID, err)
+		}
+		if repaired {
+			result.Repaired = true
+			if status, actual, err = s.check(ctx, file); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	newHash := file.Hash
+	switch {
+	case status == model.IntegrityOK && actual != file.Hash:
+		// Matched the checksum of the previous format
+		result.Migrated = true
+		newHash = actual
+	case status == model.IntegrityCorrupt && (file.VerifiedAt == nil || file.Integrity == model.IntegrityUnverified):
+		// Never verified, the hash may be of the previous format and stale
+		// or the content may be corrupt, only a re-baseline can tell
+		s.logger.Warn(fmt.Sprintf("⚠️ File %s does not match its unverified hash, re-baseline it to accept its stored content", file.ID))
+		status = model.IntegrityUnverified
+	}
+
+	return result, s.record(ctx, file, &result, status, actual, newHash)
+}
+
+// record stores the outcome of verifying file in result and on the file.
+func (s *IntegrityService) record(ctx context.Context, file *model.FileModel, result *model.VerifyResult, status model.IntegrityStatus, actual, newHash string) error {
+	result.Status = status
+	result.Actual = actual
+	result.VerifiedAt = time.Now()
+
+	if err := s.repo.RecordVerification(ctx, file.ID, file.Hash, newHash, status, result.VerifiedAt); err != nil {
+		return s.logger.WrapError("failed to record verification", err)
+	}
+	if s.cache != nil {
+		_ = s.cache.DeleteBucktValue(ctx, file.ID.String())
+	}
+
+	return nil
+}
+
+// check reads the content of file and returns its status and content checksum.
+func (s *IntegrityService) check(ctx context.Context, file *model.FileModel) (model.IntegrityStatus, string, error) {
+	ctx = backend.WithoutCache(ctx)
+
+	rc, err := s.fileBackend.Stream(ctx, file.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return model.IntegrityMissing, "", nil
+		}
+		if exists, existsErr := s.fileBackend.Exists(ctx, file.Path); existsErr == nil && !exists {
+			return model.IntegrityMissing, "", nil
+		}
+		return "", "", s.logger.WrapError("failed to read file", err)
+	}
+	defer rc.Close()
+
+	content, legacy, err := checksums(rc, file.Path)
+	if err != nil {
+		return "", "", s.logger.WrapError("failed to read file", err)
+	}
+
+	if content == file.Hash || legacy == file.Hash {
+		return model.IntegrityOK, content, nil
+	}
+	return model.IntegrityCorrupt, content, nil
+}
+
+// repair restores the content of file from a copy that matches its hash.
+func (s *IntegrityService) repair(ctx context.Context, file *model.FileModel) (bool, error) {
+	repairer, ok := s.fileBackend.(domain.RepairableBackend)
+	if !ok {
+		return false, nil
+	}
+
+	return repairer.RepairObject(ctx, file.Path, func(r io.Reader) bool {
+		content, legacy, err := checksums(r, file.Path)
+		return err == nil && (content == file.Hash || legacy == file.Hash)
+	})
+}
+
+// contentChecksum is the checksum recorded for the content of a file.
+func contentChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksums returns the content checksum of r and the hash of path and
+// content files were uploaded with before.
+func checksums(r io.Reader, path string) (content, legacy string, err error) {
+	contentSum, legacySum := sha256.New(), sha256.New()
+	legacySum.Write([]byte(path))
+
+	if _, err := io.Copy(io.MultiWriter(contentSum, legacySum), r); err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(contentSum.Sum(nil)), hex.EncodeToString(legacySum.Sum(nil)), nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/backend"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestVerifyFile(t *testing.T) {
+	ctx := t.Context()
+	log := logger.NewLogger("", true, false)
+
+	data := []byte("hello world")
+	verified := time.Now().Add(-time.Hour)
+
+	t.Run("ok", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("memory")
+		repo := new(mocks.FileRepository)
+		file := &model.FileModel{ID: uuid.New(), Path: "docs/a.txt", Hash: contentChecksum(data), VerifiedAt: &verified}
+		assert.NoError(t, memory.Put(ctx, file.Path, data))
+
+		repo.On("GetFile", file.ID).Return(file, nil)
+		repo.On("RecordVerification", file.ID, file.Hash, file.Hash, model.IntegrityOK).Return(nil)
+
+		result, err := NewIntegrityService(log, mocks.NewNoopCache(), repo, memory).VerifyFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityOK, result.Status)
+		assert.False(t, result.Migrated)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("legacy hash is migrated", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("memory")
+		repo := new(mocks.FileRepository)
+		legacy := sha256.Sum256(append([]byte("docs/a.txt"), data...))
+		file := &model.FileModel{ID: uuid.New(), Path: "docs/a.txt", Hash: hex.EncodeToString(legacy[:])}
+		assert.NoError(t, memory.Put(ctx, file.Path, data))
+
+		repo.On("GetFile", file.ID).Return(file, nil)
+		repo.On("RecordVerification", file.ID, file.Hash, contentChecksum(data), model.IntegrityOK).Return(nil)
+
+		result, err := NewIntegrityService(log, mocks.NewNoopCache(), repo, memory).VerifyFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityOK, result.Status)
+		assert.True(t, result.Migrated)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("memory")
+		repo := new(mocks.FileRepository)
+		file := &model.FileModel{ID: uuid.New(), Path: "docs/a.txt", Hash: contentChecksum(data), VerifiedAt: &verified}
+		assert.NoError(t, memory.Put(ctx, file.Path, []byte("hello w0rld")))
+
+		repo.On("GetFile", file.ID).Return(file, nil)
+		repo.On("RecordVerification", file.ID, file.Hash, file.Hash, model.IntegrityCorrupt).Return(nil)
+
+		result, err := NewIntegrityService(log, mocks.NewNoopCache(), repo, memory).VerifyFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityCorrupt, result.Status)
+		assert.Equal(t, contentChecksum([]byte("hello w0rld")), result.Actual)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("unmatched legacy hash is unverified until re-baselined", func(t *testing.T) {
+		memory := mocks.NewMemoryBackend("memory")
+		repo := new(mocks.FileRepository)
+		legacy := sha256.Sum256(append([]byte("docs/old.txt"), data...))
+		file := &model.FileModel{ID: uuid.New(), Path: "docs/a.txt", Hash: hex.EncodeToString(legacy[:])}
+		assert.NoError(t, memory.Put(ctx, file.Path, data))
+
+		repo.On("GetFile", file.ID).Return(file, nil)
+		repo.On("RecordVerification", file.ID, file.Hash, file.Hash, model.IntegrityUnverified).Return(nil).Once()
+
+		svc := NewIntegrityService(log, mocks.NewNoopCache(), repo, memory)
+		result, err := svc.VerifyFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityUnverified, result.Status)
+		assert.False(t, result.Migrated)
+
+		repo.On("RecordVerification", file.ID, file.Hash, contentChecksum(data), model.IntegrityOK).Return(nil).Once()
+
+		result, err = svc.RebaselineFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityOK, result.Status)
+		assert.True(t, result.Migrated)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		repo := new(mocks.FileRepository)
+		file := &model.FileModel{ID: uuid.New(), Path: "docs/a.txt", Hash: contentChecksum(data), VerifiedAt: &verified}
+
+		repo.On("GetFile", file.ID).Return(file, nil)
+		repo.On("RecordVerification", file.ID, file.Hash, file.Hash, model.IntegrityMissing).Return(nil)
+
+		result, err := NewIntegrityService(log, mocks.NewNoopCache(), repo, mocks.NewMemoryBackend("memory")).VerifyFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityMissing, result.Status)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("repaired from replica", func(t *testing.T) {
+		a, b := mocks.NewMemoryBackend("a"), mocks.NewMemoryBackend("b")
+		replicated := backend.NewReplicatedBackend(log, 2, a, b)
+		repo := new(mocks.FileRepository)
+		file := &model.FileModel{ID: uuid.New(), Path: "docs/a.txt", Hash: contentChecksum(data), VerifiedAt: &verified}
+		assert.NoError(t, a.Put(ctx, file.Path, []byte("hello w0rld")))
+		assert.NoError(t, b.Put(ctx, file.Path, data))
+
+		repo.On("GetFile", file.ID).Return(file, nil)
+		repo.On("RecordVerification", file.ID, file.Hash, file.Hash, model.IntegrityOK).Return(nil)
+
+		result, err := NewIntegrityService(log, mocks.NewNoopCache(), repo, replicated).VerifyFile(ctx, file.ID.String())
+		assert.NoError(t, err)
+		assert.Equal(t, model.IntegrityOK, result.Status)
+		assert.True(t, result.Repaired)
+		assert.Equal(t, data, a.Objects()[file.Path])
+		repo.AssertExpectations(t)
+	})
+}
+
+func TestVerifyFiles(t *testing.T) {
+	ctx := t.Context()
+	log := logger.NewLogger("", true, false)
+
+	memory := mocks.NewMemoryBackend("memory")
+	repo := new(mocks.FileRepository)
+	verified := time.Now().Add(-48 * time.Hour)
+
+	good := &model.FileModel{ID: uuid.New(), Path: "good.txt", Hash: contentChecksum([]byte("good")), VerifiedAt: &verified}
+	bad := &model.FileModel{ID: uuid.New(), Path: "bad.txt", Hash: contentChecksum([]byte("bad")), VerifiedAt: &verified}
+	gone := &model.FileModel{ID: uuid.New(), Path: "gone.txt", Hash: contentChecksum([]byte("gone")), VerifiedAt: &verified}
+	assert.NoError(t, memory.Put(ctx, good.Path, []byte("good")))
+	assert.NoError(t, memory.Put(ctx, bad.Path, []byte("b4d")))
+
+	repo.On("GetFilesToVerify", mock.Anything, 10).Return([]*model.FileModel{good, bad, gone}, nil)
+	repo.On("RecordVerification", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	report, err := NewIntegrityService(log, mocks.NewNoopCache(), repo, memory).VerifyFiles(ctx, 24*time.Hour, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, model.VerifyReport{Verified: 3, OK: 1, Corrupt: 1, Missing: 1}, report)
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/database"
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// OperationLease is how long the entries of an instance that stopped renewing
+// them stay in the journal before other instances recover them.
+const OperationLease = 5 * time.Minute
+
+// operations records the mutations of a service that touch both the database
+// and the backend in the journal. Entries are written outside of the
+// transaction of the caller, so they outlive a crash before its commit, and
+// deleted once the last step has run.
+type operations struct {
+	logger  domain.BucktLogger
+	journal domain.OperationRepository
+	owner   uuid.UUID // Instance leasing the entries, it renews them with OperationRepository.Renew
+
+	mu     sync.Mutex
+	failed map[uuid.UUID]*model.OperationModel // Operations whose steps after the commit failed
+}
+
+// WithJournal records every mutation that touches both the database and the
+// backend before it runs, so RecoverOperations can complete or roll back the
+// mutations a crash interrupted. Entries are leased by owner, which has to
+// renew them more often than OperationLease.
+//
+// On SQLite the journal joins the transaction of the caller, see
+// database.DB.Detached, so mutations in a transaction interrupted before its
+// commit are not recovered and their objects are left to ReconcileService.
+func WithJournal(journal domain.OperationRepository, owner uuid.UUID) FileServiceOption {
+	return func(f *FileService) {
+		f.ops.journal = journal
+		f.ops.owner = owner
+	}
+}
+
+// begin records op before its first step runs.
+func (o *operations) begin(ctx context.Context, op *model.OperationModel) error {
+	if op.ID == uuid.Nil {
+		op.ID = uuid.New()
+	}
+	if o.journal == nil {
+		return nil
+	}
+	op.Owner = o.owner
+	op.HeartbeatAt = time.Now()
+	if err := o.journal.Create(ctx, op); err != nil {
+		return o.logger.WrapError("failed to record operation", err)
+	}
+	return nil
+}
+
+// commit removes op from the journal once its last step has run.
+func (o *operations) commit(ctx context.Context, op *model.OperationModel) error {
+	if o.journal == nil {
+		return nil
+	}
+	if err := o.journal.Delete(ctx, op.ID); err != nil {
+		return o.logger.WrapError("failed to commit operation", err)
+	}
+	return nil
+}
+
+// onRollback removes op from the journal when the transaction of ctx is
+// rolled back, once undo has reverted its backend writes. When undo fails the
+// operation stays in the journal for recovery.
+func (o *operations) onRollback(ctx context.Context, op *model.OperationModel, undo func(ctx context.Context) error) {
+	database.OnRollback(ctx, func(ctx context.Context) error {
+		if undo != nil {
+			if err := undo(ctx); err != nil {
+				return err
+			}
+		}
+		return o.commit(ctx, op)
+	})
+}
+
+// finish runs the steps of op left after the commit. The database step cannot
+// be undone any more, so failures are logged and op is kept for retry.
+func (o *operations) finish(ctx context.Context, op *model.OperationModel, steps func(context.Context, *model.OperationModel) error) error {
+	if err := steps(ctx, op); err != nil {
+		o.logger.Errorf("operation %s (%s of %s) will be retried: %v", op.ID, op.Type, op.Path, err)
+
+		o.mu.Lock()
+		if o.failed == nil {
+			o.failed = make(map[uuid.UUID]*model.OperationModel)
+		}
+		o.failed[op.ID] = op
+		o.mu.Unlock()
+	}
+	return nil
+}
+
+// retry recovers the operations whose steps after the commit failed.
+func (o *operations) retry(ctx context.Context, recoverOp func(context.Context, *model.OperationModel) error) (int, error) {
+	o.mu.Lock()
+	ops := make([]*model.OperationModel, 0, len(o.failed))
+	for _, op := range o.failed {
+		ops = append(ops, op)
+	}
+	o.mu.Unlock()
+
+	retried := 0
+	for _, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return retried, err
+		}
+
+		if err := recoverOp(ctx, op); err != nil {
+			o.logger.Errorf("failed to retry operation %s (%s of %s): %v", op.ID, op.Type, op.Path, err)
+			continue
+		}
+
+		o.mu.Lock()
+		delete(o.failed, op.ID)
+		o.mu.Unlock()
+		retried++
+	}
+
+	return retried, nil
+}
+
+// recover recovers the operations that owns reports for left in the journal
+// by instances whose lease has expired.
+func (o *operations) recover(ctx context.Context, owns func(*model.OperationModel) bool, recoverOp func(context.Context, *model.OperationModel) error) (int, error) {
+	if o.journal == nil {
+		return 0, nil
+	}
+
+	ops, err := o.journal.GetExpired(ctx, o.owner, time.Now().Add(-OperationLease))
+	if err != nil {
+		return 0, o.logger.WrapError("failed to get unfinished operations", err)
+	}
+
+	recovered := 0
+	for _, op := range ops {
+		if !owns(op) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return recovered, err
+		}
+
+		if err := recoverOp(ctx, op); err != nil {
+			o.logger.Errorf("failed to recover operation %s (%s of %s): %v", op.ID, op.Type, op.Path, err)
+			continue
+		}
+		recovered++
+	}
+
+	return recovered, nil
+}
+
+// abort undoes the backend writes of an operation whose database step failed.
+// When that fails too the operation stays in the journal for recovery.
+func (f *FileService) abort(ctx context.Context, op *model.OperationModel, path string) {
+	if err := ignoreNotExist(f.fileBackend.Delete(ctx, path)); err != nil {
+		f.logger.Errorf("failed to roll back operation %s: %v", op.ID, err)
+		return
+	}
+	_ = f.ops.commit(ctx, op)
+}
+
+// replaceContent stores data as the new content of file, whose fields already
+// describe it. The content is written to a staging object first so the
+// current object stays intact until the row has been updated.
+func (f *FileService) replaceContent(ctx context.Context, file *model.FileModel, oldPath string, data []byte) error {
+	op := &model.OperationModel{
+		ID:      uuid.New(),
+		Type:    model.OperationReplaceFile,
+		FileID:  file.ID,
+		Path:    file.Path,
+		OldPath: oldPath,
+		Hash:    file.Hash,
+	}
+	op.StagingPath = stagingPath(op)
+
+	if err := f.ops.begin(ctx, op); err != nil {
+		return err
+	}
+
+	if err := f.fileBackend.Put(ctx, op.StagingPath, data); err != nil {
+		f.abort(ctx, op, op.StagingPath)
+		return err
+	}
+	f.ops.onRollback(ctx, op, func(ctx context.Context) error {
+		return ignoreNotExist(f.fileBackend.Delete(ctx, op.StagingPath))
+	})
+
+	if err := f.repo.Update(ctx, file); err != nil {
+		f.abort(ctx, op, op.StagingPath)
+		return f.logger.WrapError("failed to update file", err)
+	}
+
+	// The row is updated for good, moving the content into place is retried until it succeeds
+	_ = database.AfterCommit(ctx, func(ctx context.Context) error {
+		return f.ops.finish(ctx, op, f.finishReplace)
+	})
+	return nil
+}
+
+// finishReplace moves the staging object of a replace into place once the row
+// has been updated, and deletes the previous object when the path changed.
+func (f *FileService) finishReplace(ctx context.Context, op *model.OperationModel) error {
+	staged, err := f.fileBackend.Exists(ctx, op.StagingPath)
+	if err != nil {
+		return err
+	}
+	if staged {
+		if err := f.fileBackend.Move(ctx, op.StagingPath, op.Path); err != nil {
+			return f.logger.WrapError("failed to move file into place", err)
+		}
+	}
+
+	if op.OldPath != "" && op.OldPath != op.Path {
+		if err := ignoreNotExist(f.fileBackend.Delete(ctx, op.OldPath)); err != nil {
+			return f.logger.WrapError("failed to delete previous content", err)
+		}
+	}
+
+	return f.ops.commit(ctx, op)
+}
+
+// finishMove moves the object of a moved file once the row points to its new path.
+func (f *FileService) finishMove(ctx context.Context, op *model.OperationModel) error {
+	pending, err := f.fileBackend.Exists(ctx, op.OldPath)
+	if err != nil {
+		return err
+	}
+	if pending {
+		if err := f.fileBackend.Move(ctx, op.OldPath, op.Path); err != nil {
+			return f.logger.WrapError("failed to move file", err)
+		}
+	}
+
+	return f.ops.commit(ctx, op)
+}
+
+// RetryOperations implements domain.FileService.
+// It finishes the operations whose steps after the commit failed, such as
+// moving an object. Operations still running are left alone, unlike
+// RecoverOperations.
+func (f *FileService) RetryOperations(ctx context.Context) (int, error) {
+	return f.ops.retry(ctx, f.recoverOperation)
+}
+
+// finishScrub deletes the object of a scrubbed file once its row is gone.
+func (f *FileService) finishScrub(ctx context.Context, op *model.OperationModel) error {
+	if err := ignoreNotExist(f.fileBackend.Delete(ctx, op.Path)); err != nil {
+		return err
+	}
+	f.deleteMetadata(ctx, op.Path)
+	return f.ops.commit(ctx, op)
+}
+
+// RecoverOperations implements domain.FileService.
+// Every file operation left in the journal by an instance that stopped is
+// completed when its database step was applied and rolled back otherwise.
+// Operations are only recovered once their lease has expired, so after a
+// restart those of the previous run are recovered after OperationLease.
+func (f *FileService) RecoverOperations(ctx context.Context) (int, error) {
+	return f.ops.recover(ctx, isFileOperation, f.recoverOperation)
+}
+
+func (f *FileService) recoverOperation(ctx context.Context, op *model.OperationModel) error {
+	file, err := f.repo.GetFile(ctx, op.FileID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	found := err == nil
+
+	switch op.Type {
+	case model.OperationCreateFile:
+		// The row was inserted after the object was written
+		if !found {
+			if err := ignoreNotExist(f.fileBackend.Delete(ctx, op.Path)); err != nil {
+				return err
+			}
+		}
+	case model.OperationReplaceFile:
+		if found && file.Hash == op.Hash {
+			return f.finishReplace(ctx, op)
+		}
+		if err := ignoreNotExist(f.fileBackend.Delete(ctx, op.StagingPath)); err != nil {
+			return err
+		}
+	case model.OperationScrubFile:
+		// The object is deleted after the row
+		if !found {
+			return f.finishScrub(ctx, op)
+		}
+	case model.OperationMoveFile:
+		// The object is moved after the row
+		if found && file.Path == op.Path {
+			return f.finishMove(ctx, op)
+		}
+	}
+
+	return f.ops.commit(ctx, op)
+}
+
+// isFileOperation reports whether op belongs to the FileService, folder
+// operations are recovered by the FolderService.
+func isFileOperation(op *model.OperationModel) bool {
+	return op.FolderID == uuid.Nil
+}
+
+// stagingPath returns where the new content of a replace is written.
+func stagingPath(op *model.OperationModel) string {
+	return op.Path + ".staging-" + op.ID.String()
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,514 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// metadataSuffix is appended to the key of every sidecar.
+	metadataSuffix = ".meta.json"
+
+	// metadataPrefix is reserved for sidecars, so they cannot collide with
+	// the objects of files whatever their names.
+	metadataPrefix = ".buckt/"
+	// fileMetadataPrefix holds the sidecars of files, keyed by object path.
+	fileMetadataPrefix = metadataPrefix + "files/"
+	// folderMetadataPrefix holds the sidecars of folders, which have no object.
+	folderMetadataPrefix = metadataPrefix + "folders/"
+)
+
+// metadataPath returns the sidecar of the object at path.
+func metadataPath(path string) string {
+	return fileMetadataPrefix + objectKey(path) + metadataSuffix
+}
+
+// metadataObject returns the object the file sidecar at key belongs to, and
+// false when key is no file sidecar.
+func metadataObject(key string) (string, bool) {
+	if !strings.HasPrefix(key, fileMetadataPrefix) || !strings.HasSuffix(key, metadataSuffix) {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(key, fileMetadataPrefix), metadataSuffix), true
+}
+
+// folderMetadataPath returns the sidecar of the folder with the given ID.
+func folderMetadataPath(folder_id string) string {
+	return folderMetadataPrefix + folder_id + metadataSuffix
+}
+
+// MetadataService writes a JSON sidecar for every object, describing the
+// file it belongs to and where that file sits in the folder tree. When the
+// database is lost the tree can be rebuilt from the sidecars alone, also with
+// flat namespaces where object paths carry no names.
+type MetadataService struct {
+	logger domain.BucktLogger
+
+	folderRepo domain.FolderRepository
+	fileRepo   domain.FileRepository
+
+	backend domain.FileBackend
+}
+
+func NewMetadataService(
+	bucktLogger domain.BucktLogger,
+
+	folderRepository domain.FolderRepository,
+	fileRepository domain.FileRepository,
+
+	backend domain.FileBackend,
+) domain.MetadataService {
+	bucktLogger.Info("🚀 Initialising metadata services")
+	return &MetadataService{
+		logger: bucktLogger,
+
+		folderRepo: folderRepository,
+		fileRepo:   fileRepository,
+
+		backend: backend,
+	}
+}
+
+// WriteFileMetadata implements domain.MetadataService.
+func (m *MetadataService) WriteFileMetadata(ctx context.Context, file *model.FileModel, parent *model.FolderModel) error {
+	return m.put(ctx, metadataPath(file.Path), fileMetadata(file, parent))
+}
+
+// fileMetadata describes file, parent is the folder it is in.
+func fileMetadata(file *model.FileModel, parent *model.FolderModel) model.FileMetadata {
+	meta := model.FileMetadata{
+		Version:     model.MetadataVersion,
+		ID:          file.ID,
+		Name:        file.Name,
+		Path:        file.Path,
+		ParentID:    file.ParentID,
+		ContentType: file.ContentType,
+		Size:        file.Size,
+		Hash:        file.Hash,
+		CreatedAt:   file.CreatedAt,
+		UpdatedAt:   file.UpdatedAt,
+		DeletedAt:   deletedAt(file.DeletedAt),
+	}
+	if parent != nil {
+		meta.ParentPath = parent.Path
+		meta.Owner = parent.UserID
+	}
+	return meta
+}
+
+// DeleteFileMetadata implements domain.MetadataService.
+func (m *MetadataService) DeleteFileMetadata(ctx context.Context, path string) error {
+	return ignoreNotExist(m.backend.Delete(ctx, metadataPath(path)))
+}
+
+// WriteFolderMetadata implements domain.MetadataService.
+func (m *MetadataService) WriteFolderMetadata(ctx context.Context, folder *model.FolderModel) error {
+	return m.put(ctx, folderMetadataPath(folder.ID.String()), folderMetadata(folder))
+}
+
+// folderMetadata describes folder.
+func folderMetadata(folder *model.FolderModel) model.FolderMetadata {
+	return model.FolderMetadata{
+		Version:     model.MetadataVersion,
+		ID:          folder.ID,
+		Name:        folder.Name,
+		Description: folder.Description,
+		Path:        folder.Path,
+		ParentID:    folder.ParentID,
+		Owner:       folder.UserID,
+		CreatedAt:   folder.CreatedAt,
+		UpdatedAt:   folder.UpdatedAt,
+		DeletedAt:   deletedAt(folder.DeletedAt),
+	}
+}
+
+// DeleteFolderMetadata implements domain.MetadataService.
+func (m *MetadataService) DeleteFolderMetadata(ctx context.Context, folder_id string) error {
+	return ignoreNotExist(m.backend.Delete(ctx, folderMetadataPath(folder_id)))
+}
+
+func (m *MetadataService) put(ctx context.Context, key string, meta any) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return m.backend.Put(ctx, key, data)
+}
+
+// RebuildIndex implements domain.MetadataService.
+// Folders and files keep the IDs recorded in their sidecars. Folders a file
+// sidecar refers to without a sidecar of their own are recreated from its
+// parent path. What is already indexed is left alone, so a rebuild can be
+// run again after resolving conflicts.
+func (m *MetadataService) RebuildIndex(ctx context.Context, opts model.RebuildOptions) (model.RebuildReport, error) {
+	r := &rebuild{
+		MetadataService: m,
+		opts:            opts,
+		report:          model.RebuildReport{DryRun: opts.DryRun},
+		folderIDs:       map[uuid.UUID]uuid.UUID{},
+		folderPaths:     map[string]uuid.UUID{},
+		fileNames:       map[string]struct{}{},
+		folders:         map[uuid.UUID]*model.FolderMetadata{},
+	}
+
+	keys, err := m.backend.List(ctx, metadataPrefix)
+	if err != nil {
+		return r.report, m.logger.WrapError("failed to list objects", err)
+	}
+
+	files, err := r.readSidecars(ctx, keys)
+	if err != nil {
+		return r.report, err
+	}
+
+	// Parents are restored before their children
+	folders := make([]*model.FolderMetadata, 0, len(r.folders))
+	for _, meta := range r.folders {
+		folders = append(folders, meta)
+	}
+	slices.SortFunc(folders, parentsFirst)
+
+	for _, meta := range folders {
+		if err := ctx.Err(); err != nil {
+			return r.report, err
+		}
+		r.restoreFolder(ctx, meta)
+	}
+
+	for _, sidecar := range files {
+		if err := ctx.Err(); err != nil {
+			return r.report, err
+		}
+		r.restoreFile(ctx, sidecar.key, sidecar.meta)
+	}
+
+	return r.report, nil
+}
+
+// rebuild is the state of a single RebuildIndex run.
+type rebuild struct {
+	*MetadataService
+
+	opts   model.RebuildOptions
+	report model.RebuildReport
+
+	// folderIDs maps the folder IDs of sidecars to the folders they are indexed as
+	folderIDs map[uuid.UUID]uuid.UUID
+	// folderPaths maps paths to the folders indexed there, including those a dry run would create
+	folderPaths map[string]uuid.UUID
+	// fileNames holds the parent and name of the files restored so far
+	fileNames map[string]struct{}
+
+	folders map[uuid.UUID]*model.FolderMetadata
+}
+
+type fileSidecar struct {
+	key  string
+	meta *model.FileMetadata
+}
+
+// readSidecars loads the folder sidecars into r.folders and returns the file
+// sidecars ordered by key.
+func (r *rebuild) readSidecars(ctx context.Context, keys []string) ([]fileSidecar, error) {
+	var files []fileSidecar
+
+	slices.Sort(keys)
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		normalised := objectKey(key)
+		_, isFile := metadataObject(normalised)
+		if !isFile && !(strings.HasPrefix(normalised, folderMetadataPrefix) && strings.HasSuffix(normalised, metadataSuffix)) {
+			continue
+		}
+
+		data, err := r.backend.Get(ctx, key)
+		if err != nil {
+			r.conflict(key, uuid.Nil, "", "failed to read sidecar: "+err.Error())
+			continue
+		}
+
+		if strings.HasPrefix(normalised, folderMetadataPrefix) {
+			var meta model.FolderMetadata
+			if err := json.Unmarshal(data, &meta); err != nil || meta.Version == 0 || meta.ID == uuid.Nil {
+				r.conflict(key, uuid.Nil, "", "invalid sidecar")
+				continue
+			}
+			r.report.Sidecars++
+			r.folders[meta.ID] = &meta
+			continue
+		}
+
+		var meta model.FileMetadata
+		if err := json.Unmarshal(data, &meta); err != nil || meta.Version == 0 || meta.ID == uuid.Nil {
+			r.conflict(key, uuid.Nil, "", "invalid sidecar")
+			continue
+		}
+		r.report.Sidecars++
+		files = append(files, fileSidecar{key: key, meta: &meta})
+	}
+
+	return files, nil
+}
+
+func (r *rebuild) restoreFolder(ctx context.Context, meta *model.FolderMetadata) {
+	key := folderMetadataPath(meta.ID.String())
+
+	existing, err := r.folderRepo.GetFolderByPath(ctx, meta.Path)
+	switch {
+	case err == nil && existing.ID == meta.ID:
+		r.folderIDs[meta.ID] = existing.ID
+		r.folderPaths[meta.Path] = existing.ID
+		r.report.Indexed++
+		return
+	case err == nil:
+		// Children of the folder are restored into the one already indexed
+		r.folderIDs[meta.ID] = existing.ID
+		r.folderPaths[meta.Path] = existing.ID
+		r.conflict(key, meta.ID, meta.Path, "path is indexed as folder "+existing.ID.String())
+		return
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		r.conflict(key, meta.ID, meta.Path, err.Error())
+		return
+	}
+
+	if _, err := r.ensureFolder(ctx, meta.Path, meta.ID, meta.Owner); err != nil {
+		r.conflict(key, meta.ID, meta.Path, err.Error())
+	}
+}
+
+func (r *rebuild) restoreFile(ctx context.Context, key string, meta *model.FileMetadata) {
+	existing, err := r.fileRepo.GetFileByPath(ctx, meta.Path)
+	switch {
+	case err == nil && existing.ID == meta.ID:
+		r.report.Indexed++
+		return
+	case err == nil:
+		r.conflict(key, meta.ID, meta.Path, "path is indexed as file "+existing.ID.String())
+		return
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		r.conflict(key, meta.ID, meta.Path, err.Error())
+		return
+	}
+
+	exists, err := r.backend.Exists(ctx, meta.Path)
+	if err != nil {
+		r.conflict(key, meta.ID, meta.Path, err.Error())
+		return
+	}
+	if !exists {
+		r.conflict(key, meta.ID, meta.Path, "object is missing")
+		return
+	}
+
+	if meta.ParentPath == "" || meta.Owner == "" {
+		r.conflict(key, meta.ID, meta.Path, "sidecar has no parent folder")
+		return
+	}
+	parentID, err := r.ensureFolder(ctx, meta.ParentPath, meta.ParentID, meta.Owner)
+	if err != nil {
+		r.conflict(key, meta.ID, meta.Path, err.Error())
+		return
+	}
+
+	name := parentID.String() + "/" + meta.Name
+	if _, ok := r.fileNames[name]; ok {
+		r.conflict(key, meta.ID, meta.Path, "name is taken in folder "+parentID.String())
+		return
+	}
+	if existing, err := r.fileRepo.GetFileByName(ctx, parentID, meta.Name); err == nil {
+		r.conflict(key, meta.ID, meta.Path, "name is indexed as file "+existing.ID.String())
+		return
+	}
+
+	file := &model.FileModel{
+		ID:          meta.ID,
+		Name:        meta.Name,
+		Path:        meta.Path,
+		ContentType: meta.ContentType,
+		Size:        meta.Size,
+		ParentID:    parentID,
+		Hash:        meta.Hash,
+		CreatedAt:   meta.CreatedAt,
+		UpdatedAt:   meta.UpdatedAt,
+		DeletedAt:   softDeleted(meta.DeletedAt),
+	}
+	if !r.opts.DryRun {
+		if err := r.fileRepo.Create(ctx, file); err != nil {
+			r.conflict(key, meta.ID, meta.Path, err.Error())
+			return
+		}
+	}
+
+	r.fileNames[name] = struct{}{}
+	r.report.FilesCreated++
+}
+
+// ensureFolder returns the folder indexed at p, creating it and its missing
+// ancestors first. The folder is created with id and the fields of its
+// sidecar when there is one.
+func (r *rebuild) ensureFolder(ctx context.Context, p string, id uuid.UUID, owner string) (uuid.UUID, error) {
+	if indexed, ok := r.folderIDs[id]; ok && id != uuid.Nil {
+		return indexed, nil
+	}
+	if indexed, ok := r.folderPaths[p]; ok {
+		if id != uuid.Nil {
+			r.folderIDs[id] = indexed
+		}
+		return indexed, nil
+	}
+
+	existing, err := r.folderRepo.GetFolderByPath(ctx, p)
+	if err == nil {
+		r.folderPaths[p] = existing.ID
+		if id != uuid.Nil {
+			r.folderIDs[id] = existing.ID
+		}
+		return existing.ID, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, err
+	}
+
+	folder := &model.FolderModel{
+		ID:     id,
+		UserID: owner,
+		Name:   path.Base(p),
+		Path:   p,
+	}
+	root := p == "/"+owner+"/root_folder"
+
+	if meta, ok := r.folders[id]; ok {
+		folder.UserID = meta.Owner
+		folder.Name = meta.Name
+		folder.Description = meta.Description
+		folder.CreatedAt = meta.CreatedAt
+		folder.UpdatedAt = meta.UpdatedAt
+		folder.DeletedAt = softDeleted(meta.DeletedAt)
+		root = meta.ParentID == nil
+	} else if root {
+		folder.Description = "Root folder"
+	}
+
+	if !root {
+		parent := path.Dir(p)
+		if parent == p || parent == "/" || parent == "." {
+			return uuid.Nil, fmt.Errorf("folder %s has no root folder", p)
+		}
+
+		var parentID uuid.UUID
+		if meta, ok := r.folders[id]; ok && meta.ParentID != nil {
+			parentID = *meta.ParentID
+			if parentMeta, ok := r.folders[parentID]; ok {
+				parent = parentMeta.Path
+			}
+		}
+
+		if parentID, err = r.ensureFolder(ctx, parent, parentID, folder.UserID); err != nil {
+			return uuid.Nil, err
+		}
+		folder.ParentID = &parentID
+	}
+
+	if r.opts.DryRun {
+		if folder.ID == uuid.Nil {
+			folder.ID = uuid.New()
+		}
+	} else if _, err := r.folderRepo.Create(ctx, folder); err != nil {
+		return uuid.Nil, err
+	}
+
+	r.folderPaths[p] = folder.ID
+	if id != uuid.Nil {
+		r.folderIDs[id] = folder.ID
+	}
+	r.report.FoldersCreated++
+	return folder.ID, nil
+}
+
+func (r *rebuild) conflict(sidecar string, id uuid.UUID, path, reason string) {
+	r.report.Conflicts = append(r.report.Conflicts, model.RebuildConflict{Sidecar: sidecar, ID: id, Path: path, Reason: reason})
+}
+
+// parentsFirst orders folders by depth, then by path.
+func parentsFirst(a, b *model.FolderMetadata) int {
+	if d := strings.Count(a.Path, "/") - strings.Count(b.Path, "/"); d != 0 {
+		return d
+	}
+	return strings.Compare(a.Path, b.Path)
+}
+
+func deletedAt(deleted gorm.DeletedAt) *time.Time {
+	if !deleted.Valid {
+		return nil
+	}
+	return &deleted.Time
+}
+
+func softDeleted(t *time.Time) gorm.DeletedAt {
+	if t == nil {
+		return gorm.DeletedAt{}
+	}
+	return gorm.DeletedAt{Time: *t, Valid: true}
+}
+
+// writeMetadata writes the sidecar of file. The sidecar only matters once the
+// database is lost, so failing to write it does not fail the operation.
+func (f *FileService) writeMetadata(ctx context.Context, file *model.FileModel, parent *model.FolderModel) {
+	if f.metadata == nil {
+		return
+	}
+
+	if parent == nil {
+		var err error
+		if parent, err = f.folderService.GetFolder(ctx, "", file.ParentID.String()); err != nil {
+			f.logger.Errorf("failed to get parent folder of %s: %v", file.ID, err)
+			return
+		}
+	}
+
+	if err := f.metadata.WriteFileMetadata(ctx, file, parent); err != nil {
+		f.logger.Errorf("failed to write metadata of %s: %v", file.ID, err)
+	}
+}
+
+// refreshMetadata rewrites the sidecar of the file after a rename or move,
+// and deletes the one at oldPath when the object moved.
+func (f *FileService) refreshMetadata(ctx context.Context, fileID uuid.UUID, oldPath string) {
+	if f.metadata == nil {
+		return
+	}
+
+	file, err := f.repo.GetFile(ctx, fileID)
+	if err != nil {
+		f.logger.Errorf("failed to get file %s: %v", fileID, err)
+		return
+	}
+
+	f.writeMetadata(ctx, file, nil)
+	if oldPath != "" && oldPath != file.Path {
+		f.deleteMetadata(ctx, oldPath)
+	}
+}
+
+func (f *FileService) deleteMetadata(ctx context.Context, path string) {
+	if f.metadata == nil {
+		return
+	}
+	if err := f.metadata.DeleteFileMetadata(ctx, path); err != nil {
+		f.logger.Errorf("failed to delete metadata of %s: %v", path, err)
+	}
+}
@@ -1,116 +1,273 @@
 package service
 
 import (
-	"encoding/json"
-	"os"
-	"path/filepath"
+	"context"
+	"errors"
 	"sync"
 	"time"
 
-	"maps"
-
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
 	"github.com/Rhaqim/buckt/internal/model"
 )
 
-type MetadataService interface {
-	GetMetadata(filePath string) (map[string]interface{}, error)
-	InsertMetadata(filePath string, data map[string]interface{}) error
-	SyncMetadata(filePath string, data map[string]interface{}) error
-	RestoreFromMetadata() error
-}
+// migrationEventBuffer is the number of events a subscriber can fall behind
+// before events are dropped for it.
+const migrationEventBuffer = 64
+
+// MigrationService runs migrations of a migration backend in the background.
+// One migration runs at a time, it can be paused, resumed and cancelled and
+// its progress is published to subscribers.
+type MigrationService struct {
+	logger  domain.BucktLogger
+	backend domain.MigratableBackend
 
-type metadataService struct {
 	mu       sync.Mutex
-	metaPath string
+	progress model.MigrationProgress
+	cancel   context.CancelFunc
+	resume   chan struct{} // Closed when the paused migration resumes, nil while running
+	done     chan struct{} // Closed when the migration stopped
+
+	elapsed   time.Duration // Time spent running up to the last pause
+	resumedAt time.Time
+
+	subscribers map[int]chan model.MigrationEvent
+	nextID      int
 }
 
-func NewMetadataService(metaPath string) MetadataService {
-	return &metadataService{
-		metaPath: metaPath,
+var _ domain.MigrationService = (*MigrationService)(nil)
+
+func NewMigrationService(bucktLogger domain.BucktLogger, backend domain.MigratableBackend) domain.MigrationService {
+	return &MigrationService{
+		logger:      bucktLogger,
+		backend:     backend,
+		progress:    model.MigrationProgress{State: model.MigrationIdle},
+		subscribers: make(map[int]chan model.MigrationEvent),
 	}
 }
 
-func (s *metadataService) GetMetadata(filePath string) (map[string]interface{}, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Start implements domain.MigrationService.
+func (m *MigrationService) Start(prefix string, opts model.MigrationOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	metaFile := s.getMetadataFilePath(filePath)
-	data, err := os.ReadFile(metaFile)
-	if err != nil {
-		return nil, err
+	if m.active() {
+		return errs.ErrMigrationRunning
 	}
 
-	var metadata map[string]interface{}
-	if err := json.Unmarshal(data, &metadata); err != nil {
-		return nil, err
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.resume = nil
+	m.done = make(chan struct{})
+
+	now := time.Now()
+	m.progress = model.MigrationProgress{State: model.MigrationRunning, Prefix: prefix, StartedAt: now}
+	m.elapsed = 0
+	m.resumedAt = now
+	m.publish("", "")
+
+	go m.run(ctx, prefix, opts, m.done)
+	return nil
+}
+
+func (m *MigrationService) run(ctx context.Context, prefix string, opts model.MigrationOptions, done chan struct{}) {
+	defer close(done)
+
+	err := m.backend.Migrate(ctx, model.MigrateOptions{
+		Prefix:      prefix,
+		Concurrency: opts.Concurrency,
+		Wait:        m.wait,
+		Queued: func(total int64) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.progress.Total = total
+			m.publish("", "")
+		},
+		Progress: func(path string, size int64, err error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if err != nil {
+				m.progress.Failed++
+				m.publish(path, err.Error())
+				return
+			}
+			m.progress.Completed++
+			m.progress.Bytes += size
+			m.publish(path, "")
+		},
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.progress.State == model.MigrationRunning {
+		m.elapsed += time.Since(m.resumedAt)
 	}
+	switch {
+	case err == nil:
+		m.progress.State = model.MigrationCompleted
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil:
+		m.progress.State = model.MigrationCancelled
+	default:
+		m.progress.State = model.MigrationFailed
+		m.progress.Error = err.Error()
+		m.logger.Errorf("migration failed: %v", err)
+	}
+	m.cancel()
+	m.resume = nil
+	m.publish("", "")
+}
 
-	return metadata, nil
+// wait blocks while the migration is paused.
+func (m *MigrationService) wait(ctx context.Context) error {
+	m.mu.Lock()
+	resume := m.resume
+	m.mu.Unlock()
+
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (s *metadataService) InsertMetadata(filePath string, data map[string]interface{}) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Pause implements domain.MigrationService.
+func (m *MigrationService) Pause() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	metaFile := s.getMetadataFilePath(filePath)
-	fileData, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		return err
+	if m.progress.State != model.MigrationRunning {
+		return errs.ErrMigrationNotRunning
 	}
 
-	return os.WriteFile(metaFile, fileData, 0644)
+	m.resume = make(chan struct{})
+	m.elapsed += time.Since(m.resumedAt)
+	m.progress.State = model.MigrationPaused
+	m.publish("", "")
+	return nil
 }
 
-func (s *metadataService) SyncMetadata(filePath string, data map[string]interface{}) error {
-	existingData, err := s.GetMetadata(filePath)
-	if err != nil {
-		existingData = make(map[string]interface{}) // Create new if not found
+// Resume implements domain.MigrationService.
+func (m *MigrationService) Resume() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.progress.State != model.MigrationPaused {
+		return errs.ErrMigrationNotPaused
 	}
 
-	maps.Copy(existingData, data)
+	close(m.resume)
+	m.resume = nil
+	m.resumedAt = time.Now()
+	m.progress.State = model.MigrationRunning
+	m.publish("", "")
+	return nil
+}
+
+// Cancel implements domain.MigrationService.
+// It returns once the migration stopped, objects that were being copied are
+// retried by the next migration.
+func (m *MigrationService) Cancel() error {
+	m.mu.Lock()
+	if !m.active() {
+		m.mu.Unlock()
+		return errs.ErrMigrationNotRunning
+	}
+	m.cancel()
+	done := m.done
+	m.mu.Unlock()
 
-	return s.InsertMetadata(filePath, existingData)
+	<-done
+	return nil
 }
 
-func (s *metadataService) RestoreFromMetadata() error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Progress implements domain.MigrationService.
+func (m *MigrationService) Progress() model.MigrationProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.snapshot()
+}
 
-	files, err := filepath.Glob(filepath.Join(s.metaPath, "*.json"))
-	if err != nil {
-		return err
-	}
+// Subscribe implements domain.MigrationService.
+// Events are dropped for subscribers that fall behind, Progress always has
+// the latest counts.
+func (m *MigrationService) Subscribe() (<-chan model.MigrationEvent, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	for _, file := range files {
-		data, err := os.ReadFile(file)
-		if err != nil {
-			return err
-		}
+	id := m.nextID
+	m.nextID++
+	events := make(chan model.MigrationEvent, migrationEventBuffer)
+	m.subscribers[id] = events
 
-		var metadata map[string]interface{}
-		if err := json.Unmarshal(data, &metadata); err != nil {
-			return err
+	return events, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if events, ok := m.subscribers[id]; ok {
+			delete(m.subscribers, id)
+			close(events)
 		}
+	}
+}
 
-		// Implement database restoration logic here
-		// Example: db.InsertMetadata(metadata)
+// Close implements domain.MigrationService.
+// It also waits for the backend's background promotions.
+func (m *MigrationService) Close() {
+	if err := m.Cancel(); err != nil && !errors.Is(err, errs.ErrMigrationNotRunning) {
+		m.logger.Errorf("failed to cancel migration: %v", err)
 	}
+	m.backend.Close()
+}
 
-	return nil
+func (m *MigrationService) active() bool {
+	return m.progress.State == model.MigrationRunning || m.progress.State == model.MigrationPaused
 }
 
-func (s *metadataService) GenerateMetadata(file *model.FileModel) ([]byte, error) {
-	metadata := map[string]interface{}{
-		"filename":    file.Name,
-		"size":        file.Size,
-		"uploaded_at": file.CreatedAt.Format(time.RFC3339),
-		"checksum":    file.Hash,
-		"owner":       "file.UserID",
+// snapshot returns the progress with the throughput and ETA as of now.
+func (m *MigrationService) snapshot() model.MigrationProgress {
+	progress := m.progress
+
+	elapsed := m.elapsed
+	if progress.State == model.MigrationRunning {
+		elapsed += time.Since(m.resumedAt)
+	}
+	if elapsed > 0 {
+		progress.Throughput = float64(progress.Bytes) / elapsed.Seconds()
 	}
 
-	return json.MarshalIndent(metadata, "", "  ")
+	handled := progress.Completed + progress.Failed
+	if progress.State == model.MigrationRunning && handled > 0 && progress.Total > handled {
+		progress.ETA = time.Duration(float64(elapsed) / float64(handled) * float64(progress.Total-handled))
+	}
+	return progress
 }
 
-func (s *metadataService) getMetadataFilePath(filePath string) string {
-	return filepath.Join(s.metaPath, filepath.Base(filePath)+".json")
+// publish sends an event with the current progress to every subscriber.
+// Subscribers that fell behind miss events, except for the one ending the
+// migration: the oldest event is dropped to make room for it.
+func (m *MigrationService) publish(path, errMsg string) {
+	event := model.MigrationEvent{Progress: m.snapshot(), Path: path, Error: errMsg}
+	for _, events := range m.subscribers {
+		select {
+		case events <- event:
+			continue
+		default:
+		}
+		if !event.Progress.State.Done() {
+			continue
+		}
+
+		select {
+		case <-events:
+		default:
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
 }
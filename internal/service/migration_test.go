@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+// steppedMigration migrates its paths one step at a time, failing the one named fail.
+type steppedMigration struct {
+	mocks.MigrationBackend
+	paths []string
+	fail  string
+	step  chan struct{}
+}
+
+func (s *steppedMigration) Migrate(ctx context.Context, opts model.MigrateOptions) error {
+	opts.Queued(int64(len(s.paths)))
+
+	failed := 0
+	for _, path := range s.paths {
+		if err := opts.Wait(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-s.step:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if path == s.fail {
+			failed++
+			opts.Progress(path, 0, errors.New("throttled"))
+			continue
+		}
+		opts.Progress(path, 10, nil)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate %d objects", failed)
+	}
+	return nil
+}
+
+func TestMigrationService(t *testing.T) {
+	log := logger.NewLogger("", true, false)
+
+	t.Run("reports progress and failed objects", func(t *testing.T) {
+		backend := &steppedMigration{paths: []string{"a", "b", "c"}, fail: "b", step: make(chan struct{})}
+		svc := NewMigrationService(log, backend)
+		t.Cleanup(svc.Close)
+
+		events, unsubscribe := svc.Subscribe()
+		defer unsubscribe()
+
+		assert.NoError(t, svc.Start("docs/", model.MigrationOptions{}))
+		assert.ErrorIs(t, svc.Start("docs/", model.MigrationOptions{}), errs.ErrMigrationRunning)
+
+		for range backend.paths {
+			backend.step <- struct{}{}
+		}
+
+		var failures []model.MigrationEvent
+		var last model.MigrationEvent
+		for last = range events {
+			if last.Error != "" {
+				failures = append(failures, last)
+			}
+			if last.Progress.State.Done() {
+				break
+			}
+		}
+
+		if assert.Len(t, failures, 1) {
+			assert.Equal(t, "b", failures[0].Path)
+			assert.Equal(t, "throttled", failures[0].Error)
+		}
+
+		progress := svc.Progress()
+		assert.Equal(t, last.Progress.State, progress.State)
+		assert.Equal(t, model.MigrationFailed, progress.State)
+		assert.Equal(t, "docs/", progress.Prefix)
+		assert.Equal(t, int64(3), progress.Total)
+		assert.Equal(t, int64(2), progress.Completed)
+		assert.Equal(t, int64(1), progress.Failed)
+		assert.Equal(t, int64(20), progress.Bytes)
+		assert.Contains(t, progress.Error, "failed to migrate 1 objects")
+		assert.Zero(t, progress.ETA)
+	})
+
+	t.Run("pauses, resumes and cancels", func(t *testing.T) {
+		backend := &steppedMigration{paths: []string{"a", "b", "c"}, step: make(chan struct{})}
+		svc := NewMigrationService(log, backend)
+		t.Cleanup(svc.Close)
+
+		assert.ErrorIs(t, svc.Pause(), errs.ErrMigrationNotRunning)
+		assert.Equal(t, model.MigrationIdle, svc.Progress().State)
+
+		assert.NoError(t, svc.Start("", model.MigrationOptions{}))
+		backend.step <- struct{}{}
+
+		assert.NoError(t, svc.Pause())
+		assert.ErrorIs(t, svc.Pause(), errs.ErrMigrationNotRunning)
+		assert.Equal(t, model.MigrationPaused, svc.Progress().State)
+
+		assert.NoError(t, svc.Resume())
+		assert.ErrorIs(t, svc.Resume(), errs.ErrMigrationNotPaused)
+		backend.step <- struct{}{}
+
+		assert.NoError(t, svc.Cancel())
+		progress := svc.Progress()
+		assert.Equal(t, model.MigrationCancelled, progress.State)
+		assert.Equal(t, int64(2), progress.Completed)
+		assert.ErrorIs(t, svc.Cancel(), errs.ErrMigrationNotRunning)
+
+		// A cancelled migration can be started again
+		assert.NoError(t, svc.Start("", model.MigrationOptions{}))
+		assert.NoError(t, svc.Cancel())
+	})
+
+	t.Run("always delivers the last event", func(t *testing.T) {
+		paths := make([]string, migrationEventBuffer*2)
+		for i := range paths {
+			paths[i] = fmt.Sprintf("%d.txt", i)
+		}
+		backend := &steppedMigration{paths: paths, step: make(chan struct{})}
+		svc := NewMigrationService(log, backend)
+		t.Cleanup(svc.Close)
+
+		// The subscriber falls behind until the migration completed
+		events, unsubscribe := svc.Subscribe()
+		defer unsubscribe()
+		assert.NoError(t, svc.Start("", model.MigrationOptions{}))
+		for range paths {
+			backend.step <- struct{}{}
+		}
+		assert.Eventually(t, func() bool { return svc.Progress().State.Done() }, 5*time.Second, 10*time.Millisecond)
+
+		var last model.MigrationEvent
+		for range len(events) {
+			last = <-events
+		}
+		assert.Equal(t, model.MigrationCompleted, last.Progress.State)
+	})
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// checkUploadPolicy validates an upload against the policy and returns the
+// content type the file should be stored with.
+func checkUploadPolicy(policy *model.UploadPolicy, user_id, folder_id, file_name, content_type string, data []byte) (string, error) {
+	return checkContentPolicy(policy, user_id, folder_id, file_name, content_type, int64(len(data)), data[:min(len(data), sniffLen)])
+}
+
+// checkContentPolicy is checkUploadPolicy for content known by its size and
+// first bytes, such as an object that is streamed rather than read at once.
+func checkContentPolicy(policy *model.UploadPolicy, user_id, folder_id, file_name, content_type string, size int64, head []byte) (string, error) {
+	if policy == nil {
+		return content_type, nil
+	}
+
+	if limit := maxUploadSize(policy, user_id, folder_id); limit > 0 && size > limit {
+		return "", fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", errs.ErrFileTooLarge, size, limit)
+	}
+
+	ext := strings.ToLower(filepath.Ext(file_name))
+	if len(policy.AllowedExtensions) > 0 && !containsExtension(policy.AllowedExtensions, ext) {
+		return "", fmt.Errorf("%w: %q", errs.ErrExtensionNotAllowed, ext)
+	}
+	if containsExtension(policy.DeniedExtensions, ext) {
+		return "", fmt.Errorf("%w: %q", errs.ErrExtensionNotAllowed, ext)
+	}
+
+	declared := baseMIMEType(content_type)
+	sniffed := baseMIMEType(http.DetectContentType(head[:min(len(head), sniffLen)]))
+	conclusive := sniffed != "application/octet-stream" && sniffed != "text/plain"
+
+	contentType := declared
+	switch {
+	case declared == "" || declared == "application/octet-stream":
+		// Nothing was declared, fall back on the content and then the extension.
+		contentType = sniffed
+		if byExt := baseMIMEType(mime.TypeByExtension(ext)); !conclusive && byExt != "" {
+			contentType = byExt
+		}
+	case conclusive && declared != sniffed:
+		switch policy.OnMIMEMismatch {
+		case model.MIMEMismatchReject:
+			return "", fmt.Errorf("%w: declared %q, detected %q", errs.ErrMIMETypeMismatch, declared, sniffed)
+		case model.MIMEMismatchCorrect:
+			contentType = sniffed
+		}
+	}
+
+	if len(policy.AllowedMIMETypes) > 0 && !matchesMIMEType(policy.AllowedMIMETypes, contentType) {
+		return "", fmt.Errorf("%w: %q", errs.ErrMIMETypeNotAllowed, contentType)
+	}
+	if matchesMIMEType(policy.DeniedMIMETypes, contentType) || (conclusive && matchesMIMEType(policy.DeniedMIMETypes, sniffed)) {
+		return "", fmt.Errorf("%w: %q", errs.ErrMIMETypeNotAllowed, contentType)
+	}
+
+	if contentType == declared {
+		// Keep parameters such as the charset the client sent.
+		return content_type, nil
+	}
+	return contentType, nil
+}
+
+// maxUploadSize returns the size limit that applies, or 0 for none. User and
+// folder limits override MaxSize, the smaller of them wins when both apply.
+func maxUploadSize(policy *model.UploadPolicy, user_id, folder_id string) int64 {
+	var limit int64
+	for _, l := range []int64{policy.MaxSizePerUser[user_id], policy.MaxSizePerFolder[folder_id]} {
+		if l > 0 && (limit <= 0 || l < limit) {
+			limit = l
+		}
+	}
+	if limit <= 0 {
+		return policy.MaxSize
+	}
+	return limit
+}
+
+func containsExtension(list []string, ext string) bool {
+	for _, e := range list {
+		e = strings.ToLower(e)
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesMIMEType(list []string, contentType string) bool {
+	for _, m := range list {
+		m = strings.ToLower(m)
+		if prefix, ok := strings.CutSuffix(m, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+		if m == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+func baseMIMEType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
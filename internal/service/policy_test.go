@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestCheckUploadPolicy(t *testing.T) {
+	policy := &model.UploadPolicy{
+		MaxSize:          100,
+		MaxSizePerUser:   map[string]int64{"small": 10, "big": 1000},
+		MaxSizePerFolder: map[string]int64{"tiny": 5},
+		DeniedExtensions: []string{"exe", ".BAT"},
+		AllowedMIMETypes: []string{"image/*", "text/plain", "application/pdf"},
+		DeniedMIMETypes:  []string{"image/svg+xml"},
+		OnMIMEMismatch:   model.MIMEMismatchReject,
+	}
+
+	tests := []struct {
+		name        string
+		user        string
+		folder      string
+		file        string
+		contentType string
+		data        []byte
+		want        string
+		err         error
+	}{
+		{"allowed", "u", "f", "a.txt", "text/plain; charset=utf-8", []byte("hello"), "text/plain; charset=utf-8", nil},
+		{"too large", "u", "f", "a.txt", "text/plain", make([]byte, 101), "", errs.ErrFileTooLarge},
+		{"smaller user limit", "small", "f", "a.txt", "text/plain", make([]byte, 11), "", errs.ErrFileTooLarge},
+		{"larger user limit overrides global", "big", "f", "a.txt", "text/plain", make([]byte, 101), "text/plain", nil},
+		{"larger user limit", "big", "f", "a.txt", "text/plain", make([]byte, 1001), "", errs.ErrFileTooLarge},
+		{"smaller folder limit wins over user limit", "big", "tiny", "a.txt", "text/plain", []byte("123456"), "", errs.ErrFileTooLarge},
+		{"folder limit", "u", "tiny", "a.txt", "text/plain", []byte("123456"), "", errs.ErrFileTooLarge},
+		{"denied extension", "u", "f", "a.exe", "text/plain", []byte("x"), "", errs.ErrExtensionNotAllowed},
+		{"denied extension case", "u", "f", "a.bat", "text/plain", []byte("x"), "", errs.ErrExtensionNotAllowed},
+		{"mime not allowed", "u", "f", "a.html", "text/html", []byte("<html></html>"), "", errs.ErrMIMETypeNotAllowed},
+		{"mime wildcard", "u", "f", "a.png", "image/png", pngHeader, "image/png", nil},
+		{"mime denied", "u", "f", "a.svg", "image/svg+xml", []byte("plain"), "", errs.ErrMIMETypeNotAllowed},
+		{"mismatch", "u", "f", "a.txt", "text/plain", []byte("<html><body>x</body></html>"), "", errs.ErrMIMETypeMismatch},
+		{"sniffed when missing", "u", "f", "a", "", pngHeader, "image/png", nil},
+		{"extension when inconclusive", "u", "f", "a.pdf", "", []byte{0, 1, 2}, "application/pdf", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkUploadPolicy(policy, tt.user, tt.folder, tt.file, tt.contentType, tt.data)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCheckUploadPolicyCorrect(t *testing.T) {
+	policy := &model.UploadPolicy{OnMIMEMismatch: model.MIMEMismatchCorrect}
+
+	got, err := checkUploadPolicy(policy, "u", "f", "a.jpg", "image/jpeg", pngHeader)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", got)
+
+	got, err = checkUploadPolicy(nil, "u", "f", "a.jpg", "image/jpeg", pngHeader)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", got)
+}
+
+func TestCreateFileRejectedByPolicy(t *testing.T) {
+	ctx := t.Context()
+	mockFileRepo := new(mocks.FileRepository)
+	mockFolderService := new(mocks.FolderService)
+	mockBackend := new(mocks.LocalFileSystemService)
+
+	fileService := NewFileService(logger.NewLogger("", true, false), new(mocks.CacheManager), mockFileRepo, mockFolderService, mockBackend, false,
+		WithUploadPolicy(&model.UploadPolicy{MaxSize: 4}))
+
+	parentFolder := &model.FolderModel{ID: uuid.New(), Path: "/parent/folder"}
+	mockFolderService.On("GetFolder", "user1", "parent_id").Return(parentFolder, nil)
+
+	_, err := fileService.CreateFile(ctx, "user1", "parent_id", "file.txt", "text/plain", []byte("file data"))
+	assert.ErrorIs(t, err, errs.ErrFileTooLarge)
+
+	mockFileRepo.AssertNotCalled(t, "Create")
+	mockBackend.AssertNotCalled(t, "Put")
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/google/uuid"
+)
+
+// reconcilePageSize bounds the files loaded at once while reconciling.
+const reconcilePageSize = 1000
+
+// ReconcileService compares the objects in the backend with the file table.
+// Objects no file, sidecar, rendition or unfinished operation refers to are stray,
+// typically left by failed uploads or moves; files whose object is gone are
+// missing.
+type ReconcileService struct {
+	logger domain.BucktLogger
+
+	fileRepo      domain.FileRepository
+	renditionRepo domain.RenditionRepository
+	journal       domain.OperationRepository
+
+	fileBackend domain.FileBackend
+}
+
+func NewReconcileService(
+	bucktLogger domain.BucktLogger,
+
+	fileRepository domain.FileRepository,
+	renditionRepository domain.RenditionRepository,
+	journal domain.OperationRepository,
+
+	fileBackend domain.FileBackend,
+) domain.ReconcileService {
+	bucktLogger.Info("🚀 Initialising reconcile services")
+	return &ReconcileService{
+		logger: bucktLogger,
+
+		fileRepo:      fileRepository,
+		renditionRepo: renditionRepository,
+		journal:       journal,
+
+		fileBackend: fileBackend,
+	}
+}
+
+// Reconcile implements domain.ReconcileService.
+// The backend is listed before the file table is read, so files created in
+// between are not reported missing; their objects are younger than the grace
+// period if they are reported stray instead.
+func (r *ReconcileService) Reconcile(ctx context.Context, opts model.ReconcileOptions) (model.ReconcileReport, error) {
+	opts = opts.WithDefaults()
+	report := model.ReconcileReport{StartedAt: time.Now()}
+
+	objects, err := r.fileBackend.List(ctx, "")
+	if err != nil {
+		return report, r.logger.WrapError("failed to list objects", err)
+	}
+	report.Objects = len(objects)
+
+	listed := make(map[string]string, len(objects))
+	for _, object := range objects {
+		listed[objectKey(object)] = object
+	}
+
+	known, err := r.referencedObjects(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	var after uuid.UUID
+	for {
+		files, err := r.fileRepo.GetFilesPage(ctx, after, reconcilePageSize)
+		if err != nil {
+			return report, r.logger.WrapError("failed to get files", err)
+		}
+
+		for _, file := range files {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			report.Files++
+			key := objectKey(file.Path)
+			known[key] = struct{}{}
+			known[metadataPath(key)] = struct{}{}
+
+			if _, ok := listed[key]; ok {
+				r.checkFound(ctx, file, opts, &report)
+				continue
+			}
+			r.checkMissing(ctx, file, opts, &report)
+		}
+
+		if len(files) < reconcilePageSize {
+			break
+		}
+		after = files[len(files)-1].ID
+	}
+
+	keys := make([]string, 0, len(listed))
+	for key := range listed {
+		// Folder sidecars belong to no object
+		if strings.HasPrefix(key, folderMetadataPrefix) {
+			continue
+		}
+		if _, ok := known[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		r.checkStray(ctx, listed[key], opts, &report)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// referencedObjects returns the objects of renditions and unfinished operations.
+func (r *ReconcileService) referencedObjects(ctx context.Context) (map[string]struct{}, error) {
+	known := map[string]struct{}{}
+
+	if r.renditionRepo != nil {
+		paths, err := r.renditionRepo.GetRenditionPaths(ctx)
+		if err != nil {
+			return nil, r.logger.WrapError("failed to get renditions", err)
+		}
+		for _, p := range paths {
+			known[objectKey(p)] = struct{}{}
+		}
+	}
+
+	// Objects of unfinished operations are completed or rolled back by recovery
+	if r.journal != nil {
+		ops, err := r.journal.GetOperations(ctx)
+		if err != nil {
+			return nil, r.logger.WrapError("failed to get unfinished operations", err)
+		}
+		for _, op := range ops {
+			for _, p := range []string{op.Path, op.StagingPath, op.OldPath} {
+				if p != "" {
+					known[objectKey(p)] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return known, nil
+}
+
+// checkFound clears the mark of a missing file whose object is back.
+func (r *ReconcileService) checkFound(ctx context.Context, file *model.FileModel, opts model.ReconcileOptions, report *model.ReconcileReport) {
+	if !opts.MarkMissing || file.Integrity != model.IntegrityMissing {
+		return
+	}
+	if err := r.fileRepo.UpdateIntegrity(ctx, file.ID, model.IntegrityUnknown); err != nil {
+		r.logger.Errorf("failed to clear missing mark of %s: %v", file.ID, err)
+		return
+	}
+	report.Found++
+}
+
+func (r *ReconcileService) checkMissing(ctx context.Context, file *model.FileModel, opts model.ReconcileOptions, report *model.ReconcileReport) {
+	// The object may have been written or moved after the backend was listed
+	exists, err := r.fileBackend.Exists(ctx, file.Path)
+	if err != nil {
+		r.logger.Errorf("failed to check object of %s: %v", file.ID, err)
+		return
+	}
+	if exists {
+		return
+	}
+
+	missing := model.MissingFile{ID: file.ID, Name: file.Name, Path: file.Path}
+	if opts.MarkMissing {
+		if file.Integrity != model.IntegrityMissing {
+			err = r.fileRepo.UpdateIntegrity(ctx, file.ID, model.IntegrityMissing)
+		}
+		if err != nil {
+			missing.Error = err.Error()
+		} else {
+			missing.Marked = true
+		}
+	}
+	report.Missing = append(report.Missing, missing)
+}
+
+func (r *ReconcileService) checkStray(ctx context.Context, object string, opts model.ReconcileOptions, report *model.ReconcileReport) {
+	stray := model.StrayObject{Path: object}
+
+	// Without its age an object cannot be told apart from an upload in flight,
+	// backends that cannot stat objects report no modification time
+	var old bool
+	if stater, ok := r.fileBackend.(domain.StatBackend); ok {
+		info, err := stater.Stat(ctx, object)
+		if errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+		if err == nil {
+			stray.Size = info.Size
+			stray.LastModified = info.LastModified
+			old = !info.LastModified.IsZero() && time.Since(info.LastModified) > opts.GracePeriod
+		}
+	}
+
+	if opts.DeleteStray && old {
+		if err := r.fileBackend.Delete(ctx, object); err != nil {
+			stray.Error = err.Error()
+		} else {
+			stray.Deleted = true
+		}
+	}
+	report.Stray = append(report.Stray, stray)
+}
+
+// objectKey normalises an object path so paths of the file table and of
+// backend listings compare equal.
+func objectKey(p string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(p)), "/")
+}
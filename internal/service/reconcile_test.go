@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/backend"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	log := logger.NewLogger("", true, false)
+
+	fileBackend := backend.NewLocalFileSystemService(log, dir, mocks.NewNoopLRUCache())
+	fileRepo := new(mocks.FileRepository)
+	renditionRepo := new(mocks.RenditionRepository)
+	journal := new(mocks.OperationRepository)
+
+	put := func(path string, age time.Duration) {
+		assert.NoError(t, fileBackend.Put(ctx, path, []byte(path)))
+		modified := time.Now().Add(-age)
+		assert.NoError(t, os.Chtimes(filepath.Join(dir, path), modified, modified))
+	}
+
+	put("docs/a.txt", 48*time.Hour)
+	put("docs/a.txt.renditions/thumb.png", 48*time.Hour)
+	put(metadataPath("docs/a.txt"), 48*time.Hour)
+	put(folderMetadataPath(uuid.NewString()), 48*time.Hour)
+	put("docs/b.txt.staging-1", 48*time.Hour)
+	put("docs/old.txt", 48*time.Hour)
+	put("docs/new.txt.tmp", time.Minute)
+
+	present := &model.FileModel{ID: uuid.New(), Name: "a.txt", Path: "/docs/a.txt"}
+	missing := &model.FileModel{ID: uuid.New(), Name: "c.txt", Path: "docs/c.txt"}
+	fileRepo.On("GetFilesPage", uuid.Nil, reconcilePageSize).Return([]*model.FileModel{present, missing}, nil)
+	fileRepo.On("UpdateIntegrity", missing.ID, model.IntegrityMissing).Return(nil)
+	renditionRepo.On("GetRenditionPaths").Return([]string{"docs/a.txt.renditions/thumb.png"}, nil)
+	journal.On("GetOperations").Return([]*model.OperationModel{
+		{Type: model.OperationReplaceFile, Path: "docs/b.txt", StagingPath: "docs/b.txt.staging-1"},
+	}, nil)
+
+	service := NewReconcileService(log, fileRepo, renditionRepo, journal, fileBackend)
+
+	// Only reports by default
+	report, err := service.Reconcile(ctx, model.ReconcileOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, report.Objects)
+	assert.Equal(t, 2, report.Files)
+	if assert.Len(t, report.Stray, 2) {
+		assert.Equal(t, "docs/new.txt.tmp", report.Stray[0].Path)
+		assert.Equal(t, "docs/old.txt", report.Stray[1].Path)
+		assert.False(t, report.Stray[1].Deleted)
+	}
+	if assert.Len(t, report.Missing, 1) {
+		assert.Equal(t, missing.ID, report.Missing[0].ID)
+		assert.False(t, report.Missing[0].Marked)
+	}
+	fileRepo.AssertNotCalled(t, "UpdateIntegrity", mock.Anything, mock.Anything)
+
+	// Stray objects younger than the grace period are kept
+	report, err = service.Reconcile(ctx, model.ReconcileOptions{DeleteStray: true, MarkMissing: true})
+	assert.NoError(t, err)
+	assert.False(t, report.Stray[0].Deleted)
+	assert.True(t, report.Stray[1].Deleted)
+	assert.True(t, report.Missing[0].Marked)
+
+	exists, err := fileBackend.Exists(ctx, "docs/old.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = fileBackend.Exists(ctx, "docs/new.txt.tmp")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// The mark is cleared once the object is back
+	missing.Integrity = model.IntegrityMissing
+	put("docs/c.txt", 0)
+	fileRepo.On("UpdateIntegrity", missing.ID, model.IntegrityUnknown).Return(nil)
+
+	report, err = service.Reconcile(ctx, model.ReconcileOptions{MarkMissing: true})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Missing)
+	assert.Equal(t, 1, report.Found)
+}
+
+// agelessBackend stats objects without their modification time, like backends
+// stated by reading the object.
+type agelessBackend struct {
+	*mocks.MemoryBackend
+}
+
+func (a *agelessBackend) Stat(ctx context.Context, path string) (*model.FileInfo, error) {
+	data, err := a.Get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &model.FileInfo{Size: int64(len(data))}, nil
+}
+
+func TestReconcileUnknownAge(t *testing.T) {
+	ctx := t.Context()
+	log := logger.NewLogger("", true, false)
+
+	fileBackend := &agelessBackend{MemoryBackend: mocks.NewMemoryBackend("memory")}
+	assert.NoError(t, fileBackend.Put(ctx, "docs/stray.txt", []byte("stray")))
+
+	fileRepo := new(mocks.FileRepository)
+	fileRepo.On("GetFilesPage", uuid.Nil, reconcilePageSize).Return([]*model.FileModel{}, nil)
+	renditionRepo := new(mocks.RenditionRepository)
+	renditionRepo.On("GetRenditionPaths").Return([]string{}, nil)
+
+	service := NewReconcileService(log, fileRepo, renditionRepo, nil, fileBackend)
+
+	// An object of unknown age may be an upload in flight
+	report, err := service.Reconcile(ctx, model.ReconcileOptions{DeleteStray: true})
+	assert.NoError(t, err)
+	if assert.Len(t, report.Stray, 1) {
+		assert.Equal(t, int64(5), report.Stray[0].Size)
+		assert.False(t, report.Stray[0].Deleted)
+	}
+
+	exists, err := fileBackend.Exists(ctx, "docs/stray.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
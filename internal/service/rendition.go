@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/internal/utils"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+// renditionQueueSize bounds the uploads waiting for renditions. Uploads that do
+// not fit are rendered on demand when a rendition is first requested.
+const renditionQueueSize = 256
+
+type renditionJob struct {
+	file model.FileModel
+	data []byte
+}
+
+// RenditionService generates resized versions of uploaded images in a pool of
+// workers and stores them next to the original, under "<path>.renditions/".
+type RenditionService struct {
+	logger domain.BucktLogger
+
+	repo        domain.RenditionRepository
+	fileRepo    domain.FileRepository
+	fileBackend domain.FileBackend
+
+	sizes []model.RenditionSize
+
+	jobs    chan renditionJob
+	workers sync.WaitGroup
+	once    sync.Once
+
+	// onDemand deduplicates renditions generated for concurrent requests.
+	onDemand singleflight.Group
+}
+
+func NewRenditionService(
+	bucktLogger domain.BucktLogger,
+
+	renditionRepository domain.RenditionRepository,
+	fileRepository domain.FileRepository,
+
+	fileBackend domain.FileBackend,
+
+	sizes []model.RenditionSize,
+	workers int,
+) domain.RenditionService {
+	bucktLogger.Info("🚀 Initialising rendition services")
+
+	if workers <= 0 {
+		workers = 2
+	}
+
+	renditionService := &RenditionService{
+		logger: bucktLogger,
+
+		repo:     renditionRepository,
+		fileRepo: fileRepository,
+
+		fileBackend: fileBackend,
+
+		sizes: sizes,
+		jobs:  make(chan renditionJob, renditionQueueSize),
+	}
+
+	for range workers {
+		renditionService.workers.Add(1)
+		go renditionService.work()
+	}
+
+	return renditionService
+}
+
+// Enqueue implements domain.RenditionService.
+func (r *RenditionService) Enqueue(file *model.FileModel, data []byte) {
+	if !isRenderable(file.ContentType) || len(r.sizes) == 0 {
+		return
+	}
+
+	select {
+	case r.jobs <- renditionJob{file: *file, data: data}:
+	default:
+		r.logger.Warn(fmt.Sprintf("rendition queue is full, renditions of %s are generated on demand", file.ID))
+	}
+}
+
+// GetRendition implements domain.RenditionService.
+// Renditions that are missing or were generated from older content are
+// generated on demand.
+func (r *RenditionService) GetRendition(ctx context.Context, file_id, name string) (*model.RenditionModel, error) {
+	fileID, err := uuid.Parse(file_id)
+	if err != nil {
+		return nil, r.logger.WrapError("failed to parse uuid", err)
+	}
+
+	size, ok := r.size(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown size %q", errs.ErrRenditionNotFound, name)
+	}
+
+	file, err := r.fileRepo.GetFile(ctx, fileID)
+	if err != nil {
+		return nil, r.logger.WrapError("failed to get file metadata", err)
+	}
+
+	if err := checkScanStatus(file); err != nil {
+		return nil, r.logger.WrapError("file cannot be downloaded", err)
+	}
+
+	if !isRenderable(file.ContentType) {
+		return nil, fmt.Errorf("%w: %s is not an image", errs.ErrRenditionNotFound, file.ContentType)
+	}
+
+	rendition, err := r.repo.GetRendition(ctx, fileID, name)
+	if err == nil && rendition.Hash == file.Hash {
+		data, err := r.fileBackend.Get(ctx, rendition.Path)
+		if err == nil {
+			rendition.Data = data
+			return rendition, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, r.logger.WrapError("failed to get rendition data", err)
+		}
+	}
+
+	v, err, _ := r.onDemand.Do(file_id+"/"+name, func() (any, error) {
+		data, err := r.fileBackend.Get(ctx, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		return r.render(ctx, file, data, size)
+	})
+	if err != nil {
+		return nil, r.logger.WrapError("failed to generate rendition", err)
+	}
+
+	return v.(*model.RenditionModel), nil
+}
+
+// DeleteRenditions implements domain.RenditionService.
+func (r *RenditionService) DeleteRenditions(ctx context.Context, file *model.FileModel) error {
+	renditions, err := r.repo.GetRenditions(ctx, file.ID)
+	if err != nil {
+		return r.logger.WrapError("failed to get renditions", err)
+	}
+
+	for _, rendition := range renditions {
+		if err := r.fileBackend.Delete(ctx, rendition.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			r.logger.Errorf("failed to delete rendition %s: %v", rendition.Path, err)
+		}
+	}
+
+	if err := r.repo.DeleteRenditions(ctx, file.ID); err != nil {
+		return r.logger.WrapError("failed to delete renditions", err)
+	}
+
+	return nil
+}
+
+// Close implements domain.RenditionService.
+func (r *RenditionService) Close() {
+	r.once.Do(func() {
+		close(r.jobs)
+	})
+	r.workers.Wait()
+}
+
+func (r *RenditionService) work() {
+	defer r.workers.Done()
+
+	for job := range r.jobs {
+		ctx := context.Background()
+
+		// Skip uploads that were replaced while they waited in the queue, or
+		// that cannot be downloaded.
+		current, err := r.fileRepo.GetFile(ctx, job.file.ID)
+		if err != nil || current.Hash != job.file.Hash || checkScanStatus(current) != nil {
+			continue
+		}
+
+		for _, size := range r.sizes {
+			if _, err := r.render(ctx, &job.file, job.data, size); err != nil {
+				r.logger.Errorf("failed to generate rendition %s of %s: %v", size.Name, job.file.ID, err)
+				break
+			}
+		}
+	}
+}
+
+// render resizes data, stores the result and records it.
+func (r *RenditionService) render(ctx context.Context, file *model.FileModel, data []byte, size model.RenditionSize) (*model.RenditionModel, error) {
+	var buf bytes.Buffer
+	contentType, dimensions, err := utils.ResizeImage(bytes.NewReader(data), &buf, size.Width)
+	if err != nil {
+		return nil, err
+	}
+
+	rendition := &model.RenditionModel{
+		FileID:      file.ID,
+		Name:        size.Name,
+		Path:        renditionPath(file.Path, size.Name, contentType),
+		Hash:        file.Hash,
+		ContentType: contentType,
+		Width:       dimensions.X,
+		Height:      dimensions.Y,
+		Size:        int64(buf.Len()),
+		Data:        buf.Bytes(),
+	}
+
+	if err := r.fileBackend.Put(ctx, rendition.Path, rendition.Data); err != nil {
+		return nil, err
+	}
+
+	if err := r.repo.Save(ctx, rendition); err != nil {
+		return nil, err
+	}
+
+	return rendition, nil
+}
+
+func (r *RenditionService) size(name string) (model.RenditionSize, bool) {
+	for _, size := range r.sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+	return model.RenditionSize{}, false
+}
+
+func renditionPath(path, name, contentType string) string {
+	ext := ".jpg"
+	if contentType == "image/png" {
+		ext = ".png"
+	}
+	return path + ".renditions/" + name + ext
+}
+
+// isRenderable reports whether renditions can be generated for the content type.
+func isRenderable(contentType string) bool {
+	switch baseMIMEType(contentType) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func setupRenditionTest() (*RenditionService, *mocks.RenditionRepository, *mocks.FileRepository, *mocks.MemoryBackend) {
+	repo := new(mocks.RenditionRepository)
+	fileRepo := new(mocks.FileRepository)
+	backend := mocks.NewMemoryBackend("memory")
+
+	sizes := []model.RenditionSize{{Name: "thumb", Width: 50}, {Name: "large", Width: 1000}}
+	service := NewRenditionService(logger.NewLogger("", true, false), repo, fileRepo, backend, sizes, 1)
+	return service.(*RenditionService), repo, fileRepo, backend
+}
+
+func TestRenditionWorkers(t *testing.T) {
+	ctx := t.Context()
+	service, repo, fileRepo, backend := setupRenditionTest()
+
+	file := &model.FileModel{ID: uuid.New(), Path: "photos/a.png", ContentType: "image/png", Hash: "v1"}
+	fileRepo.On("GetFile", file.ID).Return(file, nil)
+	repo.On("Save", mock.Anything).Return(nil)
+
+	service.Enqueue(file, testPNG(t, 200, 100))
+	// Files that are not images are ignored.
+	service.Enqueue(&model.FileModel{ID: uuid.New(), ContentType: "text/plain"}, []byte("hello"))
+	service.Close()
+
+	repo.AssertNumberOfCalls(t, "Save", 2)
+	thumb := repo.Calls[0].Arguments.Get(0).(*model.RenditionModel)
+	assert.Equal(t, "photos/a.png.renditions/thumb.png", thumb.Path)
+	assert.Equal(t, 50, thumb.Width)
+	assert.Equal(t, 25, thumb.Height)
+
+	// Images are not scaled up.
+	large := repo.Calls[1].Arguments.Get(0).(*model.RenditionModel)
+	assert.Equal(t, 200, large.Width)
+
+	exists, err := backend.Exists(ctx, thumb.Path)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestGetRendition(t *testing.T) {
+	ctx := t.Context()
+	service, repo, fileRepo, backend := setupRenditionTest()
+	defer service.Close()
+
+	file := &model.FileModel{ID: uuid.New(), Path: "a.png", ContentType: "image/png", Hash: "v2"}
+	fileRepo.On("GetFile", file.ID).Return(file, nil)
+	assert.NoError(t, backend.Put(ctx, file.Path, testPNG(t, 200, 100)))
+
+	// A rendition of older content is regenerated.
+	stale := &model.RenditionModel{FileID: file.ID, Name: "thumb", Path: "a.png.renditions/thumb.png", Hash: "v1"}
+	repo.On("GetRendition", file.ID, "thumb").Return(stale, nil)
+	repo.On("Save", mock.Anything).Return(nil)
+
+	rendition, err := service.GetRendition(ctx, file.ID.String(), "thumb")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", rendition.Hash)
+	assert.Equal(t, 50, rendition.Width)
+	assert.NotEmpty(t, rendition.Data)
+	repo.AssertNumberOfCalls(t, "Save", 1)
+
+	_, err = service.GetRendition(ctx, file.ID.String(), "huge")
+	assert.ErrorIs(t, err, errs.ErrRenditionNotFound)
+
+	text := &model.FileModel{ID: uuid.New(), ContentType: "text/plain"}
+	fileRepo.On("GetFile", text.ID).Return(text, nil)
+	_, err = service.GetRendition(ctx, text.ID.String(), "thumb")
+	assert.ErrorIs(t, err, errs.ErrRenditionNotFound)
+
+	infected := &model.FileModel{ID: uuid.New(), ContentType: "image/png", ScanStatus: model.ScanStatusInfected}
+	fileRepo.On("GetFile", infected.ID).Return(infected, nil)
+	_, err = service.GetRendition(ctx, infected.ID.String(), "thumb")
+	assert.ErrorIs(t, err, errs.ErrFileInfected)
+}
+
+func TestScrubFileDeletesRenditions(t *testing.T) {
+	ctx := t.Context()
+	renditions, repo, _, backend := setupRenditionTest()
+	defer renditions.Close()
+
+	mockFileRepo := new(mocks.FileRepository)
+	mockBackend := new(mocks.LocalFileSystemService)
+	fileService := NewFileService(logger.NewLogger("", true, false), nil, mockFileRepo, new(mocks.FolderService), mockBackend, false,
+		WithRenditions(renditions))
+
+	file := &model.FileModel{ID: uuid.New(), Path: "a.png"}
+	mockFileRepo.On("GetFile", file.ID).Return(file, nil)
+	mockFileRepo.On("ScrubFile", file.ID).Return(nil)
+	mockBackend.On("Delete", "a.png").Return(nil)
+
+	assert.NoError(t, backend.Put(ctx, "a.png.renditions/thumb.png", []byte("thumb")))
+	repo.On("GetRenditions", file.ID).Return([]model.RenditionModel{{Path: "a.png.renditions/thumb.png"}}, nil)
+	repo.On("DeleteRenditions", file.ID).Return(nil)
+
+	_, err := fileService.ScrubFile(ctx, file.ID.String())
+	assert.NoError(t, err)
+
+	exists, err := backend.Exists(ctx, "a.png.renditions/thumb.png")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	repo.AssertCalled(t, "DeleteRenditions", file.ID)
+}
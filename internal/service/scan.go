@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+const (
+	// maxConcurrentScans is the number of workers scanning asynchronously.
+	maxConcurrentScans = 4
+	// scanQueueSize bounds the uploads waiting for an asynchronous scan,
+	// uploads wait for room in the queue once it is full.
+	scanQueueSize = 64
+	// scanTimeout bounds a single asynchronous scan.
+	scanTimeout = 5 * time.Minute
+)
+
+type scanJob struct {
+	file *model.FileModel
+	data []byte
+}
+
+// scanUpload scans data in sync mode and returns the status the file is stored with.
+// Sync mode fails closed: content that cannot be scanned is rejected.
+func (f *FileService) scanUpload(ctx context.Context, data []byte) (model.ScanStatus, string, error) {
+	switch {
+	case f.scanner == nil:
+		return model.ScanStatusNone, "", nil
+	case f.scanMode == model.ScanAsync:
+		return model.ScanStatusPending, "", nil
+	}
+
+	return scanVerdict(f.scanner.Scan(ctx, bytes.NewReader(data)))
+}
+
+// scanObject is scanUpload for an object already in the backend, it is
+// streamed to the scanner rather than read at once.
+func (f *FileService) scanObject(ctx context.Context, path string) (model.ScanStatus, string, error) {
+	switch {
+	case f.scanner == nil:
+		return model.ScanStatusNone, "", nil
+	case f.scanMode == model.ScanAsync:
+		return model.ScanStatusPending, "", nil
+	}
+
+	rc, err := f.fileBackend.Stream(ctx, path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read file for scanning: %w", err)
+	}
+	defer rc.Close()
+
+	return scanVerdict(f.scanner.Scan(ctx, rc))
+}
+
+// scanVerdict returns the status content is stored with after a sync scan.
+func scanVerdict(result model.ScanResult, err error) (model.ScanStatus, string, error) {
+	if err != nil {
+		return "", "", fmt.Errorf("failed to scan file: %w", err)
+	}
+	if result.Infected {
+		return "", "", fmt.Errorf("%w: %s", errs.ErrFileInfected, result.Signature)
+	}
+	return model.ScanStatusClean, "", nil
+}
+
+// startScanWorkers starts the workers scanning pending files in async mode.
+func (f *FileService) startScanWorkers() {
+	if f.scanner == nil || f.scanMode != model.ScanAsync {
+		return
+	}
+
+	f.scans = make(chan scanJob, scanQueueSize)
+	for range maxConcurrentScans {
+		f.scanWorkers.Add(1)
+		go f.scanWork()
+	}
+}
+
+// scanAsync queues a pending file for scanning, waiting for room in the queue
+// while it is full. Files that are not queued before ctx is done, and files
+// whose scan fails, stay pending until RescanPending picks them up.
+func (f *FileService) scanAsync(ctx context.Context, file *model.FileModel, data []byte) {
+	f.scanMu.RLock()
+	defer f.scanMu.RUnlock()
+	if f.scansClosed {
+		return
+	}
+
+	select {
+	case f.scans <- scanJob{file: file, data: data}:
+	case <-ctx.Done():
+		f.logger.Warn(fmt.Sprintf("scan queue is full, file %s is scanned by the next rescan", file.ID))
+	}
+}
+
+func (f *FileService) scanWork() {
+	defer f.scanWorkers.Done()
+
+	for job := range f.scans {
+		ctx, cancel := context.WithTimeout(context.Background(), scanTimeout)
+		status, err := f.scanFile(ctx, job.file, bytes.NewReader(job.data))
+		cancel()
+		if err != nil {
+			f.logger.Errorf("failed to scan file %s: %v", job.file.ID, err)
+			continue
+		}
+
+		// Renditions of pending uploads wait for a clean verdict
+		if status == model.ScanStatusClean && f.renditions != nil {
+			f.renditions.Enqueue(job.file, job.data)
+		}
+	}
+}
+
+// Close implements domain.FileService.
+// It waits for the queued scans to finish.
+func (f *FileService) Close() {
+	f.scanMu.Lock()
+	if f.scans != nil && !f.scansClosed {
+		close(f.scans)
+	}
+	f.scansClosed = true
+	f.scanMu.Unlock()
+
+	f.scanWorkers.Wait()
+}
+
+// scanFile scans content and records the verdict.
+func (f *FileService) scanFile(ctx context.Context, file *model.FileModel, r io.Reader) (model.ScanStatus, error) {
+	result, err := f.scanner.Scan(ctx, r)
+	if err != nil {
+		return "", err
+	}
+
+	status := model.ScanStatusClean
+	if result.Infected {
+		status = model.ScanStatusInfected
+		f.logger.Warn(fmt.Sprintf("🦠 Quarantined file %s: %s", file.ID, result.Signature))
+	}
+
+	if err := f.repo.UpdateScanStatus(ctx, file.ID, file.Hash, status, result.Signature); err != nil {
+		return "", err
+	}
+
+	// Drop the cached metadata, it still says pending.
+	if f.cache != nil {
+		_ = f.cache.DeleteBucktValue(ctx, file.ID.String())
+		_ = f.cache.DeleteBucktValue(ctx, fmt.Sprintf("files:%s", file.ParentID))
+	}
+	return status, nil
+}
+
+// RescanPending implements domain.FileService.
+// It scans every file still pending, for example because the scanner was
+// unreachable or the process stopped before the scan finished.
+func (f *FileService) RescanPending(ctx context.Context) (int, error) {
+	if f.scanner == nil {
+		return 0, nil
+	}
+
+	files, err := f.repo.GetFilesByScanStatus(ctx, model.ScanStatusPending)
+	if err != nil {
+		return 0, f.logger.WrapError("failed to get pending files", err)
+	}
+
+	scanned := 0
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return scanned, err
+		}
+
+		stream, err := f.fileBackend.Stream(ctx, file.Path)
+		if err != nil {
+			f.logger.Errorf("failed to read file %s for scanning: %v", file.ID, err)
+			continue
+		}
+
+		_, err = f.scanFile(ctx, file, stream)
+		stream.Close()
+		if err != nil {
+			f.logger.Errorf("failed to scan file %s: %v", file.ID, err)
+			continue
+		}
+		scanned++
+	}
+
+	return scanned, nil
+}
+
+// checkScanStatus refuses files that are infected or not scanned yet.
+func checkScanStatus(file *model.FileModel) error {
+	switch file.ScanStatus {
+	case model.ScanStatusInfected:
+		return fmt.Errorf("%w: %s", errs.ErrFileInfected, file.Signature)
+	case model.ScanStatusPending:
+		return errs.ErrFileScanPending
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	errs "github.com/Rhaqim/buckt/internal/error"
+	"github.com/Rhaqim/buckt/internal/mocks"
+	"github.com/Rhaqim/buckt/internal/model"
+	"github.com/Rhaqim/buckt/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+// fakeScanner flags content containing "EICAR". Scans wait for block when set.
+type fakeScanner struct {
+	err   error
+	block chan struct{}
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, r io.Reader) (model.ScanResult, error) {
+	if s.block != nil {
+		<-s.block
+	}
+	if s.err != nil {
+		return model.ScanResult{}, s.err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return model.ScanResult{}, err
+	}
+	if bytes.Contains(data, []byte("EICAR")) {
+		return model.ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return model.ScanResult{}, nil
+}
+
+func setupScanTest(scanner *fakeScanner, mode model.ScanMode) MockFileServices {
+	m := MockFileServices{
+		cacheManager:   new(mocks.CacheManager),
+		fileRepository: new(mocks.FileRepository),
+		folderService:  new(mocks.FolderService),
+		backend:        new(mocks.LocalFileSystemService),
+	}
+	m.fileService = NewFileService(logger.NewLogger("", true, false), m.cacheManager, m.fileRepository, m.folderService, m.backend, false,
+		WithScanner(scanner, mode))
+
+	m.folderService.On("GetFolder", "user1", "parent_id").Return(&model.FolderModel{ID: uuid.New(), Path: "/parent"}, nil)
+	m.fileRepository.On("GetFileByName", mock.Anything, mock.Anything).Return(nil, gorm.ErrRecordNotFound).Maybe()
+	return m
+}
+
+func TestScanSync(t *testing.T) {
+	ctx := t.Context()
+	m := setupScanTest(&fakeScanner{}, model.ScanSync)
+
+	_, err := m.fileService.CreateFile(ctx, "user1", "parent_id", "virus.txt", "text/plain", []byte("EICAR"))
+	assert.ErrorIs(t, err, errs.ErrFileInfected)
+	m.fileRepository.AssertNotCalled(t, "Create", mock.Anything)
+	m.backend.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+
+	m.fileRepository.On("Create", mock.MatchedBy(func(f *model.FileModel) bool {
+		return f.ScanStatus == model.ScanStatusClean
+	})).Return(nil)
+	m.backend.On("Put", "/parent/clean.txt", []byte("hello")).Return(nil)
+
+	_, err = m.fileService.CreateFile(ctx, "user1", "parent_id", "clean.txt", "text/plain", []byte("hello"))
+	assert.NoError(t, err)
+
+	// Sync mode fails closed when the scanner is unavailable.
+	m = setupScanTest(&fakeScanner{err: errors.New("connection refused")}, model.ScanSync)
+	_, err = m.fileService.CreateFile(ctx, "user1", "parent_id", "clean.txt", "text/plain", []byte("hello"))
+	assert.ErrorContains(t, err, "connection refused")
+	m.fileRepository.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestScanAsync(t *testing.T) {
+	ctx := t.Context()
+	m := setupScanTest(&fakeScanner{}, model.ScanAsync)
+
+	m.fileRepository.On("Create", mock.MatchedBy(func(f *model.FileModel) bool {
+		return f.ScanStatus == model.ScanStatusPending
+	})).Return(nil)
+	m.backend.On("Put", "/parent/virus.txt", []byte("EICAR")).Return(nil)
+	m.cacheManager.On("DeleteBucktValue", mock.Anything).Return(nil)
+
+	scanned := make(chan struct{})
+	m.fileRepository.On("UpdateScanStatus", mock.Anything, mock.Anything, model.ScanStatusInfected, "Eicar-Test-Signature").
+		Return(nil).Run(func(mock.Arguments) { close(scanned) })
+
+	_, err := m.fileService.CreateFile(ctx, "user1", "parent_id", "virus.txt", "text/plain", []byte("EICAR"))
+	assert.NoError(t, err)
+
+	select {
+	case <-scanned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("file was not scanned")
+	}
+}
+
+func TestDownloadRefusedByScanStatus(t *testing.T) {
+	ctx := t.Context()
+	m := setupScanTest(&fakeScanner{}, model.ScanAsync)
+
+	for status, want := range map[model.ScanStatus]error{
+		model.ScanStatusPending:  errs.ErrFileScanPending,
+		model.ScanStatusInfected: errs.ErrFileInfected,
+	} {
+		file := &model.FileModel{ID: uuid.New(), Path: "/parent/file.txt", ScanStatus: status}
+		m.cacheManager.On("GetBucktValue", file.ID.String()).Return("", nil)
+		m.cacheManager.On("SetBucktValue", file.ID.String(), mock.Anything).Return(nil)
+		m.fileRepository.On("GetFile", file.ID).Return(file, nil)
+
+		_, err := m.fileService.GetFile(ctx, file.ID.String())
+		assert.ErrorIs(t, err, want)
+
+		_, _, err = m.fileService.GetFileStream(ctx, file.ID.String())
+		assert.ErrorIs(t, err, want)
+	}
+
+	m.backend.AssertNotCalled(t, "Get", mock.Anything)
+	m.backend.AssertNotCalled(t, "Stream", mock.Anything)
+}
+
+func TestRescanPending(t *testing.T) {
+	ctx := t.Context()
+	m := setupScanTest(&fakeScanner{}, model.ScanAsync)
+
+	file := &model.FileModel{ID: uuid.New(), Path: "/parent/file.txt", Hash: "hash", ScanStatus: model.ScanStatusPending}
+	m.fileRepository.On("GetFilesByScanStatus", model.ScanStatusPending).Return([]*model.FileModel{file}, nil)
+	m.backend.On("Stream", file.Path).Return(io.NopCloser(bytes.NewReader([]byte("hello"))), nil)
+	m.fileRepository.On("UpdateScanStatus", file.ID, "hash", model.ScanStatusClean, "").Return(nil)
+	m.cacheManager.On("DeleteBucktValue", mock.Anything).Return(nil)
+
+	n, err := m.fileService.RescanPending(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	m.fileRepository.AssertExpectations(t)
+}
+
+func TestScanQueueBackpressure(t *testing.T) {
+	scanner := &fakeScanner{block: make(chan struct{})}
+	m := setupScanTest(scanner, model.ScanAsync)
+	svc := m.fileService.(*FileService)
+
+	var scanned atomic.Int64
+	m.fileRepository.On("UpdateScanStatus", mock.Anything, mock.Anything, model.ScanStatusClean, "").
+		Return(nil).Run(func(mock.Arguments) { scanned.Add(1) })
+	m.cacheManager.On("DeleteBucktValue", mock.Anything).Return(nil)
+
+	// Every worker is busy and the queue fills up
+	queued := maxConcurrentScans + scanQueueSize
+	for range queued {
+		svc.scanAsync(t.Context(), &model.FileModel{ID: uuid.New()}, []byte("hello"))
+	}
+	assert.Eventually(t, func() bool { return len(svc.scans) == scanQueueSize }, 5*time.Second, 10*time.Millisecond)
+
+	// Further uploads wait for room and give up with their context
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	svc.scanAsync(ctx, &model.FileModel{ID: uuid.New()}, []byte("hello"))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// Close waits for the queued scans
+	close(scanner.block)
+	svc.Close()
+	assert.Equal(t, int64(queued), scanned.Load())
+}
+
+// queuedRenditions records the files queued for renditions.
+type queuedRenditions struct {
+	domain.RenditionService
+	queued chan string
+}
+
+func (r *queuedRenditions) Enqueue(file *model.FileModel, data []byte) {
+	r.queued <- file.Name
+}
+
+func TestScanAsyncQueuesRenditionsWhenClean(t *testing.T) {
+	ctx := t.Context()
+	m := setupScanTest(&fakeScanner{}, model.ScanAsync)
+	renditions := &queuedRenditions{queued: make(chan string, 2)}
+	m.fileService.(*FileService).renditions = renditions
+
+	m.fileRepository.On("Create", mock.Anything).Return(nil)
+	m.backend.On("Put", mock.Anything, mock.Anything).Return(nil)
+	m.cacheManager.On("DeleteBucktValue", mock.Anything).Return(nil)
+	m.fileRepository.On("UpdateScanStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	_, err := m.fileService.CreateFile(ctx, "user1", "parent_id", "virus.png", "image/png", []byte("EICAR"))
+	assert.NoError(t, err)
+	_, err = m.fileService.CreateFile(ctx, "user1", "parent_id", "clean.png", "image/png", []byte("hello"))
+	assert.NoError(t, err)
+
+	m.fileService.Close()
+	close(renditions.queued)
+
+	var queued []string
+	for name := range renditions.queued {
+		queued = append(queued, name)
+	}
+	assert.Equal(t, []string{"clean.png"}, queued)
+}
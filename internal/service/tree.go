@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/Rhaqim/buckt/internal/domain"
+	"github.com/Rhaqim/buckt/internal/model"
+)
+
+// folderTree resolves slash separated paths below a root folder to folders,
+// creating the ones that do not exist yet. Folders and their files are looked
+// up once and remembered.
+type folderTree struct {
+	folderService domain.FolderService
+	fileService   domain.FileService
+
+	user_id string
+
+	// folders maps the cleaned paths of folders to their IDs.
+	folders map[string]string
+	// files maps folder IDs to the files they contain by name.
+	files map[string]map[string]*model.FileModel
+
+	// visit is called the first time a folder is resolved.
+	visit func(name, id string, action model.ExtractAction)
+}
+
+func newFolderTree(folderService domain.FolderService, fileService domain.FileService, user_id, root_id string, visit func(name, id string, action model.ExtractAction)) *folderTree {
+	return &folderTree{
+		folderService: folderService,
+		fileService:   fileService,
+		user_id:       user_id,
+		folders:       map[string]string{"": root_id},
+		files:         make(map[string]map[string]*model.FileModel),
+		visit:         visit,
+	}
+}
+
+// folder returns the ID of the folder at the cleaned path, creating it and
+// its parents when they do not exist yet.
+func (t *folderTree) folder(ctx context.Context, name string) (string, error) {
+	if id, ok := t.folders[name]; ok {
+		return id, nil
+	}
+
+	dir, base := path.Split(name)
+	parentID, err := t.folder(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return "", err
+	}
+
+	folders, err := t.folderService.GetFolders(ctx, parentID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, folder := range folders {
+		if folder.Name == base {
+			t.folders[name] = folder.ID.String()
+			t.visit(name, folder.ID.String(), model.ExtractExisting)
+			return folder.ID.String(), nil
+		}
+	}
+
+	id, err := t.folderService.CreateFolder(ctx, t.user_id, parentID, base, "")
+	if err != nil {
+		return "", err
+	}
+
+	t.folders[name] = id
+	t.visit(name, id, model.ExtractCreated)
+	return id, nil
+}
+
+// folderFiles returns the files of a folder by name.
+func (t *folderTree) folderFiles(ctx context.Context, folder_id string) (map[string]*model.FileModel, error) {
+	if files, ok := t.files[folder_id]; ok {
+		return files, nil
+	}
+
+	existing, err := t.fileService.GetFilesMetadata(ctx, folder_id)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*model.FileModel, len(existing))
+	for i := range existing {
+		files[existing[i].Name] = &existing[i]
+	}
+	t.files[folder_id] = files
+	return files, nil
+}
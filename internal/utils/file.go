@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -9,6 +11,7 @@ import (
 	"unicode"
 
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	"image/png"
 	"os"
@@ -23,25 +26,55 @@ func GenerateThumbnail(inputPath, outputPath string, width uint) error {
 	}
 	defer file.Close()
 
-	img, format, err := image.Decode(file)
+	// Save the thumbnail
+	outFile, err := os.Create(outputPath)
 	if err != nil {
 		return err
 	}
+	defer outFile.Close()
 
-	// Resize image
-	thumbnail := resize.Resize(width, 0, img, resize.Lanczos3)
+	_, _, err = ResizeImage(file, outFile, width)
+	return err
+}
 
-	// Save the thumbnail
-	outFile, err := os.Create(outputPath)
+// MaxResizePixels bounds the size of the images ResizeImage decodes, 50 megapixels.
+const MaxResizePixels = 50_000_000
+
+// ErrImageTooLarge is returned for images with more than MaxResizePixels pixels.
+var ErrImageTooLarge = errors.New("image is too large to resize")
+
+// ResizeImage decodes an image from r, scales it down to at most width pixels wide
+// keeping the aspect ratio, and encodes the result to w. Images are never scaled up.
+// PNG and GIF images are encoded as PNG to keep transparency, all others as JPEG.
+// It returns the content type and the dimensions of the result.
+//
+// The dimensions are checked before the image is decoded, so decompression
+// bombs are rejected with ErrImageTooLarge.
+func ResizeImage(r io.Reader, w io.Writer, width uint) (string, image.Point, error) {
+	// Keep what DecodeConfig reads, the image is decoded from the same stream
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &header))
 	if err != nil {
-		return err
+		return "", image.Point{}, err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxResizePixels {
+		return "", image.Point{}, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+
+	img, format, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return "", image.Point{}, err
+	}
+
+	// Resize image
+	if uint(img.Bounds().Dx()) > width {
+		img = resize.Resize(width, 0, img, resize.Lanczos3)
 	}
-	defer outFile.Close()
 
-	if format == "png" {
-		return png.Encode(outFile, thumbnail)
+	if format == "png" || format == "gif" {
+		return "image/png", img.Bounds().Size(), png.Encode(w, img)
 	}
-	return jpeg.Encode(outFile, thumbnail, nil)
+	return "image/jpeg", img.Bounds().Size(), jpeg.Encode(w, img, nil)
 }
 
 func GenerateVideoPreview(inputPath, outputPath string) error {
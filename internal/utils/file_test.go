@@ -1,6 +1,13 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
 	"reflect"
 	"testing"
 )
@@ -51,3 +58,50 @@ func TestIsValidFolderPath(t *testing.T) {
 		}
 	}
 }
+
+func TestResizeImage(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 400, 200))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		width    uint
+		expected image.Point
+	}{
+		{100, image.Pt(100, 50)},
+		{800, image.Pt(400, 200)}, // Never scaled up
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		contentType, size, err := ResizeImage(bytes.NewReader(src.Bytes()), &out, test.width)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "image/png" || size != test.expected {
+			t.Errorf("For width %d, expected image/png %v but got %s %v", test.width, test.expected, contentType, size)
+		}
+	}
+
+	if _, _, err := ResizeImage(bytes.NewReader([]byte("not an image")), io.Discard, 100); err == nil {
+		t.Error("expected an error for invalid image data")
+	}
+}
+
+func TestResizeImageRejectsBombs(t *testing.T) {
+	// A PNG header claiming 100000x100000 pixels, rejected before any pixel is decoded
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := src.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100000)
+	binary.BigEndian.PutUint32(data[20:], 100000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, _, err := ResizeImage(bytes.NewReader(data), io.Discard, 100)
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge but got %v", err)
+	}
+}